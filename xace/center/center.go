@@ -0,0 +1,565 @@
+// Package center implements AaceCenter, the registry AceDiscovery and AceCenterClient
+// ask for the instances of proxies.
+//
+// An instance registers itself to the center with a TTL and heartbeats before it
+// expires. Clients get the instances of a proxy with getProxy, the center itself
+// is found the same way under the AaceCenter interface. Clients that subscribe to
+// a proxy receive a Notify message with the events of every change of its instances.
+//
+//	c, err := center.New("center.json")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//	s := server.NewServer()
+//	c.Enable(s)
+//	s.Serve("tcp", ":9000")
+package center
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"xace/client"
+	"xace/codec"
+	"xace/log"
+	"xace/registry"
+	"xace/server"
+)
+
+// ServicePath is the service path of the center, also the interface it registers its
+// own instances under.
+const ServicePath = "AaceCenter"
+
+// NotifyMethod is the method of the Notify messages the center pushes to subscribers,
+// their payload is a client.AceCenterNotification.
+const NotifyMethod = "notify"
+
+// The status of instances, AceDiscoveryFilter keeps only the active ones.
+const (
+	StatusInactive uint8 = 1
+	StatusActive   uint8 = 2
+)
+
+// DefaultTTL is how long an instance stays registered without heartbeats if it doesn't
+// give its own TTL.
+var DefaultTTL = 30 * time.Second
+
+// Center is the state of AaceCenter, Enable serves it on a server.
+type Center struct {
+	// TTL is the TTL of instances that don't give their own, DefaultTTL if 0.
+	TTL time.Duration
+	// CheckInterval is how often expired instances are removed, a second if 0.
+	CheckInterval time.Duration
+	// NotifyQueue is how many notifications may wait for a subscribed connection, 64
+	// if 0. A connection that falls further behind is closed, its client subscribes
+	// again when it reconnects.
+	NotifyQueue int
+	// NotifyTimeout is how long a notification may take to write, a second if 0.
+	NotifyTimeout time.Duration
+
+	store *registry.KVStore
+
+	mu      sync.Mutex
+	srv     *server.Server
+	proxies map[string]*proxy
+	subs    map[string]map[subscriber]struct{}
+	// outboxes send the notifications of the subscribed connections
+	outboxes map[net.Conn]*outbox
+
+	startOnce sync.Once
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// proxy is kept after its last instance is removed, so the versions of its
+// notifications keep following each other.
+type proxy struct {
+	version   int64
+	instances map[string]*instance // by host:port
+}
+
+// subscriber is a connection subscribed to the instances of a proxy that serve inter.
+type subscriber struct {
+	conn  net.Conn
+	inter string
+}
+
+type instance struct {
+	Host   string        `json:"host"`
+	Port   string        `json:"port"`
+	Status uint8         `json:"status"`
+	TTL    time.Duration `json:"ttl"`
+	Inters []string      `json:"inters,omitempty"`
+	Labels string        `json:"labels,omitempty"`
+
+	expire time.Time
+	self   bool // the center itself, it doesn't expire
+}
+
+func instanceKey(host, port string) string {
+	return net.JoinHostPort(host, port)
+}
+
+// serves returns whether the instance serves inter.
+func (in *instance) serves(inter string) bool {
+	if len(in.Inters) == 0 || inter == "" {
+		return true
+	}
+	for _, i := range in.Inters {
+		if i == inter {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a center that keeps its instances in the file at path, so they survive
+// restarts of the center. An empty path keeps them only in memory. The instances
+// loaded from the file expire if they don't heartbeat the new center within their TTL.
+func New(path string) (*Center, error) {
+	store, err := registry.OpenKVStore(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Center{
+		store:    store,
+		proxies:  make(map[string]*proxy),
+		subs:     make(map[string]map[subscriber]struct{}),
+		outboxes: make(map[net.Conn]*outbox),
+		stopCh:   make(chan struct{}),
+	}
+
+	now := time.Now()
+	err = store.View(func(tx *registry.Tx) error {
+		for _, name := range tx.Buckets() {
+			p := c.proxy(name)
+			err := tx.Bucket(name).ForEach(func(key, value string) error {
+				in := &instance{}
+				if err := json.Unmarshal([]byte(value), in); err != nil {
+					return err
+				}
+				in.expire = now.Add(in.TTL)
+				p.instances[key] = in
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.version = nextVersion(0)
+		}
+		return nil
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Enable serves the center on s as the AaceCenter service. Close the center after s
+// is closed or shut down.
+func (c *Center) Enable(s *server.Server) error {
+	c.mu.Lock()
+	c.srv = s
+	c.mu.Unlock()
+
+	s.Plugins.Add(c)
+	c.startOnce.Do(func() {
+		go c.expire()
+	})
+	return s.RegisterName(ServicePath, &Service{Center: c}, "")
+}
+
+// nextVersion returns a version after last. Versions follow the clock so they keep
+// growing across restarts of the center, which doesn't store them.
+func nextVersion(last int64) int64 {
+	v := time.Now().UnixNano()
+	if v <= last {
+		v = last + 1
+	}
+	return v
+}
+
+// proxy returns the proxy of name, it is created if it doesn't exist. c.mu is held.
+func (c *Center) proxy(name string) *proxy {
+	p := c.proxies[name]
+	if p == nil {
+		p = &proxy{instances: make(map[string]*instance)}
+		c.proxies[name] = p
+	}
+	return p
+}
+
+func (c *Center) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultTTL
+}
+
+// register adds or replaces an instance of proxyName.
+func (c *Center) register(proxyName string, in *instance) error {
+	c.mu.Lock()
+	n, err := c.registerLocked(proxyName, in)
+	c.unlockAndNotify(n)
+	return err
+}
+
+// registerLocked writes the instance to the store and returns the notification of
+// the change. c.mu is held, so the store and the instances change together.
+func (c *Center) registerLocked(proxyName string, in *instance) (*notification, error) {
+	if in.TTL <= 0 {
+		in.TTL = c.ttl()
+	}
+	if in.Status == 0 {
+		in.Status = StatusActive
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	key := instanceKey(in.Host, in.Port)
+	err = c.store.Update(func(tx *registry.Tx) error {
+		return tx.Bucket(proxyName).Put(key, string(data))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p := c.proxy(proxyName)
+	in.expire = time.Now().Add(in.TTL)
+	old := p.instances[key]
+	p.instances[key] = in
+	if old != nil && old.Status == in.Status && equalInters(old.Inters, in.Inters) && old.Labels == in.Labels {
+		return nil, nil
+	}
+	return c.changedLocked(proxyName, p, old, in), nil
+}
+
+// RegisterSelf registers the center at host:port as an instance of proxyName that serves
+// the AaceCenter interface, so clients find it with getProxy. It stays registered while
+// the center runs.
+func (c *Center) RegisterSelf(proxyName, host, port string) error {
+	return c.register(proxyName, &instance{Host: host, Port: port, Inters: []string{ServicePath}, self: true})
+}
+
+// heartbeat extends the TTL of an instance and sets its status if it isn't 0.
+// It returns false if the instance isn't registered.
+func (c *Center) heartbeat(proxyName, host, port string, status uint8) (bool, error) {
+	key := instanceKey(host, port)
+	c.mu.Lock()
+	p := c.proxies[proxyName]
+	if p == nil || p.instances[key] == nil {
+		c.mu.Unlock()
+		return false, nil
+	}
+	in := p.instances[key]
+	in.expire = time.Now().Add(in.TTL)
+	if status == 0 || status == in.Status {
+		c.mu.Unlock()
+		return true, nil
+	}
+	updated := *in
+	updated.Status = status
+	n, err := c.registerLocked(proxyName, &updated)
+	c.unlockAndNotify(n)
+	return true, err
+}
+
+// deregister removes an instance, it returns false if it isn't registered.
+func (c *Center) deregister(proxyName, host, port string) (bool, error) {
+	c.mu.Lock()
+	ok, n, err := c.deregisterLocked(proxyName, instanceKey(host, port))
+	c.unlockAndNotify(n)
+	return ok, err
+}
+
+// deregisterLocked removes the instance at key. c.mu is held.
+func (c *Center) deregisterLocked(proxyName, key string) (bool, *notification, error) {
+	p := c.proxies[proxyName]
+	if p == nil || p.instances[key] == nil {
+		return false, nil, nil
+	}
+	err := c.store.Update(func(tx *registry.Tx) error {
+		return tx.Bucket(proxyName).Delete(key)
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	old := p.instances[key]
+	delete(p.instances, key)
+	return true, c.changedLocked(proxyName, p, old, nil), nil
+}
+
+// Instances returns the instances of proxyName that serve inter, sorted by address,
+// and the version of the proxy. An empty inter returns all instances.
+func (c *Center) Instances(proxyName, inter string) ([]client.AceCenterSrvInfo, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.proxies[proxyName]
+	if p == nil {
+		return []client.AceCenterSrvInfo{}, 0
+	}
+	return p.list(proxyName, inter), p.version
+}
+
+func (p *proxy) list(name, inter string) []client.AceCenterSrvInfo {
+	srvs := make([]client.AceCenterSrvInfo, 0, len(p.instances))
+	for _, in := range p.instances {
+		if in.serves(inter) {
+			srvs = append(srvs, in.info(name))
+		}
+	}
+	sort.Slice(srvs, func(i, j int) bool {
+		return instanceKey(srvs[i].Host, srvs[i].Port) < instanceKey(srvs[j].Host, srvs[j].Port)
+	})
+	return srvs
+}
+
+func (in *instance) info(proxyName string) client.AceCenterSrvInfo {
+	return client.AceCenterSrvInfo{Proxy: proxyName, Host: in.Host, Port: in.Port, Status: in.Status, Labels: in.Labels}
+}
+
+// notification is a change of a proxy to send to its subscribers.
+type notification struct {
+	subs []subscriber
+	n    []*client.AceCenterNotification // of subs
+}
+
+// changedLocked bumps the version of p after its instance old changed to in, either
+// can be nil, and returns the notification of the subscribers. c.mu is held.
+func (c *Center) changedLocked(name string, p *proxy, old, in *instance) *notification {
+	prev := p.version
+	p.version = nextVersion(prev)
+	if len(c.subs[name]) == 0 || c.srv == nil {
+		return nil
+	}
+
+	n := &notification{}
+	byInter := make(map[string]*client.AceCenterNotification)
+	for sub := range c.subs[name] {
+		cn := byInter[sub.inter]
+		if cn == nil {
+			// every subscriber gets the version, even if the change isn't of its interface
+			cn = &client.AceCenterNotification{Proxy: name, Inter: sub.inter, Version: p.version, PrevVersion: prev}
+			oldServes := old != nil && old.serves(sub.inter)
+			newServes := in != nil && in.serves(sub.inter)
+			switch {
+			case !oldServes && newServes:
+				cn.Events = []client.AceCenterEvent{{Type: client.AceCenterAdd, Srv: in.info(name)}}
+			case oldServes && !newServes:
+				cn.Events = []client.AceCenterEvent{{Type: client.AceCenterRemove, Srv: old.info(name)}}
+			case oldServes && newServes:
+				cn.Events = []client.AceCenterEvent{{Type: client.AceCenterUpdate, Srv: in.info(name)}}
+			}
+			byInter[sub.inter] = cn
+		}
+		n.subs = append(n.subs, sub)
+		n.n = append(n.n, cn)
+	}
+	return n
+}
+
+// outbox sends the notifications of a subscribed connection in order, so a
+// subscriber that doesn't read holds up only itself.
+type outbox struct {
+	conn net.Conn
+	srv  *server.Server
+	ch   chan outMessage
+	done chan struct{} // closed when the connection is no longer notified
+}
+
+type outMessage struct {
+	proxy string
+	data  []byte
+}
+
+// unlockAndNotify queues the notifications for their subscribers and unlocks c.mu.
+// They are queued while c.mu is held, so each connection gets them in the order of
+// the changes.
+func (c *Center) unlockAndNotify(ns ...*notification) {
+	var overflowed []net.Conn
+	for _, n := range ns {
+		if n == nil {
+			continue
+		}
+		data := make(map[*client.AceCenterNotification][]byte)
+		for i, sub := range n.subs {
+			o := c.outboxes[sub.conn]
+			if o == nil {
+				continue // dropped by an earlier notification
+			}
+			cn := n.n[i]
+			if data[cn] == nil {
+				b, err := codec.EncodeArgs(cn)
+				if err != nil {
+					log.Errorf("center: failed to encode the notification of %s: %v", cn.Proxy, err)
+					continue
+				}
+				data[cn] = b
+			}
+			select {
+			case o.ch <- outMessage{proxy: cn.Proxy, data: data[cn]}:
+			default:
+				log.Warnf("center: %s doesn't read its notifications, closing it", sub.conn.RemoteAddr())
+				c.dropLocked(sub.conn)
+				overflowed = append(overflowed, sub.conn)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	for _, conn := range overflowed {
+		conn.Close()
+	}
+}
+
+// send writes the notifications of o until it is dropped.
+func (c *Center) send(o *outbox) {
+	timeout := c.NotifyTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	for {
+		select {
+		case <-o.done:
+			return
+		case m := <-o.ch:
+			o.conn.SetWriteDeadline(time.Now().Add(timeout))
+			err := o.srv.SendMessage(o.conn, ServicePath, NotifyMethod, nil, m.data)
+			o.conn.SetWriteDeadline(time.Time{})
+			if err != nil {
+				log.Warnf("center: failed to notify %s of %s, closing it: %v", o.conn.RemoteAddr(), m.proxy, err)
+				c.mu.Lock()
+				c.dropLocked(o.conn)
+				c.mu.Unlock()
+				o.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// dropLocked removes the subscriptions and the outbox of conn. c.mu is held.
+func (c *Center) dropLocked(conn net.Conn) {
+	for name, subs := range c.subs {
+		for sub := range subs {
+			if sub.conn == conn {
+				delete(subs, sub)
+			}
+		}
+		if len(subs) == 0 {
+			delete(c.subs, name)
+		}
+	}
+	if o := c.outboxes[conn]; o != nil {
+		delete(c.outboxes, conn)
+		close(o.done)
+	}
+}
+
+// subscribe sends the changes of the instances of proxyName that serve inter to conn.
+func (c *Center) subscribe(proxyName, inter string, conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subs[proxyName] == nil {
+		c.subs[proxyName] = make(map[subscriber]struct{})
+	}
+	c.subs[proxyName][subscriber{conn: conn, inter: inter}] = struct{}{}
+
+	if c.outboxes[conn] == nil {
+		size := c.NotifyQueue
+		if size <= 0 {
+			size = 64
+		}
+		o := &outbox{conn: conn, srv: c.srv, ch: make(chan outMessage, size), done: make(chan struct{})}
+		c.outboxes[conn] = o
+		go c.send(o)
+	}
+}
+
+func (c *Center) unsubscribe(proxyName, inter string, conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.subs[proxyName], subscriber{conn: conn, inter: inter})
+	if len(c.subs[proxyName]) == 0 {
+		delete(c.subs, proxyName)
+	}
+}
+
+// HandleConnClose removes the subscriptions of a closed connection.
+func (c *Center) HandleConnClose(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropLocked(conn)
+	return true
+}
+
+func (c *Center) expire() {
+	interval := c.CheckInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case now := <-tick.C:
+			c.removeExpired(now)
+		}
+	}
+}
+
+// removeExpired removes the instances that didn't heartbeat in their TTL.
+func (c *Center) removeExpired(now time.Time) {
+	var ns []*notification
+	c.mu.Lock()
+	for name, p := range c.proxies {
+		for key, in := range p.instances {
+			if in.self || !now.After(in.expire) {
+				continue
+			}
+			log.Infof("center: instance %s of %s expired", key, name)
+			_, n, err := c.deregisterLocked(name, key)
+			if err != nil {
+				log.Errorf("center: failed to remove the expired instance %s of %s: %v", key, name, err)
+			}
+			ns = append(ns, n)
+		}
+	}
+	c.unlockAndNotify(ns...)
+}
+
+// Close stops expiring instances and closes the store.
+func (c *Center) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stopCh)
+		c.mu.Lock()
+		for conn := range c.outboxes {
+			c.dropLocked(conn)
+		}
+		c.mu.Unlock()
+		err = c.store.Close()
+	})
+	return err
+}
+
+func equalInters(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package center
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/codec"
+	"xace/protocol"
+	"xace/server"
+)
+
+type rcReply struct {
+	Reply
+	ret int32
+}
+
+func (r *rcReply) SetRetcode(c int32) { r.ret = c }
+
+func TestCenter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.json")
+	c, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CheckInterval = 20 * time.Millisecond
+	s := server.NewServer()
+	if err := c.Enable(s); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cl := client.NewClient(client.AceOption)
+	msgs := make(chan *protocol.Message, 10)
+	cl.RegisterServerMessageChan(msgs)
+	if err := cl.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	ctx := context.Background()
+
+	sub := &GetProxyReply{}
+	if err := cl.Call(ctx, ServicePath, "subscribe", &GetProxyArgs{Proxy: "P"}, sub); err != nil {
+		t.Fatal(err)
+	}
+	r := &rcReply{}
+	if err := cl.Call(ctx, ServicePath, "register", &RegisterArgs{Proxy: "P", Host: "h", Port: "1", TTL: 1, Inters: []string{"I"}}, r); err != nil || r.ret != 0 {
+		t.Fatal(err, r.ret)
+	}
+	cl.Call(ctx, ServicePath, "register", &RegisterArgs{Proxy: "P", Host: "h", Port: "2", TTL: 60}, r)
+	// the way AceCenterClient asks
+	var srvs []client.AceCenterSrvInfo
+	if err := cl.Call(ctx, ServicePath, "getProxy", []any{"P", "J"}, []any{&srvs}); err != nil || len(srvs) != 1 || srvs[0].Port != "2" || srvs[0].Status != StatusActive {
+		t.Fatal(err, srvs)
+	}
+	var n client.AceCenterNotification
+	next := func() {
+		select {
+		case m := <-msgs:
+			if m.ServiceMethod != NotifyMethod {
+				t.Fatal(m.ServiceMethod)
+			}
+			if err := codec.DecodeArgs(m.Payload, []any{&n}); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("no notify")
+		}
+	}
+	v := sub.Version
+	next()
+	if n.PrevVersion != v || len(n.Events) != 1 || n.Events[0].Type != client.AceCenterAdd || n.Events[0].Srv.Port != "1" {
+		t.Fatalf("%+v", n)
+	}
+	v = n.Version
+	next()
+	if n.PrevVersion != v || n.Events[0].Srv.Port != "2" {
+		t.Fatalf("%+v", n)
+	}
+	v = n.Version
+	// heartbeat of unknown
+	cl.Call(ctx, ServicePath, "heartbeat", &HeartbeatArgs{Proxy: "P", Host: "x", Port: "1"}, r)
+	if r.ret != RetNotRegistered {
+		t.Fatal(r.ret)
+	}
+	r.ret = 0
+	cl.Call(ctx, ServicePath, "heartbeat", &HeartbeatArgs{Proxy: "P", Host: "h", Port: "2", Status: StatusInactive}, r)
+	if r.ret != 0 {
+		t.Fatal(r.ret)
+	}
+	next()
+	if n.PrevVersion != v || n.Events[0].Type != client.AceCenterUpdate || n.Events[0].Srv.Status != StatusInactive {
+		t.Fatalf("%+v", n)
+	}
+	v = n.Version
+	// expiry of port 1 after a second
+	next()
+	if n.PrevVersion != v || n.Events[0].Type != client.AceCenterRemove || n.Events[0].Srv.Port != "1" {
+		t.Fatalf("%+v", n)
+	}
+	// a subscriber of another interface gets the version without events
+	cl.Call(ctx, ServicePath, "subscribe", &GetProxyArgs{Proxy: "P", Inter: "I"}, sub)
+	cl.Call(ctx, ServicePath, "register", &RegisterArgs{Proxy: "P", Host: "h", Port: "3", Inters: []string{"K"}}, r)
+	next()
+	m1 := n
+	next()
+	if m1.Inter == n.Inter || m1.Version != n.Version {
+		t.Fatalf("%+v %+v", m1, n)
+	}
+	if n.Inter == "I" {
+		m1, n = n, m1
+	}
+	if len(m1.Events) != 0 || len(n.Events) != 1 {
+		t.Fatalf("%+v %+v", m1, n)
+	}
+	cl.Call(ctx, ServicePath, "deregister", &DeregisterArgs{Proxy: "P", Host: "h", Port: "3"}, r)
+	next()
+	next()
+	s.Close()
+	c.Close()
+
+	c2, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ver := c2.Instances("P", "")
+	if len(got) != 1 || got[0].Port != "2" || got[0].Status != StatusInactive || ver == 0 {
+		t.Fatal(got, ver)
+	}
+	c2.Close()
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	c, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.NotifyQueue = 4
+	c.NotifyTimeout = 50 * time.Millisecond
+	c.srv = server.NewServer()
+
+	// nothing reads the other end of the pipe
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c.subscribe("P", "", conn)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if err := c.register("P", &instance{Host: "h", Port: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, _ := c.heartbeat("P", "h", "0", 0); !ok {
+		t.Fatal("heartbeat")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("changes waited for the subscriber: %v", d)
+	}
+
+	c.mu.Lock()
+	subs, outboxes := len(c.subs), len(c.outboxes)
+	c.mu.Unlock()
+	if subs != 0 || outboxes != 0 {
+		t.Fatal("the subscriber wasn't dropped", subs, outboxes)
+	}
+	if _, err := conn.Write([]byte{0}); err == nil {
+		t.Fatal("the connection of the subscriber isn't closed")
+	}
+}
@@ -0,0 +1,164 @@
+package center
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+)
+
+var (
+	centerOnce   sync.Once
+	sharedCenter *Center
+	centerServer *server.Server
+	proxies      int32
+)
+
+// startCenter serves a center kept in memory and makes it the center of the clients.
+// The center client of the clients is global, so the tests share one center.
+func startCenter(t *testing.T) (*Center, *server.Server) {
+	centerOnce.Do(func() {
+		c, err := New("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := server.NewServer()
+		if err := c.Enable(s); err != nil {
+			t.Fatal(err)
+		}
+		go s.Serve("tcp", "127.0.0.1:0")
+		for s.Address() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		host, port, _ := net.SplitHostPort(s.Address().String())
+		c.RegisterSelf("C", host, port)
+		client.InitializeAceCenter(s.Address().String(), "C")
+		sharedCenter, centerServer = c, s
+	})
+	if sharedCenter == nil {
+		t.Fatal("the center is not started")
+	}
+	return sharedCenter, centerServer
+}
+
+// newProxy returns a proxy no other test uses.
+func newProxy() string {
+	return fmt.Sprintf("P%d", atomic.AddInt32(&proxies, 1))
+}
+
+// nextServices waits for the services ch receives.
+func nextServices(t *testing.T, ch chan []*client.KVPair) []*client.KVPair {
+	t.Helper()
+	select {
+	case pairs := <-ch:
+		return pairs
+	case <-time.After(2 * time.Second):
+		t.Fatal("the change is not pushed")
+	}
+	return nil
+}
+
+func TestAceDiscoveryPush(t *testing.T) {
+	c, _ := startCenter(t)
+	proxy := newProxy()
+	// a poll interval that never comes, the changes are pushed
+	d, err := client.NewAceDiscovery(proxy, "I", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ch := d.WatchService()
+
+	c.register(proxy, &instance{Host: "h", Port: "1"})
+	if pairs := nextServices(t, ch); len(pairs) != 1 || pairs[0].Key != "ace@h:1" {
+		t.Fatalf("%v", pairs)
+	}
+	// an instance of another interface isn't pushed, an inactive one is removed
+	c.register(proxy, &instance{Host: "h", Port: "2", Inters: []string{"other"}})
+	c.heartbeat(proxy, "h", "1", StatusInactive)
+	if pairs := nextServices(t, ch); len(pairs) != 0 {
+		t.Fatalf("%v", pairs)
+	}
+	c.register(proxy, &instance{Host: "h", Port: "3"})
+	if pairs := nextServices(t, ch); len(pairs) != 1 || pairs[0].Key != "ace@h:3" {
+		t.Fatalf("%v", pairs)
+	}
+	if pairs := d.GetServices(); len(pairs) != 1 {
+		t.Fatalf("%v", pairs)
+	}
+}
+
+func TestAceDiscoveryResubscribe(t *testing.T) {
+	c, s := startCenter(t)
+	proxy := newProxy()
+	d, err := client.NewAceDiscovery(proxy, "I", 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	ch := d.WatchService()
+
+	// the subscription is lost, the discovery polls until it subscribes again
+	for _, conn := range s.ActiveClientConn() {
+		conn.Close()
+	}
+	time.Sleep(300 * time.Millisecond)
+	c.register(proxy, &instance{Host: "h", Port: "1"})
+	if pairs := nextServices(t, ch); len(pairs) != 1 {
+		t.Fatalf("%v", pairs)
+	}
+	c.register(proxy, &instance{Host: "h", Port: "2"})
+	if pairs := nextServices(t, ch); len(pairs) != 2 {
+		t.Fatalf("%v", pairs)
+	}
+}
+
+type Zone struct{ name string }
+
+type ZoneReply struct{ Name string }
+
+func (z *Zone) Get(ctx context.Context, args *struct{}, reply *ZoneReply) int32 {
+	reply.Name = z.name
+	return 0
+}
+
+func TestAceDiscoveryRoute(t *testing.T) {
+	c, cs := startCenter(t)
+	proxy := newProxy()
+
+	// the labels go from the register plugin through the center to the route rule
+	for _, zone := range []string{"a", "b"} {
+		s := server.NewServer()
+		p := NewRegisterPlugin([]string{cs.Address().String()}, proxy)
+		p.Labels = "zone=" + zone
+		s.Plugins.Add(p)
+		s.RegisterName("Zone", &Zone{name: zone}, "")
+		go s.Serve("tcp", "127.0.0.1:0")
+		defer s.Close()
+	}
+	waitInstances(t, c, proxy, "Zone", func(got []client.AceCenterSrvInfo) bool { return len(got) == 2 })
+
+	d, err := client.NewAceDiscovery(proxy, "Zone", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if pairs := d.GetServices(); len(pairs) != 2 {
+		t.Fatalf("%v", pairs)
+	}
+	xc := client.NewXClient("Zone", client.Failtry, client.RandomSelect, d, client.AceOption)
+	defer xc.Close()
+	xc.SetRouteRule(&client.RouteRule{Labels: map[string]string{"zone": "b"}})
+	for i := 0; i < 20; i++ {
+		reply := &ZoneReply{}
+		if err := xc.Call(context.Background(), "get", []any{}, []any{reply}); err != nil || reply.Name != "b" {
+			t.Fatal(err, reply)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package center
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"xace/client"
+	"xace/log"
+	"xace/util"
+)
+
+// RegisterPlugin is a server plugin that registers the server to the center as an
+// instance of a proxy, serving the services of the server:
+//
+//	p := center.NewRegisterPlugin([]string{"10.0.0.1:9000"}, "Proxy")
+//	s.Plugins.Add(p)
+//	s.RegisterName("Inter", new(Inter), "")
+//	s.Serve("tcp", ":8972")
+//
+// The instance is registered when the server listens and heartbeats the center three
+// times in its TTL. Shutdown marks it inactive first, so clients stop choosing it,
+// and deregisters it once the in-flight requests finished.
+type RegisterPlugin struct {
+	// Proxy is the proxy the server is an instance of.
+	Proxy string
+	// Address is the host:port clients connect to, the listened address if empty.
+	// An unspecified listened host is replaced by the external IPv4 address.
+	Address string
+	// TTL is the TTL of the instance, DefaultTTL if 0.
+	TTL time.Duration
+	// Labels are the labels of the instance as a query, such as zone=sh-a&version=v2,
+	// clients route calls by them with client.RouteRule.
+	Labels string
+
+	xclient client.XClient
+
+	mu        sync.Mutex
+	host      string
+	port      string
+	services  []string
+	status    uint8
+	dirty     bool // the services changed since the last register
+	listening bool
+	draining  bool
+
+	callMu   sync.Mutex // orders the calls to the center
+	kick     chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRegisterPlugin returns a plugin that registers the server as an instance of proxy
+// to the centers at the addresses, which are host:port or network@host:port.
+func NewRegisterPlugin(centers []string, proxy string) *RegisterPlugin {
+	pairs := make([]*client.KVPair, 0, len(centers))
+	for _, addr := range centers {
+		if !strings.Contains(addr, "@") {
+			addr = "tcp@" + addr
+		}
+		pairs = append(pairs, &client.KVPair{Key: addr})
+	}
+	d, _ := client.NewMultipleServersDiscovery(pairs)
+	return &RegisterPlugin{
+		Proxy:   proxy,
+		xclient: client.NewXClient(ServicePath, client.Failover, client.RandomSelect, d, client.AceOption),
+		status:  StatusActive,
+		kick:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+func (p *RegisterPlugin) ttl() time.Duration {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultTTL
+}
+
+// Register adds the service to the instance.
+func (p *RegisterPlugin) Register(name string, rcvr interface{}, metadata string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.services {
+		if s == name {
+			return nil
+		}
+	}
+	p.services = append(p.services, name)
+	p.changedLocked()
+	return nil
+}
+
+// RegisterFunction adds the service of the function to the instance.
+func (p *RegisterPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
+	return p.Register(serviceName, fn, metadata)
+}
+
+// Unregister removes the service from the instance. The services stay registered
+// while the server drains, until the instance is deregistered.
+func (p *RegisterPlugin) Unregister(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.draining {
+		return nil
+	}
+	for i, s := range p.services {
+		if s == name {
+			p.services = append(p.services[:i], p.services[i+1:]...)
+			p.changedLocked()
+			break
+		}
+	}
+	return nil
+}
+
+// changedLocked makes the next sync register the services. p.mu is held.
+func (p *RegisterPlugin) changedLocked() {
+	p.dirty = true
+	if p.listening {
+		select {
+		case p.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// HandleListen registers the instance at the address of ln.
+func (p *RegisterPlugin) HandleListen(ln net.Listener) error {
+	addr := p.Address
+	if addr == "" {
+		addr = ln.Addr().String()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("center: invalid address %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = util.ExternalIPV4(); err != nil {
+			return fmt.Errorf("center: no address to register for %s: %w", addr, err)
+		}
+	}
+
+	p.mu.Lock()
+	if p.listening {
+		p.mu.Unlock()
+		return errors.New("center: the server of the register plugin listens again")
+	}
+	p.host, p.port = host, port
+	p.listening = true
+	p.dirty = true
+	p.mu.Unlock()
+
+	go p.run()
+	return nil
+}
+
+func (p *RegisterPlugin) run() {
+	tick := time.NewTicker(p.ttl() / 3)
+	defer tick.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := p.sync(ctx); err != nil {
+			log.Warnf("center: failed to register %s to %s: %v", p.Proxy, ServicePath, err)
+		}
+		cancel()
+
+		select {
+		case <-p.stopCh:
+			return
+		case <-tick.C:
+		case <-p.kick:
+		}
+	}
+}
+
+// callReply keeps the retcode of a call to the center.
+type callReply struct {
+	retcode int32
+}
+
+func (r *callReply) SetRetcode(retcode int32) {
+	r.retcode = retcode
+}
+
+func (p *RegisterPlugin) call(ctx context.Context, method string, args interface{}) (int32, error) {
+	reply := &callReply{}
+	if err := p.xclient.Call(ctx, method, args, reply); err != nil {
+		return 0, err
+	}
+	return reply.retcode, nil
+}
+
+// sync registers the instance if its services changed or the center lost it,
+// otherwise it heartbeats it with its status.
+func (p *RegisterPlugin) sync(ctx context.Context) error {
+	p.callMu.Lock()
+	defer p.callMu.Unlock()
+
+	p.mu.Lock()
+	host, port, status, dirty := p.host, p.port, p.status, p.dirty
+	services := append([]string(nil), p.services...)
+	p.dirty = false
+	p.mu.Unlock()
+
+	if len(services) == 0 {
+		// an instance without interfaces would serve all of the proxy
+		if !dirty {
+			return nil
+		}
+		ret, err := p.call(ctx, "deregister", &DeregisterArgs{Proxy: p.Proxy, Host: host, Port: port})
+		if err == nil && ret != RetNotRegistered {
+			err = retError("deregister", ret)
+		}
+		if err != nil {
+			p.mu.Lock()
+			p.dirty = true
+			p.mu.Unlock()
+		}
+		return err
+	}
+
+	if !dirty {
+		ret, err := p.call(ctx, "heartbeat", &HeartbeatArgs{Proxy: p.Proxy, Host: host, Port: port, Status: status})
+		if err == nil && ret != RetNotRegistered {
+			return retError("heartbeat", ret)
+		}
+		if err != nil {
+			return err
+		}
+		// the instance expired in the center
+	}
+
+	args := &RegisterArgs{
+		Proxy:  p.Proxy,
+		Host:   host,
+		Port:   port,
+		Status: status,
+		TTL:    int32(p.ttl() / time.Second),
+		Inters: services,
+		Labels: p.Labels,
+	}
+	ret, err := p.call(ctx, "register", args)
+	if err == nil {
+		err = retError("register", ret)
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.dirty = true
+		p.mu.Unlock()
+	}
+	return err
+}
+
+func retError(method string, ret int32) error {
+	if ret == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s retcode %d", method, ret)
+}
+
+// PreShutdown marks the instance inactive, so clients route new calls to other instances.
+func (p *RegisterPlugin) PreShutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.draining = true
+	p.status = StatusInactive
+	listening := p.listening
+	p.mu.Unlock()
+
+	if !listening {
+		return nil
+	}
+	return p.sync(ctx)
+}
+
+// PostDrain deregisters the instance after its in-flight requests finished.
+func (p *RegisterPlugin) PostDrain(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+
+	p.mu.Lock()
+	host, port, listening := p.host, p.port, p.listening
+	p.mu.Unlock()
+	if !listening {
+		return nil
+	}
+
+	p.callMu.Lock()
+	defer p.callMu.Unlock()
+	ret, err := p.call(ctx, "deregister", &DeregisterArgs{Proxy: p.Proxy, Host: host, Port: port})
+	if err == nil && ret != RetNotRegistered {
+		err = retError("deregister", ret)
+	}
+	p.xclient.Close()
+	return err
+}
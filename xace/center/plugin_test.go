@@ -0,0 +1,84 @@
+package center
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+)
+
+type Slow struct{}
+
+type SlowArgs struct{ Sleep int }
+
+type SlowReply struct{ Slept int }
+
+func (s *Slow) Wait(ctx context.Context, args *SlowArgs, reply *SlowReply) int32 {
+	time.Sleep(time.Duration(args.Sleep) * time.Millisecond)
+	reply.Slept = args.Sleep
+	return 0
+}
+
+// waitInstances waits up to 2 seconds for the instances of proxy to pass ok.
+func waitInstances(t *testing.T, c *Center, proxy, inter string, ok func([]client.AceCenterSrvInfo) bool) []client.AceCenterSrvInfo {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := c.Instances(proxy, inter)
+		if ok(got) {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s.%s: %+v", proxy, inter, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	c, cs := startCenter(t)
+	proxy := newProxy()
+
+	s := server.NewServer()
+	p := NewRegisterPlugin([]string{cs.Address().String()}, proxy)
+	p.TTL = 3 * time.Second
+	s.Plugins.Add(p)
+	s.RegisterName("Slow", &Slow{}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+
+	// registered on Serve
+	got := waitInstances(t, c, proxy, "Slow", func(got []client.AceCenterSrvInfo) bool { return len(got) == 1 })
+	if got[0].Status != StatusActive || got[0].Host != "127.0.0.1" {
+		t.Fatalf("%+v", got)
+	}
+	if other, _ := c.Instances(proxy, "Other"); len(other) != 0 {
+		t.Fatalf("%+v", other)
+	}
+
+	// inactive while the calls in flight end, deregistered after Shutdown
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xc := client.NewXClient("Slow", client.Failtry, client.RandomSelect, d, client.AceOption)
+	defer xc.Close()
+	done := make(chan error, 1)
+	go func() {
+		reply := &SlowReply{}
+		done <- xc.Call(context.Background(), "wait", []any{1500}, []any{reply})
+	}()
+	time.Sleep(200 * time.Millisecond)
+	shut := make(chan error, 1)
+	go func() { shut <- s.Shutdown(context.Background()) }()
+	waitInstances(t, c, proxy, "Slow", func(got []client.AceCenterSrvInfo) bool {
+		return len(got) == 1 && got[0].Status == StatusInactive
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-shut; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Instances(proxy, ""); len(got) != 0 {
+		t.Fatalf("deregistered %+v", got)
+	}
+}
@@ -0,0 +1,157 @@
+package center
+
+// The args and replies below are written as the list of their fields, the parameters
+// AceCenterClient and the other ace clients of a center send and read.
+//go:generate go run xace/cmd/acegen -type RegisterArgs,HeartbeatArgs,DeregisterArgs,GetProxyArgs,GetProxyReply service.go
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"xace/client"
+	"xace/log"
+	"xace/server"
+)
+
+// The retcodes of the methods of the center.
+const (
+	// RetInvalidArgs means the args miss the proxy, host or port.
+	RetInvalidArgs int32 = -90101
+	// RetNotRegistered means the instance of a heartbeat or deregister isn't registered,
+	// an instance that gets it on a heartbeat expired and must register again.
+	RetNotRegistered int32 = -90102
+	// RetStoreFailed means the center failed to write its store.
+	RetStoreFailed int32 = -90103
+	// RetNoConnection means subscribe wasn't called over a connection the center can push to.
+	RetNoConnection int32 = -90104
+)
+
+// RegisterArgs are the args of register.
+type RegisterArgs struct {
+	Proxy  string
+	Host   string
+	Port   string
+	Status uint8 // StatusActive if 0
+	// TTL is how many seconds the instance stays registered without heartbeats,
+	// the TTL of the center if 0.
+	TTL int32
+	// Inters are the interfaces the instance serves, all of the proxy if empty.
+	Inters []string
+	// Labels are the labels of the instance as a query, such as zone=sh-a&version=v2.
+	// Clients route calls by them with RouteRule.
+	Labels string
+}
+
+// HeartbeatArgs are the args of heartbeat.
+type HeartbeatArgs struct {
+	Proxy  string
+	Host   string
+	Port   string
+	Status uint8 // unchanged if 0
+}
+
+// DeregisterArgs are the args of deregister.
+type DeregisterArgs struct {
+	Proxy string
+	Host  string
+	Port  string
+}
+
+// GetProxyArgs are the args of getProxy, subscribe and unsubscribe.
+type GetProxyArgs struct {
+	Proxy string
+	Inter string // all interfaces if empty
+}
+
+// GetProxyReply is the reply of getProxy and subscribe. Srvs comes first, so
+// AceCenterClient reads it with a one element reply list.
+type GetProxyReply struct {
+	Srvs    []client.AceCenterSrvInfo
+	Version int64
+}
+
+// Reply is the empty reply of the methods that only return a retcode.
+type Reply struct{}
+
+// Service is the AaceCenter service of a Center.
+type Service struct {
+	Center *Center
+}
+
+// Register adds or replaces an instance.
+func (s *Service) Register(ctx context.Context, args *RegisterArgs, reply *Reply) int32 {
+	if args.Proxy == "" || args.Host == "" || args.Port == "" {
+		return RetInvalidArgs
+	}
+	in := &instance{
+		Host:   args.Host,
+		Port:   args.Port,
+		Status: args.Status,
+		TTL:    time.Duration(args.TTL) * time.Second,
+		// args may go back to the pool after this call
+		Inters: append([]string(nil), args.Inters...),
+		Labels: args.Labels,
+	}
+	if err := s.Center.register(args.Proxy, in); err != nil {
+		log.Errorf("center: failed to register %s:%s of %s: %v", args.Host, args.Port, args.Proxy, err)
+		return RetStoreFailed
+	}
+	return 0
+}
+
+// Heartbeat keeps an instance registered for its TTL.
+func (s *Service) Heartbeat(ctx context.Context, args *HeartbeatArgs, reply *Reply) int32 {
+	ok, err := s.Center.heartbeat(args.Proxy, args.Host, args.Port, args.Status)
+	if err != nil {
+		log.Errorf("center: failed to update %s:%s of %s: %v", args.Host, args.Port, args.Proxy, err)
+		return RetStoreFailed
+	}
+	if !ok {
+		return RetNotRegistered
+	}
+	return 0
+}
+
+// Deregister removes an instance.
+func (s *Service) Deregister(ctx context.Context, args *DeregisterArgs, reply *Reply) int32 {
+	ok, err := s.Center.deregister(args.Proxy, args.Host, args.Port)
+	if err != nil {
+		log.Errorf("center: failed to deregister %s:%s of %s: %v", args.Host, args.Port, args.Proxy, err)
+		return RetStoreFailed
+	}
+	if !ok {
+		return RetNotRegistered
+	}
+	return 0
+}
+
+// GetProxy returns the instances of a proxy that serve an interface.
+func (s *Service) GetProxy(ctx context.Context, args *GetProxyArgs, reply *GetProxyReply) int32 {
+	reply.Srvs, reply.Version = s.Center.Instances(args.Proxy, args.Inter)
+	return 0
+}
+
+// Subscribe returns the instances of a proxy like GetProxy and pushes their changes
+// to the connection of the call until it closes or unsubscribes. The first change
+// follows the version of the reply.
+func (s *Service) Subscribe(ctx context.Context, args *GetProxyArgs, reply *GetProxyReply) int32 {
+	conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	if !ok {
+		return RetNoConnection
+	}
+	if args.Proxy == "" {
+		return RetInvalidArgs
+	}
+	s.Center.subscribe(args.Proxy, args.Inter, conn)
+	reply.Srvs, reply.Version = s.Center.Instances(args.Proxy, args.Inter)
+	return 0
+}
+
+// Unsubscribe stops pushing the changes of a proxy to the connection of the call.
+func (s *Service) Unsubscribe(ctx context.Context, args *GetProxyArgs, reply *Reply) int32 {
+	if conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn); ok {
+		s.Center.unsubscribe(args.Proxy, args.Inter, conn)
+	}
+	return 0
+}
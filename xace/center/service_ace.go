@@ -0,0 +1,180 @@
+// Code generated by acegen from service.go. DO NOT EDIT.
+
+package center
+
+import (
+	"reflect"
+
+	"xace/codec"
+)
+
+func (x *RegisterArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(7)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Proxy)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Host)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Port)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.Status)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.TTL))
+	e.PackFieldType(codec.FT_ARRAY)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackNum(len(x.Inters))
+	for i0 := range x.Inters {
+		e.PackString(x.Inters[i0])
+	}
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Labels)
+	return e.Err()
+}
+
+func (x *RegisterArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Proxy = d.DecodeString(field)
+		case 1:
+			x.Host = d.DecodeString(field)
+		case 2:
+			x.Port = d.DecodeString(field)
+		case 3:
+			x.Status = uint8(d.DecodeUint(field))
+		case 4:
+			x.TTL = int32(d.DecodeInt(field))
+		case 5:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.Inters = nil
+			case cap(x.Inters) < n0:
+				x.Inters = make([]string, n0)
+			default:
+				x.Inters = x.Inters[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				x.Inters[i0] = d.DecodeString(elem0)
+			}
+		case 6:
+			x.Labels = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *HeartbeatArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(4)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Proxy)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Host)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Port)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.Status)
+	return e.Err()
+}
+
+func (x *HeartbeatArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Proxy = d.DecodeString(field)
+		case 1:
+			x.Host = d.DecodeString(field)
+		case 2:
+			x.Port = d.DecodeString(field)
+		case 3:
+			x.Status = uint8(d.DecodeUint(field))
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *DeregisterArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(3)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Proxy)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Host)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Port)
+	return e.Err()
+}
+
+func (x *DeregisterArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Proxy = d.DecodeString(field)
+		case 1:
+			x.Host = d.DecodeString(field)
+		case 2:
+			x.Port = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *GetProxyArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(2)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Proxy)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Inter)
+	return e.Err()
+}
+
+func (x *GetProxyArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Proxy = d.DecodeString(field)
+		case 1:
+			x.Inter = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *GetProxyReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(2)
+	e.EncodeValue(reflect.ValueOf(x.Srvs))
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(x.Version)
+	return e.Err()
+}
+
+func (x *GetProxyReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			d.DecodeField(field, &x.Srvs)
+		case 1:
+			x.Version = d.DecodeInt(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
@@ -2,13 +2,19 @@ package client
 
 import (
     "fmt"
+    "reflect"
     "sort"
+    "strconv"
     "strings"
     "sync"
     "time"
     "xace/log"
 )
 
+// AceDiscovery discovers the instances of a proxy that serve an interface from the center.
+// It subscribes to them so the center pushes their changes, and polls the center every d
+// while it can't subscribe. The value of a pair is the status of the instance followed by
+// its labels, such as 2&zone=sh-a, so RouteRule matches the labels.
 type AceDiscovery struct {
     proxy       string
     inter       string
@@ -21,16 +27,27 @@ type AceDiscovery struct {
     mu          sync.Mutex
     filter      ServiceDiscoveryFilter
 
+    srvsMu      sync.Mutex
+    srvs        map[string]AceCenterSrvInfo  // by host:port
+    version     int64                        // of srvs in the center
+    subscribed  bool                         // the center pushes the changes after version
+    subscribing bool
+    pending     []*AceCenterNotification     // received while subscribing
+
     stopCh      chan  struct{}
+    closeOnce   sync.Once
 }
 
+// AceDiscoveryFilter keeps the instances whose status is 2, the active ones.
 func AceDiscoveryFilter(kvp *KVPair) bool {
-    return kvp.Value == "2"
+    status, _, _ := strings.Cut(kvp.Value, "&")
+    return status == "2"
 }
 
 func NewAceDiscovery(proxy string, inter string, d time.Duration) (*AceDiscovery, error) {
-    discovery := &AceDiscovery{proxy:proxy,inter:inter, d:d, filter:AceDiscoveryFilter}
+    discovery := &AceDiscovery{proxy:proxy,inter:inter, d:d, filter:AceDiscoveryFilter, stopCh: make(chan struct{})}
     if len(proxy) > 0 {
+        GetAceCenterClient().addDiscovery(discovery)
         discovery.lookup()
         go discovery.watch()
     }
@@ -54,7 +71,9 @@ func (d *AceDiscovery) Clone(servicePath string) (ServiceDiscovery, error) {
 }
 
 func (d *AceDiscovery) SetFilter(filter ServiceDiscoveryFilter) {
+    d.mu.Lock()
     d.filter = filter
+    d.mu.Unlock()
 }
 
 func (d *AceDiscovery) GetServices() []*KVPair {
@@ -85,16 +104,112 @@ func (d *AceDiscovery) RemoveWatcher(ch chan []*KVPair) {
     d.chans = chans
 }
 
+// lookup subscribes to the instances, or gets them if the center can't push them.
 func (d *AceDiscovery) lookup() {
-    var pairs []*KVPair
-    err, srvs := GetAceCenterClient().getProxyInfos(d.proxy,d.inter)
+    center := GetAceCenterClient()
+
+    d.srvsMu.Lock()
+    if d.subscribing {
+        d.srvsMu.Unlock()
+        return
+    }
+    d.subscribing = true
+    d.srvsMu.Unlock()
+
+    srvs, version, err := center.subscribe(d.proxy, d.inter)
+    subscribed := err == nil
+    if !subscribed {
+        log.Debugf("subscribe proxy infos error. %s:%s %v", d.proxy, d.inter, err)
+        err, srvs = center.getProxyInfos(d.proxy,d.inter)
+        version = 0
+    }
+
+    d.srvsMu.Lock()
+    defer d.srvsMu.Unlock()
+    pending := d.pending
+    d.subscribing, d.pending = false, nil
     if err != nil {
         log.Warnf("get proxy infos error. %s:%s",d.proxy,d.inter)
         return
     }
-    for _, info :=range srvs {
-        pair := &KVPair{Key: fmt.Sprintf("ace@%s:%s",info.Host,info.Port),Value: fmt.Sprintf("%d",info.Status)}
-        if d.filter != nil && !d.filter(pair) {
+
+    d.srvs = make(map[string]AceCenterSrvInfo, len(srvs))
+    for i := range srvs {
+        d.srvs[srvKey(&srvs[i])] = srvs[i]
+    }
+    d.version, d.subscribed = version, subscribed
+    d.publishLocked()
+
+    // the changes pushed before the reply arrived
+    for _, n := range pending {
+        if !d.applyLocked(n) {
+            break
+        }
+    }
+}
+
+// apply applies the events pushed by the center.
+func (d *AceDiscovery) apply(n *AceCenterNotification) {
+    d.srvsMu.Lock()
+    defer d.srvsMu.Unlock()
+    if d.subscribing {
+        d.pending = append(d.pending, n)
+        return
+    }
+    if d.subscribed {
+        d.applyLocked(n)
+    }
+}
+
+// applyLocked applies n if it follows the version of the instances. If a change was
+// missed, it gets all instances again and returns false. srvsMu is held.
+func (d *AceDiscovery) applyLocked(n *AceCenterNotification) bool {
+    if n.Version <= d.version {
+        return true
+    }
+    if n.PrevVersion != d.version {
+        log.Infof("missed proxy infos %d-%d of %s:%s", d.version, n.PrevVersion, d.proxy, d.inter)
+        d.subscribed = false
+        go d.lookup()
+        return false
+    }
+    for i := range n.Events {
+        e := &n.Events[i]
+        switch e.Type {
+        case AceCenterAdd, AceCenterUpdate:
+            d.srvs[srvKey(&e.Srv)] = e.Srv
+        case AceCenterRemove:
+            delete(d.srvs, srvKey(&e.Srv))
+        }
+    }
+    d.version = n.Version
+    d.publishLocked()
+    return true
+}
+
+// pushDown makes the discovery poll the center until it subscribes again.
+func (d *AceDiscovery) pushDown() {
+    d.srvsMu.Lock()
+    d.subscribed = false
+    d.srvsMu.Unlock()
+}
+
+// publishLocked sends the pairs of the instances to the watchers if they changed.
+// srvsMu is held.
+func (d *AceDiscovery) publishLocked() {
+    d.mu.Lock()
+    filter := d.filter
+    d.mu.Unlock()
+
+    var pairs []*KVPair
+    for _, info :=range d.srvs {
+        // the value is the status, then the labels RouteRule matches
+        value := strconv.Itoa(int(info.Status))
+        if info.Labels != "" {
+            value += "&" + info.Labels
+        }
+        pair := &KVPair{Key: fmt.Sprintf("ace@%s:%s",info.Host,info.Port),Value: value}
+        if filter != nil && !filter(pair) {
             continue
         }
         pairs = append(pairs, pair)
@@ -107,23 +222,15 @@ func (d *AceDiscovery) lookup() {
     }
 
     d.pairsMu.Lock()
+    if reflect.DeepEqual(d.pairs, pairs) {
+        d.pairsMu.Unlock()
+        return
+    }
     d.pairs = pairs
     d.pairsMu.Unlock()
 
     d.mu.Lock()
-    for _, ch := range d.chans {
-        ch := ch
-        go func() {
-            defer func() {
-                recover()
-            }()
-            select {
-            case ch<- pairs:
-            case <-time.After(time.Minute):
-                log.Warn("chan is full and new change has been dropped")
-            }
-        }()
-    }
+    pushPairs(d.chans, pairs)
     d.mu.Unlock()
 }
 
@@ -136,12 +243,24 @@ func (d *AceDiscovery) watch() {
         case <-d.stopCh:
             return
         case <-tick.C:
-            d.lookup()
+            d.srvsMu.Lock()
+            subscribed := d.subscribed
+            d.srvsMu.Unlock()
+            if !subscribed {
+                d.lookup()
+            }
         }
     }
 }
 
 func (d *AceDiscovery) Close() {
-    close(d.stopCh)
+    d.closeOnce.Do(func() {
+        close(d.stopCh)
+        if len(d.proxy) > 0 {
+            center := GetAceCenterClient()
+            if center.removeDiscovery(d) {
+                go center.unsubscribe(d.proxy, d.inter)
+            }
+        }
+    })
 }
-
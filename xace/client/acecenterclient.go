@@ -4,6 +4,10 @@ import (
     "time"
     "sync"
     "context"
+    "errors"
+    "fmt"
+    "net"
+    "xace/codec"
     "xace/log"
     "xace/protocol"
 )
@@ -13,8 +17,37 @@ type AceCenterSrvInfo struct {
     Host    string
     Port    string
     Status  uint8
+    // Labels of the instance as a query, such as zone=sh-a&version=v2, for RouteRule.
+    // Centers that don't know them leave it empty.
+    Labels  string
 }
 
+// The types of AceCenterEvent.
+const (
+    AceCenterAdd    uint8 = 1
+    AceCenterRemove uint8 = 2
+    AceCenterUpdate uint8 = 3
+)
+
+// AceCenterEvent is a change of an instance of a proxy.
+type AceCenterEvent struct {
+    Type    uint8
+    Srv     AceCenterSrvInfo
+}
+
+// AceCenterNotification is the payload of the notify messages the center pushes to the
+// subscribers of a proxy. Its events change the instances of PrevVersion to Version,
+// a subscriber that has another version missed a change.
+type AceCenterNotification struct {
+    Proxy       string
+    Inter       string
+    Version     int64
+    PrevVersion int64
+    Events      []AceCenterEvent
+}
+
+var errNoAceCenter = errors.New("ace center is not initialized")
+
 
 type AceCenterClient struct {
     domain      string
@@ -22,6 +55,9 @@ type AceCenterClient struct {
 	xclient     XClient
 
     msgchan chan *protocol.Message
+
+    mu          sync.Mutex
+    discoveries map[string]map[*AceDiscovery]struct{}  // by proxy.inter
 }
 
 var sync_center sync.Once
@@ -29,7 +65,7 @@ var centerclient *AceCenterClient
 
 func GetAceCenterClient() *AceCenterClient {
     sync_center.Do(func() {
-        centerclient = &AceCenterClient{}
+        centerclient = &AceCenterClient{discoveries: make(map[string]map[*AceDiscovery]struct{})}
         centerclient.msgchan = make(chan *protocol.Message, 10)
         go centerclient.handle()
     })
@@ -43,10 +79,109 @@ func AceGetProxyInfos(proxy, inter string) (error, []AceCenterSrvInfo) {
 
 func (c *AceCenterClient) handle() {
     for msg :=range c.msgchan {
+        if msg.MessageStatusType() == protocol.Error {
+            // a connection to the center closed with the subscriptions on it
+            log.Warnf("acecenterclient lost the connection to %s, poll the center", msg.Metadata["server"])
+            c.pushDown()
+            continue
+        }
+        if msg.ServicePath == "AaceCenter" && msg.ServiceMethod == "notify" {
+            var n AceCenterNotification
+            // the notification is the only parameter
+            if err := codec.DecodeArgs(msg.Payload, []any{&n}); err != nil {
+                log.Warnf("acecenterclient failed to decode notify. %v", err)
+                continue
+            }
+            c.notify(&n)
+            continue
+        }
         log.Debugf("acecenterclient recv packet. %s:%s", msg.ServicePath,msg.ServiceMethod)
     }
 }
 
+func (c *AceCenterClient) addDiscovery(d *AceDiscovery) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    key := d.proxy + "." + d.inter
+    if c.discoveries[key] == nil {
+        c.discoveries[key] = make(map[*AceDiscovery]struct{})
+    }
+    c.discoveries[key][d] = struct{}{}
+}
+
+// removeDiscovery removes d, it returns whether it was the last one of its proxy and inter.
+func (c *AceCenterClient) removeDiscovery(d *AceDiscovery) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    key := d.proxy + "." + d.inter
+    delete(c.discoveries[key], d)
+    if len(c.discoveries[key]) == 0 {
+        delete(c.discoveries, key)
+        return true
+    }
+    return false
+}
+
+func (c *AceCenterClient) notify(n *AceCenterNotification) {
+    c.mu.Lock()
+    var ds []*AceDiscovery
+    for d := range c.discoveries[n.Proxy + "." + n.Inter] {
+        ds = append(ds, d)
+    }
+    c.mu.Unlock()
+    for _, d := range ds {
+        d.apply(n)
+    }
+}
+
+func (c *AceCenterClient) pushDown() {
+    c.mu.Lock()
+    var ds []*AceDiscovery
+    for _, m := range c.discoveries {
+        for d := range m {
+            ds = append(ds, d)
+        }
+    }
+    c.mu.Unlock()
+    for _, d := range ds {
+        d.pushDown()
+    }
+}
+
+// subscribe returns the instances of proxy that serve inter and their version, the center
+// pushes their changes to this client until the connection closes.
+func (c *AceCenterClient) subscribe(proxy, inter string) (srvs []AceCenterSrvInfo, version int64, err error) {
+    if c.xclient == nil {
+        return nil, 0, errNoAceCenter
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+
+    srvs = make([]AceCenterSrvInfo, 0, 3)
+    reply := &protocol.AceReply{Args: []any{&srvs, &version}}
+    err = c.xclient.Call(ctx, "subscribe", []any{proxy, inter}, reply)
+    if err == nil && reply.Retcode != 0 {
+        err = fmt.Errorf("ace center subscribe retcode %d", reply.Retcode)
+    }
+    return srvs, version, err
+}
+
+func (c *AceCenterClient) unsubscribe(proxy, inter string) {
+    if c.xclient == nil {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+    defer cancel()
+    if err := c.xclient.Call(ctx, "unsubscribe", []any{proxy, inter}, []any{}); err != nil {
+        log.Debugf("acecenterclient unsubscribe %s:%s. %v", proxy, inter, err)
+    }
+}
+
+func srvKey(info *AceCenterSrvInfo) string {
+    return net.JoinHostPort(info.Host, info.Port)
+}
+
 func (c *AceCenterClient) getProxyInfos(proxy, inter string) (error, []AceCenterSrvInfo) {
     srvs := make([]AceCenterSrvInfo, 0, 3)
     if c.xclient == nil {
@@ -85,19 +220,30 @@ func (c *Client) getProxyInfos(proxy, inter string) (error, []AceCenterSrvInfo)
     return nil, srvs
 }
 
+// generateAceCenterClient connects to the center, retrying with backoff for up to wait.
+// The client reconnects by itself when its connection breaks, its calls wait for it.
 func generateAceCenterClient(domain string, wait time.Duration) *Client {
-    curtime := time.Now().UnixMilli()
+    option := AceOption
+    option.Reconnect = true
+    option.ReconnectQueue = true
+
+    start := time.Now()
+    backoff := defaultReconnectBackoff
     for {
-        client := NewClient(AceOption)
+        client := NewClient(option)
         err := client.Connect("tcp",domain)
-        if err == nil && client != nil {
+        if err == nil {
             return client
         }
-        if wait <= 0 || (time.Now().UnixMilli()-curtime) > wait.Milliseconds() {
+        if wait <= 0 || time.Since(start) > wait {
             log.Error("generate center client time out. ", err)
             break
         }
         log.Warn("generate center client. ", err)
+        time.Sleep(jitter(backoff))
+        if backoff *= 2; backoff > defaultMaxReconnectBackoff {
+            backoff = defaultMaxReconnectBackoff
+        }
     }
     return nil
 }
@@ -110,6 +256,9 @@ func InitializeAceCenter(domain string, proxy string) {
     center.domain = domain
 
     client := generateAceCenterClient(domain, 0*time.Second)
+    if client == nil {
+        return
+    }
     addr := client.RemoteAddr()
 
     InitAceClientBuilder()
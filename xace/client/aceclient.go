@@ -23,6 +23,7 @@ type AceClient struct {
 	option     Option
 
 	selectors map[string]Selector
+	routes    map[string]*RouteRule
 	Plugins   PluginContainer
 	latitude  float64
 	longitude float64
@@ -70,6 +71,43 @@ func (c *AceClient) SetSelector(servicePath string, s Selector) {
 	c.mu.Unlock()
 }
 
+// SetRouteRule routes the calls of servicePath by rule, nil removes its rule. It applies
+// at once if the service is called already. The rule isn't copied, set a new one to change it.
+func (c *AceClient) SetRouteRule(servicePath string, rule *RouteRule) {
+	c.mu.Lock()
+	if c.routes == nil {
+		c.routes = make(map[string]*RouteRule)
+	}
+	if rule == nil {
+		delete(c.routes, servicePath)
+	} else {
+		c.routes[servicePath] = rule
+	}
+	if xclient := c.xclients[servicePath]; xclient != nil {
+		xclient.SetRouteRule(rule)
+	}
+	c.mu.Unlock()
+}
+
+// SetRouteRules replaces the route rules of all services, such as when they are reloaded.
+func (c *AceClient) SetRouteRules(rules map[string]*RouteRule) {
+	c.mu.Lock()
+	old := c.routes
+	c.routes = make(map[string]*RouteRule, len(rules))
+	for servicePath, rule := range rules {
+		if rule != nil {
+			c.routes[servicePath] = rule
+		}
+	}
+	for servicePath, xclient := range c.xclients {
+		rule := c.routes[servicePath]
+		if xclient != nil && (rule != nil || old[servicePath] != nil) {
+			xclient.SetRouteRule(rule)
+		}
+	}
+	c.mu.Unlock()
+}
+
 // SetPlugins sets client's plugins.
 func (c *AceClient) SetPlugins(plugins PluginContainer) {
 	c.Plugins = plugins
@@ -84,6 +122,14 @@ func (c *AceClient) GetPlugins() PluginContainer {
 	return c.Plugins
 }
 
+// SetDiscovery sets the discovery the services are cloned from, such as a RegistryDiscovery
+// instead of the AaceCenter. It applies to the services not called yet.
+func (c *AceClient) SetDiscovery(d ServiceDiscovery) {
+	c.mu.Lock()
+	c.discovery = d
+	c.mu.Unlock()
+}
+
 func (c *AceClient) newXClient(servicePath string) (xclient XClient, err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -115,6 +161,10 @@ func (c *AceClient) newXClient(servicePath string) (xclient XClient, err error)
 		xclient.SetSelector(s)
 	}
 
+	if rule, ok := c.routes[servicePath]; ok {
+		xclient.SetRouteRule(rule)
+	}
+
 	if c.selectMode == Closest {
 		xclient.ConfigGeoSelector(c.latitude, c.longitude)
 	}
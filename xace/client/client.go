@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"io"
+	"math"
 	"net"
 	"net/url"
 	"strconv"
@@ -51,6 +52,34 @@ func (s strErr) IsServiceError() bool {
 	return true
 }
 
+// shedError is the ServiceError of a request the server rejected for its load, see
+// protocol.ShedKey.
+type shedError struct {
+	ServiceError
+}
+
+func (e shedError) Unwrap() error {
+	return e.ServiceError
+}
+
+// replyDecodeError is returned when the reply can't be decoded. It is a ServiceError
+// because calling again gets the same reply, and it wraps the *codec.DecodeError.
+type replyDecodeError struct {
+	err error
+}
+
+func (e replyDecodeError) Error() string {
+	return "failed to decode reply: " + e.err.Error()
+}
+
+func (e replyDecodeError) IsServiceError() bool {
+	return true
+}
+
+func (e replyDecodeError) Unwrap() error {
+	return e.err
+}
+
 // DefaultOption is a common option configuration for client.
 var DefaultOption = Option{
 	Retries:             3,
@@ -58,6 +87,7 @@ var DefaultOption = Option{
 	ConnectTimeout:      time.Second,
 	SerializeType:       protocol.AcePack,
 	CompressType:        protocol.None,
+	CompressThreshold:   protocol.DefaultCompressThreshold,
 	BackupLatency:       10 * time.Millisecond,
 	MaxWaitForHeartbeat: 30 * time.Second,
 	TCPKeepAlivePeriod:  time.Minute,
@@ -70,6 +100,7 @@ var AceOption = Option{
 	ConnectTimeout:      time.Second,
 	SerializeType:       protocol.AcePack,
 	CompressType:        protocol.None,
+	CompressThreshold:   protocol.DefaultCompressThreshold,
 	BackupLatency:       10 * time.Millisecond,
 	MaxWaitForHeartbeat: 30 * time.Second,
 	TCPKeepAlivePeriod:  time.Minute,
@@ -93,6 +124,7 @@ var CircuitBreaker Breaker = NewConsecCircuitBreaker(10, 2000) //circuit.NewRate
 // ErrShutdown connection is closed.
 var (
 	ErrShutdown         = errors.New("connection is shut down")
+	ErrReconnecting     = errors.New("connection is reconnecting")
 	ErrUnsupportedCodec = errors.New("unsupported codec")
 )
 
@@ -111,6 +143,7 @@ type RPCClient interface {
 	Go(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call
 	Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error
 	SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error)
+	NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*ClientStream, error)
 	Close() error
 	RemoteAddr() string
 
@@ -119,6 +152,7 @@ type RPCClient interface {
 
 	IsClosing() bool
 	IsShutdown() bool
+	IsDraining() bool
 
 	GetConn() net.Conn
 }
@@ -131,12 +165,22 @@ type Client struct {
 	r    *bufio.Reader
 	// w    *bufio.Writer
 
+	network string
+	address string
+
 	mutex        sync.Mutex // protects following
 	seq          uint64
 	pending      map[uint64]*Call
+	streams      map[uint64]*ClientStream
 	closing      bool // user has called Close
 	shutdown     bool // server has told us to stop
+	draining     bool // server has sent GOAWAY, no new calls
+	restarting   bool // the GOAWAY said a new process serves the address
 	pluginClosed bool // the plugin has been called
+	reconnecting bool // the connection broke and Option.Reconnect dials again
+	reconnected  chan struct{}
+
+	rtt ewma // latency of the calls and raw messages, for the load selectors
 
 	Plugins PluginContainer
 
@@ -152,11 +196,15 @@ func NewClient(option Option) *Client {
 
 // RemoteAddr returns the remote address.
 func (client *Client) RemoteAddr() string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	return client.Conn.RemoteAddr().String()
 }
 
 // GetConn returns the underlying conn.
 func (client *Client) GetConn() net.Conn {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
 	return client.Conn
 }
 
@@ -178,6 +226,24 @@ type Option struct {
 
 	// Retries retries to send
 	Retries int
+	// RetryBudget caps the retries of Failtry and Failover at this fraction of the calls
+	// of the last 10 seconds, 0 doesn't cap them. Set it with MinRetriesPerSecond and
+	// RetryBackoff, such as 0.1, 10 and 10ms, so the retries of an outage don't pile up.
+	RetryBudget float64
+	// MinRetriesPerSecond are allowed beside RetryBudget, so clients of few calls can retry.
+	MinRetriesPerSecond int
+	// RetryBackoff is the base of the exponential backoff between retries, the nth retry
+	// waits a random time up to RetryBackoff<<(n-1). 0 retries at once.
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the backoff, a second if 0.
+	MaxRetryBackoff time.Duration
+
+	// ThrottleK enables the adaptive throttling of Google SRE if it is positive. Calls
+	// are rejected with ErrThrottled by the client itself once the servers accept fewer
+	// than 1/ThrottleK of its requests, 2 is usual.
+	ThrottleK float64
+	// ThrottleWindow is how long the requests are counted, 2 minutes if 0.
+	ThrottleWindow time.Duration
 
 	// TLSConfig for tcp and quic
 	TLSConfig *tls.Config
@@ -190,14 +256,33 @@ type Option struct {
 	// IdleTimeout sets max idle time for underlying net.Conns
 	IdleTimeout time.Duration
 
+	// PoolSize is how many connections are opened to each server at most, 1 if 0. The
+	// connections are added when the calls in flight grow, see PoolGrowPending, and
+	// closed after IdleTimeout without calls but one.
+	PoolSize int
+	// PoolGrowPending is how many calls in flight the connection a call takes may have
+	// before another one is opened, 16 if 0.
+	PoolGrowPending int
+	// PoolRoundRobin takes the connections of a pool in turn, by default a call takes
+	// the one with the fewest calls in flight.
+	PoolRoundRobin bool
+
 	// BackupLatency is used for Failbackup mode. rpcx will sends another request if the first response doesn't return in BackupLatency time.
 	BackupLatency time.Duration
+	// Hedge makes Failbackup hedge only the idempotent methods, after their observed
+	// latency, see HedgePolicy. Without it every call sends one backup.
+	Hedge *HedgePolicy
 
 	// Breaker is used to config CircuitBreaker
 	GenBreaker func() Breaker
 
+	// SerializeType and CompressType other than AcePack and None are sent in the
+	// header ext, only servers that read it understand them. Upgrade the servers first.
 	SerializeType protocol.SerializeType
 	CompressType  protocol.CompressType
+	// CompressThreshold is the min size of the payloads CompressType compresses,
+	// smaller ones are sent as they are.
+	CompressThreshold int
 
 	// send heartbeat message to service and check responses
 	Heartbeat bool
@@ -207,8 +292,24 @@ type Option struct {
 
 	// TCPKeepAlive, if it is zero we don't set keepalive
 	TCPKeepAlivePeriod time.Duration
+
+	// Reconnect makes a Client dial again when its connection breaks instead of shutting
+	// down. The calls in flight fail, the calls made while it reconnects fail with
+	// ErrReconnecting unless ReconnectQueue.
+	Reconnect bool
+	// ReconnectBackoff is the wait before the first dial, it doubles after each failed
+	// one up to MaxReconnectBackoff, with jitter. 100ms and 30s if 0.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+	// ReconnectQueue makes the calls made while the client reconnects wait for the
+	// connection or the end of their contexts.
+	ReconnectQueue bool
 	// bidirectional mode, if true serverMessageChan will block to wait message for consume. default false.
 	BidirectionalBlock bool
+
+	// StreamWindow is how many messages the server can send on a stream before they are read.
+	// 0 means protocol.DefaultStreamWindow.
+	StreamWindow int
 }
 
 // Call represents an active RPC.
@@ -222,9 +323,15 @@ type Call struct {
 	Error         error       // After completion, the error status.
 	Done          chan *Call  // Strobes when call is complete.
 	Raw           bool        // raw message or not
+
+	start time.Time
+	rtt   *ewma // the latency of the client the call is observed in, nil if none
 }
 
 func (call *Call) done() {
+	if call.rtt != nil {
+		call.rtt.observe(time.Since(call.start), call.Error)
+	}
 	select {
 	case call.Done <- call:
 		// ok
@@ -251,6 +358,29 @@ func (client *Client) IsClosing() bool {
 	return client.closing
 }
 
+// IsDraining reports whether the server is shutting down. The calls in flight still
+// complete, new calls should go to other servers.
+func (client *Client) IsDraining() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.draining
+}
+
+// IsRestarting reports whether a new process of the draining server took over its
+// address, so a new connection to it reaches the new process.
+func (client *Client) IsRestarting() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.restarting
+}
+
+// pendingCalls returns how many calls wait for their responses.
+func (client *Client) pendingCalls() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return len(client.pending)
+}
+
 // IsShutdown client is shutdown or not.
 func (client *Client) IsShutdown() bool {
 	client.mutex.Lock()
@@ -303,7 +433,9 @@ func (client *Client) Call(ctx context.Context, servicePath, serviceMethod strin
 }
 
 func (client *Client) call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error {
+	// no pending call has this seq until send sets it
 	seq := new(uint64)
+	*seq = math.MaxUint64
 	ctx = context.WithValue(ctx, seqKey{}, seq)
 
 	if share.Trace {
@@ -325,6 +457,9 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 		if call != nil {
 			call.Error = ctx.Err()
 			call.done()
+			if call.Metadata[protocol.CancelableKey] != "" {
+				client.cancel(*seq)
+			}
 		}
 
 		return ctx.Err()
@@ -340,14 +475,14 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 				for k, v := range call.ResMetadata {
 					resMeta[k] = v
 				}
-				resMeta[share.ServerAddress] = client.Conn.RemoteAddr().String()
+				resMeta[share.ServerAddress] = client.RemoteAddr()
 				locker.Unlock()
 
 			} else {
 				for k, v := range call.ResMetadata {
 					resMeta[k] = v
 				}
-				resMeta[share.ServerAddress] = client.Conn.RemoteAddr().String()
+				resMeta[share.ServerAddress] = client.RemoteAddr()
 			}
 		}
 	}
@@ -355,6 +490,16 @@ func (client *Client) call(ctx context.Context, servicePath, serviceMethod strin
 	return err
 }
 
+// cancel tells the server to abandon the request of seq.
+func (client *Client) cancel(seq uint64) {
+	msg := protocol.NewMessage()
+	msg.SetMessageType(protocol.Cancel)
+	msg.SetSeq(seq)
+	if err := client.writeMessage(msg); err != nil {
+		log.Debugf("failed to cancel request %d: %v", seq, err)
+	}
+}
+
 // SendRaw sends raw messages. You don't care args and replies.
 func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
 	ctx = context.WithValue(ctx, seqKey{}, r.Seq())
@@ -394,15 +539,25 @@ func (client *Client) SendRaw(ctx context.Context, r *protocol.Message) (map[str
 
 	seq := r.Seq()
 	client.mutex.Lock()
+	conn, err := client.connLocked(ctx)
+	if err != nil {
+		client.mutex.Unlock()
+		return nil, nil, err
+	}
 	if client.pending == nil {
 		client.pending = make(map[uint64]*Call)
 	}
+	if !r.IsOneway() {
+		call.start, call.rtt = time.Now(), &client.rtt
+	}
 	client.pending[seq] = call
 	client.mutex.Unlock()
 
-	data := r.EncodeSlicePointer()
-	_, err := client.Conn.Write(*data)
-	protocol.PutData(data)
+	data, err := r.EncodeSlicePointer()
+	if err == nil {
+		_, err = conn.Write(*data)
+		protocol.PutData(data)
+	}
 
 	if err != nil {
 		client.mutex.Lock()
@@ -498,8 +653,9 @@ func urlencode(data map[string]string) string {
 func (client *Client) send(ctx context.Context, call *Call) {
 	// Register this call.
 	client.mutex.Lock()
-	if client.shutdown || client.closing {
-		call.Error = ErrShutdown
+	conn, err := client.connLocked(ctx)
+	if err != nil {
+		call.Error = err
 		client.mutex.Unlock()
 		call.done()
 		return
@@ -523,6 +679,9 @@ func (client *Client) send(ctx context.Context, call *Call) {
 
 	seq := client.seq
 	client.seq++
+	if call.Reply != nil && !isHeartbeat {
+		call.start, call.rtt = time.Now(), &client.rtt
+	}
 	client.pending[seq] = call
 	client.mutex.Unlock()
 
@@ -562,8 +721,18 @@ func (client *Client) send(ctx context.Context, call *Call) {
 		call.done()
 		return
 	}
-	if len(data) > 1024 && client.option.CompressType != protocol.None {
-		req.SetCompressType(client.option.CompressType)
+	if client.option.CompressType != protocol.None {
+		if _, ok := protocol.Compressors[client.option.CompressType]; !ok {
+			client.mutex.Lock()
+			delete(client.pending, seq)
+			client.mutex.Unlock()
+			call.Error = protocol.ErrUnsupportedCompressor
+			call.done()
+			return
+		}
+		if len(data) >= client.option.CompressThreshold {
+			req.SetCompressType(client.option.CompressType)
+		}
 	}
 
 	req.Payload = data
@@ -575,9 +744,11 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	if share.Trace {
 		log.Debugf("client.send for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
 	}
-	allData := req.EncodeSlicePointer()
-	_, err = client.Conn.Write(*allData)
-	protocol.PutData(allData)
+	allData, err := req.EncodeSlicePointer()
+	if err == nil {
+		_, err = conn.Write(*allData)
+		protocol.PutData(allData)
+	}
 	if share.Trace {
 		log.Debugf("client.sent for %s.%s, args: %+v in case of client call", call.ServicePath, call.ServiceMethod, call.Args)
 	}
@@ -609,7 +780,7 @@ func (client *Client) send(ctx context.Context, call *Call) {
 	}
 
 	if client.option.IdleTimeout != 0 {
-		_ = client.Conn.SetDeadline(time.Now().Add(client.option.IdleTimeout))
+		_ = conn.SetDeadline(time.Now().Add(client.option.IdleTimeout))
 	}
 }
 
@@ -631,6 +802,10 @@ func (client *Client) input() {
 		}
         DTest()
 
+		if client.handleStreamMessage(res) {
+			continue
+		}
+
 		seq := res.Seq()
 		var call *Call
 		//isServerMessage := (res.MessageType() == protocol.Request && !res.IsHeartbeat() && res.IsOneway())
@@ -650,6 +825,14 @@ func (client *Client) input() {
 		switch {
 		case call == nil:
 			if !isServerMessage {
+				if res.IsGoAway() {
+					client.mutex.Lock()
+					client.draining = true
+					client.restarting = res.Metadata[protocol.GoAwayRestartKey] != ""
+					client.mutex.Unlock()
+					log.Infof("server %s is shutting down, draining the connection", client.Conn.RemoteAddr())
+					continue
+				}
 				if client.ServerMessageChan != nil {
 					client.handleServerRequest(res)
 				}
@@ -661,11 +844,16 @@ func (client *Client) input() {
 				call.ResMetadata = res.Metadata
 
 				// convert server error to a customized error, which implements ServerError interface
+				var se ServiceError
 				if ClientErrorFunc != nil {
-					call.Error = ClientErrorFunc(res.Metadata[protocol.ServiceError])
+					se = ClientErrorFunc(res.Metadata[protocol.ServiceError])
 				} else {
-					call.Error = strErr(res.Metadata[protocol.ServiceError])
+					se = strErr(res.Metadata[protocol.ServiceError])
+				}
+				if se != nil && res.Metadata[protocol.ShedKey] != "" {
+					se = shedError{se}
 				}
+				call.Error = se
 
 			}
 
@@ -682,6 +870,9 @@ func (client *Client) input() {
                         acereply.Retcode = res.Retcode
 					    _ = codec.Decode(data, acereply.Args)
                     } else {
+                        if rr, ok := call.Reply.(protocol.RetcodeReply); ok {
+                            rr.SetRetcode(res.Retcode)
+                        }
 					    _ = codec.Decode(data, call.Reply)
                     }
 				}
@@ -695,20 +886,24 @@ func (client *Client) input() {
                 acereply, arok := call.Reply.(*protocol.AceReply)
                 if arok {
                     acereply.Retcode = res.Retcode
+                } else if rr, ok := call.Reply.(protocol.RetcodeReply); ok {
+                    rr.SetRetcode(res.Retcode)
                 }
 				data := res.Payload
-				if len(data) > 0 && res.Retcode != -90006 {
+				// a oneway call, such as a heartbeat, may be answered before send forgets it
+				if len(data) > 0 && res.Retcode != -90006 && call.Reply != nil {
 					codec := share.Codecs[res.SerializeType()]
 					if codec == nil {
 						call.Error = strErr(ErrUnsupportedCodec.Error())
 					} else {
+                        var derr error
                         if arok {
-                            err = codec.Decode(data, acereply.Args)
+                            derr = codec.Decode(data, acereply.Args)
                         } else {
-						    err = codec.Decode(data, call.Reply)
+						    derr = codec.Decode(data, call.Reply)
                         }
-						if err != nil {
-							call.Error = strErr(err.Error())
+						if derr != nil {
+							call.Error = replyDecodeError{derr}
 						}
 					}
 				}
@@ -745,8 +940,14 @@ func (client *Client) input() {
 		client.pluginClosed = true
 	}
 	client.Conn.Close()
-	client.shutdown = true
 	closing := client.closing
+	reconnect := client.option.Reconnect && !closing
+	if reconnect {
+		client.reconnecting = true
+		client.reconnected = make(chan struct{})
+	} else {
+		client.shutdown = true
+	}
 	if err == io.EOF {
 		if closing {
 			err = ErrShutdown
@@ -754,16 +955,21 @@ func (client *Client) input() {
 			err = io.ErrUnexpectedEOF
 		}
 	}
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
 		call.done()
 	}
+	client.closeStreams(err)
 
 	client.mutex.Unlock()
 
 	if err != nil && !closing {
 		log.Errorf("rpcx: client protocol error: %v", err)
 	}
+	if reconnect {
+		go client.reconnect()
+	}
 }
 
 func (client *Client) handleServerRequest(msg *protocol.Message) {
@@ -804,13 +1010,17 @@ func (client *Client) heartbeat() {
 			t.Stop()
 			return
 		}
+		if client.isReconnecting() {
+			continue
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), client.option.MaxWaitForHeartbeat)
         call := client.Go(ctx, "AaceCheck", "check", args, nil, nil)
         cancel()
 
-        if call.Error != nil {
-			log.Warnf("failed to heartbeat to %s: %v", client.Conn.RemoteAddr().String(), call.Error)
+        // a heartbeat made while reconnecting fails with the old connection
+        if call.Error != nil && !client.isReconnecting() {
+			log.Warnf("failed to heartbeat to %s: %v", client.RemoteAddr(), call.Error)
 			client.Close()
         }
 
@@ -859,6 +1069,7 @@ func (client *Client) Close() error {
 			call.done()
 		}
 	}
+	client.closeStreams(ErrShutdown)
 
 	var err error
 	if !client.pluginClosed {
@@ -876,6 +1087,10 @@ func (client *Client) Close() error {
 	}
 
 	client.closing = true
+	if client.reconnecting {
+		// wake the queued calls and stop dialing
+		close(client.reconnected)
+	}
 	client.mutex.Unlock()
 	return err
 }
@@ -28,6 +28,28 @@ var ConnFactories = map[string]ConnFactoryFn{
 
 // Connect connects the server via specified network.
 func (client *Client) Connect(network, address string) error {
+	client.network, client.address = network, address
+
+	conn, err := client.dial(network, address)
+	if err != nil {
+		return err
+	}
+
+	client.Conn = conn
+	client.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+	// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
+
+	// start reading and writing since connected
+	go client.input()
+
+	if client.option.Heartbeat && client.option.HeartbeatInterval > 0 {
+		go client.heartbeat()
+	}
+	return nil
+}
+
+// dial opens a connection to the server and runs the ConnCreated plugins.
+func (client *Client) dial(network, address string) (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
@@ -50,7 +72,7 @@ func (client *Client) Connect(network, address string) error {
 		conn, err = newDirectConn(client, "tcp", address)
 	default:
         log.Warnf("failed network: %s to dial server.", network)
-        return errors.New("won't supoort network")
+        return nil, errors.New("won't supoort network")
 	}
 
 	if err == nil && conn != nil {
@@ -66,28 +88,16 @@ func (client *Client) Connect(network, address string) error {
 		if client.Plugins != nil {
 			conn, err = client.Plugins.DoConnCreated(conn)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
-
-		client.Conn = conn
-		client.r = bufio.NewReaderSize(conn, ReaderBuffsize)
-		// c.w = bufio.NewWriterSize(conn, WriterBuffsize)
-
-		// start reading and writing since connected
-		go client.input()
-
-		if client.option.Heartbeat && client.option.HeartbeatInterval > 0 {
-			go client.heartbeat()
-		}
-
 	}
 
 	if err != nil && client.Plugins != nil {
 		client.Plugins.DoConnCreateFailed(network, address)
 	}
 
-	return err
+	return conn, err
 }
 
 func newDirectConn(c *Client, network, address string) (net.Conn, error) {
@@ -0,0 +1,373 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/edwingeng/doublejump"
+
+	"xace/share"
+)
+
+// HashAlgorithm is how a consistent hash maps keys to servers.
+type HashAlgorithm int
+
+const (
+	// JumpHash is the jump consistent hash of ConsistentHash. It needs no memory but
+	// a removed server moves keys of the others.
+	JumpHash HashAlgorithm = iota
+	// RingHash places Replicas points of each server on a ring, a key goes to the next
+	// point. Only the keys of an added or removed server move.
+	RingHash
+	// MaglevHash fills a lookup table of TableSize slots evenly with the servers, like
+	// Google's Maglev. Lookups are O(1) and few keys of other servers move.
+	MaglevHash
+)
+
+const (
+	defaultHashReplicas    = 160
+	defaultMaglevTableSize = 65537 // a prime
+)
+
+// ConsistentHashConfig configures the ConsistentHash selection of a XClient.
+type ConsistentHashConfig struct {
+	Algorithm HashAlgorithm
+	// Replicas are the points of a server on the ring of RingHash, 160 if 0.
+	Replicas int
+	// TableSize is the slots of the table of MaglevHash, much more than the servers.
+	// It is rounded up to a prime, 65537 if 0.
+	TableSize int
+
+	// MetadataKey takes the hash key from the request metadata, a user or tenant ID
+	// keeps going to the same server for any method. Calls without it hash like
+	// ConsistentHash, the service, method and args.
+	MetadataKey string
+	// KeyFunc returns the hash key of a call, it is used before MetadataKey. An empty
+	// key hashes like ConsistentHash.
+	KeyFunc func(ctx context.Context, servicePath, serviceMethod string, args interface{}) string
+
+	// LoadFactor bounds the load of the servers if > 0: a server with more than
+	// 1+LoadFactor times the average of the pending calls passes its keys on to the
+	// next servers of the key, until its load goes down.
+	LoadFactor float64
+}
+
+// ConfigConsistentHash selects servers by a consistent hash of cfg.
+func (c *xClient) ConfigConsistentHash(cfg ConsistentHashConfig) {
+	c.mu.Lock()
+	s := newHashSelector(c.routeLocked(c.servers), cfg)
+	s.setLoad(c.load)
+	c.selector = s
+	c.selectMode = ConsistentHash
+	c.mu.Unlock()
+}
+
+// hashTable maps hash keys to servers.
+type hashTable interface {
+	// walk calls f with the servers of key in order of preference, until f returns
+	// false or every server was given.
+	walk(key uint64, f func(server string) bool)
+}
+
+// hashSelector is the configured consistentHashSelector.
+type hashSelector struct {
+	cfg     ConsistentHashConfig
+	table   hashTable
+	servers []string
+	load    loadFunc
+}
+
+func newHashSelector(servers map[string]string, cfg ConsistentHashConfig) *hashSelector {
+	s := &hashSelector{cfg: cfg}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *hashSelector) setLoad(load loadFunc) {
+	s.load = load
+}
+
+// key returns the hash key of a call.
+func (s *hashSelector) key(ctx context.Context, servicePath, serviceMethod string, args interface{}) uint64 {
+	if s.cfg.KeyFunc != nil {
+		if k := s.cfg.KeyFunc(ctx, servicePath, serviceMethod, args); k != "" {
+			return HashString(k)
+		}
+	}
+	if s.cfg.MetadataKey != "" {
+		if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+			if k := meta[s.cfg.MetadataKey]; k != "" {
+				return HashString(k)
+			}
+		}
+	}
+	return genKey(servicePath, serviceMethod, args)
+}
+
+func (s *hashSelector) Select(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+	if len(s.servers) == 0 {
+		return ""
+	}
+	key := s.key(ctx, servicePath, serviceMethod, args)
+
+	var selected string
+	if s.cfg.LoadFactor <= 0 || s.load == nil {
+		s.table.walk(key, func(server string) bool {
+			selected = server
+			return false
+		})
+		return selected
+	}
+
+	// bounded loads: the first server of the key under the bound of the average
+	pending := func(server string) int {
+		if l := s.load(server, servicePath, serviceMethod); l != nil {
+			return l.pendingCalls()
+		}
+		return 0
+	}
+	loads := make(map[string]int, len(s.servers))
+	total := 0
+	for _, server := range s.servers {
+		n := pending(server)
+		loads[server] = n
+		total += n
+	}
+	bound := int(math.Ceil((1 + s.cfg.LoadFactor) * float64(total+1) / float64(len(s.servers))))
+	s.table.walk(key, func(server string) bool {
+		if selected == "" {
+			selected = server // all of them are full, which the bound prevents
+		}
+		if loads[server]+1 <= bound {
+			selected = server
+			return false
+		}
+		return true
+	})
+	return selected
+}
+
+func (s *hashSelector) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+	}
+	sort.Strings(ss)
+
+	switch s.cfg.Algorithm {
+	case RingHash:
+		s.table = newRingTable(ss, s.cfg.Replicas)
+	case MaglevHash:
+		s.table = newMaglevTable(ss, s.cfg.TableSize)
+	default:
+		s.table = newJumpTable(ss)
+	}
+	s.servers = ss
+}
+
+// jumpTable walks jump consistent hashes of the key hashed again.
+type jumpTable struct {
+	h       *doublejump.Hash
+	servers []string
+}
+
+func newJumpTable(servers []string) *jumpTable {
+	h := doublejump.NewHash()
+	for _, k := range servers {
+		h.Add(k)
+	}
+	return &jumpTable{h: h, servers: servers}
+}
+
+func (t *jumpTable) walk(key uint64, f func(server string) bool) {
+	var seen map[string]bool
+	for i := 0; i < 2*len(t.servers) && len(seen) < len(t.servers); i++ {
+		server, _ := t.h.Get(key).(string)
+		if server != "" && !seen[server] {
+			if !f(server) {
+				return
+			}
+			if seen == nil {
+				seen = make(map[string]bool, len(t.servers))
+			}
+			seen[server] = true
+		}
+		key = key*2862933555777941757 + 1
+	}
+	// the hashes missed some, give them in order
+	for _, server := range t.servers {
+		if !seen[server] && !f(server) {
+			return
+		}
+	}
+}
+
+// nextPrime returns the smallest prime >= n.
+func nextPrime(n int) int {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; ; n += 2 {
+		prime := true
+		for d := 3; d*d <= n; d += 2 {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// mix64 spreads the bits of a FNV hash, the hashes of similar strings are close.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// ringTable is a hash ring of the points of the servers.
+type ringTable struct {
+	hashes  []uint64
+	servers []string // of hashes
+	n       int
+}
+
+func newRingTable(servers []string, replicas int) *ringTable {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+	type point struct {
+		hash   uint64
+		server string
+	}
+	points := make([]point, 0, len(servers)*replicas)
+	for _, k := range servers {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{mix64(HashString(k + "#" + strconv.Itoa(i))), k})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	t := &ringTable{
+		hashes:  make([]uint64, len(points)),
+		servers: make([]string, len(points)),
+		n:       len(servers),
+	}
+	for i, p := range points {
+		t.hashes[i], t.servers[i] = p.hash, p.server
+	}
+	return t
+}
+
+func (t *ringTable) walk(key uint64, f func(server string) bool) {
+	if len(t.hashes) == 0 {
+		return
+	}
+	key = mix64(key)
+	start := sort.Search(len(t.hashes), func(i int) bool { return t.hashes[i] >= key })
+	var seen map[string]bool
+	for i := 0; i < len(t.hashes); i++ {
+		server := t.servers[(start+i)%len(t.hashes)]
+		if seen[server] {
+			continue
+		}
+		if !f(server) {
+			return
+		}
+		if seen == nil {
+			seen = make(map[string]bool, t.n)
+		}
+		if seen[server] = true; len(seen) == t.n {
+			return
+		}
+	}
+}
+
+// maglevTable is the lookup table of Maglev hashing.
+type maglevTable struct {
+	table   []int // index of servers
+	servers []string
+}
+
+func newMaglevTable(servers []string, size int) *maglevTable {
+	if size <= 1 {
+		size = defaultMaglevTableSize
+	}
+	// the permutation of a server only visits all the slots if its skip is coprime
+	// with the size, filling the table would never end
+	size = nextPrime(size)
+	t := &maglevTable{servers: servers}
+	if len(servers) == 0 {
+		return t
+	}
+
+	m := uint64(size)
+	offsets := make([]uint64, len(servers))
+	skips := make([]uint64, len(servers))
+	for i, k := range servers {
+		offsets[i] = mix64(HashString(k)) % m
+		skips[i] = mix64(HashString(k+"#skip"))%(m-1) + 1
+	}
+
+	t.table = make([]int, size)
+	for i := range t.table {
+		t.table[i] = -1
+	}
+	next := make([]uint64, len(servers))
+	for filled := 0; ; {
+		for i := range servers {
+			// the next slot of the permutation of server i that is free
+			slot := (offsets[i] + next[i]*skips[i]) % m
+			for t.table[slot] >= 0 {
+				next[i]++
+				slot = (offsets[i] + next[i]*skips[i]) % m
+			}
+			t.table[slot] = i
+			next[i]++
+			if filled++; filled == size {
+				return t
+			}
+		}
+	}
+}
+
+func (t *maglevTable) walk(key uint64, f func(server string) bool) {
+	if len(t.table) == 0 {
+		return
+	}
+	// the following slots give the other servers in an order of the key
+	key = mix64(key)
+	var seen []bool
+	n := 0
+	for i := 0; i < len(t.table) && n < len(t.servers); i++ {
+		s := t.table[(key+uint64(i))%uint64(len(t.table))]
+		if seen != nil && seen[s] {
+			continue
+		}
+		if !f(t.servers[s]) {
+			return
+		}
+		if seen == nil {
+			seen = make([]bool, len(t.servers))
+		}
+		seen[s] = true
+		n++
+	}
+	// a table smaller than the servers misses some
+	for s, ok := range seen {
+		if !ok && !f(t.servers[s]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"xace/share"
+)
+
+func hashServers(n int) map[string]string {
+	servers := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		servers[fmt.Sprintf("tcp@10.0.0.%d:8972", i)] = ""
+	}
+	return servers
+}
+
+func tenantContext(i int) context.Context {
+	return context.WithValue(context.Background(), share.ReqMetaDataKey, map[string]string{"tenant": fmt.Sprint(i)})
+}
+
+func TestHashSelectorDistribution(t *testing.T) {
+	const keys = 10000
+	for _, alg := range []HashAlgorithm{RingHash, MaglevHash} {
+		s := newHashSelector(hashServers(10), ConsistentHashConfig{Algorithm: alg, MetadataKey: "tenant"})
+
+		before := make([]string, keys)
+		count := make(map[string]int)
+		for i := range before {
+			before[i] = s.Select(tenantContext(i), "Arith", "Mul", nil)
+			count[before[i]]++
+		}
+		for server, n := range count {
+			// 1000 each if even
+			if n < 700 || n > 1300 {
+				t.Errorf("%v: %s got %d keys", alg, server, n)
+			}
+		}
+
+		// the key comes from the metadata, not the method or args
+		s.UpdateServer(hashServers(11))
+		moved := 0
+		for i := range before {
+			if s.Select(tenantContext(i), "Other", "Method", i) != before[i] {
+				moved++
+			}
+		}
+		// 1/11 of the keys go to the new server
+		if moved > 2*keys/11 {
+			t.Errorf("%v: %d of %d keys moved", alg, moved, keys)
+		}
+	}
+}
+
+func TestHashTableWalk(t *testing.T) {
+	servers := []string{"a", "b", "c", "d", "e"}
+	tables := map[string]hashTable{
+		"jump":   newJumpTable(servers),
+		"ring":   newRingTable(servers, 0),
+		"maglev": newMaglevTable(servers, 0),
+	}
+	for name, table := range tables {
+		for key := uint64(0); key < 100; key++ {
+			seen := make(map[string]bool)
+			table.walk(key, func(server string) bool {
+				if seen[server] {
+					t.Fatalf("%s: %s given twice", name, server)
+				}
+				seen[server] = true
+				return true
+			})
+			if len(seen) != len(servers) {
+				t.Fatalf("%s: walked %d servers", name, len(seen))
+			}
+		}
+	}
+}
+
+func TestMaglevTableSize(t *testing.T) {
+	done := make(chan *maglevTable)
+	go func() {
+		// even, most skips share a factor with it
+		done <- newMaglevTable([]string{"a", "b", "c"}, 65536)
+	}()
+	select {
+	case table := <-done:
+		if len(table.table) != 65537 {
+			t.Fatalf("size %d", len(table.table))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the table isn't filled")
+	}
+
+	for n, want := range map[int]int{2: 2, 4: 5, 13: 13, 100: 101} {
+		if got := nextPrime(n); got != want {
+			t.Errorf("nextPrime(%d) = %d", n, got)
+		}
+	}
+}
+
+type fakeLoad struct{ pending int }
+
+func (l *fakeLoad) pendingCalls() int { return l.pending }
+func (l *fakeLoad) latency() float64  { return 0 }
+
+func TestHashSelectorBoundedLoad(t *testing.T) {
+	servers := hashServers(4)
+	for _, alg := range []HashAlgorithm{JumpHash, RingHash, MaglevHash} {
+		loads := make(map[string]*fakeLoad)
+		for k := range servers {
+			loads[k] = &fakeLoad{}
+		}
+		s := newHashSelector(servers, ConsistentHashConfig{
+			Algorithm:  alg,
+			LoadFactor: 0.25,
+			KeyFunc: func(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+				return "hot"
+			},
+		})
+		s.setLoad(func(k, servicePath, serviceMethod string) serverLoad { return loads[k] })
+
+		for i := 0; i < 400; i++ {
+			loads[s.Select(context.Background(), "Arith", "Mul", nil)].pending++
+		}
+		// 1.25 times the average of 100
+		for k, l := range loads {
+			if l.pending > 125 {
+				t.Errorf("%v: %s has %d calls", alg, k, l.pending)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGoAwayDrain(t *testing.T) {
+	svcA, svcB := &sleepService{}, &sleepService{}
+	a := startSleepServer(t, svcA, "127.0.0.1:0")
+	b := startSleepServer(t, svcB, "127.0.0.1:0")
+	ka := "tcp@" + a.Address().String()
+	d, _ := NewMultipleServersDiscovery([]*KVPair{{Key: ka}, {Key: "tcp@" + b.Address().String()}})
+	xc := NewXClient("H", Failover, RoundRobin, d, AceOption)
+	defer xc.Close()
+	for i := 0; i < 4; i++ {
+		if err := xc.Call(context.Background(), "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(&svcA.calls) == 0 || atomic.LoadInt32(&svcB.calls) == 0 {
+		t.Fatalf("round robin called a %d and b %d times", svcA.calls, svcB.calls)
+	}
+
+	// a call in flight on a while it shuts down
+	x := xc.(*xClient)
+	ca, err := x.getCachedClient(ka, "H", "Put", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- ca.Call(context.Background(), "H", "Put", []any{800}, []any{&SleepReply{}})
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt32(&svcA.calls) == 3 }, "the call in flight")
+	shut := make(chan error, 1)
+	go func() { shut <- a.Shutdown(context.Background()) }()
+	waitFor(t, ca.IsDraining, "the GOAWAY")
+
+	// the new calls go to b
+	for i := 0; i < 10; i++ {
+		if err := xc.Call(context.Background(), "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&svcA.calls); n != 3 {
+		t.Fatalf("the draining server got %d new calls", n-3)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("the call in flight: %v", err)
+	}
+	if err := <-shut; err != nil {
+		t.Fatal(err)
+	}
+
+	// once its connection is closed the server is selected again, it may restart
+	waitFor(t, ca.IsShutdown, "the closed connection")
+	x.mu.Lock()
+	x.restoreDrainedLocked()
+	_, ok := x.servers[ka]
+	x.mu.Unlock()
+	if !ok {
+		t.Fatal("the drained server is not restored")
+	}
+}
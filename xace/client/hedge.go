@@ -0,0 +1,377 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"xace/protocol"
+	"xace/share"
+)
+
+const (
+	// hedgeSamples is how many latencies of a method its percentile is taken of.
+	hedgeSamples = 256
+	// hedgeUpdate is how many new latencies the percentile is taken again after.
+	hedgeUpdate = 16
+)
+
+// HedgePolicy is how Failbackup hedges calls. A call that isn't answered within the
+// delay of its method is sent again to another server, up to MaxHedges times. The
+// first answer wins and the other requests are canceled on their servers. Only the
+// methods marked Idempotent are hedged, the other calls are sent once.
+//
+// The delay is the Percentile of the latencies of the method, BackupLatency until
+// MinSamples calls succeeded. Hedges take retries of the retry budget.
+type HedgePolicy struct {
+	MaxHedges  int
+	Percentile float64
+	MinSamples int
+	// MinDelay keeps calls of fast methods from being hedged for their jitter.
+	MinDelay time.Duration
+
+	mu         sync.Mutex
+	idempotent map[string]bool
+	methods    map[string]*hedgeMethod
+}
+
+// NewHedgePolicy returns a HedgePolicy of up to maxHedges hedges after the p95 latency.
+// Its fields may be changed before it is used.
+func NewHedgePolicy(maxHedges int) *HedgePolicy {
+	return &HedgePolicy{
+		MaxHedges:  maxHedges,
+		Percentile: 0.95,
+		MinSamples: 20,
+		MinDelay:   time.Millisecond,
+		idempotent: make(map[string]bool),
+		methods:    make(map[string]*hedgeMethod),
+	}
+}
+
+// Idempotent marks methods of servicePath as safe to be called more than once,
+// their calls are hedged. Method names are case insensitive like the server.
+func (p *HedgePolicy) Idempotent(servicePath string, methods ...string) {
+	p.mu.Lock()
+	for _, m := range methods {
+		p.idempotent[hedgeKey(servicePath, m)] = true
+	}
+	p.mu.Unlock()
+}
+
+// HedgeStats is how the calls of a method were hedged.
+type HedgeStats struct {
+	ServicePath string
+	Method      string
+	Delay       time.Duration // 0 until MinSamples calls succeeded
+	Calls       uint64
+	Hedges      uint64 // requests sent beside the first ones
+	// Wins are how many calls the ith request answered, Wins[0] is the first request.
+	Wins []uint64
+}
+
+// Stats returns how the calls of the idempotent methods were hedged.
+func (p *HedgePolicy) Stats() []HedgeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]HedgeStats, 0, len(p.methods))
+	for key, m := range p.methods {
+		var st HedgeStats
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			st.ServicePath, st.Method = key[:i], key[i+1:]
+		}
+		m.mu.Lock()
+		st.Delay = m.delay
+		st.Calls = m.calls
+		st.Hedges = m.hedges
+		st.Wins = append([]uint64(nil), m.wins...)
+		m.mu.Unlock()
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func hedgeKey(servicePath, method string) string {
+	return servicePath + "." + strings.ToLower(method)
+}
+
+// method returns the state of an idempotent method, nil for other methods.
+func (p *HedgePolicy) method(servicePath, method string) *hedgeMethod {
+	key := hedgeKey(servicePath, method)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.idempotent[key] {
+		return nil
+	}
+	m, ok := p.methods[key]
+	if !ok {
+		m = &hedgeMethod{}
+		p.methods[key] = m
+	}
+	return m
+}
+
+// hedgeMethod is the latencies and the stats of a method.
+type hedgeMethod struct {
+	mu      sync.Mutex
+	samples [hedgeSamples]time.Duration // a ring
+	n       int                         // samples taken
+	delay   time.Duration               // the percentile, 0 until MinSamples
+	calls   uint64
+	hedges  uint64
+	wins    []uint64
+}
+
+// hedgeDelay returns how long a request waits before the next one is sent.
+func (m *hedgeMethod) hedgeDelay(p *HedgePolicy, backup time.Duration) time.Duration {
+	m.mu.Lock()
+	d := m.delay
+	m.mu.Unlock()
+	if d == 0 {
+		d = backup
+	}
+	if d < p.MinDelay {
+		d = p.MinDelay
+	}
+	return d
+}
+
+// observe takes the latency of a request that answered a call without an error.
+func (m *hedgeMethod) observe(p *HedgePolicy, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.samples[m.n%hedgeSamples] = latency
+	m.n++
+	if m.n < p.MinSamples || m.n%hedgeUpdate != 0 && m.delay != 0 {
+		return
+	}
+	n := m.n
+	if n > hedgeSamples {
+		n = hedgeSamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, m.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	m.delay = sorted[int(p.Percentile*float64(n-1))]
+}
+
+// done counts a call, sent requests and the ith won, -1 if none did.
+func (m *hedgeMethod) done(sent, won int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.calls++
+	m.hedges += uint64(sent - 1)
+	if won >= 0 {
+		for len(m.wins) <= won {
+			m.wins = append(m.wins, 0)
+		}
+		m.wins[won]++
+	}
+	m.mu.Unlock()
+}
+
+// hedgeResult is the answer of the ith request of a hedged call.
+type hedgeResult struct {
+	i       int
+	k       string
+	client  RPCClient
+	reply   interface{}
+	resMeta map[string]string
+	latency time.Duration
+	err     error
+}
+
+// hedge calls serviceMethod like Failbackup. Without a HedgePolicy every call is sent
+// once more after BackupLatency, so k and client are the server of the first request.
+func (c *xClient) hedge(ctx context.Context, k string, client RPCClient, serviceMethod string, args interface{}, reply interface{}) error {
+	policy := c.option.Hedge
+	maxHedges, delay := 1, c.option.BackupLatency
+	var m *hedgeMethod
+	if policy != nil {
+		if m = policy.method(c.servicePath, serviceMethod); m == nil {
+			if client == nil {
+				return ErrServerUnavailable
+			}
+			err := c.wrapCall(ctx, client, serviceMethod, args, reply)
+			if err != nil && uncoverError(err) {
+				c.removeClient(k, c.servicePath, serviceMethod, client)
+			}
+			return err
+		}
+		maxHedges, delay = policy.MaxHedges, m.hedgeDelay(policy, delay)
+	}
+
+	hctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the requests are cancelable and each keeps its own metadata
+	reqMeta, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	resMeta, _ := ctx.Value(share.ResMetaDataKey).(map[string]string)
+
+	results := make(chan hedgeResult, maxHedges+1)
+	used := make(map[string]bool, maxHedges+1)
+	sent, pending := 0, 0
+	send := func() error {
+		if client == nil {
+			var err error
+			if k, client, err = c.selectHedge(hctx, serviceMethod, args, used); err != nil {
+				return err
+			}
+		}
+		used[k] = true
+		r := hedgeResult{i: sent, k: k, client: client, reply: newReply(reply)}
+		client = nil
+		sent++
+		pending++
+
+		meta := make(map[string]string, len(reqMeta)+1)
+		for key, v := range reqMeta {
+			meta[key] = v
+		}
+		meta[protocol.CancelableKey] = "1"
+		actx := context.WithValue(hctx, share.ReqMetaDataKey, meta)
+		if resMeta != nil {
+			r.resMeta = make(map[string]string)
+			actx = context.WithValue(actx, share.ResMetaDataKey, r.resMeta)
+		}
+		go func() {
+			start := time.Now()
+			r.err = c.wrapCall(actx, r.client, serviceMethod, args, r.reply)
+			r.latency = time.Since(start)
+			results <- r
+		}()
+		return nil
+	}
+
+	if err := send(); err != nil {
+		return err
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	var err error
+	for pending > 0 {
+		select {
+		case <-ctx.Done():
+			m.done(sent, -1)
+			return ctx.Err()
+		case <-t.C:
+			if sent <= maxHedges && c.retryBudget.retry() {
+				_ = send()
+				t.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err != nil && uncoverError(r.err) {
+				// the server failed, hedge at once
+				c.removeClient(r.k, c.servicePath, serviceMethod, r.client)
+				err = r.err
+				if sent <= maxHedges && c.retryBudget.retry() {
+					_ = send()
+				}
+				continue
+			}
+
+			if r.err == nil {
+				if reply != nil {
+					setReply(reply, r.reply)
+				}
+				m.observe(policy, r.latency)
+			}
+			if resMeta != nil {
+				setHedgeResMeta(ctx, resMeta, r.resMeta, r.i)
+			}
+			m.done(sent, r.i)
+			return r.err
+		}
+	}
+	m.done(sent, -1)
+	return err
+}
+
+// selectHedge selects a server for a hedge, one of the earlier requests didn't go to
+// if it can.
+func (c *xClient) selectHedge(ctx context.Context, serviceMethod string, args interface{}, used map[string]bool) (string, RPCClient, error) {
+	var k string
+	var client RPCClient
+	var err error
+	for i := 0; i < 3; i++ {
+		k, client, err = c.selectClient(ctx, c.servicePath, serviceMethod, args)
+		if err != nil || !used[k] {
+			break
+		}
+	}
+	return k, client, err
+}
+
+// setHedgeResMeta copies the response metadata of the request that won into the
+// one of the call, with the number of the request.
+func setHedgeResMeta(ctx context.Context, dst, src map[string]string, i int) {
+	if locker, ok := ctx.Value(share.ContextTagsLock).(*sync.Mutex); ok {
+		locker.Lock()
+		defer locker.Unlock()
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	dst[share.HedgeIndex] = strconv.Itoa(i)
+}
+
+// newReply returns an empty reply of the type of reply for a request of a hedged call.
+func newReply(reply interface{}) interface{} {
+	switch r := reply.(type) {
+	case nil:
+		return nil
+	case *protocol.AceReply:
+		return &protocol.AceReply{Args: newParams(r.Args)}
+	case []any:
+		return newParams(r)
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
+
+// newParams returns empty parameters of the types of the reply parameters params.
+func newParams(params []any) []any {
+	r := make([]any, len(params))
+	for i, a := range params {
+		r[i] = a
+		if v := reflect.ValueOf(a); v.Kind() == reflect.Ptr && !v.IsNil() {
+			r[i] = reflect.New(v.Elem().Type()).Interface()
+		}
+	}
+	return r
+}
+
+// setReply sets reply to the reply of newReply that won.
+func setReply(reply, won interface{}) {
+	switch r := reply.(type) {
+	case *protocol.AceReply:
+		w := won.(*protocol.AceReply)
+		r.Retcode = w.Retcode
+		setParams(r.Args, w.Args)
+	case []any:
+		setParams(r, won.([]any))
+	default:
+		reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(won).Elem())
+	}
+}
+
+// setParams sets the reply parameters params to the ones of won.
+func setParams(params, won []any) {
+	for i, a := range won {
+		if v := reflect.ValueOf(a); v.Kind() == reflect.Ptr && !v.IsNil() {
+			reflect.ValueOf(params[i]).Elem().Set(v.Elem())
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/protocol"
+	"xace/server"
+	"xace/share"
+)
+
+func TestHedgeDelay(t *testing.T) {
+	p := NewHedgePolicy(1)
+	p.MinSamples = 20
+	m := &hedgeMethod{}
+	backup := 30 * time.Millisecond
+	for i := 1; i < 20; i++ {
+		m.observe(p, time.Duration(i)*time.Millisecond)
+	}
+	if d := m.hedgeDelay(p, backup); d != backup {
+		t.Fatalf("the delay is %v before MinSamples", d)
+	}
+	m.observe(p, 100*time.Millisecond)
+	// the p95 of 1ms to 19ms and 100ms
+	if d := m.hedgeDelay(p, backup); d != 19*time.Millisecond {
+		t.Fatalf("the delay is %v", d)
+	}
+	// the delay follows the latencies every hedgeUpdate samples
+	for i := 0; i < 300; i++ {
+		m.observe(p, 100*time.Microsecond)
+	}
+	if d := m.hedgeDelay(p, backup); d != p.MinDelay {
+		t.Fatalf("the delay is %v under MinDelay", d)
+	}
+}
+
+// sleepService answers after SleepArgs.Sleep milliseconds, or after 500ms if it is slow.
+type sleepService struct {
+	slow     bool
+	calls    int32
+	canceled int32
+}
+
+type SleepArgs struct{ Sleep int }
+
+type SleepReply struct{ Slept int }
+
+func (s *sleepService) Get(ctx context.Context, args *SleepArgs, reply *SleepReply) int32 {
+	atomic.AddInt32(&s.calls, 1)
+	d := time.Duration(args.Sleep) * time.Millisecond
+	if s.slow {
+		d = 500 * time.Millisecond
+	}
+	select {
+	case <-time.After(d):
+	case <-ctx.Done():
+		atomic.AddInt32(&s.canceled, 1)
+		return -1
+	}
+	reply.Slept = int(d / time.Millisecond)
+	return 0
+}
+
+func (s *sleepService) Put(ctx context.Context, args *SleepArgs, reply *SleepReply) int32 {
+	return s.Get(ctx, args, reply)
+}
+
+// startSleepServer serves svc as H at addr until the test ends.
+func startSleepServer(t *testing.T, svc *sleepService, addr string) *server.Server {
+	s := server.NewServer()
+	s.RegisterName("H", svc, "")
+	go s.Serve("tcp", addr)
+	for s.Address() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func startHedgeServers(t *testing.T) (slow, fast *sleepService, d ServiceDiscovery) {
+	var pairs []*KVPair
+	for _, svc := range []*sleepService{{slow: true}, {}} {
+		s := startSleepServer(t, svc, "127.0.0.1:0")
+		pairs = append(pairs, &KVPair{Key: "tcp@" + s.Address().String()})
+		if svc.slow {
+			slow = svc
+		} else {
+			fast = svc
+		}
+	}
+	d, _ = NewMultipleServersDiscovery(pairs)
+	return slow, fast, d
+}
+
+func TestHedge(t *testing.T) {
+	slow, fast, d := startHedgeServers(t)
+	opt := AceOption
+	opt.Heartbeat = false
+	p := NewHedgePolicy(2)
+	p.Idempotent("H", "get")
+	opt.Hedge = p
+	xc := NewXClient("H", Failbackup, RoundRobin, d, opt)
+	defer xc.Close()
+
+	for i := 0; i < 40; i++ {
+		res := map[string]string{}
+		ctx := context.WithValue(context.Background(), share.ResMetaDataKey, res)
+		start := time.Now()
+		reply := &SleepReply{}
+		if err := xc.Call(ctx, "Get", []any{2}, []any{reply}); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Slept != 2 || time.Since(start) > 200*time.Millisecond {
+			t.Fatalf("call %d: slept %d in %v", i, reply.Slept, time.Since(start))
+		}
+		if res[share.HedgeIndex] == "" || res[share.ServerAddress] == "" {
+			t.Fatalf("call %d: %v", i, res)
+		}
+	}
+
+	// the requests the slow server got lost and were canceled
+	time.Sleep(50 * time.Millisecond)
+	calls, canceled := atomic.LoadInt32(&slow.calls), atomic.LoadInt32(&slow.canceled)
+	if canceled == 0 || canceled != calls {
+		t.Fatalf("%d of %d losing requests are canceled", canceled, calls)
+	}
+	stats := p.Stats()
+	if len(stats) != 1 || stats[0].Calls != 40 || stats[0].Delay == 0 || stats[0].Hedges == 0 ||
+		len(stats[0].Wins) < 2 || stats[0].Wins[1] == 0 {
+		t.Fatalf("%+v", stats)
+	}
+
+	// the other methods are sent once
+	n := atomic.LoadInt32(&slow.calls) + atomic.LoadInt32(&fast.calls)
+	for i := 0; i < 2; i++ {
+		xc.Call(context.Background(), "Put", []any{2}, []any{&SleepReply{}})
+	}
+	if sent := atomic.LoadInt32(&slow.calls) + atomic.LoadInt32(&fast.calls) - n; sent != 2 {
+		t.Fatalf("2 calls sent %d requests", sent)
+	}
+
+	// the reply of the winner is copied to an AceReply too
+	reply := &protocol.AceReply{Args: []any{&SleepReply{}}}
+	if err := xc.Call(context.Background(), "Get", []any{3}, reply); err != nil {
+		t.Fatal(err)
+	}
+	if slept := reply.Args[0].(*SleepReply).Slept; slept != 3 {
+		t.Fatalf("slept %d", slept)
+	}
+}
+
+func TestFailbackupWithoutHedge(t *testing.T) {
+	_, _, d := startHedgeServers(t)
+	opt := AceOption
+	opt.Heartbeat = false
+	xc := NewXClient("H", Failbackup, RoundRobin, d, opt)
+	defer xc.Close()
+
+	for i := 0; i < 10; i++ {
+		reply := &SleepReply{}
+		if err := xc.Call(context.Background(), "Put", []any{2}, []any{reply}); err != nil || reply.Slept != 2 {
+			t.Fatal(err, reply)
+		}
+	}
+}
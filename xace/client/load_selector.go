@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/valyala/fastrand"
+)
+
+// ewmaDecay is how long it takes a latency EWMA to forget about 63% of the past.
+const ewmaDecay = 10 * time.Second
+
+// ewma is a moving average of latencies that decays with time, not with samples,
+// so a server that takes few calls doesn't keep an old latency.
+type ewma struct {
+	mu    sync.Mutex
+	value float64 // nanoseconds, 0 until the first sample
+	last  int64
+}
+
+// observe takes the latency of a call that ended with err.
+func (e *ewma) observe(d time.Duration, err error) {
+	now := time.Now().UnixNano()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	v := float64(d)
+	switch {
+	case err == context.Canceled || err == context.DeadlineExceeded:
+		// the call would have taken longer, only a longer wait says something
+		if v <= e.value {
+			return
+		}
+	case !accepted(err):
+		// a server that fails fast mustn't draw the calls
+		v = math.Max(v, 2*e.value)
+	}
+
+	if e.last == 0 {
+		e.value = v
+	} else {
+		w := math.Exp(-float64(now-e.last) / float64(ewmaDecay))
+		e.value = e.value*w + v*(1-w)
+	}
+	e.last = now
+}
+
+func (e *ewma) get() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
+
+// serverLoad is the live load of the connection to a server.
+type serverLoad interface {
+	pendingCalls() int
+	latency() float64
+}
+
+// latency returns the latency EWMA of the calls, in nanoseconds. Every call with a
+// reply is observed when it is done, whether xClient, a hedge or the caller made it.
+// Streams are not.
+func (client *Client) latency() float64 {
+	return client.rtt.get()
+}
+
+// pendingCalls returns how many calls of the connections wait for their responses.
+func (p *clientPool) pendingCalls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, c := range p.conns {
+		n += c.pendingCalls()
+	}
+	return n
+}
+
+// latency returns the mean latency EWMA of the connections of the pool that have
+// one, in nanoseconds.
+func (p *clientPool) latency() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sum float64
+	n := 0
+	for _, c := range p.conns {
+		if l := c.latency(); l > 0 {
+			sum += l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// loadFunc returns the load of the connection of server k, nil if it has none.
+type loadFunc func(k, servicePath, serviceMethod string) serverLoad
+
+// loadSelector is a Selector that selects by the load of the servers.
+type loadSelector interface {
+	setLoad(load loadFunc)
+}
+
+// load returns the load of the cached client of server k. c.mu is held.
+func (c *xClient) load(k, servicePath, serviceMethod string) serverLoad {
+	client := c.findCachedClient(k, servicePath, serviceMethod)
+	if client == nil {
+		return nil
+	}
+	l, _ := client.(serverLoad)
+	return l
+}
+
+// leastPendingSelector selects the server with the fewest calls waiting for their
+// responses, a random one of them on a tie. A server without a connection has none.
+type leastPendingSelector struct {
+	servers []string
+	load    loadFunc
+}
+
+func newLeastPendingSelector(servers map[string]string) Selector {
+	s := &leastPendingSelector{}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *leastPendingSelector) setLoad(load loadFunc) {
+	s.load = load
+}
+
+func (s *leastPendingSelector) Select(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+	ss := s.servers
+	if len(ss) == 0 {
+		return ""
+	}
+
+	// start at a random server so ties don't all go to the first one
+	start := int(fastrand.Uint32n(uint32(len(ss))))
+	if s.load == nil {
+		return ss[start]
+	}
+	selected, min := "", math.MaxInt
+	for i := range ss {
+		k := ss[(start+i)%len(ss)]
+		n := 0
+		if l := s.load(k, servicePath, serviceMethod); l != nil {
+			n = l.pendingCalls()
+		}
+		if n < min {
+			selected, min = k, n
+			if n == 0 {
+				break
+			}
+		}
+	}
+	return selected
+}
+
+func (s *leastPendingSelector) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+	}
+
+	s.servers = ss
+}
+
+// p2cSelector picks two servers at random and selects the one of the lower cost,
+// its latency EWMA times its pending calls plus one. A server without a latency yet
+// costs 0, so new servers are tried.
+type p2cSelector struct {
+	servers []string
+	load    loadFunc
+}
+
+func newP2CSelector(servers map[string]string) Selector {
+	s := &p2cSelector{}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (s *p2cSelector) setLoad(load loadFunc) {
+	s.load = load
+}
+
+func (s *p2cSelector) Select(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+	ss := s.servers
+	switch len(ss) {
+	case 0:
+		return ""
+	case 1:
+		return ss[0]
+	}
+
+	i := fastrand.Uint32n(uint32(len(ss)))
+	j := fastrand.Uint32n(uint32(len(ss) - 1))
+	if j >= i {
+		j++
+	}
+	a, b := ss[i], ss[j]
+	if s.load == nil {
+		return a
+	}
+	if s.cost(b, servicePath, serviceMethod) < s.cost(a, servicePath, serviceMethod) {
+		return b
+	}
+	return a
+}
+
+func (s *p2cSelector) cost(k, servicePath, serviceMethod string) float64 {
+	l := s.load(k, servicePath, serviceMethod)
+	if l == nil {
+		return 0
+	}
+	return l.latency() * float64(l.pendingCalls()+1)
+}
+
+func (s *p2cSelector) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+	}
+
+	s.servers = ss
+}
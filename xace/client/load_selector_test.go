@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"xace/server"
+)
+
+func TestEWMA(t *testing.T) {
+	var e ewma
+	e.observe(10*time.Millisecond, nil)
+	if got := time.Duration(e.get()); got != 10*time.Millisecond {
+		t.Fatalf("the first latency is taken as %v", got)
+	}
+	// a canceled call that was shorter says nothing
+	e.observe(time.Millisecond, context.DeadlineExceeded)
+	if got := time.Duration(e.get()); got != 10*time.Millisecond {
+		t.Fatalf("a short canceled call moved the latency to %v", got)
+	}
+	// a failure counts as at least twice the latency
+	e.last -= int64(ewmaDecay)
+	e.observe(time.Millisecond, errors.New("connection refused"))
+	if got := time.Duration(e.get()); got <= 10*time.Millisecond {
+		t.Fatalf("a failure lowered the latency to %v", got)
+	}
+}
+
+// selectLoad is a serverLoad of fixed values.
+type selectLoad struct {
+	pending int
+	rtt     time.Duration
+}
+
+func (l *selectLoad) pendingCalls() int { return l.pending }
+func (l *selectLoad) latency() float64  { return float64(l.rtt) }
+
+func loadsOf(loads map[string]*selectLoad) loadFunc {
+	return func(k, servicePath, serviceMethod string) serverLoad {
+		if l, ok := loads[k]; ok {
+			return l
+		}
+		return nil
+	}
+}
+
+func TestLeastPendingSelector(t *testing.T) {
+	loads := map[string]*selectLoad{"a": {pending: 3}, "b": {pending: 1}, "c": {pending: 2}}
+	s := newLeastPendingSelector(map[string]string{"a": "", "b": "", "c": ""})
+	s.(loadSelector).setLoad(loadsOf(loads))
+	for i := 0; i < 20; i++ {
+		if k := s.Select(context.Background(), "S", "m", nil); k != "b" {
+			t.Fatalf("selected %s", k)
+		}
+	}
+	// ties are spread
+	loads["a"].pending, loads["c"].pending = 1, 1
+	picked := map[string]int{}
+	for i := 0; i < 300; i++ {
+		picked[s.Select(context.Background(), "S", "m", nil)]++
+	}
+	if len(picked) != 3 {
+		t.Fatalf("a tie picked %v", picked)
+	}
+}
+
+func TestP2CSelector(t *testing.T) {
+	loads := map[string]*selectLoad{"fast": {rtt: time.Millisecond}, "slow": {rtt: 20 * time.Millisecond}}
+	s := newP2CSelector(map[string]string{"fast": "", "slow": ""})
+	s.(loadSelector).setLoad(loadsOf(loads))
+	for i := 0; i < 20; i++ {
+		if k := s.Select(context.Background(), "S", "m", nil); k != "fast" {
+			t.Fatalf("selected %s", k)
+		}
+	}
+	// the pending calls weigh the latency
+	loads["fast"].pending = 30
+	if k := s.Select(context.Background(), "S", "m", nil); k != "slow" {
+		t.Fatalf("selected %s", k)
+	}
+	// a server without a connection is tried first
+	s.UpdateServer(map[string]string{"fast": "", "new": ""})
+	if k := s.Select(context.Background(), "S", "m", nil); k != "new" {
+		t.Fatalf("selected %s", k)
+	}
+}
+
+type delayService struct{ delay time.Duration }
+
+type DelayArgs struct{ A int }
+
+type DelayReply struct{ C int }
+
+func (s *delayService) Do(ctx context.Context, args *DelayArgs, reply *DelayReply) int32 {
+	time.Sleep(s.delay)
+	reply.C = args.A
+	return 0
+}
+
+func TestLoadSelectors(t *testing.T) {
+	if m, err := SelectModeString("P2CEWMA"); err != nil || m != P2CEWMA || LeastPending.String() != "LeastPending" {
+		t.Fatal(m, err)
+	}
+	for _, mode := range []SelectMode{LeastPending, P2CEWMA} {
+		var keys []string
+		var pairs []*KVPair
+		for _, delay := range []time.Duration{time.Millisecond, 20 * time.Millisecond} {
+			s := server.NewServer()
+			s.RegisterName("L", &delayService{delay}, "")
+			go s.Serve("tcp", "127.0.0.1:0")
+			for s.Address() == nil {
+				time.Sleep(5 * time.Millisecond)
+			}
+			defer s.Close()
+			keys = append(keys, "tcp@"+s.Address().String())
+			pairs = append(pairs, &KVPair{Key: keys[len(keys)-1]})
+		}
+		d, _ := NewMultipleServersDiscovery(pairs)
+		opt := DefaultOption
+		opt.Heartbeat = false
+		xc := NewXClient("L", Failfast, mode, d, opt)
+		defer xc.Close()
+
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 40; i++ {
+					reply := &DelayReply{}
+					if err := xc.Call(context.Background(), "Do", []any{i}, []any{reply}); err != nil || reply.C != i {
+						t.Error(err, reply)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		c := xc.(*xClient)
+		c.mu.Lock()
+		fast, slow := c.load(keys[0], "L", "Do"), c.load(keys[1], "L", "Do")
+		c.mu.Unlock()
+		if fast == nil || slow == nil || fast.latency() >= slow.latency() {
+			t.Fatalf("%v: the latencies are not observed", mode)
+		}
+		if mode == P2CEWMA {
+			// idle, the fast server costs less
+			for i := 0; i < 100; i++ {
+				c.mu.Lock()
+				k := c.selector.Select(context.Background(), "L", "Do", nil)
+				c.mu.Unlock()
+				if k != keys[0] {
+					t.Fatalf("selected the slow server")
+				}
+			}
+		}
+	}
+}
+
+func TestLatencyOfDirectCalls(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("L", &delayService{10 * time.Millisecond}, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	defer s.Close()
+	addr := s.Address().String()
+
+	opt := DefaultOption
+	opt.Heartbeat = false
+	client := NewClient(opt)
+	if err := client.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	p := newClientPool(opt, nil)
+	if err := p.Connect("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for _, c := range []RPCClient{client, p} {
+		reply := &DelayReply{}
+		if err := c.Call(context.Background(), "L", "Do", []any{1}, []any{reply}); err != nil || reply.C != 1 {
+			t.Fatal(err, reply)
+		}
+		if l := c.(serverLoad).latency(); l < float64(10*time.Millisecond) {
+			t.Fatalf("%T: the latency of a direct call is %v", c, time.Duration(l))
+		}
+	}
+
+	// a canceled call that waited longer raises the latency
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	before := client.latency()
+	if err := client.Call(ctx, "L", "Do", []any{1}, []any{&DelayReply{}}); err == nil {
+		t.Fatal("the call isn't canceled")
+	}
+	if client.latency() != before {
+		t.Fatalf("a short canceled call moved the latency to %v", time.Duration(client.latency()))
+	}
+}
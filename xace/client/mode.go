@@ -10,7 +10,7 @@ const (
 	Failfast
 	//Failtry use current client again
 	Failtry
-	//Failbackup select another server if the first server doesn't respond in specified time and use the fast response.
+	//Failbackup select another server if the first server doesn't respond in specified time and use the fast response, the slower requests are canceled. See Option.Hedge.
 	Failbackup
 )
 
@@ -26,10 +26,14 @@ const (
 	WeightedRoundRobin
 	//WeightedICMP is selecting by weighted Ping time
 	WeightedICMP
-	//ConsistentHash is selecting by hashing
+	//ConsistentHash is selecting by hashing, see XClient.ConfigConsistentHash
 	ConsistentHash
 	//Closest is selecting the closest server
 	Closest
+	//LeastPending is selecting the server with the fewest calls waiting for responses
+	LeastPending
+	//P2CEWMA is selecting the less loaded of two random servers by their latency EWMA
+	P2CEWMA
 
 	// SelectByUser is selecting by implementation of users
 	SelectByUser = 1000
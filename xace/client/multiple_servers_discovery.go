@@ -0,0 +1,90 @@
+package client
+
+import (
+	"sync"
+)
+
+// MultipleServersDiscovery is a discovery of a static list of servers,
+// which can be replaced by Update.
+type MultipleServersDiscovery struct {
+	pairsMu sync.RWMutex
+	pairs   []*KVPair
+
+	mu     sync.Mutex
+	chans  []chan []*KVPair
+	filter ServiceDiscoveryFilter
+}
+
+// NewMultipleServersDiscovery returns a new MultipleServersDiscovery.
+func NewMultipleServersDiscovery(pairs []*KVPair) (*MultipleServersDiscovery, error) {
+	return &MultipleServersDiscovery{pairs: pairs}, nil
+}
+
+// Clone clones this ServiceDiscovery with new servicePath, all paths share the servers.
+func (d *MultipleServersDiscovery) Clone(servicePath string) (ServiceDiscovery, error) {
+	return d, nil
+}
+
+// SetFilter sets the filter, it applies to the servers of the next Update.
+func (d *MultipleServersDiscovery) SetFilter(filter ServiceDiscoveryFilter) {
+	d.mu.Lock()
+	d.filter = filter
+	d.mu.Unlock()
+}
+
+// GetServices returns the servers.
+func (d *MultipleServersDiscovery) GetServices() []*KVPair {
+	d.pairsMu.RLock()
+	defer d.pairsMu.RUnlock()
+	return d.pairs
+}
+
+// WatchService returns a chan that receives the servers of every Update.
+func (d *MultipleServersDiscovery) WatchService() chan []*KVPair {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan []*KVPair, 10)
+	d.chans = append(d.chans, ch)
+	return ch
+}
+
+// RemoveWatcher removes a chan of WatchService.
+func (d *MultipleServersDiscovery) RemoveWatcher(ch chan []*KVPair) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var chans []chan []*KVPair
+	for _, c := range d.chans {
+		if c != ch {
+			chans = append(chans, c)
+		}
+	}
+	d.chans = chans
+}
+
+// Update replaces the servers and pushes them to the watchers.
+func (d *MultipleServersDiscovery) Update(pairs []*KVPair) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.filter != nil {
+		var filtered []*KVPair
+		for _, p := range pairs {
+			if d.filter(p) {
+				filtered = append(filtered, p)
+			}
+		}
+		pairs = filtered
+	}
+
+	d.pairsMu.Lock()
+	d.pairs = pairs
+	d.pairsMu.Unlock()
+
+	pushPairs(d.chans, pairs)
+}
+
+// Close does nothing, the servers are static.
+func (d *MultipleServersDiscovery) Close() {
+}
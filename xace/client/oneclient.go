@@ -25,11 +25,14 @@ type OneClient struct {
 	option     Option
 
 	selectors map[string]Selector
+	routes    map[string]*RouteRule
 	Plugins   PluginContainer
 	latitude  float64
 	longitude float64
 	auth      string
 
+	hashConfig *ConsistentHashConfig
+
 	serverMessageChan chan<- *protocol.Message
 }
 
@@ -68,6 +71,43 @@ func (c *OneClient) SetSelector(servicePath string, s Selector) {
 	c.mu.Unlock()
 }
 
+// SetRouteRule routes the calls of servicePath by rule, nil removes its rule. It applies
+// at once if the service is called already. The rule isn't copied, set a new one to change it.
+func (c *OneClient) SetRouteRule(servicePath string, rule *RouteRule) {
+	c.mu.Lock()
+	if c.routes == nil {
+		c.routes = make(map[string]*RouteRule)
+	}
+	if rule == nil {
+		delete(c.routes, servicePath)
+	} else {
+		c.routes[servicePath] = rule
+	}
+	if xclient := c.xclients[servicePath]; xclient != nil {
+		xclient.SetRouteRule(rule)
+	}
+	c.mu.Unlock()
+}
+
+// SetRouteRules replaces the route rules of all services, such as when they are reloaded.
+func (c *OneClient) SetRouteRules(rules map[string]*RouteRule) {
+	c.mu.Lock()
+	old := c.routes
+	c.routes = make(map[string]*RouteRule, len(rules))
+	for servicePath, rule := range rules {
+		if rule != nil {
+			c.routes[servicePath] = rule
+		}
+	}
+	for servicePath, xclient := range c.xclients {
+		rule := c.routes[servicePath]
+		if xclient != nil && (rule != nil || old[servicePath] != nil) {
+			xclient.SetRouteRule(rule)
+		}
+	}
+	c.mu.Unlock()
+}
+
 // SetPlugins sets client's plugins.
 func (c *OneClient) SetPlugins(plugins PluginContainer) {
 	c.Plugins = plugins
@@ -96,6 +136,18 @@ func (c *OneClient) ConfigGeoSelector(latitude, longitude float64) {
 	c.mu.RUnlock()
 }
 
+// ConfigConsistentHash selects servers by a consistent hash of cfg for all services.
+func (c *OneClient) ConfigConsistentHash(cfg ConsistentHashConfig) {
+	c.selectMode = ConsistentHash
+	c.hashConfig = &cfg
+
+	c.mu.RLock()
+	for _, v := range c.xclients {
+		v.ConfigConsistentHash(cfg)
+	}
+	c.mu.RUnlock()
+}
+
 // Auth sets s token for Authentication.
 func (c *OneClient) Auth(auth string) {
 	c.auth = auth
@@ -136,10 +188,18 @@ func (c *OneClient) newXClient(servicePath string) (xclient XClient, err error)
 		xclient.SetSelector(s)
 	}
 
+	if rule, ok := c.routes[servicePath]; ok {
+		xclient.SetRouteRule(rule)
+	}
+
 	if c.selectMode == Closest {
 		xclient.ConfigGeoSelector(c.latitude, c.longitude)
 	}
 
+	if c.selectMode == ConsistentHash && c.hashConfig != nil {
+		xclient.ConfigConsistentHash(*c.hashConfig)
+	}
+
 	if c.auth != "" {
 		xclient.Auth(c.auth)
 	}
@@ -238,6 +298,15 @@ func (c *OneClient) Stream(ctx context.Context, meta map[string]string) (net.Con
 	return xclient.Stream(ctx, meta)
 }
 
+// NewStream opens a stream to servicePath.serviceMethod on the connection of a selected server.
+func (c *OneClient) NewStream(ctx context.Context, servicePath string, serviceMethod string, args interface{}) (*ClientStream, error) {
+    xclient, err := c.getXClient(servicePath)
+    if err != nil {
+        return nil, err
+    }
+	return xclient.NewStream(ctx, serviceMethod, args)
+}
+
 // Close closes all xclients and its underlying connections to services.
 func (c *OneClient) Close() error {
 	var result error
@@ -0,0 +1,326 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"xace/log"
+	"xace/protocol"
+)
+
+// defaultPoolGrowPending is the PoolGrowPending if Option leaves it 0.
+const defaultPoolGrowPending = 16
+
+// clientPool is an RPCClient of up to Option.PoolSize connections to one server.
+// It starts with one connection and opens another one, in the background, when
+// the connection a call takes already has PoolGrowPending calls in flight. A broken
+// connection is dropped alone, the pool is shut down once none is left.
+type clientPool struct {
+	option  Option
+	Plugins PluginContainer
+
+	network string
+	address string
+
+	mu                sync.Mutex
+	conns             []*pooledClient
+	next              int // the connection of the next call in round robin
+	dialing           bool
+	closing           bool
+	serverMessageChan chan<- *protocol.Message
+}
+
+// pooledClient is a connection of a pool.
+type pooledClient struct {
+	*Client
+	lastUsed time.Time
+}
+
+func newClientPool(option Option, plugins PluginContainer) *clientPool {
+	// a broken connection is dropped, the pool grows again with the load
+	option.Reconnect = false
+	return &clientPool{option: option, Plugins: plugins}
+}
+
+// Connect opens the first connection to the server.
+func (p *clientPool) Connect(network, address string) error {
+	p.network, p.address = network, address
+	client, err := p.dial()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.conns = append(p.conns, &pooledClient{Client: client, lastUsed: time.Now()})
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *clientPool) dial() (*Client, error) {
+	client := &Client{
+		option:  p.option,
+		Plugins: p.Plugins,
+	}
+	if err := client.Connect(p.network, p.address); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	client.RegisterServerMessageChan(p.serverMessageChan)
+	p.mu.Unlock()
+	return client, nil
+}
+
+// grow adds a connection to the pool.
+func (p *clientPool) grow() {
+	client, err := p.dial()
+
+	p.mu.Lock()
+	p.dialing = false
+	if err != nil || p.closing {
+		p.mu.Unlock()
+		if err != nil {
+			log.Warnf("failed to add a connection to %s: %v", p.address, err)
+		} else {
+			client.Close()
+		}
+		return
+	}
+	p.conns = append(p.conns, &pooledClient{Client: client, lastUsed: time.Now()})
+	p.mu.Unlock()
+
+	if p.Plugins != nil {
+		p.Plugins.DoClientConnected(client.Conn)
+	}
+}
+
+// pruneLocked drops the broken connections.
+func (p *clientPool) pruneLocked() {
+	conns := p.conns[:0]
+	for _, c := range p.conns {
+		if !c.IsClosing() && !c.IsShutdown() {
+			conns = append(conns, c)
+		}
+	}
+	for i := len(conns); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = conns
+}
+
+// shrinkLocked closes the connections that took no calls for IdleTimeout, but one.
+func (p *clientPool) shrinkLocked(now time.Time) {
+	if p.option.IdleTimeout <= 0 {
+		return
+	}
+	conns := p.conns[:0]
+	for i, c := range p.conns {
+		if len(conns) > 0 || i < len(p.conns)-1 {
+			if now.Sub(c.lastUsed) > p.option.IdleTimeout && c.pendingCalls() == 0 {
+				c.Close()
+				continue
+			}
+		}
+		conns = append(conns, c)
+	}
+	for i := len(conns); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = conns
+}
+
+// get returns the connection of a call, nil if none is left.
+func (p *clientPool) get() *Client {
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pruneLocked()
+	p.shrinkLocked(now)
+	if len(p.conns) == 0 {
+		return nil
+	}
+
+	var c *pooledClient
+	var pending int
+	if p.option.PoolRoundRobin {
+		c = p.conns[p.next%len(p.conns)]
+		p.next++
+		pending = c.pendingCalls()
+	} else {
+		for _, pc := range p.conns {
+			n := pc.pendingCalls()
+			if c == nil || n < pending {
+				c, pending = pc, n
+			}
+		}
+	}
+	c.lastUsed = now
+
+	grow := p.option.PoolGrowPending
+	if grow <= 0 {
+		grow = defaultPoolGrowPending
+	}
+	if pending >= grow && len(p.conns) < p.option.PoolSize && !p.dialing && !p.closing {
+		p.dialing = true
+		go p.grow()
+	}
+	return c.Client
+}
+
+// done closes the connection of a call that failed by it, so the next calls
+// take the other connections.
+func (p *clientPool) done(client *Client, err error) {
+	if err != nil && uncoverError(err) && err != ErrShutdown {
+		client.Close()
+	}
+}
+
+// Go invokes the function asynchronously on a connection of the pool.
+func (p *clientPool) Go(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	client := p.get()
+	if client == nil {
+		if done == nil {
+			done = make(chan *Call, 1)
+		}
+		call := &Call{
+			ServicePath:   servicePath,
+			ServiceMethod: serviceMethod,
+			Args:          args,
+			Reply:         reply,
+			Error:         ErrShutdown,
+			Done:          done,
+		}
+		call.done()
+		return call
+	}
+	return client.Go(ctx, servicePath, serviceMethod, args, reply, done)
+}
+
+// Call invokes the named function on a connection of the pool.
+func (p *clientPool) Call(ctx context.Context, servicePath, serviceMethod string, args interface{}, reply interface{}) error {
+	client := p.get()
+	if client == nil {
+		return ErrShutdown
+	}
+	err := client.Call(ctx, servicePath, serviceMethod, args, reply)
+	p.done(client, err)
+	return err
+}
+
+// SendRaw sends a raw message on a connection of the pool.
+func (p *clientPool) SendRaw(ctx context.Context, r *protocol.Message) (map[string]string, []byte, error) {
+	client := p.get()
+	if client == nil {
+		return nil, nil, ErrShutdown
+	}
+	m, payload, err := client.SendRaw(ctx, r)
+	p.done(client, err)
+	return m, payload, err
+}
+
+// NewStream opens a stream on a connection of the pool.
+func (p *clientPool) NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*ClientStream, error) {
+	client := p.get()
+	if client == nil {
+		return nil, ErrShutdown
+	}
+	return client.NewStream(ctx, servicePath, serviceMethod, args)
+}
+
+// Close closes all connections of the pool.
+func (p *clientPool) Close() error {
+	p.mu.Lock()
+	if p.closing {
+		p.mu.Unlock()
+		return ErrShutdown
+	}
+	p.closing = true
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of the pool.
+func (p *clientPool) RemoteAddr() string {
+	if conn := p.GetConn(); conn != nil {
+		return conn.RemoteAddr().String()
+	}
+	return p.address
+}
+
+// GetConn returns the first healthy connection of the pool.
+func (p *clientPool) GetConn() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneLocked()
+	if len(p.conns) == 0 {
+		return nil
+	}
+	return p.conns[0].Conn
+}
+
+// RegisterServerMessageChan registers the channel that receives server requests of all connections.
+func (p *clientPool) RegisterServerMessageChan(ch chan<- *protocol.Message) {
+	p.mu.Lock()
+	p.serverMessageChan = ch
+	for _, c := range p.conns {
+		c.RegisterServerMessageChan(ch)
+	}
+	p.mu.Unlock()
+}
+
+// UnregisterServerMessageChan removes ServerMessageChan of all connections.
+func (p *clientPool) UnregisterServerMessageChan() {
+	p.mu.Lock()
+	p.serverMessageChan = nil
+	for _, c := range p.conns {
+		c.UnregisterServerMessageChan()
+	}
+	p.mu.Unlock()
+}
+
+// IsClosing reports whether the pool is closed.
+func (p *clientPool) IsClosing() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closing
+}
+
+// IsShutdown reports whether all connections of the pool broke.
+func (p *clientPool) IsShutdown() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pruneLocked()
+	return len(p.conns) == 0 && !p.dialing
+}
+
+// IsDraining reports whether the server sent GOAWAY, it sends it on all connections.
+func (p *clientPool) IsDraining() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		if c.IsDraining() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRestarting reports whether a new process of the draining server took over its address.
+func (p *clientPool) IsRestarting() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		if c.IsRestarting() {
+			return true
+		}
+	}
+	return false
+}
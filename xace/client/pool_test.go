@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolSize returns how many connections p holds.
+func poolSize(p *clientPool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// burst makes 40 calls of 20ms at once.
+func burst(t *testing.T, c RPCClient) {
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := &SleepReply{}
+			if err := c.Call(context.Background(), "H", "Put", []any{20}, []any{reply}); err != nil || reply.Slept != 20 {
+				t.Error(err, reply)
+			}
+		}()
+		time.Sleep(time.Millisecond)
+	}
+	wg.Wait()
+}
+
+func TestClientPool(t *testing.T) {
+	s := startSleepServer(t, &sleepService{}, "127.0.0.1:0")
+	for _, roundRobin := range []bool{false, true} {
+		opt := AceOption
+		// the heartbeats keep the connections from their read deadline, not from the pool
+		opt.Heartbeat = true
+		opt.HeartbeatInterval = 50 * time.Millisecond
+		opt.IdleTimeout = 300 * time.Millisecond
+		opt.PoolSize = 4
+		opt.PoolGrowPending = 2
+		opt.PoolRoundRobin = roundRobin
+		p := newClientPool(opt, nil)
+		if err := p.Connect("tcp", s.Address().String()); err != nil {
+			t.Fatal(err)
+		}
+
+		burst(t, p)
+		if n := poolSize(p); n < 2 || n > opt.PoolSize {
+			t.Fatalf("round robin %v: the pool grew to %d connections", roundRobin, n)
+		}
+
+		// a broken connection is dropped alone
+		n := poolSize(p)
+		p.mu.Lock()
+		broken := p.conns[0]
+		p.mu.Unlock()
+		broken.Conn.Close()
+		for !broken.IsShutdown() {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 0; i < 10; i++ {
+			if err := p.Call(context.Background(), "H", "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := poolSize(p); got != n-1 || p.IsShutdown() {
+			t.Fatalf("round robin %v: %d of %d connections are left", roundRobin, got, n)
+		}
+
+		// the idle connections are closed, but one
+		time.Sleep(2 * opt.IdleTimeout)
+		if err := p.Call(context.Background(), "H", "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+			t.Fatal(err)
+		}
+		if n := poolSize(p); n != 1 {
+			t.Fatalf("round robin %v: %d connections are left idle", roundRobin, n)
+		}
+		p.Close()
+	}
+}
+
+func TestClientPoolOfXClient(t *testing.T) {
+	s := startSleepServer(t, &sleepService{}, "127.0.0.1:0")
+	d, _ := NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	opt := AceOption
+	opt.Heartbeat = false
+	opt.PoolSize = 4
+	opt.PoolGrowPending = 2
+	xc := NewXClient("H", Failover, RandomSelect, d, opt)
+	defer xc.Close()
+
+	if err := xc.Call(context.Background(), "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := xc.(*xClient).getCachedClient("tcp@"+s.Address().String(), "H", "Put", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*clientPool); !ok {
+		t.Fatalf("the client of a PoolSize of 4 is a %T", c)
+	}
+}
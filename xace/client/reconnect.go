@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	"github.com/valyala/fastrand"
+
+	"xace/log"
+)
+
+const (
+	// defaultReconnectBackoff is the ReconnectBackoff if Option leaves it 0.
+	defaultReconnectBackoff = 100 * time.Millisecond
+	// defaultMaxReconnectBackoff is the MaxReconnectBackoff if Option leaves it 0.
+	defaultMaxReconnectBackoff = 30 * time.Second
+)
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(fastrand.Uint32n(uint32(half/time.Millisecond)+1))*time.Millisecond
+}
+
+// connLocked returns the connection to send on. While the client reconnects it fails
+// with ErrReconnecting, or waits if Option.ReconnectQueue. client.mutex is held, it
+// is released while waiting.
+func (client *Client) connLocked(ctx context.Context) (net.Conn, error) {
+	for client.reconnecting && !client.closing {
+		if !client.option.ReconnectQueue {
+			return nil, ErrReconnecting
+		}
+		reconnected := client.reconnected
+		client.mutex.Unlock()
+		select {
+		case <-reconnected:
+			client.mutex.Lock()
+		case <-ctx.Done():
+			client.mutex.Lock()
+			return nil, ctx.Err()
+		}
+	}
+	if client.shutdown || client.closing {
+		return nil, ErrShutdown
+	}
+	return client.Conn, nil
+}
+
+func (client *Client) isReconnecting() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.reconnecting
+}
+
+// reconnect dials the server until it succeeds or the client is closed. The client
+// keeps its ServerMessageChan, the plugins see the new connection like the first one.
+func (client *Client) reconnect() {
+	backoff := client.option.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	max := client.option.MaxReconnectBackoff
+	if max <= 0 {
+		max = defaultMaxReconnectBackoff
+	}
+
+	client.mutex.Lock()
+	closed := client.reconnected
+	client.mutex.Unlock()
+
+	for {
+		t := time.NewTimer(jitter(backoff))
+		select {
+		case <-t.C:
+		case <-closed:
+			t.Stop()
+			return
+		}
+
+		conn, err := client.dial(client.network, client.address)
+		if err != nil {
+			log.Warnf("failed to reconnect to %s, retry in %v: %v", client.address, backoff, err)
+			if backoff *= 2; backoff > max {
+				backoff = max
+			}
+			continue
+		}
+
+		client.mutex.Lock()
+		if client.closing {
+			client.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		client.Conn = conn
+		client.r = bufio.NewReaderSize(conn, ReaderBuffsize)
+		client.reconnecting = false
+		client.draining = false
+		client.restarting = false
+		client.pluginClosed = false
+		close(client.reconnected)
+		client.mutex.Unlock()
+
+		log.Infof("reconnected to %s", client.address)
+		if client.Plugins != nil {
+			client.Plugins.DoClientConnected(conn)
+		}
+		go client.input()
+		return
+	}
+}
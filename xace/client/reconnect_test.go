@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/protocol"
+)
+
+// waitFor waits up to 2 seconds for cond.
+func waitFor(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+// connPlugin counts the connections the plugins see.
+type connPlugin struct{ created, connected int32 }
+
+func (p *connPlugin) ConnCreated(conn net.Conn) (net.Conn, error) {
+	atomic.AddInt32(&p.created, 1)
+	return conn, nil
+}
+
+func (p *connPlugin) ClientConnected(conn net.Conn) (net.Conn, error) {
+	atomic.AddInt32(&p.connected, 1)
+	return conn, nil
+}
+
+func TestReconnect(t *testing.T) {
+	for _, queue := range []bool{false, true} {
+		s := startSleepServer(t, &sleepService{}, "127.0.0.1:0")
+		addr := s.Address().String()
+		opt := AceOption
+		opt.HeartbeatInterval = 50 * time.Millisecond
+		opt.Reconnect = true
+		opt.ReconnectQueue = queue
+		opt.ReconnectBackoff = 20 * time.Millisecond
+		opt.MaxReconnectBackoff = 50 * time.Millisecond
+		c := NewClient(opt)
+		plugin := &connPlugin{}
+		c.Plugins = NewPluginContainer()
+		c.Plugins.Add(plugin)
+		ch := make(chan *protocol.Message, 10)
+		c.RegisterServerMessageChan(ch)
+		if err := c.Connect("tcp", addr); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Call(context.Background(), "H", "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+			t.Fatal(err)
+		}
+
+		s.Close()
+		select {
+		case m := <-ch:
+			if m.MessageStatusType() != protocol.Error {
+				t.Fatalf("queue %v: the disconnection is told as %v", queue, m.MessageStatusType())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("queue %v: the disconnection is not told", queue)
+		}
+		waitFor(t, c.isReconnecting, "the reconnection")
+
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			done <- c.Call(ctx, "H", "Put", []any{7}, []any{&SleepReply{}})
+		}()
+		if !queue {
+			if err := <-done; err != ErrReconnecting {
+				t.Fatalf("a call while reconnecting: %v", err)
+			}
+		}
+		// a few heartbeats fail while the server is down
+		time.Sleep(200 * time.Millisecond)
+		startSleepServer(t, &sleepService{}, addr)
+		if queue {
+			if err := <-done; err != nil {
+				t.Fatalf("a queued call: %v", err)
+			}
+		}
+		waitFor(t, func() bool { return !c.isReconnecting() }, "the reconnected client")
+		if err := c.Call(context.Background(), "H", "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+			t.Fatalf("queue %v: a call after reconnecting: %v", queue, err)
+		}
+
+		// the heartbeats go on over the new connection
+		time.Sleep(150 * time.Millisecond)
+		if c.IsClosing() || c.IsShutdown() {
+			t.Fatalf("queue %v: the reconnected client is closed", queue)
+		}
+		if created, connected := atomic.LoadInt32(&plugin.created), atomic.LoadInt32(&plugin.connected); created != 2 || connected < 1 {
+			t.Fatalf("queue %v: the plugins saw %d connections created and %d connected", queue, created, connected)
+		}
+		c.Close()
+	}
+}
+
+func TestReconnectClose(t *testing.T) {
+	s := startSleepServer(t, &sleepService{}, "127.0.0.1:0")
+	opt := AceOption
+	opt.Heartbeat = false
+	opt.Reconnect = true
+	opt.ReconnectQueue = true
+	c := NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	// the server closes the connections it serves
+	if err := c.Call(context.Background(), "H", "Put", []any{1}, []any{&SleepReply{}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	waitFor(t, c.isReconnecting, "the reconnection")
+
+	done := make(chan error, 1)
+	go func() { done <- c.Call(context.Background(), "H", "Put", []any{7}, []any{&SleepReply{}}) }()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	select {
+	case err := <-done:
+		if err != ErrShutdown {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the queued call is not woken by Close")
+	}
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"xace/log"
+	"xace/registry"
+)
+
+// RegistryDiscovery is a discovery of the instances a registry.Store keeps for a service,
+// such as the ones a registry.RegistryPlugin publishes. It pushes every change of them
+// to its watchers.
+type RegistryDiscovery struct {
+	store       registry.Store
+	servicePath string
+
+	pairsMu sync.RWMutex
+	pairs   []*KVPair
+
+	mu     sync.Mutex
+	chans  []chan []*KVPair
+	filter ServiceDiscoveryFilter
+
+	stop      func()
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRegistryDiscovery returns a discovery of servicePath in store.
+func NewRegistryDiscovery(store registry.Store, servicePath string) (*RegistryDiscovery, error) {
+	d := &RegistryDiscovery{store: store, servicePath: servicePath, stopCh: make(chan struct{})}
+	ch, stop := store.Watch(servicePath)
+	d.stop = stop
+	if err := d.lookup(); err != nil {
+		stop()
+		return nil, err
+	}
+	go d.watch(ch)
+	return d, nil
+}
+
+// NewFileDiscovery returns a discovery of servicePath in the JSON or YAML file at path,
+// which is polled every interval. See registry.FileStore for the format.
+func NewFileDiscovery(path, servicePath string, interval time.Duration) (*RegistryDiscovery, error) {
+	return NewRegistryDiscovery(registry.NewFileStore(path, interval), servicePath)
+}
+
+// NewKVDiscovery returns a discovery of servicePath in an embedded key-value store.
+func NewKVDiscovery(store *registry.KVStore, servicePath string) (*RegistryDiscovery, error) {
+	return NewRegistryDiscovery(store, servicePath)
+}
+
+// Clone clones this ServiceDiscovery with new servicePath, the path of an AceClient
+// is reduced to its interface.
+func (d *RegistryDiscovery) Clone(servicePath string) (ServiceDiscovery, error) {
+	_, inter := splitAcePath(servicePath)
+	nd, err := NewRegistryDiscovery(d.store, inter)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	filter := d.filter
+	d.mu.Unlock()
+	if filter != nil {
+		nd.SetFilter(filter)
+	}
+	return nd, nil
+}
+
+// SetFilter sets the filter and applies it to the services.
+func (d *RegistryDiscovery) SetFilter(filter ServiceDiscoveryFilter) {
+	d.mu.Lock()
+	d.filter = filter
+	d.mu.Unlock()
+	if err := d.lookup(); err != nil {
+		log.Warnf("registry discovery: failed to get %s: %v", d.servicePath, err)
+	}
+}
+
+// GetServices returns the services.
+func (d *RegistryDiscovery) GetServices() []*KVPair {
+	d.pairsMu.RLock()
+	defer d.pairsMu.RUnlock()
+	return d.pairs
+}
+
+// WatchService returns a chan that receives the services after they change.
+func (d *RegistryDiscovery) WatchService() chan []*KVPair {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan []*KVPair, 10)
+	d.chans = append(d.chans, ch)
+	return ch
+}
+
+// RemoveWatcher removes a chan of WatchService.
+func (d *RegistryDiscovery) RemoveWatcher(ch chan []*KVPair) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var chans []chan []*KVPair
+	for _, c := range d.chans {
+		if c != ch {
+			chans = append(chans, c)
+		}
+	}
+	d.chans = chans
+}
+
+// lookup reads the services from the store and pushes them to the watchers if they changed.
+func (d *RegistryDiscovery) lookup() error {
+	insts, err := d.store.Instances(d.servicePath)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	filter := d.filter
+	d.mu.Unlock()
+
+	var pairs []*KVPair
+	for _, in := range insts {
+		pair := &KVPair{Key: in.Address, Value: in.Value()}
+		if filter != nil && !filter(pair) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	d.pairsMu.Lock()
+	if reflect.DeepEqual(d.pairs, pairs) {
+		d.pairsMu.Unlock()
+		return nil
+	}
+	d.pairs = pairs
+	d.pairsMu.Unlock()
+
+	d.mu.Lock()
+	pushPairs(d.chans, pairs)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *RegistryDiscovery) watch(ch <-chan struct{}) {
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ch:
+			if err := d.lookup(); err != nil {
+				log.Warnf("registry discovery: failed to get %s: %v", d.servicePath, err)
+			}
+		}
+	}
+}
+
+// Close stops watching the store.
+func (d *RegistryDiscovery) Close() {
+	d.closeOnce.Do(func() {
+		d.stop()
+		close(d.stopCh)
+	})
+}
+
+// pushPairs sends pairs to the watchers without blocking the caller, a watcher
+// that doesn't receive them in a minute misses the change.
+func pushPairs(chans []chan []*KVPair, pairs []*KVPair) {
+	for _, ch := range chans {
+		ch := ch
+		go func() {
+			defer func() {
+				recover()
+			}()
+			select {
+			case ch <- pairs:
+			case <-time.After(time.Minute):
+				log.Warn("chan is full and new change has been dropped")
+			}
+		}()
+	}
+}
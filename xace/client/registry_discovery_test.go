@@ -0,0 +1,76 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xace/registry"
+)
+
+// nextPairs waits for the services ch receives.
+func nextPairs(t *testing.T, ch chan []*KVPair) []*KVPair {
+	t.Helper()
+	select {
+	case pairs := <-ch:
+		return pairs
+	case <-time.After(2 * time.Second):
+		t.Fatal("the change is not pushed")
+	}
+	return nil
+}
+
+func TestKVDiscovery(t *testing.T) {
+	s, _ := registry.OpenKVStore("")
+	s.Put("Inter", &registry.Instance{Address: "tcp@a:1", Weight: 3})
+	base, err := NewKVDiscovery(s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Close()
+
+	// the path of an AceClient is reduced to its interface
+	sd, err := base.Clone("Proxy.Inter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := sd.(*RegistryDiscovery)
+	defer d.Close()
+	if pairs := d.GetServices(); len(pairs) != 1 || pairs[0].Key != "tcp@a:1" || pairs[0].Value != "weight=3" {
+		t.Fatalf("%v", pairs)
+	}
+
+	ch := d.WatchService()
+	s.Put("Inter", &registry.Instance{Address: "tcp@b:1", State: registry.StateInactive})
+	if pairs := nextPairs(t, ch); len(pairs) != 2 || pairs[1].Value != "state=inactive" {
+		t.Fatalf("%v", pairs)
+	}
+
+	d.SetFilter(func(pair *KVPair) bool { return pair.Key != "tcp@a:1" })
+	if pairs := nextPairs(t, ch); len(pairs) != 1 || pairs[0].Key != "tcp@b:1" {
+		t.Fatalf("filtered %v", pairs)
+	}
+	d.RemoveWatcher(ch)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.yaml")
+	p := registry.NewRegistryPlugin("tcp@a:1", registry.NewFileStore(path, 10*time.Millisecond))
+	if err := p.Register("Arith", nil, "group=blue"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewFileDiscovery(path, "Arith", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if pairs := d.GetServices(); len(pairs) != 1 || pairs[0].Value != "group=blue" {
+		t.Fatalf("%v", pairs)
+	}
+
+	ch := d.WatchService()
+	p.Unregister("Arith")
+	if pairs := nextPairs(t, ch); len(pairs) != 0 {
+		t.Fatalf("unregistered %v", pairs)
+	}
+}
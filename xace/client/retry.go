@@ -0,0 +1,198 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/valyala/fastrand"
+)
+
+// ErrThrottled is returned by calls the client rejects itself because the servers
+// reject too many of its requests, see Option.ThrottleK.
+var ErrThrottled = errors.New("request throttled by the client")
+
+const (
+	// retryBudgetWindow is how long the calls and retries of a retry budget are counted.
+	retryBudgetWindow = 10 * time.Second
+	// defaultThrottleWindow is the ThrottleWindow if Option leaves it 0.
+	defaultThrottleWindow = 2 * time.Minute
+	// defaultMaxRetryBackoff is the MaxRetryBackoff if Option leaves it 0.
+	defaultMaxRetryBackoff = time.Second
+	// rollingBuckets is how many buckets a rolling window is counted in.
+	rollingBuckets = 10
+)
+
+// rolling counts two numbers over a window of time in buckets.
+type rolling struct {
+	width   int64 // of a bucket, in nanoseconds
+	buckets [rollingBuckets]struct {
+		start int64
+		n     [2]float64
+	}
+}
+
+func newRolling(window time.Duration) *rolling {
+	return &rolling{width: int64(window) / rollingBuckets}
+}
+
+// add adds v to the ith number of the current bucket.
+func (r *rolling) add(now int64, i int, v float64) {
+	start := now - now%r.width
+	b := &r.buckets[(now/r.width)%rollingBuckets]
+	if b.start != start {
+		b.start = start
+		b.n = [2]float64{}
+	}
+	b.n[i] += v
+}
+
+// sum returns the numbers of the window.
+func (r *rolling) sum(now int64) (float64, float64) {
+	var a, b float64
+	oldest := now - now%r.width - (rollingBuckets-1)*r.width
+	for i := range r.buckets {
+		if r.buckets[i].start >= oldest {
+			a += r.buckets[i].n[0]
+			b += r.buckets[i].n[1]
+		}
+	}
+	return a, b
+}
+
+// retryBudget allows retries up to a fraction of the calls, plus a few per second.
+type retryBudget struct {
+	ratio float64
+	min   float64 // retries of the window beside ratio
+
+	mu     sync.Mutex
+	counts *rolling // calls, retries
+}
+
+func newRetryBudget(option Option) *retryBudget {
+	if option.RetryBudget <= 0 {
+		return nil
+	}
+	return &retryBudget{
+		ratio:  option.RetryBudget,
+		min:    float64(option.MinRetriesPerSecond) * retryBudgetWindow.Seconds(),
+		counts: newRolling(retryBudgetWindow),
+	}
+}
+
+// call counts a call.
+func (b *retryBudget) call() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.counts.add(time.Now().UnixNano(), 0, 1)
+	b.mu.Unlock()
+}
+
+// retry takes a retry from the budget, false if it is spent.
+func (b *retryBudget) retry() bool {
+	if b == nil {
+		return true
+	}
+	now := time.Now().UnixNano()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	calls, retries := b.counts.sum(now)
+	if retries >= b.min+b.ratio*calls {
+		return false
+	}
+	b.counts.add(now, 1, 1)
+	return true
+}
+
+// throttle rejects requests locally with the probability of Google SRE's adaptive
+// throttling, (requests - k*accepts) / (requests + 1), so the servers get about k
+// times the requests they accept.
+type throttle struct {
+	k float64
+
+	mu     sync.Mutex
+	counts *rolling // requests, accepts
+}
+
+func newThrottle(option Option) *throttle {
+	if option.ThrottleK <= 0 {
+		return nil
+	}
+	window := option.ThrottleWindow
+	if window <= 0 {
+		window = defaultThrottleWindow
+	}
+	return &throttle{k: option.ThrottleK, counts: newRolling(window)}
+}
+
+// allow counts a request, false if it is rejected.
+func (t *throttle) allow() bool {
+	if t == nil {
+		return true
+	}
+	now := time.Now().UnixNano()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests, accepts := t.counts.sum(now)
+	t.counts.add(now, 0, 1)
+	p := (requests - t.k*accepts) / (requests + 1)
+	return p <= 0 || float64(fastrand.Uint32n(1<<30))/(1<<30) >= p
+}
+
+// done counts the request as accepted unless the server didn't take it.
+func (t *throttle) done(err error) {
+	if t == nil || !accepted(err) {
+		return
+	}
+	t.mu.Lock()
+	t.counts.add(time.Now().UnixNano(), 1, 1)
+	t.mu.Unlock()
+}
+
+// accepted reports whether the server took the request of err.
+func accepted(err error) bool {
+	if err == nil || err == context.Canceled {
+		return true
+	}
+	if _, ok := err.(shedError); ok {
+		return false
+	}
+	_, ok := err.(ServiceError)
+	return ok
+}
+
+// retryWait waits before the attempt-th retry, for a random time up to
+// RetryBackoff<<attempt. It returns an error if the budget has no retry left
+// or ctx is done.
+func (c *xClient) retryWait(ctx context.Context, attempt int) error {
+	if !c.retryBudget.retry() {
+		return ErrRetryBudgetExhausted
+	}
+	if c.option.RetryBackoff <= 0 {
+		return nil
+	}
+	max := c.option.MaxRetryBackoff
+	if max <= 0 {
+		max = defaultMaxRetryBackoff
+	}
+	d := c.option.RetryBackoff
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	d = time.Duration(fastrand.Uint32n(uint32(d/time.Microsecond)+1)) * time.Microsecond
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
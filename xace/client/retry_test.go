@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/protocol"
+	"xace/server"
+)
+
+// closingListener accepts connections and closes them, every call to it fails.
+func closingListener(t *testing.T) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accepts int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepts, 1)
+			c.Close()
+		}
+	}()
+	return l, &accepts
+}
+
+func TestRetryBudget(t *testing.T) {
+	l, accepts := closingListener(t)
+	defer l.Close()
+
+	opt := AceOption
+	opt.Heartbeat = false
+	opt.RetryBudget = 0.1
+	opt.RetryBackoff = time.Millisecond
+	d, _ := NewPeer2PeerDiscovery("tcp@"+l.Addr().String(), "")
+	xc := NewXClient("S", Failtry, RandomSelect, d, opt)
+	defer xc.Close()
+
+	for i := 0; i < 100; i++ {
+		xc.Call(context.Background(), "m", &struct{ A int }{}, &struct{ B int }{})
+	}
+	time.Sleep(50 * time.Millisecond)
+	// 100 calls and the retries of the budget, 10% of them
+	if n := atomic.LoadInt32(accepts); n < 100 || n > 115 {
+		t.Fatalf("%d attempts", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	c := &xClient{option: Option{RetryBackoff: 10 * time.Millisecond, MaxRetryBackoff: 40 * time.Millisecond}}
+	for attempt := 1; attempt < 6; attempt++ {
+		start := time.Now()
+		if err := c.retryWait(context.Background(), attempt); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d > 60*time.Millisecond {
+			t.Fatalf("attempt %d waited %v", attempt, d)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle(Option{ThrottleK: 2, ThrottleWindow: time.Second})
+	for i := 0; i < 100; i++ {
+		if !th.allow() {
+			t.Fatal("rejected while the server accepts")
+		}
+		th.done(nil)
+	}
+
+	// a service error is an answer of the server, only shed requests count against it
+	for i := 0; i < 100; i++ {
+		if !th.allow() {
+			t.Fatal("rejected on service errors")
+		}
+		th.done(strErr("no such user"))
+	}
+
+	rejected := 0
+	for i := 0; i < 2000; i++ {
+		if !th.allow() {
+			rejected++
+			continue
+		}
+		th.done(shedError{strErr("request reached rate limit: S.m")})
+	}
+	// about 1100, the rejects start after 2*200 requests
+	if rejected < 900 {
+		t.Fatalf("%d rejected", rejected)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if !th.allow() {
+		t.Fatal("the window didn't roll")
+	}
+}
+
+func TestThrottledNotRetried(t *testing.T) {
+	l, _ := closingListener(t)
+	defer l.Close()
+
+	opt := AceOption
+	opt.Heartbeat = false
+	opt.RetryBudget = 0.1
+	opt.RetryBackoff = time.Millisecond
+	opt.ThrottleK = 1
+	d, _ := NewPeer2PeerDiscovery("tcp@"+l.Addr().String(), "")
+	for _, mode := range []FailMode{Failtry, Failover} {
+		xc := NewXClient("S", mode, RandomSelect, d, opt)
+		c := xc.(*xClient)
+		// requests that were never accepted, the throttle rejects nearly all
+		for i := 0; i < 100000; i++ {
+			c.throttle.allow()
+		}
+
+		err := xc.Call(context.Background(), "m", &struct{ A int }{}, &struct{ B int }{})
+		if err != ErrThrottled {
+			t.Fatalf("%v: %v", mode, err)
+		}
+		if _, retries := c.retryBudget.counts.sum(time.Now().UnixNano()); retries != 0 {
+			t.Fatalf("%v: %v retries", mode, retries)
+		}
+		xc.Close()
+	}
+}
+
+type shedPlugin struct{}
+
+func (shedPlugin) PostReadRequest(ctx context.Context, r *protocol.Message, e error) error {
+	if e != nil {
+		return e
+	}
+	return fmt.Errorf("%w: %s.%s", server.ErrReqReachLimit, r.ServicePath, r.ServiceMethod)
+}
+
+type shedService struct{}
+
+func (shedService) Get(ctx context.Context, args *struct{ A int }, reply *struct{ B int }) int32 {
+	return 0
+}
+
+func TestShedResponse(t *testing.T) {
+	s := server.NewServer()
+	s.RegisterName("S", shedService{}, "")
+	s.Plugins.Add(shedPlugin{})
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Close()
+	for s.Address() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	c := NewClient(AceOption)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	err := c.Call(context.Background(), "S", "Get", []any{0}, []any{&struct{ B int }{}})
+	var shed shedError
+	if !errors.As(err, &shed) {
+		t.Fatalf("%v is not shed", err)
+	}
+	if _, ok := err.(ServiceError); !ok {
+		t.Fatalf("%v is not a ServiceError", err)
+	}
+	if accepted(err) {
+		t.Fatal("a shed request is accepted")
+	}
+}
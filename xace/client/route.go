@@ -0,0 +1,127 @@
+package client
+
+import (
+	"net/url"
+	"time"
+)
+
+// routeCheckInterval is how often the servers of a route rule are checked again for
+// breakers that opened or closed.
+const routeCheckInterval = time.Second
+
+// RouteRule routes the calls of a service to the servers of some labels, such as the
+// zone, idc or version in the metadata of the servers. The calls go to the servers
+// matching Labels while at least MinServers of them are healthy, else they spill over
+// to the sets of Fallback in order. A server whose breaker is open isn't healthy.
+//
+// If no set has MinServers, the first set with a healthy server is used, and all the
+// servers if none has. Add an empty set to Fallback to spill over to all servers before.
+//
+//	rule := &client.RouteRule{
+//		Labels:     map[string]string{"zone": "sh-a", "version": "v2"},
+//		Fallback:   []map[string]string{{"idc": "sh", "version": "v2"}, {"version": "v2"}},
+//		MinServers: 2,
+//	}
+type RouteRule struct {
+	// Labels are the values the metadata of the servers must have, an empty value
+	// only needs the label.
+	Labels   map[string]string
+	Fallback []map[string]string
+	// MinServers is the healthy servers a set needs, 1 if 0.
+	MinServers int
+}
+
+// matchLabels reports whether the metadata of a server has labels.
+func matchLabels(metadata string, labels map[string]string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	values, err := url.ParseQuery(metadata)
+	if err != nil {
+		return false
+	}
+	for k, v := range labels {
+		vv, ok := values[k]
+		if !ok || v != "" && (len(vv) == 0 || vv[0] != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRouteRule routes the calls by rule, nil removes the rule. It can be changed at
+// any time, the calls in flight keep their servers.
+func (c *xClient) SetRouteRule(rule *RouteRule) {
+	c.mu.Lock()
+	c.route = rule
+	c.setServersLocked(c.servers)
+	c.mu.Unlock()
+}
+
+// healthy reports whether the breaker of server k is closed.
+func (c *xClient) healthy(k string) bool {
+	breaker, ok := c.breakers.Load(k)
+	return !ok || breaker.(Breaker).Ready()
+}
+
+// routeLocked returns the servers of the route rule, all of them without a rule.
+func (c *xClient) routeLocked(servers map[string]string) map[string]string {
+	rule := c.route
+	if rule == nil {
+		return servers
+	}
+	min := rule.MinServers
+	if min <= 0 {
+		min = 1
+	}
+
+	var first map[string]string
+	for i := -1; i < len(rule.Fallback); i++ {
+		labels := rule.Labels
+		if i >= 0 {
+			labels = rule.Fallback[i]
+		}
+		set := make(map[string]string)
+		for k, v := range servers {
+			if matchLabels(v, labels) && c.healthy(k) {
+				set[k] = v
+			}
+		}
+		if len(set) >= min {
+			return set
+		}
+		if first == nil && len(set) > 0 {
+			first = set
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return servers
+}
+
+// rerouteLocked gives the selector the servers of the route rule again if the health
+// of the servers changed them.
+func (c *xClient) rerouteLocked() {
+	c.routeChecked = time.Now()
+	routed := c.routeLocked(c.servers)
+	if sameServers(routed, c.routed) {
+		return
+	}
+	c.routed = routed
+	if c.selector != nil {
+		c.selector.UpdateServer(routed)
+	}
+}
+
+func sameServers(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if vb, ok := b[k]; !ok || vb != v {
+			return false
+		}
+	}
+	return true
+}
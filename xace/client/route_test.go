@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMatchLabels(t *testing.T) {
+	tests := []struct {
+		metadata string
+		labels   map[string]string
+		want     bool
+	}{
+		{"zone=a&version=v2", nil, true},
+		{"zone=a&version=v2", map[string]string{"zone": "a"}, true},
+		{"zone=a&version=v2", map[string]string{"zone": "a", "version": "v1"}, false},
+		{"zone=a", map[string]string{"idc": ""}, false},
+		{"zone=a&idc=", map[string]string{"idc": ""}, true},
+		{"%zz", map[string]string{"zone": "a"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchLabels(tt.metadata, tt.labels); got != tt.want {
+			t.Errorf("%q %v: got %v", tt.metadata, tt.labels, got)
+		}
+	}
+}
+
+func TestRouteFallback(t *testing.T) {
+	d, _ := NewMultipleServersDiscovery([]*KVPair{
+		{Key: "tcp@a:1", Value: "zone=a&version=v2"},
+		{Key: "tcp@a:2", Value: "zone=a&version=v1"},
+		{Key: "tcp@b:1", Value: "zone=b&version=v2"},
+		{Key: "tcp@b:2", Value: "zone=b&version=v2"},
+	})
+	opt := DefaultOption
+	opt.GenBreaker = func() Breaker { return NewConsecCircuitBreaker(1, time.Minute) }
+	xc := NewXClient("S", Failfast, RoundRobin, d, opt).(*xClient)
+	defer xc.Close()
+
+	// pick returns the servers 20 calls select
+	pick := func() map[string]int {
+		picked := map[string]int{}
+		for i := 0; i < 20; i++ {
+			xc.mu.Lock()
+			if xc.route != nil && time.Since(xc.routeChecked) > routeCheckInterval {
+				xc.rerouteLocked()
+			}
+			picked[xc.selector.Select(context.Background(), "S", "m", nil)]++
+			xc.mu.Unlock()
+		}
+		return picked
+	}
+	if picked := pick(); len(picked) != 4 {
+		t.Fatalf("without a rule: %v", picked)
+	}
+
+	ac := &AceClient{xclients: map[string]XClient{"p.S": xc}}
+	ac.SetRouteRule("p.S", &RouteRule{
+		Labels:   map[string]string{"zone": "a", "version": "v2"},
+		Fallback: []map[string]string{{"version": "v2"}},
+	})
+	if picked := pick(); len(picked) != 1 || picked["tcp@a:1"] != 20 {
+		t.Fatalf("the local server: %v", picked)
+	}
+
+	// the breaker of a:1 opens, the calls spill over to the fallback
+	b, _ := xc.breakers.LoadOrStore("tcp@a:1", opt.GenBreaker())
+	b.(Breaker).Fail()
+	xc.routeChecked = time.Time{}
+	if picked := pick(); len(picked) != 2 || picked["tcp@b:1"] != 10 || picked["tcp@b:2"] != 10 {
+		t.Fatalf("the fallback: %v", picked)
+	}
+
+	// no set has MinServers, the first one with a healthy server is used
+	ac.SetRouteRules(map[string]*RouteRule{"p.S": {Labels: map[string]string{"zone": "b"}, MinServers: 3}})
+	if picked := pick(); len(picked) != 2 || picked["tcp@a:1"] != 0 || picked["tcp@a:2"] != 0 {
+		t.Fatalf("under MinServers: %v", picked)
+	}
+
+	ac.SetRouteRules(nil)
+	if picked := pick(); len(picked) != 4 {
+		t.Fatalf("the rule is removed: %v", picked)
+	}
+}
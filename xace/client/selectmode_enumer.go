@@ -6,7 +6,7 @@ import (
 	"fmt"
 )
 
-const _SelectModeName = "RandomSelectRoundRobinWeightedRoundRobinWeightedICMPConsistentHashClosest"
+const _SelectModeName = "RandomSelectRoundRobinWeightedRoundRobinWeightedICMPConsistentHashClosestLeastPendingP2CEWMA"
 /*
 RandomSelect
 RoundRobin
@@ -14,9 +14,11 @@ WeightedRoundRobin
 WeightedICMP
 ConsistentHash
 Closest
+LeastPending
+P2CEWMA
 */
 
-var _SelectModeIndex = [...]uint8{0, 12, 22, 40, 52, 66, 73}
+var _SelectModeIndex = [...]uint8{0, 12, 22, 40, 52, 66, 73, 85, 92}
 
 func (i SelectMode) String() string {
 	if i < 0 || i >= SelectMode(len(_SelectModeIndex)-1) {
@@ -25,7 +27,7 @@ func (i SelectMode) String() string {
 	return _SelectModeName[_SelectModeIndex[i]:_SelectModeIndex[i+1]]
 }
 
-var _SelectModeValues = []SelectMode{0, 1, 2, 3, 4, 5}
+var _SelectModeValues = []SelectMode{0, 1, 2, 3, 4, 5, 6, 7}
 
 var _SelectModeNameToValueMap = map[string]SelectMode{
 	_SelectModeName[0:12]:  0,
@@ -34,6 +36,8 @@ var _SelectModeNameToValueMap = map[string]SelectMode{
 	_SelectModeName[40:52]: 3,
 	_SelectModeName[52:66]: 4,
 	_SelectModeName[66:73]: 5,
+	_SelectModeName[73:85]: 6,
+	_SelectModeName[85:92]: 7,
 }
 
 // SelectModeString retrieves an enum value from the enum constants string name.
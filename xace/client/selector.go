@@ -35,6 +35,10 @@ func newSelector(selectMode SelectMode, servers map[string]string) Selector {
 		return newWeightedICMPSelector(servers)
 	case ConsistentHash:
 		return newConsistentHashSelector(servers)
+	case LeastPending:
+		return newLeastPendingSelector(servers)
+	case P2CEWMA:
+		return newP2CSelector(servers)
 	case SelectByUser:
 		return nil
 	default:
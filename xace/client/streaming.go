@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"xace/codec"
+	"xace/protocol"
+	"xace/share"
+)
+
+// ErrStreamSendClosed is returned by Send after CloseSend.
+var ErrStreamSendClosed = errors.New("send on a stream after CloseSend")
+
+// ClientStream is the client side of a streaming call on the connection of a Client.
+// Send, CloseSend and Recv can be called from different goroutines.
+type ClientStream struct {
+	client *Client
+	seq    uint64
+	header protocol.Header
+	codec  codec.Codec
+
+	ctx      context.Context
+	credit   *protocol.StreamCredit
+	recv     *protocol.StreamReceiver
+	finished chan struct{}
+
+	mu          sync.Mutex
+	sendClosed  bool
+	done        bool
+	retcode     int32
+	resMetadata map[string]string
+}
+
+// NewStream opens a stream to a method that takes *server.Stream in place of reply.
+// args is sent with the request that opens the stream. The stream is canceled when ctx is done.
+func (client *Client) NewStream(ctx context.Context, servicePath, serviceMethod string, args interface{}) (*ClientStream, error) {
+	c := share.Codecs[client.option.SerializeType]
+	if c == nil {
+		return nil, ErrUnsupportedCodec
+	}
+	if client.option.CompressType != protocol.None {
+		if _, ok := protocol.Compressors[client.option.CompressType]; !ok {
+			return nil, protocol.ErrUnsupportedCompressor
+		}
+	}
+	data, err := c.Encode(args)
+	if err != nil {
+		return nil, err
+	}
+
+	window := client.option.StreamWindow
+	if window <= 0 {
+		window = protocol.DefaultStreamWindow
+	}
+
+	req := protocol.NewMessage()
+	req.SetMessageType(protocol.Request)
+	req.SetSerializeType(client.option.SerializeType)
+	req.SetCompressType(client.option.CompressType)
+	req.ServicePath = servicePath
+	req.ServiceMethod = serviceMethod
+	req.Metadata = make(map[string]string)
+	if meta, ok := ctx.Value(share.ReqMetaDataKey).(map[string]string); ok {
+		for k, v := range meta {
+			req.Metadata[k] = v
+		}
+	}
+	req.Metadata[protocol.StreamWindowKey] = strconv.Itoa(window)
+	req.Payload = data
+
+	cs := &ClientStream{
+		client:   client,
+		codec:    c,
+		ctx:      ctx,
+		credit:   protocol.NewStreamCredit(0), // the server grants its window first
+		recv:     protocol.NewStreamReceiver(window),
+		finished: make(chan struct{}),
+	}
+
+	client.mutex.Lock()
+	if _, err = client.connLocked(ctx); err != nil {
+		client.mutex.Unlock()
+		return nil, err
+	}
+	if client.streams == nil {
+		client.streams = make(map[uint64]*ClientStream)
+	}
+	cs.seq = client.seq
+	client.seq++
+	client.streams[cs.seq] = cs
+	client.mutex.Unlock()
+
+	req.SetSeq(cs.seq)
+	cs.header = *req.Header
+
+	if err = client.writeMessage(req); err != nil {
+		client.removeStream(cs.seq)
+		cs.finish(err)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cs.abort(ctx.Err())
+		case <-cs.finished:
+		}
+	}()
+
+	return cs, nil
+}
+
+// Send sends a message to the server. It blocks if the server doesn't read fast enough.
+func (cs *ClientStream) Send(v interface{}) error {
+	cs.mu.Lock()
+	closed := cs.sendClosed
+	cs.mu.Unlock()
+	if closed {
+		return ErrStreamSendClosed
+	}
+
+	if err := cs.credit.Acquire(cs.ctx); err != nil {
+		return err
+	}
+	data, err := cs.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return cs.writeFrame(protocol.StreamData, protocol.Normal, data)
+}
+
+// CloseSend tells the server there are no more messages, Recv of the server returns io.EOF.
+func (cs *ClientStream) CloseSend() error {
+	cs.mu.Lock()
+	if cs.sendClosed || cs.done {
+		cs.mu.Unlock()
+		return nil
+	}
+	cs.sendClosed = true
+	cs.mu.Unlock()
+
+	return cs.writeFrame(protocol.StreamEnd, protocol.Normal, nil)
+}
+
+// Recv receives a message from the server into v.
+// It returns io.EOF after the method of the server returns nil, or the error it returns.
+func (cs *ClientStream) Recv(v interface{}) error {
+	data, grant, err := cs.recv.Recv(cs.ctx)
+	if err != nil {
+		return err
+	}
+	if grant > 0 {
+		if err = cs.writeFrame(protocol.StreamWindow, protocol.Normal, protocol.PackWindow(grant)); err != nil {
+			return err
+		}
+	}
+	if _, ok := cs.codec.(*codec.AceCodec); ok {
+		// AcePack sends a message as the only parameter of a list, like the args of a call
+		if _, ok := v.([]any); !ok {
+			v = []any{v}
+		}
+	}
+	return cs.codec.Decode(data, v)
+}
+
+// Close cancels the stream if it is not finished.
+func (cs *ClientStream) Close() error {
+	cs.abort(context.Canceled)
+	return nil
+}
+
+// Retcode returns the retcode of the method after Recv returns io.EOF.
+func (cs *ClientStream) Retcode() int32 {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.retcode
+}
+
+// ResMetadata returns metadata of the response that ends the stream.
+func (cs *ClientStream) ResMetadata() map[string]string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.resMetadata
+}
+
+func (cs *ClientStream) writeFrame(t protocol.MessageType, status protocol.MessageStatusType, payload []byte) error {
+	msg := protocol.NewMessage()
+	msg.SetMessageType(t)
+	msg.SetSeq(cs.seq)
+	msg.SetSerializeType(cs.header.SerializeType())
+	if t == protocol.StreamData && len(payload) >= cs.client.option.CompressThreshold {
+		msg.SetCompressType(cs.header.CompressType())
+	}
+	msg.SetMessageStatusType(status)
+	msg.Payload = payload
+	return cs.client.writeMessage(msg)
+}
+
+// abort cancels the stream and tells the server if it is still running.
+func (cs *ClientStream) abort(err error) {
+	if !cs.finish(err) {
+		return
+	}
+	if cs.client.removeStream(cs.seq) {
+		_ = cs.writeFrame(protocol.StreamEnd, protocol.Error, nil)
+	}
+}
+
+// finish ends the stream with err, it returns false if it is already finished.
+func (cs *ClientStream) finish(err error) bool {
+	cs.mu.Lock()
+	if cs.done {
+		cs.mu.Unlock()
+		return false
+	}
+	cs.done = true
+	cs.mu.Unlock()
+
+	cs.recv.End(err)
+	cs.credit.Close()
+	close(cs.finished)
+	return true
+}
+
+// handleStreamMessage routes messages of streams, it returns false if res is not one of them.
+func (client *Client) handleStreamMessage(res *protocol.Message) bool {
+	isFrame := res.IsStreamFrame()
+	if !isFrame && res.MessageType() != protocol.Response {
+		return false
+	}
+
+	seq := res.Seq()
+	client.mutex.Lock()
+	cs := client.streams[seq]
+	if cs != nil && !isFrame {
+		delete(client.streams, seq)
+	}
+	client.mutex.Unlock()
+	if cs == nil {
+		// frames of canceled streams are dropped
+		return isFrame
+	}
+
+	switch res.MessageType() {
+	case protocol.StreamData:
+		if !cs.recv.Push(res.Payload) {
+			cs.abort(errors.New("stream window exceeded"))
+		}
+	case protocol.StreamWindow:
+		if n, err := protocol.UnpackWindow(res.Payload); err == nil {
+			cs.credit.Grant(n)
+		}
+	case protocol.StreamEnd:
+		cs.finish(nil)
+	case protocol.Response:
+		res.UnpackRetCode()
+		var err error
+		if res.MessageStatusType() == protocol.Error {
+			if ClientErrorFunc != nil {
+				err = ClientErrorFunc(res.Metadata[protocol.ServiceError])
+			} else {
+				err = strErr(res.Metadata[protocol.ServiceError])
+			}
+		}
+		cs.mu.Lock()
+		cs.retcode = res.Retcode
+		cs.resMetadata = res.Metadata
+		cs.mu.Unlock()
+		cs.finish(err)
+	}
+	return true
+}
+
+func (client *Client) removeStream(seq uint64) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if _, ok := client.streams[seq]; !ok {
+		return false
+	}
+	delete(client.streams, seq)
+	return true
+}
+
+// closeStreams ends all streams, it is called with client.mutex held.
+func (client *Client) closeStreams(err error) {
+	for seq, cs := range client.streams {
+		delete(client.streams, seq)
+		cs.finish(err)
+	}
+}
+
+// writeMessage writes msg to the connection.
+func (client *Client) writeMessage(msg *protocol.Message) error {
+	conn := client.GetConn()
+	data, err := msg.EncodeSlicePointer()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(*data)
+	protocol.PutData(data)
+	if err == nil && client.option.IdleTimeout != 0 {
+		_ = conn.SetDeadline(time.Now().Add(client.option.IdleTimeout))
+	}
+	return err
+}
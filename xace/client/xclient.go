@@ -3,19 +3,20 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
-	//"os"
+	"os"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	//"github.com/juju/ratelimit"
 	ex "xace/errors"
 	"xace/log"
 	"xace/protocol"
@@ -34,6 +35,14 @@ var (
 	ErrXClientNoServer = errors.New("can not found any server")
 	// ErrServerUnavailable selected server is unavailable.
 	ErrServerUnavailable = errors.New("selected server is unavailable")
+	// ErrRetryBudgetExhausted the retries of the xclient reached Option.RetryBudget.
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+	// ErrServerDraining selected server is shutting down and takes no new calls.
+	ErrServerDraining = errors.New("selected server is shutting down")
+	// ErrFileTransferFailed the server failed to store the uploaded file.
+	ErrFileTransferFailed = errors.New("server failed to store the file")
+	// ErrFileChecksumMismatch the downloaded file doesn't match the checksum from the server.
+	ErrFileChecksumMismatch = errors.New("file checksum mismatch")
 )
 
 // Receipt represents the result of the service returned.
@@ -50,6 +59,8 @@ type XClient interface {
 	GetPlugins() PluginContainer
 	SetSelector(s Selector)
 	ConfigGeoSelector(latitude, longitude float64)
+	ConfigConsistentHash(cfg ConsistentHashConfig)
+	SetRouteRule(rule *RouteRule)
 	Auth(auth string)
 
 	Go(ctx context.Context, serviceMethod string, args interface{}, reply interface{}, done chan *Call) (*Call, error)
@@ -61,13 +72,14 @@ type XClient interface {
 	SendFile(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error
 	DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer, meta map[string]string) error
 	Stream(ctx context.Context, meta map[string]string) (net.Conn, error)
+	NewStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error)
 	Close() error
 }
 
 // SetSelector sets customized selector by users.
 func (c *xClient) SetSelector(s Selector) {
 	c.mu.RLock()
-	s.UpdateServer(c.servers)
+	s.UpdateServer(c.routeLocked(c.servers))
 	c.mu.RUnlock()
 
 	c.selector = s
@@ -106,6 +118,14 @@ type xClient struct {
 	servers   map[string]string
 	discovery ServiceDiscovery
 	selector  Selector
+	// draining are the servers that sent GOAWAY, left out of servers
+	// until the connections of their clients close.
+	draining map[string]drainingServer
+
+	// route picks the servers the selector gets, routed, out of servers.
+	route        *RouteRule
+	routed       map[string]string
+	routeChecked time.Time
 
 	//slGroup singleflight.Group
 
@@ -119,6 +139,9 @@ type xClient struct {
 	ch chan []*KVPair
 
 	serverMessageChan chan<- *protocol.Message
+
+	retryBudget *retryBudget
+	throttle    *throttle
 }
 
 // NewXClient creates a XClient that supports service discovery and service governance.
@@ -130,6 +153,8 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 		servicePath:  servicePath,
 		cachedClient: make(map[string]RPCClient),
 		option:       option,
+		retryBudget:  newRetryBudget(option),
+		throttle:     newThrottle(option),
 	}
 
 	pairs := discovery.GetServices()
@@ -145,6 +170,9 @@ func NewXClient(servicePath string, failMode FailMode, selectMode SelectMode, di
 	client.servers = servers
 	if selectMode != Closest && selectMode != SelectByUser {
 		client.selector = newSelector(selectMode, servers)
+		if s, ok := client.selector.(loadSelector); ok {
+			s.setLoad(client.load)
+		}
 	}
 
 	client.Plugins = &pluginContainer{}
@@ -168,6 +196,8 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 		cachedClient:      make(map[string]RPCClient),
 		option:            option,
 		serverMessageChan: serverMessageChan,
+		retryBudget:       newRetryBudget(option),
+		throttle:          newThrottle(option),
 	}
 
 	pairs := discovery.GetServices()
@@ -182,6 +212,9 @@ func NewBidirectionalXClient(servicePath string, failMode FailMode, selectMode S
 	client.servers = servers
 	if selectMode != Closest && selectMode != SelectByUser {
 		client.selector = newSelector(selectMode, servers)
+		if s, ok := client.selector.(loadSelector); ok {
+			s.setLoad(client.load)
+		}
 	}
 
 	client.Plugins = &pluginContainer{}
@@ -207,7 +240,7 @@ func (c *xClient) GetPlugins() PluginContainer {
 // ConfigGeoSelector sets location of client's latitude and longitude,
 // and use newGeoSelector.
 func (c *xClient) ConfigGeoSelector(latitude, longitude float64) {
-	c.selector = newGeoSelector(c.servers, latitude, longitude)
+	c.selector = newGeoSelector(c.routeLocked(c.servers), latitude, longitude)
 	c.selectMode = Closest
 }
 
@@ -228,13 +261,105 @@ func (c *xClient) watch(ch chan []*KVPair) {
 		}
 		c.mu.Lock()
 		filterByStateAndGroup(c.option.Group, servers)
-		c.servers = servers
+		c.filterDrainingLocked(servers)
+		c.setServersLocked(servers)
+		c.mu.Unlock()
+	}
+}
+
+func (c *xClient) setServersLocked(servers map[string]string) {
+	c.servers = servers
+	c.routed = c.routeLocked(servers)
+	c.routeChecked = time.Now()
+	if c.selector != nil {
+		c.selector.UpdateServer(c.routed)
+	}
+}
+
+// drainingServer is a server left out of the selection while it shuts down.
+type drainingServer struct {
+	client RPCClient
+	value  string
+}
+
+func (d drainingServer) closed() bool {
+	return d.client.IsClosing() || d.client.IsShutdown()
+}
+
+// drainLocked drops the draining client of k from the cache and leaves k out of the
+// selection until the client closes. The client isn't closed, its calls in flight complete.
+func (c *xClient) drainLocked(client RPCClient, k, servicePath, serviceMethod string) {
+	c.uncacheLocked(client, k, servicePath, serviceMethod)
 
-		if c.selector != nil {
-			c.selector.UpdateServer(servers)
+	value, ok := c.servers[k]
+	if !ok {
+		return
+	}
+	log.Infof("server %s of %s is shutting down, routing new calls to other servers", k, c.servicePath)
+	if c.draining == nil {
+		c.draining = make(map[string]drainingServer)
+	}
+	c.draining[k] = drainingServer{client: client, value: value}
+
+	// the selector may still hold the old map
+	servers := make(map[string]string, len(c.servers))
+	for key, v := range c.servers {
+		if key != k {
+			servers[key] = v
 		}
+	}
+	c.setServersLocked(servers)
+}
 
-		c.mu.Unlock()
+// uncacheLocked drops client from the cache without closing it.
+func (c *xClient) uncacheLocked(client RPCClient, k, servicePath, serviceMethod string) {
+	network, _ := splitNetworkAndAddress(k)
+	if builder, ok := getCacheClientBuilder(network); ok {
+		builder.DeleteCachedClient(client, k, servicePath, serviceMethod)
+		return
+	}
+	delete(c.cachedClient, k)
+}
+
+// isRestarting reports whether a new process took over the address of the draining client.
+func isRestarting(client RPCClient) bool {
+	r, ok := client.(interface{ IsRestarting() bool })
+	return ok && r.IsRestarting()
+}
+
+// restoreDrainedLocked puts the drained servers whose clients closed back to the
+// selection, the server may have restarted at the same address.
+func (c *xClient) restoreDrainedLocked() {
+	var servers map[string]string
+	for k, d := range c.draining {
+		if !d.closed() {
+			continue
+		}
+		delete(c.draining, k)
+		if servers == nil {
+			servers = make(map[string]string, len(c.servers)+1)
+			for key, v := range c.servers {
+				servers[key] = v
+			}
+		}
+		servers[k] = d.value
+	}
+	if servers != nil {
+		c.setServersLocked(servers)
+	}
+}
+
+// filterDrainingLocked removes the draining servers from servers of the discovery.
+func (c *xClient) filterDrainingLocked(servers map[string]string) {
+	for k, d := range c.draining {
+		v, ok := servers[k]
+		if !ok || d.closed() {
+			delete(c.draining, k)
+			continue
+		}
+		d.value = v
+		c.draining[k] = d
+		delete(servers, k)
 	}
 }
 
@@ -253,18 +378,30 @@ func filterByStateAndGroup(group string, servers map[string]string) {
 // selects a client from candidates base on c.selectMode
 func (c *xClient) selectClient(ctx context.Context, servicePath, serviceMethod string, args interface{}) (string, RPCClient, error) {
     DTest()
-	c.mu.Lock()
-	fn := c.selector.Select
-	if c.Plugins != nil {
-		fn = c.Plugins.DoWrapSelect(fn)
-	}
-	k := fn(ctx, servicePath, serviceMethod, args)
-	c.mu.Unlock()
-	if k == "" {
-		return "", nil, ErrXClientNoServer
+	for {
+		c.mu.Lock()
+		if len(c.draining) > 0 {
+			c.restoreDrainedLocked()
+		}
+		if c.route != nil && time.Since(c.routeChecked) > routeCheckInterval {
+			c.rerouteLocked()
+		}
+		fn := c.selector.Select
+		if c.Plugins != nil {
+			fn = c.Plugins.DoWrapSelect(fn)
+		}
+		k := fn(ctx, servicePath, serviceMethod, args)
+		c.mu.Unlock()
+		if k == "" {
+			return "", nil, ErrXClientNoServer
+		}
+		client, err := c.getCachedClient(k, servicePath, serviceMethod, args)
+		// the draining client left the cache, select again
+		if err == ErrServerDraining {
+			continue
+		}
+		return k, client, err
 	}
-	client, err := c.getCachedClient(k, servicePath, serviceMethod, args)
-	return k, client, err
 }
 
 func (c *xClient) getCachedClient(k string, servicePath, serviceMethod string, args interface{}) (RPCClient, error) {
@@ -291,7 +428,7 @@ func (c *xClient) getCachedClient(k string, servicePath, serviceMethod string, a
 	client = c.findCachedClient(k, servicePath, serviceMethod)
 	c.mu.RUnlock()
 	if client != nil {
-		if !client.IsClosing() && !client.IsShutdown() {
+		if !client.IsClosing() && !client.IsShutdown() && !client.IsDraining() {
 			return client, nil
 		}
 	}
@@ -301,10 +438,20 @@ func (c *xClient) getCachedClient(k string, servicePath, serviceMethod string, a
 	client = c.findCachedClient(k, servicePath, serviceMethod)
 	if client != nil {
 		if !client.IsClosing() && !client.IsShutdown() {
-	        c.mu.Unlock()
-			return client, nil
-        }
-	    c.deleteCachedClient(client, k, servicePath, serviceMethod)
+			if !client.IsDraining() {
+				c.mu.Unlock()
+				return client, nil
+			}
+			if !isRestarting(client) {
+				c.drainLocked(client, k, servicePath, serviceMethod)
+				c.mu.Unlock()
+				return nil, ErrServerDraining
+			}
+			// connect to the new process, the draining client completes its calls
+			c.uncacheLocked(client, k, servicePath, serviceMethod)
+		} else {
+			c.deleteCachedClient(client, k, servicePath, serviceMethod)
+		}
     }
     var err error
     client, err = c.generateClient(k, servicePath, serviceMethod)
@@ -358,6 +505,11 @@ func (c *xClient) deleteCachedClient(client RPCClient, k, servicePath, serviceMe
 }
 
 func (c *xClient) removeClient(k, servicePath, serviceMethod string, client RPCClient) {
+	// a pool has dropped the broken connection, the others still serve
+	if pool, ok := client.(*clientPool); ok && !pool.IsClosing() && !pool.IsShutdown() {
+		return
+	}
+
 	c.mu.Lock()
 	cl := c.findCachedClient(k, servicePath, serviceMethod)
 	if cl == client {
@@ -375,6 +527,9 @@ func (c *xClient) generateClient(k, servicePath, serviceMethod string) (client R
 	network, addr := splitNetworkAndAddress(k)
 	if builder, ok := getCacheClientBuilder(network); ok && builder != nil {
 		client, err = builder.GenerateClient(k, servicePath, serviceMethod)
+	} else if c.option.PoolSize > 1 {
+		client = newClientPool(c.option, c.Plugins)
+		err = client.Connect(network, addr)
 	} else {
         client = &Client{
             option:  c.option,
@@ -400,9 +555,17 @@ func (c *xClient) getCachedClientWithoutLock(k, servicePath, serviceMethod strin
 	client := c.findCachedClient(k, servicePath, serviceMethod)
 	if client != nil {
 		if !client.IsClosing() && !client.IsShutdown() {
-			return client, needCallPlugin, nil
+			if !client.IsDraining() {
+				return client, needCallPlugin, nil
+			}
+			if !isRestarting(client) {
+				c.drainLocked(client, k, servicePath, serviceMethod)
+				return nil, needCallPlugin, ErrServerDraining
+			}
+			c.uncacheLocked(client, k, servicePath, serviceMethod)
+		} else {
+			c.deleteCachedClient(client, k, servicePath, serviceMethod)
 		}
-		c.deleteCachedClient(client, k, servicePath, serviceMethod)
 	}
     var err error
     client, err = c.generateClient(k, servicePath, serviceMethod)
@@ -427,9 +590,7 @@ func (c *xClient) setInitAceClient(client RPCClient, k, servicePath, serviceMeth
 
 	c.mu.Lock()
 	c.servers[k] = "2" //first one
-	if c.selector != nil {
-		c.selector.UpdateServer(c.servers)
-    }
+	c.setServersLocked(c.servers)
 	c.mu.Unlock()
 }
 
@@ -510,6 +671,8 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient Call", c.servicePath, serviceMethod, c.failMode, args)
 	}
 
+	c.retryBudget.call()
+
 	var err error
 	k, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
 	if err != nil {
@@ -544,11 +707,20 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 				if _, ok := err.(ServiceError); ok {
 					return err
 				}
+				if rejectedByClient(err) {
+					return err
+				}
 			}
 
 			if uncoverError(err) {
 				c.removeClient(k, c.servicePath, serviceMethod, client)
 			}
+			if retries < 0 {
+				break
+			}
+			if e = c.retryWait(ctx, c.option.Retries-retries); e != nil {
+				break
+			}
 			client, e = c.getCachedClient(k, c.servicePath, serviceMethod, args)
 		}
 		if err == nil {
@@ -571,11 +743,20 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 				if _, ok := err.(ServiceError); ok {
 					return err
 				}
+				if rejectedByClient(err) {
+					return err
+				}
 			}
 
 			if uncoverError(err) {
 				c.removeClient(k, c.servicePath, serviceMethod, client)
 			}
+			if retries < 0 {
+				break
+			}
+			if e = c.retryWait(ctx, c.option.Retries-retries); e != nil {
+				break
+			}
 			// select another server
 			k, client, e = c.selectClient(ctx, c.servicePath, serviceMethod, args)
 		}
@@ -585,59 +766,7 @@ func (c *xClient) Call(ctx context.Context, serviceMethod string, args interface
 		}
 		return err
 	case Failbackup:
-		ctx, cancelFn := context.WithCancel(ctx)
-		defer cancelFn()
-		call1 := make(chan *Call, 10)
-		call2 := make(chan *Call, 10)
-
-		var reply1, reply2 interface{}
-
-		if reply != nil {
-			reply1 = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			reply2 = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-		}
-
-		_, err1 := c.Go(ctx, serviceMethod, args, reply1, call1)
-
-		t := time.NewTimer(c.option.BackupLatency)
-		select {
-		case <-ctx.Done(): // cancel by context
-			err = ctx.Err()
-			return err
-		case call := <-call1:
-			err = call.Error
-			if err == nil && reply != nil {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(reply1).Elem())
-			}
-			return err
-		case <-t.C:
-
-		}
-		_, err2 := c.Go(ctx, serviceMethod, args, reply2, call2)
-		if err2 != nil {
-			if uncoverError(err2) {
-				c.removeClient(k, c.servicePath, serviceMethod, client)
-			}
-			err = err1
-			return err
-		}
-
-		select {
-		case <-ctx.Done(): // cancel by context
-			err = ctx.Err()
-		case call := <-call1:
-			err = call.Error
-			if err == nil && reply != nil && reply1 != nil {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(reply1).Elem())
-			}
-		case call := <-call2:
-			err = call.Error
-			if err == nil && reply != nil && reply2 != nil {
-				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(reply2).Elem())
-			}
-		}
-
-		return err
+		return c.hedge(ctx, k, client, serviceMethod, args, reply)
 	default: // Failfast
 		err = c.wrapCall(ctx, client, serviceMethod, args, reply)
 		if err != nil {
@@ -655,6 +784,11 @@ func uncoverError(err error) bool {
 		return false
 	}
 
+	// the client rejected the call, not the server
+	if err == ErrThrottled {
+		return false
+	}
+
 	if err == context.DeadlineExceeded {
 		return false
 	}
@@ -666,6 +800,12 @@ func uncoverError(err error) bool {
 	return true
 }
 
+// rejectedByClient reports whether the client rejected the call itself. Retrying it
+// would only spend the retry budget and be throttled again.
+func rejectedByClient(err error) bool {
+	return err == ErrThrottled || err == ErrRetryBudgetExhausted
+}
+
 func contextCanceled(err error) bool {
 	if err == context.DeadlineExceeded {
 		return true
@@ -699,6 +839,8 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		log.Debugf("select a client for %s.%s, failMode: %v, args: %+v in case of xclient SendRaw", r.ServicePath, r.ServiceMethod, c.failMode, r.Payload)
 	}
 
+	c.retryBudget.call()
+
 	var err error
 	k, client, err := c.selectClient(ctx, r.ServicePath, r.ServiceMethod, r.Payload)
 	if err != nil {
@@ -724,7 +866,9 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				var m map[string]string
+				var payload []byte
+				m, payload, err = c.wrapSendRaw(ctx, client, r)
 				if err == nil {
 					return m, payload, nil
 				}
@@ -734,11 +878,20 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 				if _, ok := err.(ServiceError); ok {
 					return nil, nil, err
 				}
+				if rejectedByClient(err) {
+					return nil, nil, err
+				}
 			}
 
 			if uncoverError(err) {
 				c.removeClient(k, r.ServicePath, r.ServiceMethod, client)
 			}
+			if retries < 0 {
+				break
+			}
+			if e = c.retryWait(ctx, c.option.Retries-retries); e != nil {
+				break
+			}
 			client, e = c.getCachedClient(k, r.ServicePath, r.ServiceMethod, r.Payload)
 		}
 
@@ -751,7 +904,9 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 		for retries >= 0 {
 			retries--
 			if client != nil {
-				m, payload, err := c.wrapSendRaw(ctx, client, r)
+				var m map[string]string
+				var payload []byte
+				m, payload, err = c.wrapSendRaw(ctx, client, r)
 				if err == nil {
 					return m, payload, nil
 				}
@@ -761,11 +916,20 @@ func (c *xClient) SendRaw(ctx context.Context, r *protocol.Message) (map[string]
 				if _, ok := err.(ServiceError); ok {
 					return nil, nil, err
 				}
+				if rejectedByClient(err) {
+					return nil, nil, err
+				}
 			}
 
 			if uncoverError(err) {
 				c.removeClient(k, r.ServicePath, r.ServiceMethod, client)
 			}
+			if retries < 0 {
+				break
+			}
+			if e = c.retryWait(ctx, c.option.Retries-retries); e != nil {
+				break
+			}
 			// select another server
 			k, client, e = c.selectClient(ctx, r.ServicePath, r.ServiceMethod, r.Payload)
 		}
@@ -800,9 +964,14 @@ func (c *xClient) wrapCall(ctx context.Context, client RPCClient, serviceMethod
 		ctx = share.NewContext(ctx)
 	}
 
+	if !c.throttle.allow() {
+		return ErrThrottled
+	}
+
 	c.Plugins.DoPreCall(ctx, c.servicePath, serviceMethod, args)
 	err := client.Call(ctx, c.servicePath, serviceMethod, args, reply)
 	c.Plugins.DoPostCall(ctx, c.servicePath, serviceMethod, args, reply, err)
+	c.throttle.done(err)
 
 	if share.Trace {
 		log.Debugf("called a client for %s.%s, args: %+v, err: %v in case of xclient wrapCall", c.servicePath, serviceMethod, args, err)
@@ -821,10 +990,15 @@ func (c *xClient) wrapSendRaw(ctx context.Context, client RPCClient, r *protocol
 		log.Debugf("call a client for %s.%s, args: %+v in case of xclient wrapSendRaw", c.servicePath, r.ServiceMethod, r.Payload)
 	}
 
+	if !c.throttle.allow() {
+		return nil, nil, ErrThrottled
+	}
+
 	ctx = share.NewContext(ctx)
 	c.Plugins.DoPreCall(ctx, c.servicePath, r.ServiceMethod, r.Payload)
 	m, payload, err := client.SendRaw(ctx, r)
 	c.Plugins.DoPostCall(ctx, c.servicePath, r.ServiceMethod, r.Payload, nil, err)
+	c.throttle.done(err)
 
 	if share.Trace {
 		log.Debugf("called a client for %s.%s, args: %+v, err: %v in case of xclient wrapSendRaw", c.servicePath, r.ServiceMethod, r.Payload, err)
@@ -1143,11 +1317,44 @@ check:
 	return receipts, err
 }
 
+// RetcodeError is returned when a service of the server, such as the file transfer,
+// fails a call with a retcode.
+type RetcodeError struct {
+	ServiceMethod string
+	Retcode       int32
+}
+
+func (e *RetcodeError) Error() string {
+	return fmt.Sprintf("%s failed with retcode %d", e.ServiceMethod, e.Retcode)
+}
+
+// callService calls a method of a service of the server, such as the file transfer.
+// With AcePack the fields of args are sent as the parameters and reply is read after
+// the retcode of the method, as for other ace services. Other codecs send args as it is.
+func (c *xClient) callService(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	if c.option.SerializeType != protocol.AcePack {
+		return c.Call(ctx, method, args, reply)
+	}
+	v := reflect.Indirect(reflect.ValueOf(args))
+	params := make([]any, v.NumField())
+	for i := range params {
+		params[i] = v.Field(i).Interface()
+	}
+	ar := &protocol.AceReply{Args: []any{reply}}
+	if err := c.Call(ctx, method, params, ar); err != nil {
+		return err
+	}
+	if ar.Retcode != 0 {
+		return &RetcodeError{ServiceMethod: c.servicePath + "." + method, Retcode: ar.Retcode}
+	}
+	return nil
+}
+
 // SendFile sends a local file to the server.
 // fileName is the path of local file.
 // rateInBytesPerSecond can limit bandwidth of sending,  0 means does not limit the bandwidth, unit is bytes / second.
+// If the server has a part of this file already, only the rest is sent.
 func (c *xClient) SendFile(ctx context.Context, fileName string, rateInBytesPerSecond int64, meta map[string]string) error {
-    /*
 	file, err := os.Open(fileName)
 	if err != nil {
 		return err
@@ -1155,87 +1362,134 @@ func (c *xClient) SendFile(ctx context.Context, fileName string, rateInBytesPerS
 
 	defer file.Close()
 
-	fi, err := os.Stat(fileName)
+	fi, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return err
+	}
+
 	args := share.FileTransferArgs{
 		FileName: fi.Name(),
 		FileSize: fi.Size(),
 		Meta:     meta,
+		Checksum: hex.EncodeToString(h.Sum(nil)),
 	}
 
 	ctx = setServerTimeout(ctx)
 
 	reply := &share.FileTransferReply{}
-	err = c.Call(ctx, "TransferFile", args, reply)
+	err = c.callService(ctx, "TransferFile", args, reply)
 	if err != nil {
 		return err
 	}
 
+	if reply.Offset < 0 || reply.Offset > fi.Size() {
+		return fmt.Errorf("invalid offset %d for file size %d", reply.Offset, fi.Size())
+	}
+	if _, err = file.Seek(reply.Offset, io.SeekStart); err != nil {
+		return err
+	}
+
 	conn, err := net.DialTimeout("tcp", reply.Addr, c.option.ConnectTimeout)
 	if err != nil {
 		return err
 	}
 
 	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
 
 	_, err = conn.Write(reply.Token)
 	if err != nil {
 		return err
 	}
 
-	var tb *ratelimit.Bucket
-
+	var limiter *bandwidthLimiter
 	if rateInBytesPerSecond > 0 {
-		tb = ratelimit.NewBucketWithRate(float64(rateInBytesPerSecond), rateInBytesPerSecond)
+		limiter = newBandwidthLimiter(rateInBytesPerSecond)
 	}
 
 	sendBuffer := make([]byte, FileTransferBufferSize)
-loop:
 	for {
-		select {
-		case <-ctx.Done():
-		default:
-			if tb != nil {
-				tb.Wait(FileTransferBufferSize)
-			}
-			n, err := file.Read(sendBuffer)
-			if err != nil {
-				if err == io.EOF {
-					return nil
-				} else {
+		n, er := file.Read(sendBuffer)
+		if n > 0 {
+			if limiter != nil {
+				if err = limiter.wait(ctx, n); err != nil {
 					return err
 				}
 			}
-			if n == 0 {
-				break loop
-			}
-			_, err = conn.Write(sendBuffer[:n])
-			if err != nil {
-				if err == io.EOF {
-					return nil
-				} else {
-					return err
-				}
+			if _, err = conn.Write(sendBuffer[:n]); err != nil {
+				return ctxErr(ctx, err)
 			}
 		}
-	}*/
+		if er == io.EOF {
+			break
+		}
+		if er != nil {
+			return er
+		}
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.CloseWrite()
+	}
 
+	// servers that don't reply just close the connection
+	var ack [1]byte
+	n, err := conn.Read(ack[:])
+	if n == 1 {
+		if ack[0] != share.FileTransferOK {
+			return ErrFileTransferFailed
+		}
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return ctxErr(ctx, err)
+	}
 	return nil
 }
 
+// DownloadFile downloads a file from the server and writes it to saveTo.
+// If saveTo is an io.Seeker with some data already, the download resumes from its end,
+// and if it is also an io.Reader the whole file is verified with the checksum from the server.
 func (c *xClient) DownloadFile(ctx context.Context, requestFileName string, saveTo io.Writer, meta map[string]string) error {
 	ctx = setServerTimeout(ctx)
 
+	h := sha256.New()
+	var offset int64
+	canVerify := true
+	if seeker, ok := saveTo.(io.Seeker); ok {
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if end > 0 {
+			offset = end
+			canVerify = false
+			if r, ok := saveTo.(io.Reader); ok {
+				if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				if _, err = io.CopyN(h, r, end); err != nil {
+					return err
+				}
+				canVerify = true
+			}
+		}
+	}
+
 	args := share.DownloadFileArgs{
 		FileName: requestFileName,
 		Meta:     meta,
+		Offset:   offset,
 	}
 
 	reply := &share.FileTransferReply{}
-	err := c.Call(ctx, "DownloadFile", args, reply)
+	err := c.callService(ctx, "DownloadFile", args, reply)
 	if err != nil {
 		return err
 	}
@@ -1246,39 +1500,85 @@ func (c *xClient) DownloadFile(ctx context.Context, requestFileName string, save
 	}
 
 	defer conn.Close()
+	stop := closeOnDone(ctx, conn)
+	defer stop()
 
 	_, err = conn.Write(reply.Token)
 	if err != nil {
 		return err
 	}
 
-	buf := make([]byte, FileTransferBufferSize)
-	r := bufio.NewReader(conn)
-loop:
-	for {
+	w := saveTo
+	if canVerify && reply.Checksum != "" {
+		w = io.MultiWriter(saveTo, h)
+	}
+
+	n, err := io.CopyBuffer(w, bufio.NewReader(conn), make([]byte, FileTransferBufferSize))
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	if reply.FileSize > 0 && offset+n != reply.FileSize {
+		return io.ErrUnexpectedEOF
+	}
+	if canVerify && reply.Checksum != "" && hex.EncodeToString(h.Sum(nil)) != reply.Checksum {
+		return ErrFileChecksumMismatch
+	}
+
+	return nil
+}
+
+// closeOnDone closes conn when ctx is done so blocked reads and writes return.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
 		select {
 		case <-ctx.Done():
-		default:
-			n, er := r.Read(buf)
-			if n > 0 {
-				_, ew := saveTo.Write(buf[0:n])
-				if ew != nil {
-					err = ew
-					break loop
-				}
-			}
-			if er != nil {
-				if er != io.EOF {
-					err = er
-				}
-				break loop
-			}
+			conn.Close()
+		case <-done:
 		}
-	}
+	}()
+	return func() { close(done) }
+}
 
+// ctxErr prefers the error of ctx, the err of a closed connection says nothing.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	return err
 }
 
+// bandwidthLimiter paces sending to rate bytes per second.
+type bandwidthLimiter struct {
+	rate  int64
+	start time.Time
+	sent  int64
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: rate, start: time.Now()}
+}
+
+// wait blocks until n more bytes can be sent.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.sent += int64(n)
+	expect := time.Duration(float64(l.sent) / float64(l.rate) * float64(time.Second))
+	d := expect - time.Since(l.start)
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 // Close closes this client and its underlying connections to services.
 func (c *xClient) Close() error {
 	var errs []error
@@ -1318,7 +1618,7 @@ func (c *xClient) Stream(ctx context.Context, meta map[string]string) (net.Conn,
 	ctx = setServerTimeout(ctx)
 
 	reply := &share.StreamServiceReply{}
-	err := c.Call(ctx, "Stream", args, reply)
+	err := c.callService(ctx, "Stream", args, reply)
 	if err != nil {
 		return nil, err
 	}
@@ -1336,3 +1636,28 @@ func (c *xClient) Stream(ctx context.Context, meta map[string]string) (net.Conn,
 
 	return conn, nil
 }
+
+// NewStream opens a stream to serviceMethod on the connection of a selected server.
+func (c *xClient) NewStream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	if c.isShutdown {
+		return nil, ErrXClientShutdown
+	}
+
+	if c.auth != "" {
+		metadata := ctx.Value(share.ReqMetaDataKey)
+		if metadata == nil {
+			metadata = map[string]string{}
+			ctx = context.WithValue(ctx, share.ReqMetaDataKey, metadata)
+		}
+		m := metadata.(map[string]string)
+		m[share.AuthKey] = c.auth
+	}
+
+	ctx = setServerTimeout(ctx)
+
+	_, client, err := c.selectClient(ctx, c.servicePath, serviceMethod, args)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewStream(ctx, c.servicePath, serviceMethod, args)
+}
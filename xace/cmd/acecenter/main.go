@@ -0,0 +1,62 @@
+// Acecenter runs AaceCenter, the registry of proxies, for local setups and tests.
+//
+// Usage:
+//
+//	acecenter [-addr :9000] [-data center.json] [-ttl 30s] [-proxy name [-advertise host:port]]
+//
+// With -data the instances survive restarts, without it they are kept in memory.
+// With -proxy the center registers itself under the AaceCenter interface of that
+// proxy, so clients of InitializeAceCenter find it.
+package main
+
+import (
+	"flag"
+	"net"
+
+	"xace/center"
+	"xace/log"
+	"xace/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listened address")
+	data := flag.String("data", "", "file the instances are kept in, in memory if empty")
+	ttl := flag.Duration("ttl", center.DefaultTTL, "TTL of instances that don't give their own")
+	proxy := flag.String("proxy", "", "proxy the center registers itself to")
+	advertise := flag.String("advertise", "", "host:port the center registers itself with, -addr if empty")
+	flag.Parse()
+
+	c, err := center.New(*data)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.TTL = *ttl
+
+	s := server.NewServer()
+	if err := c.Enable(s); err != nil {
+		log.Fatal(err)
+	}
+
+	if *proxy != "" {
+		hostport := *advertise
+		if hostport == "" {
+			hostport = *addr
+		}
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		if err := c.RegisterSelf(*proxy, host, port); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = s.Serve("tcp", *addr)
+	c.Close()
+	if err != nil && err != server.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
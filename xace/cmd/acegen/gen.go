@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type generator struct {
+	buf     bytes.Buffer
+	imports map[string]string // package name to import path
+}
+
+func (g *generator) p(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *generator) use(name, path string) {
+	g.imports[name] = path
+}
+
+// generate returns the formatted source of the structs and services of file.
+func generate(file *idlFile) ([]byte, error) {
+	g := &generator{imports: make(map[string]string)}
+	g.use("codec", "xace/codec")
+	if len(file.services) > 0 {
+		g.use("context", "context")
+		g.use("client", "xace/client")
+		g.use("server", "xace/server")
+	}
+
+	for _, def := range file.structs {
+		g.useReflect(def)
+		g.genMethods(def)
+	}
+	for _, svc := range file.services {
+		for _, m := range svc.methods {
+			for _, f := range append(m.args[:len(m.args):len(m.args)], m.reply...) {
+				for _, pkg := range f.pkgs {
+					path, ok := file.imports[pkg]
+					if !ok {
+						return nil, fmt.Errorf("%s.%s: unknown package %s in %s", svc.name, m.name, pkg, f.typ)
+					}
+					g.use(pkg, path)
+				}
+			}
+			args := &structDef{name: argsName(svc, m), fields: m.args}
+			reply := &structDef{name: replyName(svc, m), fields: m.reply, retcode: m.retcode}
+			g.useReflect(args)
+			g.useReflect(reply)
+			g.genStruct(args, fmt.Sprintf("are the args of %s.%s.", svc.name, m.name))
+			g.genStruct(reply, fmt.Sprintf("is the reply of %s.%s.", svc.name, m.name))
+		}
+		g.genClient(svc)
+		g.genServer(svc)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by acegen from %s. DO NOT EDIT.\n\n", file.name)
+	fmt.Fprintf(&out, "package %s\n\n", file.pkg)
+	out.WriteString("import (\n")
+	// the standard library first, then the others
+	var std, others []string
+	for name, path := range g.imports {
+		if strings.Contains(strings.SplitN(path, "/", 2)[0], ".") || strings.HasPrefix(path, "xace/") {
+			others = append(others, name)
+		} else {
+			std = append(std, name)
+		}
+	}
+	for i, names := range [][]string{std, others} {
+		if i > 0 && len(std) > 0 && len(names) > 0 {
+			out.WriteString("\n")
+		}
+		sort.Slice(names, func(i, j int) bool { return g.imports[names[i]] < g.imports[names[j]] })
+		for _, name := range names {
+			path := g.imports[name]
+			if name == path[strings.LastIndex(path, "/")+1:] {
+				fmt.Fprintf(&out, "\t%q\n", path)
+			} else {
+				fmt.Fprintf(&out, "\t%s %q\n", name, path)
+			}
+		}
+	}
+	out.WriteString(")\n\n")
+	out.Write(g.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return src, nil
+}
+
+// useReflect imports reflect if a field of def is written by reflection.
+func (g *generator) useReflect(def *structDef) {
+	for _, f := range def.fields {
+		if f != nil && f.kind == kindReflect {
+			g.use("reflect", "reflect")
+		}
+	}
+}
+
+func argsName(svc *service, m *method) string {
+	return svc.name + m.name + "Args"
+}
+
+func replyName(svc *service, m *method) string {
+	return svc.name + m.name + "Reply"
+}
+
+// genStruct declares the args or reply struct of a method with its methods.
+// A reply of a retcode method also keeps the retcode the client receives.
+func (g *generator) genStruct(def *structDef, doc string) {
+	g.p("// %s %s", def.name, doc)
+	g.p("type %s struct {", def.name)
+	for _, f := range def.fields {
+		g.p("%s %s", f.name, f.typ)
+	}
+	if def.retcode {
+		g.p("retcode int32")
+	}
+	g.p("}")
+	g.p("")
+
+	g.p("func (x *%s) Reset() { *x = %s{} }", def.name, def.name)
+	g.p("")
+	if def.retcode {
+		g.p("func (x *%s) SetRetcode(retcode int32) { x.retcode = retcode }", def.name)
+		g.p("")
+	}
+	g.genMethods(def)
+}
+
+// genMethods writes MarshalAce and UnmarshalAce of def, they read and write the
+// fields at their positions as the reflection of the codec package does.
+func (g *generator) genMethods(def *structDef) {
+	// the empty omitempty fields and the gaps at the end are not written
+	last := len(def.fields)
+	for last > 0 && (def.fields[last-1] == nil || def.fields[last-1].omitEmpty) {
+		last--
+	}
+
+	g.p("func (x *%s) MarshalAce(e *codec.EncBuffer) error {", def.name)
+	if last == len(def.fields) {
+		g.p("e.PackFieldNum(%d)", len(def.fields))
+	} else {
+		g.p("n := %d", len(def.fields))
+		for i := len(def.fields) - 1; i >= last; i-- {
+			if f := def.fields[i]; f != nil {
+				g.p("if n == %d && %s {", i+1, emptyCheck(f, "x."+f.name))
+			} else {
+				g.p("if n == %d {", i+1)
+			}
+			g.p("n = %d", i)
+			g.p("}")
+		}
+		g.p("e.PackFieldNum(n)")
+	}
+	for i, f := range def.fields {
+		if i >= last {
+			g.p("if n > %d {", i)
+		}
+		switch {
+		case f == nil:
+			g.p("e.PackFieldType(codec.FT_PACK)")
+		case f.omitEmpty:
+			g.p("if %s {", emptyCheck(f, "x."+f.name))
+			g.p("e.PackFieldType(codec.FT_PACK)")
+			g.p("} else {")
+			g.encodeField(f, "x."+f.name)
+			g.p("}")
+		default:
+			g.encodeField(f, "x."+f.name)
+		}
+		if i >= last {
+			g.p("}")
+		}
+	}
+	g.p("return e.Err()")
+	g.p("}")
+	g.p("")
+
+	g.p("func (x *%s) UnmarshalAce(d *codec.DecBuffer) error {", def.name)
+	g.p("n := int(d.UnpackFieldNum())")
+	g.p("for i := 0; i < n && d.Err() == nil; i++ {")
+	if len(def.fields) == 0 {
+		g.p("d.SkipField(d.UnpackField())")
+	} else {
+		g.p("field := d.UnpackField()")
+		g.p("switch i {")
+		for i, f := range def.fields {
+			if f == nil {
+				continue
+			}
+			g.p("case %d:", i)
+			g.decodeField(f, "x."+f.name)
+		}
+		g.p("default:")
+		g.p("d.SkipField(field)")
+		g.p("}")
+	}
+	g.p("}")
+	g.p("return d.Err()")
+	g.p("}")
+	g.p("")
+}
+
+// emptyCheck returns the condition that v of field f is empty for omitempty.
+func emptyCheck(f *field, v string) string {
+	switch f.kind {
+	case kindBool:
+		return "!" + v
+	case kindByte, kindInt, kindUint, kindFloat:
+		return v + " == 0"
+	case kindString, kindBytes, kindSlice, kindMap:
+		return "len(" + v + ") == 0"
+	}
+	return "codec.IsEmpty(" + v + ")"
+}
+
+// fieldTypes returns the field types f is written with, a container is followed by
+// the types of its keys and elements.
+func fieldTypes(f *field) []string {
+	switch f.kind {
+	case kindBool, kindByte:
+		return []string{"FT_CHAR"}
+	case kindInt, kindUint:
+		return []string{"FT_NUMBER"}
+	case kindFloat:
+		return []string{"FT_FLOAT"}
+	case kindString:
+		return []string{"FT_STRING"}
+	case kindBytes:
+		return []string{"FT_BYTES"}
+	case kindTime:
+		return []string{"FT_DATE"}
+	case kindStruct:
+		return []string{"FT_STRUCT"}
+	case kindSlice:
+		return append([]string{"FT_ARRAY"}, fieldTypes(f.elem)...)
+	case kindMap:
+		return append(append([]string{"FT_MAP"}, fieldTypes(f.key)...), fieldTypes(f.elem)...)
+	}
+	return nil
+}
+
+// encodeField writes the field type and the value of v as the codec does for its type.
+func (g *generator) encodeField(f *field, v string) {
+	if f.kind == kindReflect {
+		g.p("e.EncodeValue(reflect.ValueOf(%s))", v)
+		return
+	}
+	for _, ft := range fieldTypes(f) {
+		g.p("e.PackFieldType(codec.%s)", ft)
+	}
+	g.encodeValue(f, v, 0)
+}
+
+// encodeValue writes v without its field type, as the keys and elements of containers
+// are. The loop variables are numbered by the depth of the container.
+func (g *generator) encodeValue(f *field, v string, depth int) {
+	switch f.kind {
+	case kindBool:
+		g.p("e.PackBool(%s)", v)
+	case kindByte:
+		g.p("e.PackByte(%s)", v)
+	case kindInt:
+		g.p("e.PackInt(%s)", convert("int64", f.typ, v))
+	case kindUint:
+		g.p("e.PackUint(%s)", convert("uint64", f.typ, v))
+	case kindFloat:
+		g.p("e.PackFloat(%s)", convert("float64", f.typ, v))
+	case kindString:
+		g.p("e.PackString(%s)", v)
+	case kindBytes:
+		g.p("e.PackBytes(%s)", v)
+	case kindTime:
+		g.p("e.PackInt(%s.UnixMilli())", v)
+	case kindStruct:
+		g.p("if err := %s.MarshalAce(e); err != nil {", v)
+		g.p("return err")
+		g.p("}")
+	case kindSlice:
+		i := fmt.Sprintf("i%d", depth)
+		g.p("e.PackNum(len(%s))", v)
+		g.p("for %s := range %s {", i, v)
+		g.encodeValue(f.elem, v+"["+i+"]", depth+1)
+		g.p("}")
+	case kindMap:
+		k, e := fmt.Sprintf("k%d", depth), fmt.Sprintf("v%d", depth)
+		g.p("e.PackNum(len(%s))", v)
+		g.p("for %s, %s := range %s {", k, e, v)
+		g.encodeValue(f.key, k, depth+1)
+		g.encodeValue(f.elem, e, depth+1)
+		g.p("}")
+	}
+}
+
+// decodeField reads field into v.
+func (g *generator) decodeField(f *field, v string) {
+	if f.kind == kindReflect {
+		g.p("d.DecodeField(field, &%s)", v)
+		return
+	}
+	g.decodeValue(f, v, "field", 0)
+}
+
+// decodeValue reads v written as the field type in the variable ft.
+func (g *generator) decodeValue(f *field, v, ft string, depth int) {
+	switch f.kind {
+	case kindBool:
+		g.p("%s = d.DecodeBool(%s)", v, ft)
+	case kindByte:
+		g.p("%s = %s", v, convert(f.typ, "uint64", "d.DecodeUint("+ft+")"))
+	case kindInt:
+		g.p("%s = %s", v, convert(f.typ, "int64", "d.DecodeInt("+ft+")"))
+	case kindUint:
+		g.p("%s = %s", v, convert(f.typ, "uint64", "d.DecodeUint("+ft+")"))
+	case kindFloat:
+		g.p("%s = %s", v, convert(f.typ, "float64", "d.DecodeFloat("+ft+")"))
+	case kindString:
+		g.p("%s = d.DecodeString(%s)", v, ft)
+	case kindBytes:
+		g.p("%s = d.DecodeBytes(%s)", v, ft)
+	case kindTime:
+		g.p("%s = d.DecodeTime(%s)", v, ft)
+	case kindStruct:
+		g.p("d.DecodeAce(%s, &%s)", ft, v)
+	case kindSlice:
+		n, i, elem := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth), fmt.Sprintf("elem%d", depth)
+		g.p("%s, %s := d.DecodeArray(%s)", n, elem, ft)
+		// a negative count is FT_PACK, a nil slice, the others reuse v as reflection does
+		g.p("switch {")
+		g.p("case %s < 0:", n)
+		g.p("%s = nil", v)
+		g.p("case cap(%s) < %s:", v, n)
+		g.p("%s = make(%s, %s)", v, f.typ, n)
+		g.p("default:")
+		g.p("%s = %s[:%s]", v, v, n)
+		g.p("}")
+		g.p("for %s := 0; %s < %s && d.Err() == nil; %s++ {", i, i, n, i)
+		g.decodeValue(f.elem, v+"["+i+"]", elem, depth+1)
+		g.p("}")
+	case kindMap:
+		n, i := fmt.Sprintf("n%d", depth), fmt.Sprintf("i%d", depth)
+		key, elem := fmt.Sprintf("key%d", depth), fmt.Sprintf("elem%d", depth)
+		k, e := fmt.Sprintf("k%d", depth), fmt.Sprintf("v%d", depth)
+		g.p("%s, %s, %s := d.DecodeMap(%s)", n, key, elem, ft)
+		// the entries are added to a map v already holds
+		g.p("if %s < 0 {", n)
+		g.p("%s = nil", v)
+		g.p("} else if %s == nil {", v)
+		g.p("%s = make(%s, %s)", v, f.typ, n)
+		g.p("}")
+		g.p("for %s := 0; %s < %s && d.Err() == nil; %s++ {", i, i, n, i)
+		g.p("var %s %s", k, f.key.typ)
+		g.p("var %s %s", e, f.elem.typ)
+		g.decodeValue(f.key, k, key, depth+1)
+		g.decodeValue(f.elem, e, elem, depth+1)
+		g.p("%s[%s] = %s", v, k, e)
+		g.p("}")
+	}
+}
+
+// convert converts v of type from to type to, if they differ.
+func convert(to, from, v string) string {
+	if to == from {
+		return v
+	}
+	return to + "(" + v + ")"
+}
+
+func (g *generator) genClient(svc *service) {
+	name := svc.name + "Client"
+	pathConst := svc.name + "ServicePath"
+
+	g.p("// %s is the service path %s clients call.", pathConst, svc.name)
+	g.p("const %s = %q", pathConst, svc.path)
+	g.p("")
+	g.p("// %s calls the %s service through an AceClient.", name, svc.name)
+	g.p("type %s struct {", name)
+	g.p("c *client.AceClient")
+	g.p("}")
+	g.p("")
+	g.p("// New%s returns a %s that calls %s.", name, name, pathConst)
+	g.p("func New%s(c *client.AceClient) *%s {", name, name)
+	g.p("return &%s{c: c}", name)
+	g.p("}")
+	g.p("")
+
+	for _, m := range svc.methods {
+		// the locals of the method must not hide the parameters
+		taken := make(map[string]bool)
+		for _, f := range m.args {
+			taken[f.param] = true
+		}
+		recv := local("c", taken)
+		ctx := local("ctx", taken)
+		args := local("args", taken)
+		reply := local("reply", taken)
+		err := local("err", taken)
+
+		params := []string{ctx + " context.Context"}
+		for _, f := range m.args {
+			params = append(params, f.param+" "+f.typ)
+		}
+		var results, values []string
+		for _, f := range m.reply {
+			results = append(results, f.typ)
+			values = append(values, reply+"."+f.name)
+		}
+		if m.retcode {
+			results = append(results, "int32")
+			values = append(values, reply+".retcode")
+		}
+		results = append(results, "error")
+		values = append(values, err)
+
+		g.p("// %s calls %s.%s.", m.name, svc.name, m.name)
+		g.p("func (%s *%s) %s(%s) (%s) {", recv, name, m.name, strings.Join(params, ", "), strings.Join(results, ", "))
+		g.p("%s := &%s{", args, argsName(svc, m))
+		for _, f := range m.args {
+			g.p("%s: %s,", f.name, f.param)
+		}
+		g.p("}")
+		g.p("%s := &%s{}", reply, replyName(svc, m))
+		g.p("%s := %s.c.Call(%s, %s, %q, %s, %s)", err, recv, ctx, pathConst, strings.ToLower(m.name), args, reply)
+		g.p("return %s", strings.Join(values, ", "))
+		g.p("}")
+		g.p("")
+	}
+}
+
+// local returns name, or name with a number if a parameter has it.
+func local(name string, taken map[string]bool) string {
+	l := name
+	for i := 1; taken[l]; i++ {
+		l = name + strconv.Itoa(i)
+	}
+	taken[l] = true
+	return l
+}
+
+func (g *generator) genServer(svc *service) {
+	name := unexported(svc.name) + "Service"
+	inter := svc.path[strings.Index(svc.path, ".")+1:]
+
+	g.p("// Register%s registers impl as the %s service of s.", svc.name, inter)
+	g.p("func Register%s(s *server.Server, impl %s, metadata string) error {", svc.name, svc.name)
+	g.p("return s.RegisterName(%q, &%s{impl: impl}, metadata)", inter, name)
+	g.p("}")
+	g.p("")
+	g.p("// %s calls a %s with the args and reply the server decodes.", name, svc.name)
+	g.p("type %s struct {", name)
+	g.p("impl %s", svc.name)
+	g.p("}")
+	g.p("")
+
+	for _, m := range svc.methods {
+		ret := "error"
+		last := "err"
+		if m.retcode {
+			ret = "int32"
+			last = "retcode"
+		}
+		var in []string
+		for _, f := range m.args {
+			in = append(in, "args."+f.name)
+		}
+		call := fmt.Sprintf("s.impl.%s(%s)", m.name, strings.Join(append([]string{"ctx"}, in...), ", "))
+
+		g.p("func (s *%s) %s(ctx context.Context, args *%s, reply *%s) %s {", name, m.name, argsName(svc, m), replyName(svc, m), ret)
+		if len(m.reply) == 0 {
+			g.p("return %s", call)
+		} else {
+			var out []string
+			for _, f := range m.reply {
+				out = append(out, "reply."+f.name)
+			}
+			g.p("var %s %s", last, ret)
+			g.p("%s, %s = %s", strings.Join(out, ", "), last, call)
+			g.p("return %s", last)
+		}
+		g.p("}")
+		g.p("")
+	}
+}
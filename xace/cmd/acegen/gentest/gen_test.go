@@ -0,0 +1,227 @@
+package gentest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/codec"
+	"xace/server"
+)
+
+type plainInner struct {
+	A int16
+	B []string `ace:",omitempty"`
+}
+
+// plainTagged is laid out as Tagged but goes through reflection.
+type plainTagged struct {
+	X     int         `ace:"x,order=2"`
+	Y     string      `ace:",omitempty"`
+	Z     *plainInner `ace:",order=6,omitempty"`
+	In    []plainInner
+	M     map[string]plainInner
+	Any   any
+	skip  int
+	Inner plainInner `ace:",omitempty"`
+	Last  uint64     `ace:",order=12,omitempty"`
+}
+
+func plainOf(x *Tagged) *plainTagged {
+	p := &plainTagged{X: x.X, Y: x.Y, Any: x.Any, Last: x.Last, Inner: plainInner(x.Inner)}
+	if x.Z != nil {
+		z := plainInner(*x.Z)
+		p.Z = &z
+	}
+	for _, in := range x.In {
+		p.In = append(p.In, plainInner(in))
+	}
+	if x.M != nil {
+		p.M = make(map[string]plainInner)
+		for k, v := range x.M {
+			p.M[k] = plainInner(v)
+		}
+	}
+	return p
+}
+
+func TestGenType(t *testing.T) {
+	cases := []Tagged{
+		{},
+		{X: 1},
+		{X: 1, Y: "y", Z: &Inner{A: 2}, In: []Inner{{A: 3, B: []string{"b"}}}, M: map[string]Inner{"k": {A: 4}}, Any: "s", Inner: Inner{A: 5}},
+		{Last: 9},
+		{Inner: Inner{B: []string{}}},
+	}
+	for i, c := range cases {
+		fast, err := codec.EncodeArgs(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := plainOf(&c)
+		slow, err := codec.EncodeArgs(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// reflection sends the struct as the only parameter
+		if !bytes.Equal(fast, slow[2:]) {
+			t.Fatalf("case %d:\n%v\n%v", i, fast, slow[2:])
+		}
+
+		var back Tagged
+		if err := codec.DecodeArgs(slow, []any{&back}); err != nil {
+			t.Fatal(err)
+		}
+		var pb plainTagged
+		if err := codec.DecodeArgs(fast, &pb); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(plainOf(&back), &pb) {
+			t.Fatalf("case %d:\n%+v\n%+v", i, back, pb)
+		}
+
+		// nested in containers, read by the generated methods and by reflection
+		nested, err := codec.EncodeArgs([]any{[]Tagged{c}, map[int]*Tagged{1: &c}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ws []Tagged
+		var wm map[int]*Tagged
+		if err := codec.DecodeArgs(nested, []any{&ws, &wm}); err != nil {
+			t.Fatal(err)
+		}
+		var ps []plainTagged
+		if err := codec.DecodeArgs(nested, []any{&ps}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ws[0], *wm[1]) || !reflect.DeepEqual(plainOf(&ws[0]), &ps[0]) || ws[0].X != c.X {
+			t.Fatalf("case %d: %+v %+v %+v", i, ws, wm, ps)
+		}
+	}
+
+	// a slice and a map the generated methods read into are reused as reflection does
+	data, _ := codec.EncodeArgs(&Tagged{In: []Inner{{A: 1}}, M: map[string]Inner{"new": {A: 2}}})
+	back := Tagged{In: make([]Inner, 0, 4), M: map[string]Inner{"old": {A: 3}}}
+	in := back.In
+	if err := codec.DecodeArgs(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if &back.In[0] != &in[:1][0] || len(back.M) != 2 {
+		t.Fatalf("%+v", back)
+	}
+
+	// the wrong field type for a slice fails
+	bad, _ := codec.EncodeArgs([]any{1, "y", 2.5, "not a slice"})
+	if err := codec.DecodeArgs(bad, &back); !errors.Is(err, codec.ErrTypeMismatch) {
+		t.Fatal(err)
+	}
+}
+
+func sinkArgs() *SinkAllArgs {
+	return &SinkAllArgs{B: true, U8: 3, Bt: 4, I: 5, I8: -6, I32: 7, U: 8, U16: 9, F32: 1.5, F: 2.5, S: "x", Raw: []byte{1, 2},
+		T: time.UnixMilli(1700000000123), Items: []Item{{Name: "a", N: 1}}, M: map[string]int{"k": 1}, P: &Item{Name: "p", N: 2}, A: "any"}
+}
+
+func TestGenArgs(t *testing.T) {
+	a := sinkArgs()
+	fast, err := codec.EncodeArgs(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type plain SinkAllArgs
+	p := plain(*a)
+	slow, err := codec.EncodeArgs(&p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fast, slow[2:]) {
+		t.Fatalf("\n%v\n%v", fast, slow[2:])
+	}
+	var back SinkAllArgs
+	if err := codec.DecodeArgs(fast, &back); err != nil {
+		t.Fatal(err)
+	}
+	var pb plain
+	if err := codec.DecodeArgs(slow, []any{&pb}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain(back), pb) {
+		t.Fatalf("%+v\n%+v", back, pb)
+	}
+
+	// fewer parameters than fields, converted as reflection converts them
+	short, _ := codec.EncodeArgs([]any{true, "7"})
+	var s SinkAllArgs
+	if err := codec.DecodeArgs(short, &s); err != nil || !s.B || s.U8 != 7 {
+		t.Fatal(err, s)
+	}
+	bad, _ := codec.EncodeArgs([]any{1.5})
+	if err := codec.DecodeArgs(bad, &s); !errors.Is(err, codec.ErrTypeMismatch) {
+		t.Fatal(err)
+	}
+}
+
+type sink struct{}
+
+func (sink) All(ctx context.Context, b bool, u8 uint8, bt byte, i int, i8 int8, i32 int32, u uint, u16 uint16, f32 float32, f float64, s string, raw []byte, t time.Time, items []Item, m map[string]int, p *Item, a any) (bool, uint8, int8, float32, string, []byte, time.Time, []Item, *Item, any, int32) {
+	return b, u8 + bt, i8, f32, s + "!", raw, t, items, p, a, int32(i + int(i32) + int(u) + int(u16) + len(m))
+}
+
+func (sink) Err(ctx context.Context, s string, n int) error {
+	if n < 0 {
+		return errors.New("negative")
+	}
+	return nil
+}
+
+func (sink) None(ctx context.Context) error { return nil }
+
+func (sink) Clash(ctx context.Context, c, args, reply, err string) int32 {
+	return int32(len(c + args + reply + err))
+}
+
+func TestGenService(t *testing.T) {
+	s := server.NewServer()
+	if err := RegisterSink(s, sink{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer s.Close()
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xc := client.NewXClient("Sink", client.Failtry, client.RandomSelect, d, client.AceOption)
+	defer xc.Close()
+	ctx := context.Background()
+
+	a := sinkArgs()
+	r := &SinkAllReply{}
+	if err := xc.Call(ctx, "all", a, r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Rb || r.Ru8 != 7 || r.Ri8 != -6 || r.Rs != "x!" || !r.Rt.Equal(a.T) || r.Rp.Name != "p" || r.Ra != "any" || !reflect.DeepEqual(r.Ritems, a.Items) {
+		t.Fatalf("%+v", r)
+	}
+
+	er := &SinkErrReply{}
+	if err := xc.Call(ctx, "err", &SinkErrArgs{Arg0: "s", Arg1: -1}, er); err == nil || err.Error() != "negative" {
+		t.Fatal(err)
+	}
+	if err := xc.Call(ctx, "err", &SinkErrArgs{Arg0: "s", Arg1: 1}, er); err != nil {
+		t.Fatal(err)
+	}
+	if err := xc.Call(ctx, "none", &SinkNoneArgs{}, &SinkNoneReply{}); err != nil {
+		t.Fatal(err)
+	}
+	// a reflective client against the generated server
+	var rb bool
+	var ru8 uint8
+	if err := xc.Call(ctx, "all", []any{true, 1, 2}, []any{&rb, &ru8}); err != nil || !rb || ru8 != 3 {
+		t.Fatal(err, rb, ru8)
+	}
+}
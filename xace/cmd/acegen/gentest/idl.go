@@ -0,0 +1,45 @@
+// Package gentest has the structs and services the tests of acegen generate code for,
+// the generated methods are checked against reflection.
+package gentest
+
+//go:generate go run xace/cmd/acegen -type Tagged,Inner,Item idl.go
+
+import (
+	"context"
+	"time"
+)
+
+// Item is a struct in a slice, as a parameter and a reply.
+type Item struct {
+	Name string
+	N    int
+}
+
+// Sink takes and returns the kinds of values acegen writes.
+//
+//ace:service Proxy.Sink
+type Sink interface {
+	All(ctx context.Context, b bool, u8 uint8, bt byte, i int, i8 int8, i32 int32, u uint, u16 uint16, f32 float32, f float64, s string, raw []byte, t time.Time, items []Item, m map[string]int, p *Item, a any) (rb bool, ru8 uint8, ri8 int8, rf32 float32, rs string, rraw []byte, rt time.Time, ritems []Item, rp *Item, ra any, retcode int32)
+	Err(context.Context, string, int) error
+	None(ctx context.Context) error
+	Clash(ctx context.Context, c, args, reply, err string) int32
+}
+
+// Inner is nested in Tagged, as a value, a pointer, an element and embedded.
+type Inner struct {
+	A int16
+	B []string `ace:",omitempty"`
+}
+
+// Tagged has fields moved, left out and omitted by their tags.
+type Tagged struct {
+	X     int    `ace:"x,order=2"`
+	Y     string `ace:",omitempty"`
+	Z     *Inner `ace:",order=6,omitempty"`
+	In    []Inner
+	M     map[string]Inner
+	Any   any
+	skip  int
+	Inner `ace:",omitempty"`
+	Last  uint64 `ace:",order=12,omitempty"`
+}
@@ -0,0 +1,697 @@
+// Code generated by acegen from idl.go. DO NOT EDIT.
+
+package gentest
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"xace/client"
+	"xace/codec"
+	"xace/server"
+)
+
+func (x *Item) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(2)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Name)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.N))
+	return e.Err()
+}
+
+func (x *Item) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Name = d.DecodeString(field)
+		case 1:
+			x.N = int(d.DecodeInt(field))
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *Inner) MarshalAce(e *codec.EncBuffer) error {
+	n := 2
+	if n == 2 && len(x.B) == 0 {
+		n = 1
+	}
+	e.PackFieldNum(n)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.A))
+	if n > 1 {
+		if len(x.B) == 0 {
+			e.PackFieldType(codec.FT_PACK)
+		} else {
+			e.PackFieldType(codec.FT_ARRAY)
+			e.PackFieldType(codec.FT_STRING)
+			e.PackNum(len(x.B))
+			for i0 := range x.B {
+				e.PackString(x.B[i0])
+			}
+		}
+	}
+	return e.Err()
+}
+
+func (x *Inner) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.A = int16(d.DecodeInt(field))
+		case 1:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.B = nil
+			case cap(x.B) < n0:
+				x.B = make([]string, n0)
+			default:
+				x.B = x.B[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				x.B[i0] = d.DecodeString(elem0)
+			}
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *Tagged) MarshalAce(e *codec.EncBuffer) error {
+	n := 13
+	if n == 13 && x.Last == 0 {
+		n = 12
+	}
+	if n == 12 {
+		n = 11
+	}
+	if n == 11 && codec.IsEmpty(x.Inner) {
+		n = 10
+	}
+	e.PackFieldNum(n)
+	e.PackFieldType(codec.FT_PACK)
+	e.PackFieldType(codec.FT_PACK)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.X))
+	if len(x.Y) == 0 {
+		e.PackFieldType(codec.FT_PACK)
+	} else {
+		e.PackFieldType(codec.FT_STRING)
+		e.PackString(x.Y)
+	}
+	e.PackFieldType(codec.FT_PACK)
+	e.PackFieldType(codec.FT_PACK)
+	if codec.IsEmpty(x.Z) {
+		e.PackFieldType(codec.FT_PACK)
+	} else {
+		e.EncodeValue(reflect.ValueOf(x.Z))
+	}
+	e.PackFieldType(codec.FT_ARRAY)
+	e.PackFieldType(codec.FT_STRUCT)
+	e.PackNum(len(x.In))
+	for i0 := range x.In {
+		if err := x.In[i0].MarshalAce(e); err != nil {
+			return err
+		}
+	}
+	e.PackFieldType(codec.FT_MAP)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackFieldType(codec.FT_STRUCT)
+	e.PackNum(len(x.M))
+	for k0, v0 := range x.M {
+		e.PackString(k0)
+		if err := v0.MarshalAce(e); err != nil {
+			return err
+		}
+	}
+	e.EncodeValue(reflect.ValueOf(x.Any))
+	if n > 10 {
+		if codec.IsEmpty(x.Inner) {
+			e.PackFieldType(codec.FT_PACK)
+		} else {
+			e.PackFieldType(codec.FT_STRUCT)
+			if err := x.Inner.MarshalAce(e); err != nil {
+				return err
+			}
+		}
+	}
+	if n > 11 {
+		e.PackFieldType(codec.FT_PACK)
+	}
+	if n > 12 {
+		if x.Last == 0 {
+			e.PackFieldType(codec.FT_PACK)
+		} else {
+			e.PackFieldType(codec.FT_NUMBER)
+			e.PackUint(x.Last)
+		}
+	}
+	return e.Err()
+}
+
+func (x *Tagged) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 2:
+			x.X = int(d.DecodeInt(field))
+		case 3:
+			x.Y = d.DecodeString(field)
+		case 6:
+			d.DecodeField(field, &x.Z)
+		case 7:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.In = nil
+			case cap(x.In) < n0:
+				x.In = make([]Inner, n0)
+			default:
+				x.In = x.In[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				d.DecodeAce(elem0, &x.In[i0])
+			}
+		case 8:
+			n0, key0, elem0 := d.DecodeMap(field)
+			if n0 < 0 {
+				x.M = nil
+			} else if x.M == nil {
+				x.M = make(map[string]Inner, n0)
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				var k0 string
+				var v0 Inner
+				k0 = d.DecodeString(key0)
+				d.DecodeAce(elem0, &v0)
+				x.M[k0] = v0
+			}
+		case 9:
+			d.DecodeField(field, &x.Any)
+		case 10:
+			d.DecodeAce(field, &x.Inner)
+		case 12:
+			x.Last = d.DecodeUint(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// SinkAllArgs are the args of Sink.All.
+type SinkAllArgs struct {
+	B     bool
+	U8    uint8
+	Bt    byte
+	I     int
+	I8    int8
+	I32   int32
+	U     uint
+	U16   uint16
+	F32   float32
+	F     float64
+	S     string
+	Raw   []byte
+	T     time.Time
+	Items []Item
+	M     map[string]int
+	P     *Item
+	A     any
+}
+
+func (x *SinkAllArgs) Reset() { *x = SinkAllArgs{} }
+
+func (x *SinkAllArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(17)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackBool(x.B)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.U8)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.Bt)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.I))
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.I8))
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.I32))
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackUint(uint64(x.U))
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackUint(uint64(x.U16))
+	e.PackFieldType(codec.FT_FLOAT)
+	e.PackFloat(float64(x.F32))
+	e.PackFieldType(codec.FT_FLOAT)
+	e.PackFloat(x.F)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.S)
+	e.PackFieldType(codec.FT_BYTES)
+	e.PackBytes(x.Raw)
+	e.PackFieldType(codec.FT_DATE)
+	e.PackInt(x.T.UnixMilli())
+	e.PackFieldType(codec.FT_ARRAY)
+	e.PackFieldType(codec.FT_STRUCT)
+	e.PackNum(len(x.Items))
+	for i0 := range x.Items {
+		if err := x.Items[i0].MarshalAce(e); err != nil {
+			return err
+		}
+	}
+	e.PackFieldType(codec.FT_MAP)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackNum(len(x.M))
+	for k0, v0 := range x.M {
+		e.PackString(k0)
+		e.PackInt(int64(v0))
+	}
+	e.EncodeValue(reflect.ValueOf(x.P))
+	e.EncodeValue(reflect.ValueOf(x.A))
+	return e.Err()
+}
+
+func (x *SinkAllArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.B = d.DecodeBool(field)
+		case 1:
+			x.U8 = uint8(d.DecodeUint(field))
+		case 2:
+			x.Bt = byte(d.DecodeUint(field))
+		case 3:
+			x.I = int(d.DecodeInt(field))
+		case 4:
+			x.I8 = int8(d.DecodeInt(field))
+		case 5:
+			x.I32 = int32(d.DecodeInt(field))
+		case 6:
+			x.U = uint(d.DecodeUint(field))
+		case 7:
+			x.U16 = uint16(d.DecodeUint(field))
+		case 8:
+			x.F32 = float32(d.DecodeFloat(field))
+		case 9:
+			x.F = d.DecodeFloat(field)
+		case 10:
+			x.S = d.DecodeString(field)
+		case 11:
+			x.Raw = d.DecodeBytes(field)
+		case 12:
+			x.T = d.DecodeTime(field)
+		case 13:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.Items = nil
+			case cap(x.Items) < n0:
+				x.Items = make([]Item, n0)
+			default:
+				x.Items = x.Items[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				d.DecodeAce(elem0, &x.Items[i0])
+			}
+		case 14:
+			n0, key0, elem0 := d.DecodeMap(field)
+			if n0 < 0 {
+				x.M = nil
+			} else if x.M == nil {
+				x.M = make(map[string]int, n0)
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				var k0 string
+				var v0 int
+				k0 = d.DecodeString(key0)
+				v0 = int(d.DecodeInt(elem0))
+				x.M[k0] = v0
+			}
+		case 15:
+			d.DecodeField(field, &x.P)
+		case 16:
+			d.DecodeField(field, &x.A)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// SinkAllReply is the reply of Sink.All.
+type SinkAllReply struct {
+	Rb      bool
+	Ru8     uint8
+	Ri8     int8
+	Rf32    float32
+	Rs      string
+	Rraw    []byte
+	Rt      time.Time
+	Ritems  []Item
+	Rp      *Item
+	Ra      any
+	retcode int32
+}
+
+func (x *SinkAllReply) Reset() { *x = SinkAllReply{} }
+
+func (x *SinkAllReply) SetRetcode(retcode int32) { x.retcode = retcode }
+
+func (x *SinkAllReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(10)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackBool(x.Rb)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.Ru8)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.Ri8))
+	e.PackFieldType(codec.FT_FLOAT)
+	e.PackFloat(float64(x.Rf32))
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Rs)
+	e.PackFieldType(codec.FT_BYTES)
+	e.PackBytes(x.Rraw)
+	e.PackFieldType(codec.FT_DATE)
+	e.PackInt(x.Rt.UnixMilli())
+	e.PackFieldType(codec.FT_ARRAY)
+	e.PackFieldType(codec.FT_STRUCT)
+	e.PackNum(len(x.Ritems))
+	for i0 := range x.Ritems {
+		if err := x.Ritems[i0].MarshalAce(e); err != nil {
+			return err
+		}
+	}
+	e.EncodeValue(reflect.ValueOf(x.Rp))
+	e.EncodeValue(reflect.ValueOf(x.Ra))
+	return e.Err()
+}
+
+func (x *SinkAllReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Rb = d.DecodeBool(field)
+		case 1:
+			x.Ru8 = uint8(d.DecodeUint(field))
+		case 2:
+			x.Ri8 = int8(d.DecodeInt(field))
+		case 3:
+			x.Rf32 = float32(d.DecodeFloat(field))
+		case 4:
+			x.Rs = d.DecodeString(field)
+		case 5:
+			x.Rraw = d.DecodeBytes(field)
+		case 6:
+			x.Rt = d.DecodeTime(field)
+		case 7:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.Ritems = nil
+			case cap(x.Ritems) < n0:
+				x.Ritems = make([]Item, n0)
+			default:
+				x.Ritems = x.Ritems[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				d.DecodeAce(elem0, &x.Ritems[i0])
+			}
+		case 8:
+			d.DecodeField(field, &x.Rp)
+		case 9:
+			d.DecodeField(field, &x.Ra)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// SinkErrArgs are the args of Sink.Err.
+type SinkErrArgs struct {
+	Arg0 string
+	Arg1 int
+}
+
+func (x *SinkErrArgs) Reset() { *x = SinkErrArgs{} }
+
+func (x *SinkErrArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(2)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Arg0)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.Arg1))
+	return e.Err()
+}
+
+func (x *SinkErrArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Arg0 = d.DecodeString(field)
+		case 1:
+			x.Arg1 = int(d.DecodeInt(field))
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// SinkErrReply is the reply of Sink.Err.
+type SinkErrReply struct {
+}
+
+func (x *SinkErrReply) Reset() { *x = SinkErrReply{} }
+
+func (x *SinkErrReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(0)
+	return e.Err()
+}
+
+func (x *SinkErrReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		d.SkipField(d.UnpackField())
+	}
+	return d.Err()
+}
+
+// SinkNoneArgs are the args of Sink.None.
+type SinkNoneArgs struct {
+}
+
+func (x *SinkNoneArgs) Reset() { *x = SinkNoneArgs{} }
+
+func (x *SinkNoneArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(0)
+	return e.Err()
+}
+
+func (x *SinkNoneArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		d.SkipField(d.UnpackField())
+	}
+	return d.Err()
+}
+
+// SinkNoneReply is the reply of Sink.None.
+type SinkNoneReply struct {
+}
+
+func (x *SinkNoneReply) Reset() { *x = SinkNoneReply{} }
+
+func (x *SinkNoneReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(0)
+	return e.Err()
+}
+
+func (x *SinkNoneReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		d.SkipField(d.UnpackField())
+	}
+	return d.Err()
+}
+
+// SinkClashArgs are the args of Sink.Clash.
+type SinkClashArgs struct {
+	C     string
+	Args  string
+	Reply string
+	Err   string
+}
+
+func (x *SinkClashArgs) Reset() { *x = SinkClashArgs{} }
+
+func (x *SinkClashArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(4)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.C)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Args)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Reply)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Err)
+	return e.Err()
+}
+
+func (x *SinkClashArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.C = d.DecodeString(field)
+		case 1:
+			x.Args = d.DecodeString(field)
+		case 2:
+			x.Reply = d.DecodeString(field)
+		case 3:
+			x.Err = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// SinkClashReply is the reply of Sink.Clash.
+type SinkClashReply struct {
+	retcode int32
+}
+
+func (x *SinkClashReply) Reset() { *x = SinkClashReply{} }
+
+func (x *SinkClashReply) SetRetcode(retcode int32) { x.retcode = retcode }
+
+func (x *SinkClashReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(0)
+	return e.Err()
+}
+
+func (x *SinkClashReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		d.SkipField(d.UnpackField())
+	}
+	return d.Err()
+}
+
+// SinkServicePath is the service path Sink clients call.
+const SinkServicePath = "Proxy.Sink"
+
+// SinkClient calls the Sink service through an AceClient.
+type SinkClient struct {
+	c *client.AceClient
+}
+
+// NewSinkClient returns a SinkClient that calls SinkServicePath.
+func NewSinkClient(c *client.AceClient) *SinkClient {
+	return &SinkClient{c: c}
+}
+
+// All calls Sink.All.
+func (c *SinkClient) All(ctx context.Context, b bool, u8 uint8, bt byte, i int, i8 int8, i32 int32, u uint, u16 uint16, f32 float32, f float64, s string, raw []byte, t time.Time, items []Item, m map[string]int, p *Item, a any) (bool, uint8, int8, float32, string, []byte, time.Time, []Item, *Item, any, int32, error) {
+	args := &SinkAllArgs{
+		B:     b,
+		U8:    u8,
+		Bt:    bt,
+		I:     i,
+		I8:    i8,
+		I32:   i32,
+		U:     u,
+		U16:   u16,
+		F32:   f32,
+		F:     f,
+		S:     s,
+		Raw:   raw,
+		T:     t,
+		Items: items,
+		M:     m,
+		P:     p,
+		A:     a,
+	}
+	reply := &SinkAllReply{}
+	err := c.c.Call(ctx, SinkServicePath, "all", args, reply)
+	return reply.Rb, reply.Ru8, reply.Ri8, reply.Rf32, reply.Rs, reply.Rraw, reply.Rt, reply.Ritems, reply.Rp, reply.Ra, reply.retcode, err
+}
+
+// Err calls Sink.Err.
+func (c *SinkClient) Err(ctx context.Context, arg0 string, arg1 int) error {
+	args := &SinkErrArgs{
+		Arg0: arg0,
+		Arg1: arg1,
+	}
+	reply := &SinkErrReply{}
+	err := c.c.Call(ctx, SinkServicePath, "err", args, reply)
+	return err
+}
+
+// None calls Sink.None.
+func (c *SinkClient) None(ctx context.Context) error {
+	args := &SinkNoneArgs{}
+	reply := &SinkNoneReply{}
+	err := c.c.Call(ctx, SinkServicePath, "none", args, reply)
+	return err
+}
+
+// Clash calls Sink.Clash.
+func (c1 *SinkClient) Clash(ctx context.Context, c string, args string, reply string, err string) (int32, error) {
+	args1 := &SinkClashArgs{
+		C:     c,
+		Args:  args,
+		Reply: reply,
+		Err:   err,
+	}
+	reply1 := &SinkClashReply{}
+	err1 := c1.c.Call(ctx, SinkServicePath, "clash", args1, reply1)
+	return reply1.retcode, err1
+}
+
+// RegisterSink registers impl as the Sink service of s.
+func RegisterSink(s *server.Server, impl Sink, metadata string) error {
+	return s.RegisterName("Sink", &sinkService{impl: impl}, metadata)
+}
+
+// sinkService calls a Sink with the args and reply the server decodes.
+type sinkService struct {
+	impl Sink
+}
+
+func (s *sinkService) All(ctx context.Context, args *SinkAllArgs, reply *SinkAllReply) int32 {
+	var retcode int32
+	reply.Rb, reply.Ru8, reply.Ri8, reply.Rf32, reply.Rs, reply.Rraw, reply.Rt, reply.Ritems, reply.Rp, reply.Ra, retcode = s.impl.All(ctx, args.B, args.U8, args.Bt, args.I, args.I8, args.I32, args.U, args.U16, args.F32, args.F, args.S, args.Raw, args.T, args.Items, args.M, args.P, args.A)
+	return retcode
+}
+
+func (s *sinkService) Err(ctx context.Context, args *SinkErrArgs, reply *SinkErrReply) error {
+	return s.impl.Err(ctx, args.Arg0, args.Arg1)
+}
+
+func (s *sinkService) None(ctx context.Context, args *SinkNoneArgs, reply *SinkNoneReply) error {
+	return s.impl.None(ctx)
+}
+
+func (s *sinkService) Clash(ctx context.Context, args *SinkClashArgs, reply *SinkClashReply) int32 {
+	return s.impl.Clash(ctx, args.C, args.Args, args.Reply, args.Err)
+}
@@ -0,0 +1,91 @@
+// Acegen generates typed AcePack clients and servers from Go interfaces.
+//
+// A service is described by an interface marked with an ace:service comment,
+// followed by the service path the clients call:
+//
+//	//ace:service PublicConf.PublicConf
+//	type PublicConf interface {
+//		Add(ctx context.Context, dest int64, desc string) (str string, retcode int32)
+//		Del(ctx context.Context, key string) error
+//	}
+//
+// Every method takes a context.Context first and returns an int32 retcode or an
+// error last. The other parameters are the args and the other results are the
+// reply, both are written in the order they are declared. Only the methods that
+// return a retcode have a reply, ace clients read the retcode in front of it.
+//
+// For each method acegen writes the args and reply structs with MarshalAce and
+// UnmarshalAce, so they are encoded without reflection. Bools, numbers, strings,
+// []byte, time.Time, the structs of -type and the slices and maps of them are read
+// and written directly, other types go through the reflection of the codec package.
+// For each service it writes a client wrapping client.AceClient and a RegisterXxx
+// function that registers an implementation of the interface on a server.Server.
+//
+// With -type, acegen also writes MarshalAce and UnmarshalAce for the listed
+// struct types of the file, so the codec skips reflection for them wherever
+// they are written. The fields are laid out by their ace tags as the codec does.
+//
+// Usage:
+//
+//	acegen [-o output] [-type T1,T2] [file.go]
+//
+// The file defaults to $GOFILE so acegen can run from go:generate, the output
+// defaults to the file name with the _ace.go suffix.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("acegen: ")
+
+	output := flag.String("o", "", "output file, default to <file>_ace.go")
+	typeNames := flag.String("type", "", "comma-separated list of struct types to generate MarshalAce and UnmarshalAce for")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: acegen [-o output] [-type T1,T2] [file.go]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	input := os.Getenv("GOFILE")
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		input = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(input, ".go") + "_ace.go"
+	}
+
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+	file, err := parseFile(input, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(file.services) == 0 && len(file.structs) == 0 {
+		log.Fatalf("no ace:service interface in %s and no -type given", input)
+	}
+	src, err := generate(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const serviceMarker = "//ace:service"
+
+// idlFile is an input file with the services declared in it
+// and the structs to generate methods for.
+type idlFile struct {
+	name     string
+	pkg      string
+	imports  map[string]string // package name to import path
+	services []*service
+	structs  []*structDef
+	types    map[string]bool // the structs that get MarshalAce and UnmarshalAce
+}
+
+// structDef is a struct to write MarshalAce and UnmarshalAce for.
+type structDef struct {
+	name    string
+	fields  []*field // indexed by position, nil for gaps
+	retcode bool     // a reply that keeps the retcode
+}
+
+type service struct {
+	name    string // the Go interface
+	path    string // the service path the clients call, such as Proxy.Inter
+	methods []*method
+}
+
+type method struct {
+	name    string
+	args    []*field
+	reply   []*field
+	retcode bool // the method returns an int32 retcode instead of an error
+}
+
+// field is a parameter or a result of a method, and the struct field that carries it.
+type field struct {
+	param string // name of the parameter in the client method
+	name  string // name of the struct field
+	typ   string
+	kind  kind
+	pkgs  []string // packages the type refers to
+
+	key, elem *field // of a kindSlice or kindMap, only typ and kind are set
+
+	omitEmpty bool
+}
+
+// kind is how a field is read and written, kindReflect for the types the codec
+// package handles by reflection.
+type kind int
+
+const (
+	kindReflect kind = iota
+	kindBool
+	kindByte
+	kindInt
+	kindUint
+	kindFloat
+	kindString
+	kindBytes
+	kindTime
+	kindStruct // a struct acegen writes the methods for
+	kindSlice
+	kindMap
+)
+
+// parseFile parses the services of filename and the structs named by typeNames.
+func parseFile(filename string, typeNames []string) (*idlFile, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	file := &idlFile{
+		name:    filepath.Base(filename),
+		pkg:     f.Name.Name,
+		imports: make(map[string]string),
+		types:   make(map[string]bool),
+	}
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := filepath.Base(path)
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		file.imports[name] = path
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range typeNames {
+		wanted[name] = true
+		file.types[name] = true
+	}
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if wanted[ts.Name.Name] {
+				delete(wanted, ts.Name.Name)
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s is not a struct", fset.Position(ts.Pos()), ts.Name.Name)
+				}
+				def, err := parseStruct(fset, file, ts.Name.Name, st)
+				if err != nil {
+					return nil, err
+				}
+				file.structs = append(file.structs, def)
+				continue
+			}
+
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			path, ok := servicePath(doc)
+			if !ok {
+				continue
+			}
+			it, ok := ts.Type.(*ast.InterfaceType)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s is marked as service but is not an interface", fset.Position(ts.Pos()), ts.Name.Name)
+			}
+			if path == "" {
+				path = ts.Name.Name
+			}
+			svc, err := parseService(fset, file, ts.Name.Name, path, it)
+			if err != nil {
+				return nil, err
+			}
+			file.services = append(file.services, svc)
+		}
+	}
+	for _, name := range typeNames {
+		if wanted[name] {
+			return nil, fmt.Errorf("type %s is not declared in %s", name, filename)
+		}
+	}
+	return file, nil
+}
+
+// servicePath returns the path after the ace:service marker of doc.
+func servicePath(doc *ast.CommentGroup) (string, bool) {
+	if doc == nil {
+		return "", false
+	}
+	for _, c := range doc.List {
+		if c.Text == serviceMarker || strings.HasPrefix(c.Text, serviceMarker+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(c.Text, serviceMarker)), true
+		}
+	}
+	return "", false
+}
+
+func parseService(fset *token.FileSet, file *idlFile, name, path string, it *ast.InterfaceType) (*service, error) {
+	svc := &service{name: name, path: path}
+	for _, m := range it.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) != 1 {
+			return nil, fmt.Errorf("%s: service %s can only have methods", fset.Position(m.Pos()), name)
+		}
+		mt, err := parseMethod(file, m.Names[0].Name, ft)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s.%s: %v", fset.Position(m.Pos()), name, m.Names[0].Name, err)
+		}
+		svc.methods = append(svc.methods, mt)
+	}
+	if len(svc.methods) == 0 {
+		return nil, fmt.Errorf("%s: service %s has no methods", fset.Position(it.Pos()), name)
+	}
+	return svc, nil
+}
+
+func parseMethod(file *idlFile, name string, ft *ast.FuncType) (*method, error) {
+	m := &method{name: name}
+
+	params := expand(ft.Params)
+	if len(params) == 0 || types.ExprString(params[0].typ) != "context.Context" {
+		return nil, fmt.Errorf("the first parameter must be a context.Context")
+	}
+	params = params[1:]
+	nameUnnamed(params, "arg")
+	for _, p := range params {
+		if _, ok := p.typ.(*ast.Ellipsis); ok {
+			return nil, fmt.Errorf("variadic parameter %s is not supported", p.name)
+		}
+		m.args = append(m.args, newField(file, p.name, p.typ))
+	}
+
+	results := expand(ft.Results)
+	nameUnnamed(results, "result")
+	if len(results) == 0 {
+		return nil, fmt.Errorf("the last result must be an int32 retcode or an error")
+	}
+	switch types.ExprString(results[len(results)-1].typ) {
+	case "int32":
+		m.retcode = true
+	case "error":
+		if len(results) > 1 {
+			return nil, fmt.Errorf("a method returning an error can't return a reply, the clients read a retcode before it")
+		}
+	default:
+		return nil, fmt.Errorf("the last result must be an int32 retcode or an error")
+	}
+	for _, r := range results[:len(results)-1] {
+		m.reply = append(m.reply, newField(file, r.name, r.typ))
+	}
+
+	if err := checkNames(m.args); err != nil {
+		return nil, err
+	}
+	if err := checkNames(m.reply); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// maxFieldNum is the most fields a struct can have on the wire.
+const maxFieldNum = 255
+
+// parseStruct lays out the fields of a struct by their ace tags, the same way
+// the codec package does by reflection.
+func parseStruct(fset *token.FileSet, file *idlFile, name string, st *ast.StructType) (*structDef, error) {
+	def := &structDef{name: name}
+	order := -1
+	for _, sf := range st.Fields.List {
+		var tag string
+		if sf.Tag != nil {
+			s, _ := strconv.Unquote(sf.Tag.Value)
+			tag = reflect.StructTag(s).Get("ace")
+		}
+		names := sf.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{embeddedName(sf.Type)}
+		}
+		for _, n := range names {
+			if !n.IsExported() || tag == "-" {
+				continue
+			}
+			if n.Name == "MarshalAce" || n.Name == "UnmarshalAce" {
+				return nil, fmt.Errorf("%s: field %s.%s has the name of a generated method", fset.Position(n.Pos()), name, n.Name)
+			}
+
+			f := newField(file, n.Name, sf.Type)
+			order++
+			parts := strings.Split(tag, ",")
+			for _, opt := range parts[1:] {
+				switch {
+				case opt == "omitempty":
+					f.omitEmpty = true
+				case strings.HasPrefix(opt, "order="):
+					o, err := strconv.Atoi(strings.TrimPrefix(opt, "order="))
+					if err != nil || o < 0 {
+						return nil, fmt.Errorf("%s: invalid order %q of field %s.%s", fset.Position(n.Pos()), opt, name, n.Name)
+					}
+					order = o
+				case opt == "":
+				default:
+					return nil, fmt.Errorf("%s: unknown tag option %q of field %s.%s", fset.Position(n.Pos()), opt, name, n.Name)
+				}
+			}
+
+			if order >= maxFieldNum {
+				return nil, fmt.Errorf("%s: order %d of field %s.%s is out of range, the limit is %d", fset.Position(n.Pos()), order, name, n.Name, maxFieldNum-1)
+			}
+			for len(def.fields) <= order {
+				def.fields = append(def.fields, nil)
+			}
+			if prev := def.fields[order]; prev != nil {
+				return nil, fmt.Errorf("%s: fields %s and %s of %s have the same order %d", fset.Position(n.Pos()), prev.name, n.Name, name, order)
+			}
+			def.fields[order] = f
+		}
+	}
+	return def, nil
+}
+
+// embeddedName returns the field name of an embedded type.
+func embeddedName(typ ast.Expr) *ast.Ident {
+	for {
+		switch t := typ.(type) {
+		case *ast.StarExpr:
+			typ = t.X
+		case *ast.SelectorExpr:
+			return t.Sel
+		case *ast.IndexExpr:
+			typ = t.X
+		case *ast.IndexListExpr:
+			typ = t.X
+		case *ast.Ident:
+			return t
+		default:
+			return ast.NewIdent("_")
+		}
+	}
+}
+
+type param struct {
+	name string
+	typ  ast.Expr
+}
+
+// expand lists the parameters of fl one by one.
+func expand(fl *ast.FieldList) []param {
+	if fl == nil {
+		return nil
+	}
+	var ps []param
+	for _, f := range fl.List {
+		if len(f.Names) == 0 {
+			ps = append(ps, param{typ: f.Type})
+			continue
+		}
+		for _, n := range f.Names {
+			ps = append(ps, param{name: n.Name, typ: f.Type})
+		}
+	}
+	return ps
+}
+
+// nameUnnamed names the unnamed parameters by prefix and their position.
+func nameUnnamed(ps []param, prefix string) {
+	for i := range ps {
+		if ps[i].name == "" || ps[i].name == "_" {
+			ps[i].name = prefix + strconv.Itoa(i)
+		}
+	}
+}
+
+func newField(file *idlFile, name string, typ ast.Expr) *field {
+	f := newElem(file, typ)
+	f.param = name
+	f.name = exported(name)
+	ast.Inspect(typ, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				f.pkgs = append(f.pkgs, id.Name)
+			}
+			return false
+		}
+		return true
+	})
+	return f
+}
+
+// newElem returns the field of type typ without a name, the key or the element of a
+// container. A slice or map is written by acegen if its keys and elements are.
+func newElem(file *idlFile, typ ast.Expr) *field {
+	f := &field{typ: types.ExprString(typ), kind: kindOf(file, typ)}
+	switch t := typ.(type) {
+	case *ast.ArrayType:
+		if f.kind == kindReflect && t.Len == nil {
+			if f.elem = newElem(file, t.Elt); f.elem.kind != kindReflect {
+				f.kind = kindSlice
+			}
+		}
+	case *ast.MapType:
+		f.key, f.elem = newElem(file, t.Key), newElem(file, t.Value)
+		if f.key.kind != kindReflect && f.key.kind != kindSlice && f.key.kind != kindMap && f.elem.kind != kindReflect {
+			f.kind = kindMap
+		}
+	}
+	if f.kind == kindReflect {
+		f.key, f.elem = nil, nil
+	}
+	return f
+}
+
+// kindOf returns how a field of type typ is written, only predeclared types,
+// []byte, time.Time and the structs of -type are known without type checking.
+func kindOf(file *idlFile, typ ast.Expr) kind {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if file.types[t.Name] {
+			return kindStruct
+		}
+		switch t.Name {
+		case "bool":
+			return kindBool
+		case "byte", "uint8":
+			return kindByte
+		case "int", "int8", "int16", "int32", "int64":
+			return kindInt
+		case "uint", "uint16", "uint32", "uint64":
+			return kindUint
+		case "float32", "float64":
+			return kindFloat
+		case "string":
+			return kindString
+		}
+	case *ast.ArrayType:
+		if id, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (id.Name == "byte" || id.Name == "uint8") {
+			return kindBytes
+		}
+	case *ast.SelectorExpr:
+		if id, ok := t.X.(*ast.Ident); ok && file.imports[id.Name] == "time" && t.Sel.Name == "Time" {
+			return kindTime
+		}
+	}
+	return kindReflect
+}
+
+// reserved are the methods of the generated structs.
+var reserved = map[string]bool{
+	"Reset":        true,
+	"MarshalAce":   true,
+	"UnmarshalAce": true,
+	"SetRetcode":   true,
+}
+
+func checkNames(fields []*field) error {
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		if reserved[f.name] {
+			return fmt.Errorf("%s can't be used as name, %s is a method of the generated struct", f.param, f.name)
+		}
+		if seen[f.name] {
+			return fmt.Errorf("%s is used by two parameters or results", f.name)
+		}
+		seen[f.name] = true
+	}
+	return nil
+}
+
+func exported(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func unexported(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
@@ -0,0 +1,97 @@
+// Package acebench compares the AcePack codec on structs with generated AceMarshaler
+// and AceUnmarshaler methods against the same structs through reflection.
+//
+// Usage:
+//
+//	go test -bench . -benchmem xace/codec/acebench
+package acebench
+
+//go:generate go run xace/cmd/acegen -type Order,Line order.go
+
+import (
+	"time"
+)
+
+// Order and Line have MarshalAce and UnmarshalAce generated in order_ace.go.
+type Order struct {
+	ID       int64
+	Customer string
+	Paid     bool
+	Total    float64
+	Created  time.Time
+	Lines    []Line
+	Tags     map[string]string
+	Note     string `ace:"note,omitempty"`
+	Internal string `ace:"-"`
+	Coupon   string `ace:",order=10,omitempty"`
+}
+
+type Line struct {
+	SKU      string
+	Quantity int32
+	Price    float64
+	Flags    uint8
+	Blob     []byte
+}
+
+// plainOrder and plainLine are laid out as Order and Line but go through reflection.
+type plainOrder struct {
+	ID       int64
+	Customer string
+	Paid     bool
+	Total    float64
+	Created  time.Time
+	Lines    []plainLine
+	Tags     map[string]string
+	Note     string `ace:"note,omitempty"`
+	Internal string `ace:"-"`
+	Coupon   string `ace:",order=10,omitempty"`
+}
+
+type plainLine struct {
+	SKU      string
+	Quantity int32
+	Price    float64
+	Flags    uint8
+	Blob     []byte
+}
+
+func newOrder() *Order {
+	o := &Order{
+		ID:       1234567,
+		Customer: "customer-42",
+		Paid:     true,
+		Total:    99.5,
+		Created:  time.UnixMilli(1700000000000),
+		Tags:     map[string]string{"channel": "web", "region": "eu"},
+		Note:     "leave at the door",
+	}
+	for i := 0; i < 8; i++ {
+		o.Lines = append(o.Lines, Line{
+			SKU:      "sku-" + string(rune('a'+i)),
+			Quantity: int32(i + 1),
+			Price:    12.25,
+			Flags:    uint8(i),
+			Blob:     []byte{1, 2, 3, 4},
+		})
+	}
+	return o
+}
+
+func plainOf(o *Order) *plainOrder {
+	p := &plainOrder{
+		ID:       o.ID,
+		Customer: o.Customer,
+		Paid:     o.Paid,
+		Total:    o.Total,
+		Created:  o.Created,
+		Tags:     o.Tags,
+		Note:     o.Note,
+		Internal: o.Internal,
+		Coupon:   o.Coupon,
+	}
+	for _, l := range o.Lines {
+		p.Lines = append(p.Lines, plainLine(l))
+	}
+	return p
+}
@@ -0,0 +1,163 @@
+// Code generated by acegen from order.go. DO NOT EDIT.
+
+package acebench
+
+import (
+	"xace/codec"
+)
+
+func (x *Order) MarshalAce(e *codec.EncBuffer) error {
+	n := 11
+	if n == 11 && len(x.Coupon) == 0 {
+		n = 10
+	}
+	if n == 10 {
+		n = 9
+	}
+	if n == 9 {
+		n = 8
+	}
+	if n == 8 && len(x.Note) == 0 {
+		n = 7
+	}
+	e.PackFieldNum(n)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(x.ID)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Customer)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackBool(x.Paid)
+	e.PackFieldType(codec.FT_FLOAT)
+	e.PackFloat(x.Total)
+	e.PackFieldType(codec.FT_DATE)
+	e.PackInt(x.Created.UnixMilli())
+	e.PackFieldType(codec.FT_ARRAY)
+	e.PackFieldType(codec.FT_STRUCT)
+	e.PackNum(len(x.Lines))
+	for i0 := range x.Lines {
+		if err := x.Lines[i0].MarshalAce(e); err != nil {
+			return err
+		}
+	}
+	e.PackFieldType(codec.FT_MAP)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackNum(len(x.Tags))
+	for k0, v0 := range x.Tags {
+		e.PackString(k0)
+		e.PackString(v0)
+	}
+	if n > 7 {
+		if len(x.Note) == 0 {
+			e.PackFieldType(codec.FT_PACK)
+		} else {
+			e.PackFieldType(codec.FT_STRING)
+			e.PackString(x.Note)
+		}
+	}
+	if n > 8 {
+		e.PackFieldType(codec.FT_PACK)
+	}
+	if n > 9 {
+		e.PackFieldType(codec.FT_PACK)
+	}
+	if n > 10 {
+		if len(x.Coupon) == 0 {
+			e.PackFieldType(codec.FT_PACK)
+		} else {
+			e.PackFieldType(codec.FT_STRING)
+			e.PackString(x.Coupon)
+		}
+	}
+	return e.Err()
+}
+
+func (x *Order) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.ID = d.DecodeInt(field)
+		case 1:
+			x.Customer = d.DecodeString(field)
+		case 2:
+			x.Paid = d.DecodeBool(field)
+		case 3:
+			x.Total = d.DecodeFloat(field)
+		case 4:
+			x.Created = d.DecodeTime(field)
+		case 5:
+			n0, elem0 := d.DecodeArray(field)
+			switch {
+			case n0 < 0:
+				x.Lines = nil
+			case cap(x.Lines) < n0:
+				x.Lines = make([]Line, n0)
+			default:
+				x.Lines = x.Lines[:n0]
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				d.DecodeAce(elem0, &x.Lines[i0])
+			}
+		case 6:
+			n0, key0, elem0 := d.DecodeMap(field)
+			if n0 < 0 {
+				x.Tags = nil
+			} else if x.Tags == nil {
+				x.Tags = make(map[string]string, n0)
+			}
+			for i0 := 0; i0 < n0 && d.Err() == nil; i0++ {
+				var k0 string
+				var v0 string
+				k0 = d.DecodeString(key0)
+				v0 = d.DecodeString(elem0)
+				x.Tags[k0] = v0
+			}
+		case 7:
+			x.Note = d.DecodeString(field)
+		case 10:
+			x.Coupon = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+func (x *Line) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(5)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.SKU)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(int64(x.Quantity))
+	e.PackFieldType(codec.FT_FLOAT)
+	e.PackFloat(x.Price)
+	e.PackFieldType(codec.FT_CHAR)
+	e.PackByte(x.Flags)
+	e.PackFieldType(codec.FT_BYTES)
+	e.PackBytes(x.Blob)
+	return e.Err()
+}
+
+func (x *Line) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.SKU = d.DecodeString(field)
+		case 1:
+			x.Quantity = int32(d.DecodeInt(field))
+		case 2:
+			x.Price = d.DecodeFloat(field)
+		case 3:
+			x.Flags = uint8(d.DecodeUint(field))
+		case 4:
+			x.Blob = d.DecodeBytes(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
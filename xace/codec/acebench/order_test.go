@@ -0,0 +1,93 @@
+package acebench
+
+import (
+	"reflect"
+	"testing"
+
+	"xace/codec"
+)
+
+// TestGenerated checks that the generated methods write what reflection writes and
+// read it back. The generated struct is written as the fields of the parameter list,
+// reflection wraps a struct as the only parameter.
+func TestGenerated(t *testing.T) {
+	order := newOrder()
+	// the entries of a map are written in no order
+	order.Tags = map[string]string{"channel": "web"}
+	plain := plainOf(order)
+
+	fast, err := codec.EncodeArgs(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow, err := codec.EncodeArgs(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fast, slow[2:]) {
+		t.Fatalf("generated and reflective encodings differ:\n%v\n%v", fast, slow[2:])
+	}
+
+	order = newOrder()
+	order.Internal = "not sent"
+	fast, _ = codec.EncodeArgs(order)
+	var got Order
+	if err := codec.DecodeArgs(fast, &got); err != nil {
+		t.Fatal(err)
+	}
+	var gotPlain plainOrder
+	if err := codec.DecodeArgs(fast, &gotPlain); err != nil {
+		t.Fatal(err)
+	}
+	order.Internal = ""
+	if !reflect.DeepEqual(&got, order) || !reflect.DeepEqual(&gotPlain, plainOf(order)) {
+		t.Fatalf("decoded\n%+v\n%+v\nwant %+v", got, gotPlain, order)
+	}
+
+	// the generated methods read the reflective encoding, the empty containers as well
+	plain = plainOf(newOrder())
+	plain.Lines, plain.Tags = nil, map[string]string{}
+	slow, _ = codec.EncodeArgs(plain)
+	got = Order{Lines: make([]Line, 3), Tags: map[string]string{"old": "kept"}}
+	if err := codec.DecodeArgs(slow, []any{&got}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Lines) != 0 || got.Tags["old"] != "kept" || got.Customer != plain.Customer {
+		t.Fatalf("decoded %+v", got)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	order := newOrder()
+	b.Run("reflect", func(b *testing.B) { benchEncode(b, plainOf(order)) })
+	b.Run("generated", func(b *testing.B) { benchEncode(b, order) })
+}
+
+func BenchmarkDecode(b *testing.B) {
+	order := newOrder()
+	fast, _ := codec.EncodeArgs(order)
+	b.Run("reflect", func(b *testing.B) { benchDecode(b, fast, func() any { return new(plainOrder) }) })
+	b.Run("generated", func(b *testing.B) { benchDecode(b, fast, func() any { return new(Order) }) })
+}
+
+func benchEncode(b *testing.B, v any) {
+	b.ReportAllocs()
+	var e codec.EncBuffer
+	for i := 0; i < b.N; i++ {
+		e.Reset()
+		if err := e.EncodeArgs(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchDecode(b *testing.B, data []byte, newValue func() any) {
+	b.ReportAllocs()
+	var d codec.DecBuffer
+	for i := 0; i < b.N; i++ {
+		d.Reset(data)
+		if err := d.DecodeArgs(newValue()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -59,7 +59,7 @@ type AceCodec struct{}
 
 // Encode encodes an object into slice of bytes.
 func (c AceCodec) Encode(i interface{}) ([]byte, error) {
-    return EncodeArgs(i), nil
+    return EncodeArgs(i)
 }
 
 // Decode decodes an object from slice of bytes.
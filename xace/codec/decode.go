@@ -7,11 +7,19 @@ import (
     "errors"
     "strconv"
     "reflect"
+    "time"
+    "encoding"
 )
 
+// DecBuffer reads AcePack data. The Unpack methods never read past the data,
+// the first failure is kept in Err and the later reads return zero values.
 type DecBuffer struct {
 	data	[]byte
 	curpos	int
+	err     error
+	root    string
+	path    []pathElem
+	depth   int
 }
 
 var decbufferPool = sync.Pool{
@@ -24,28 +32,76 @@ var decbufferPool = sync.Pool{
 func (d *DecBuffer) Reset(buf []byte) {
     d.data = buf
     d.curpos = 0
+    d.err = nil
+    d.root = ""
+    d.path = d.path[:0]
+    d.depth = 0
+}
+
+// Err returns the first error met while decoding, it is a *DecodeError.
+func (d *DecBuffer) Err() error {
+    return d.err
+}
+
+func (d *DecBuffer) fail(err error, expected, actual FIELDTYPE, offset int, msg string) {
+    if d.err != nil {
+        return
+    }
+    d.err = &DecodeError{
+        Path:     formatPath(d.root, d.path),
+        Expected: expected,
+        Actual:   actual,
+        Offset:   offset,
+        Err:      err,
+        Msg:      msg,
+    }
+}
+
+func (d *DecBuffer) mismatch(actual FIELDTYPE, value reflect.Value, offset int) {
+    d.fail(ErrTypeMismatch, fieldTypeOf(value.Type()), actual, offset, "cannot decode into "+value.Type().String())
+}
+
+func (d *DecBuffer) push(p pathElem) {
+    d.path = append(d.path, p)
+}
+
+func (d *DecBuffer) pop() {
+    // don't keep map keys alive in the pooled buffer
+    d.path[len(d.path)-1] = pathElem{}
+    d.path = d.path[:len(d.path)-1]
 }
 
 func (d *DecBuffer) UnpackByte() byte {
+	if d.curpos >= len(d.data) {
+		d.fail(ErrTruncated, FT_PACK, FT_PACK, d.curpos, "no bytes left")
+		return 0
+	}
 	ch := d.data[d.curpos]
 	d.curpos += 1
 	return ch
 }
 
 func (d *DecBuffer) UnpackUint64()  uint64 {
-	var exp uint64 = 1
 	var val uint64 = 0
-	var slen int = len(d.data)
-	for d.curpos < slen  {
-		var ch byte = d.UnpackByte()
+	var shift uint = 0
+	start := d.curpos
+	for shift < 64 {
+		if d.curpos >= len(d.data) {
+			d.fail(ErrTruncated, FT_PACK, FT_PACK, start, "varint is not terminated")
+			return 0
+		}
+		var ch byte = d.data[d.curpos]
+		d.curpos += 1
+		if shift == 63 && ch > 1 {
+			break
+		}
+		val |= uint64(ch&0x7f) << shift
 		if (ch&0x80) == 0 {
-			val += uint64(ch) * exp
 			return val
 		}
-		ch &= 0x7f
-		val += uint64(ch) * exp
-		exp <<= 7
+		shift += 7
 	}
+	d.fail(ErrInvalidData, FT_PACK, FT_PACK, start, "varint overflows 64 bits")
 	return 0
 }
 func (d *DecBuffer) UnpackInt64() int64 {
@@ -90,23 +146,66 @@ func (d *DecBuffer) UnpackFieldType() FIELDTYPE {
 }
 
 func (d *DecBuffer) UnpackField() *FieldType {
+    return d.unpackField(0)
+}
+
+func (d *DecBuffer) unpackField(depth int) *FieldType {
     field := NewFieldType(FT_PACK)
+    start := d.curpos
     field.BaseType = d.UnpackFieldType()
-    if field.BaseType == FT_ARRAY {
-        field.SubType = append(field.SubType, d.UnpackField())
-    } else if field.BaseType == FT_MAP {
-        field.SubType = append(field.SubType, d.UnpackField())
-        field.SubType = append(field.SubType, d.UnpackField())
+    if !field.BaseType.valid() {
+        d.fail(ErrInvalidData, FT_PACK, field.BaseType, start, "unknown field type")
+    }
+    if field.BaseType == FT_ARRAY || field.BaseType == FT_MAP {
+        if depth >= maxDepth {
+            d.fail(ErrInvalidData, FT_PACK, field.BaseType, start, "field type nests too deep")
+        }
+        field.SubType = append(field.SubType, d.subField(depth))
+        if field.BaseType == FT_MAP {
+            field.SubType = append(field.SubType, d.subField(depth))
+        }
     }
     return field
 }
 
+// subField reads the element type of a container. After an error it stops
+// reading but still returns a type, so SubType can always be indexed.
+func (d *DecBuffer) subField(depth int) *FieldType {
+    if d.err != nil {
+        return NewFieldType(FT_PACK)
+    }
+    return d.unpackField(depth + 1)
+}
+
 func (d *DecBuffer) UnpackFieldNum() uint8 {
 	return  d.UnpackByte()
 }
 
+// unpackLen reads the length of a string or bytes and checks it against the data left.
+func (d *DecBuffer) unpackLen() int {
+	start := d.curpos
+	n := d.UnpackUint64()
+	if left := len(d.data) - d.curpos; n > uint64(left) {
+		d.fail(ErrTruncated, FT_PACK, FT_PACK, start, fmt.Sprintf("length %d exceeds the %d bytes left", n, left))
+		return 0
+	}
+	return int(n)
+}
+
+// UnpackCount reads the number of elements of an array or map. Every element
+// takes at least one byte, so a count larger than the data left is broken.
+func (d *DecBuffer) UnpackCount() int {
+	start := d.curpos
+	n := d.UnpackUint32()
+	if left := len(d.data) - d.curpos; uint64(n) > uint64(left) {
+		d.fail(ErrTruncated, FT_PACK, FT_PACK, start, fmt.Sprintf("count %d exceeds the %d bytes left", n, left))
+		return 0
+	}
+	return int(n)
+}
+
 func (d *DecBuffer) UnpackString() string {
-	var slen int = d.UnpackInt()
+	var slen int = d.unpackLen()
 	if slen == 0 {
 		return string("")
 	}
@@ -123,7 +222,8 @@ func (d *DecBuffer) Unpack2String(fieldtype FIELDTYPE) string {
         val := d.UnpackInt64()
         return strconv.Itoa(int(val))
     }
-    panic("unpack to string type error"+string(fieldtype))
+    d.fail(ErrTypeMismatch, FT_STRING, fieldtype, d.curpos, "")
+    return ""
 }
 
 func (d *DecBuffer) Unpack2UNumber(fieldtype FIELDTYPE) uint64 {
@@ -131,27 +231,36 @@ func (d *DecBuffer) Unpack2UNumber(fieldtype FIELDTYPE) uint64 {
         return d.UnpackUint64()
     }
     if fieldtype == FT_STRING {
+        start := d.curpos
         str := d.UnpackString()
-        val,_ := strconv.ParseUint(str,10,64)
+        val, err := parseUint(str)
+        if err != nil {
+            d.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, start, err.Error())
+        }
         return val
     }
-    panic("unpack to number error"+string(fieldtype))
+    d.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, d.curpos, "")
+    return 0
 }
 func (d *DecBuffer) Unpack2Number(fieldtype FIELDTYPE) int64 {
     if fieldtype == FT_NUMBER {
         return d.UnpackInt64()
     }
     if fieldtype == FT_STRING {
+        start := d.curpos
         str := d.UnpackString()
-        val,_ := strconv.ParseInt(str,10,64)
+        val, err := parseInt(str)
+        if err != nil {
+            d.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, start, err.Error())
+        }
         return val
     }
-    fmt.Println("unpack to number eror")
-    panic("unpack to number error"+string(fieldtype))
+    d.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, d.curpos, "")
+    return 0
 }
 
 func (d *DecBuffer) UnpackBytes() []byte {
-	var slen int = d.UnpackInt()
+	var slen int = d.unpackLen()
 	if slen == 0 {
 		return []byte{}
 	}
@@ -160,10 +269,17 @@ func (d *DecBuffer) UnpackBytes() []byte {
 	return bt
 }
 
-func (d *DecBuffer) peekField(field *FieldType) {
+func (d *DecBuffer) peekField(field *FieldType, depth int) {
+    if d.err != nil {
+        return
+    }
+    if depth > maxDepth {
+        d.fail(ErrInvalidData, FT_PACK, field.BaseType, d.curpos, "value nests too deep")
+        return
+    }
     switch field.BaseType {
     case FT_CHAR:
-        d.curpos += 1
+        d.UnpackByte()
     case FT_NUMBER,FT_FLOAT,FT_DATE:
         d.UnpackUint64()
     case FT_STRING:
@@ -172,21 +288,23 @@ func (d *DecBuffer) peekField(field *FieldType) {
         d.UnpackBytes()
     case FT_STRUCT:
         plen := int(d.UnpackFieldNum())
-        for i:=0; i < plen; i++ {
-            d.PeekField()
+        for i:=0; i < plen && d.err == nil; i++ {
+            d.peekField(d.UnpackField(), depth+1)
         }
+    case FT_ANY:
+        d.peekField(d.UnpackField(), depth+1)
 
     case FT_ARRAY:
-        un := int(d.UnpackNum())
-        for i:=0; i<un; i++ {
-            d.peekField(field.SubType[0])
+        un := d.UnpackCount()
+        for i:=0; i<un && d.err == nil; i++ {
+            d.peekField(field.SubType[0], depth+1)
         }
 
     case FT_MAP:
-        un := int(d.UnpackNum())
-        for i:=0; i<un; i++ {
-            d.peekField(field.SubType[0])
-            d.peekField(field.SubType[1])
+        un := d.UnpackCount()
+        for i:=0; i<un && d.err == nil; i++ {
+            d.peekField(field.SubType[0], depth+1)
+            d.peekField(field.SubType[1], depth+1)
         }
     }
 }
@@ -194,15 +312,84 @@ func (d *DecBuffer) peekField(field *FieldType) {
 
 func (d *DecBuffer) PeekField() {
     field := d.UnpackField()
-    d.peekField(field)
+    d.peekField(field, 0)
 }
 
 func allocValue(t reflect.Type) reflect.Value {
     return reflect.New(t).Elem()
 }
 
-// value.Kind() != reflect.Ptr  ,  must not ptr 
+// parseInt and parseUint read numbers sent as strings, an empty string is 0.
+func parseInt(s string) (int64, error) {
+    if s == "" {
+        return 0, nil
+    }
+    return strconv.ParseInt(s, 10, 64)
+}
+
+func parseUint(s string) (uint64, error) {
+    if s == "" {
+        return 0, nil
+    }
+    return strconv.ParseUint(s, 10, 64)
+}
+
+// decodeByField decodes a value of field into value. Pointers are allocated,
+// the other kinds must match the field type or be convertible from it.
 func (d *DecBuffer) decodeByField(field *FieldType, value reflect.Value) {
+    if d.err != nil {
+        return
+    }
+    if field.BaseType == FT_PACK {
+        // a placeholder without value, such as an omitted field or a nil pointer
+        value.Set(reflect.Zero(value.Type()))
+        return
+    }
+    start := d.curpos
+    if d.depth >= maxDepth {
+        d.fail(ErrInvalidData, fieldTypeOf(value.Type()), field.BaseType, start, "value nests too deep")
+        return
+    }
+    d.depth++
+    defer func() { d.depth-- }()
+
+    if field.BaseType == FT_ANY {
+        // the dynamic type comes before the value
+        d.decodeByField(d.UnpackField(), value)
+        return
+    }
+    switch value.Kind() {
+    case reflect.Ptr:
+        vp := reflect.New(value.Type().Elem())
+        value.Set(vp)
+        d.decodeByField(field, vp.Elem())
+        return
+    case reflect.Interface:
+        d.decodeInterface(field, value, start)
+        return
+    }
+    if field.BaseType == FT_STRUCT && value.CanAddr() && reflect.PointerTo(value.Type()).Implements(aceUnmarshalerType) {
+        if err := value.Addr().Interface().(AceUnmarshaler).UnmarshalAce(d); err != nil && d.err == nil {
+            d.err = err
+        }
+        return
+    }
+    if value.Type() == timeType && (field.BaseType == FT_DATE || field.BaseType == FT_NUMBER) {
+        v := d.UnpackInt64()
+        value.Set(reflect.ValueOf(time.UnixMilli(v)))
+        return
+    }
+    if field.BaseType == FT_BYTES && reflect.PointerTo(value.Type()).Implements(binaryUnmarshalerType) {
+        v := d.UnpackBytes()
+        if d.err != nil {
+            return
+        }
+        if err := value.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(v); err != nil {
+            d.fail(ErrInvalidData, FT_BYTES, FT_BYTES, start, err.Error())
+        }
+        return
+    }
+
     switch field.BaseType {
     case FT_CHAR:
         v := d.UnpackUint8()
@@ -215,13 +402,12 @@ func (d *DecBuffer) decodeByField(field *FieldType, value reflect.Value) {
             }
         case reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint,reflect.Uint64:
             value.SetUint(uint64(v))
-        case reflect.Int16,reflect.Int32,reflect.Int64,reflect.Int:
+        case reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,reflect.Int:
             value.SetInt(int64(v))
         default:
-            fmt.Println(value.Kind())
-            panic("aace connot transform value type char"+string(value.Kind()))
+            d.mismatch(field.BaseType, value, start)
         }
-    case FT_NUMBER:
+    case FT_NUMBER,FT_DATE:
         v := d.UnpackInt64()
         switch value.Kind() {
         case reflect.Bool:
@@ -233,64 +419,89 @@ func (d *DecBuffer) decodeByField(field *FieldType, value reflect.Value) {
         case reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint,reflect.Uint64:
             value.SetUint(uint64(v))
 
-        case reflect.Int16,reflect.Int32,reflect.Int64,reflect.Int:
+        case reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,reflect.Int:
             value.SetInt(int64(v))
         case reflect.String:
             value.SetString(strconv.Itoa(int(v)))
         default:
-            fmt.Println(value.Kind())
-            panic("aace cannot transform value type number"+string(value.Kind()))
+            d.mismatch(field.BaseType, value, start)
+        }
+    case FT_FLOAT:
+        v := d.UnpackFloat()
+        switch value.Kind() {
+        case reflect.Float32,reflect.Float64:
+            value.SetFloat(v)
+        default:
+            d.mismatch(field.BaseType, value, start)
         }
     case FT_STRING:
         v := d.UnpackString()
         switch value.Kind() {
         case reflect.String:
             value.SetString(v)
-        case reflect.Uint32,reflect.Uint,reflect.Uint64,reflect.Int32,reflect.Int64,reflect.Int:
-            val,_ := strconv.ParseInt(v,10,64)
+        case reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64,reflect.Int:
+            val, err := parseInt(v)
+            if err != nil {
+                d.fail(ErrTypeMismatch, FT_NUMBER, field.BaseType, start, err.Error())
+            }
             value.SetInt(val)
+        case reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint,reflect.Uint64:
+            val, err := parseUint(v)
+            if err != nil {
+                d.fail(ErrTypeMismatch, FT_NUMBER, field.BaseType, start, err.Error())
+            }
+            value.SetUint(val)
         default:
-            panic("aace cannot transform value type string")
+            d.mismatch(field.BaseType, value, start)
         }
 
     case FT_BYTES:
-        if value.Kind() != reflect.Slice {
-            panic("aace cannot transfrom value type bytes")
+        if (value.Kind() != reflect.Slice && value.Kind() != reflect.Array) || value.Type().Elem().Kind() != reflect.Uint8 {
+            d.mismatch(field.BaseType, value, start)
+            return
         }
         v := d.UnpackBytes()
-        value.SetBytes(v)
+        if value.Kind() == reflect.Slice {
+            value.SetBytes(v)
+            return
+        }
+        if len(v) > value.Len() {
+            d.fail(ErrTypeMismatch, FT_BYTES, field.BaseType, start, fmt.Sprintf("%d bytes don't fit in %s", len(v), value.Type().String()))
+            return
+        }
+        n := reflect.Copy(value, reflect.ValueOf(v))
+        zeroFrom(value, n)
 
     case FT_ARRAY:
-        if value.Kind() != reflect.Slice {
-            panic("aace cannot transfrom value type slice")
+        if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+            d.mismatch(field.BaseType, value, start)
+            return
         }
-        un := int(d.UnpackNum())
-        if value.Cap() < un {
+        un := d.UnpackCount()
+        if value.Kind() == reflect.Array {
+            if un > value.Len() {
+                d.fail(ErrTypeMismatch, FT_ARRAY, field.BaseType, start, fmt.Sprintf("%d elements don't fit in %s", un, value.Type().String()))
+                return
+            }
+            zeroFrom(value, un)
+        } else if value.Cap() < un {
             value.Set(reflect.MakeSlice(value.Type(), un, un))
         } else {
             value.SetLen(un)
         }
 
-        vt := value.Type().Elem()
-        if vt.Kind() == reflect.Ptr {
-            for i:=0; i<un; i++ {
-                vd := reflect.New(vt.Elem())
-                vi := value.Index(i)
-                vi.Set(vd)
-                d.decodeByField(field.SubType[0],vd.Elem())
-            }
-        } else {
-            for i:=0; i<un; i++ {
-                d.decodeByField(field.SubType[0],value.Index(i))
-            }
+        for i:=0; i<un && d.err == nil; i++ {
+            d.push(pathElem{index: i})
+            d.decodeByField(field.SubType[0],value.Index(i))
+            d.pop()
         }
 
     case FT_MAP:
         if value.Kind() != reflect.Map {
-            fmt.Println(value.Kind())
-            panic("aace cannot fransfrom value type map")
+            d.mismatch(field.BaseType, value, start)
+            return
         }
-        un := int(d.UnpackNum())
+        un := d.UnpackCount()
         if value.IsNil() {
             value.Set(reflect.MakeMapWithSize(value.Type(), un))
         }
@@ -298,137 +509,178 @@ func (d *DecBuffer) decodeByField(field *FieldType, value reflect.Value) {
         kt := value.Type().Key()    // KT
         vt := value.Type().Elem()   // T / *T
 
-        for i:=0; i<un; i++ {
+        for i:=0; i<un && d.err == nil; i++ {
+            d.push(pathElem{index: i})
             km := reflect.New(kt).Elem()
             d.decodeByField(field.SubType[0],km)
-            if vt.Kind() == reflect.Ptr {  // vt  *T
-                vm := reflect.New(vt.Elem())
-                d.decodeByField(field.SubType[1],vm.Elem())
-                value.SetMapIndex(km, vm)
-            } else {                        // vt T
-                vm := reflect.New(vt).Elem()
-                d.decodeByField(field.SubType[1],vm)
+            if kt.Kind() == reflect.Interface && km.Elem().IsValid() && !km.Elem().Type().Comparable() {
+                d.fail(ErrInvalidData, FT_PACK, field.SubType[0].BaseType, start, "map key of type "+km.Elem().Type().String()+" is not comparable")
+            }
+            d.path[len(d.path)-1].key = km
+            vm := reflect.New(vt).Elem()
+            d.decodeByField(field.SubType[1],vm)
+            d.pop()
+            if d.err == nil {
                 value.SetMapIndex(km, vm)
             }
         }
 
     case FT_STRUCT:
-        vt := value.Type()
-        unnum := int(d.UnpackFieldNum())
-        fieldnum := vt.NumField()
-        var i int = 0
-        for ; i<unnum && i < fieldnum; i++ {
-            fv := value.Field(i)
-            if fv.Kind() == reflect.Ptr {
-                sf := reflect.New(fv.Type().Elem())  // sf *T
-                fv.Set(sf)
-                d.decodeValue(fv.Elem())
-            } else {
-                d.decodeValue(fv)
+        if value.Kind() == reflect.Slice && value.Type().Elem() == anyType {
+            // a struct of unknown type is decoded as the list of its fields
+            unnum := int(d.UnpackFieldNum())
+            value.Set(reflect.MakeSlice(value.Type(), unnum, unnum))
+            for i:=0; i<unnum && d.err == nil; i++ {
+                d.push(pathElem{index: i})
+                d.decodeValue(value.Index(i))
+                d.pop()
             }
+            return
         }
-        for ; i<unnum; i++ {
-            d.UnpackField()
+        if value.Kind() != reflect.Struct {
+            d.mismatch(field.BaseType, value, start)
+            return
         }
+        layout := layoutOf(value.Type())
+        if layout.err != nil {
+            if d.err == nil {
+                d.err = layout.err
+            }
+            return
+        }
+        unnum := int(d.UnpackFieldNum())
+        for i:=0; i<unnum && d.err == nil; i++ {
+            if i >= len(layout.fields) || layout.fields[i] == nil {
+                // gaps and fields added by newer peers
+                d.PeekField()
+                continue
+            }
+            f := layout.fields[i]
+            d.push(pathElem{name: f.name})
+            d.decodeValue(value.Field(f.index))
+            d.pop()
+        }
+
+    default:
+        d.fail(ErrInvalidData, fieldTypeOf(value.Type()), field.BaseType, start, "unknown field type")
+    }
+}
+
+// decodeInterface decodes into an interface value. An empty interface gets a value
+// of the type anyTypeOf returns, and a non-nil pointer in the interface is decoded into.
+func (d *DecBuffer) decodeInterface(field *FieldType, value reflect.Value, start int) {
+    if !value.IsNil() && value.Elem().Kind() == reflect.Ptr && !value.Elem().IsNil() {
+        d.decodeByField(field, value.Elem().Elem())
+        return
+    }
+    t := anyTypeOf(field)
+    if value.NumMethod() != 0 || t == nil {
+        d.mismatch(field.BaseType, value, start)
+        return
     }
+    v := reflect.New(t).Elem()
+    d.decodeByField(field, v)
+    value.Set(v)
 }
+
+// zeroFrom zeroes the elements of array value from index i.
+func zeroFrom(value reflect.Value, i int) {
+    zero := reflect.Zero(value.Type().Elem())
+    for ; i < value.Len(); i++ {
+        value.Index(i).Set(zero)
+    }
+}
+
 func (d *DecBuffer) decodeValue(value reflect.Value) {
     field := d.UnpackField()
     d.decodeByField(field, value)
 }
 
-
-func (d *DecBuffer) DecodeValue(arg any) {
+// target returns the value arg points to.
+func target(arg any) (reflect.Value, error) {
     value := reflect.ValueOf(arg)
-    if value.Kind() == reflect.Invalid {
-        panic("ace: cannot decode invalid value")
-    }
-    if value.Kind() != reflect.Ptr {
-        panic("ace: cannot unpack data 2 value")
-    }
-    if value.IsNil() {
-        panic("ace: cannot unpack nil value")
+    if value.Kind() != reflect.Ptr || value.IsNil() {
+        return reflect.Value{}, fmt.Errorf("ace: cannot decode into %T, it must be a non-nil pointer", arg)
     }
+    return value.Elem(), nil
+}
 
-    //value = reflect.Indirect(value)
-    value = value.Elem()
+func (d *DecBuffer) DecodeValue(arg any) error {
+    value, err := target(arg)
+    if err != nil {
+        return err
+    }
 
     d.decodeValue(value)
+    return d.err
 }
 
-func (d *DecBuffer) DecodeStruct(arg any) {
-    value := reflect.ValueOf(arg)
-    if value.Kind() == reflect.Invalid {
-        panic("ace: cannot decode invalid value")
-    }
-    if value.Kind() != reflect.Ptr {
-        panic("ace: cannot unpack data 2 value")
-    }
-    if value.IsNil() {
-        panic("ace: cannot unpack nil value")
+func (d *DecBuffer) DecodeStruct(arg any) error {
+    value, err := target(arg)
+    if err != nil {
+        return err
     }
-
-    //value = reflect.Indirect(value)
-    value = value.Elem()
-
     if value.Kind() != reflect.Struct {
-        panic("ace: cannot unpack data 2 value, only to struct")
+        return fmt.Errorf("ace: cannot decode into %T, only to struct", arg)
     }
     field := NewFieldType(FT_STRUCT)
     d.decodeByField(field, value)
+    return d.err
 }
 
-func (d *DecBuffer) Decode(args... any) (err error) {
-    n := len(args)
-    if n < 1 {
-        return errors.New("decode to no parameters")
-    }
-    defer func() {
-        if errt := recover(); errt != nil {
-            fmt.Println(errt)
-            //err = errors.New("decode invalid ")
-        }
-    }()
+// decodeList decodes the parameters into args in order, the extra ones on either side are ignored.
+func (d *DecBuffer) decodeList(args []any) error {
+    d.root = "args"
     curn := int(d.UnpackFieldNum())
-    for i:=0; i<n && i<curn; i++ {
-        d.DecodeValue(args[i])
+    for i:=0; i<len(args) && i<curn && d.err == nil; i++ {
+        value, err := target(args[i])
+        if err != nil {
+            return err
+        }
+        d.push(pathElem{index: i})
+        d.decodeValue(value)
+        d.pop()
     }
-    return err
+    return d.err
+}
+
+func (d *DecBuffer) Decode(args... any) error {
+    if len(args) < 1 {
+        return errors.New("decode to no parameters")
+    }
+    return d.decodeList(args)
 }
 
-func (d *DecBuffer) DecodeArgs(args any) (err error) {
-    defer func() {
-        if errt := recover(); errt != nil {
-            fmt.Println(errt)
-            err = errors.New("decode invalid ")
+func (d *DecBuffer) DecodeArgs(args any) error {
+    if u, ok := args.(AceUnmarshaler); ok {
+        if t := reflect.TypeOf(args); t.Kind() == reflect.Ptr {
+            d.root = t.Elem().Name()
+        }
+        if err := u.UnmarshalAce(d); err != nil && d.err == nil {
+            d.err = err
         }
-    }()
+        return d.err
+    }
     lt, ok := args.([]any)
     if ok {
-        n := len(lt)
-        if n < 1 {
+        if len(lt) < 1 {
             return nil
         }
-        curn := int(d.UnpackFieldNum())
-        for i:=0; i<n && i<curn; i++ {
-            d.DecodeValue(lt[i])
-        }
-        return
-    } else {
-        d.DecodeStruct(args)
-        //err = errors.New("no support decode input type")
+        return d.decodeList(lt)
     }
-    return err
+    value := reflect.ValueOf(args)
+    if value.Kind() == reflect.Ptr && !value.IsNil() && value.Elem().Kind() == reflect.Struct {
+        d.root = value.Elem().Type().Name()
+        return d.DecodeStruct(args)
+    }
+    return d.decodeList([]any{args})
 }
 
 func DecodeArgs(buf []byte,args any) error {
     dec := decbufferPool.Get().(*DecBuffer)
     dec.Reset(buf)
-    return dec.DecodeArgs(args)
+    err := dec.DecodeArgs(args)
+    dec.Reset(nil)
+    decbufferPool.Put(dec)
+    return err
 }
-
-
-
-
-
-
@@ -2,9 +2,12 @@ package codec
 
 import (
     //"io"
+    "encoding"
+    "errors"
     "sync"
     "math"
     "reflect"
+    "time"
 )
 
 const tooBig = (1 << 30) << (^uint(0) >> 62)
@@ -12,6 +15,8 @@ const tooBig = (1 << 30) << (^uint(0) >> 62)
 type EncBuffer struct {
     data    []byte
     scratch [64]byte
+    err     error
+    depth   int
 }
 
 // global aacehead
@@ -24,6 +29,8 @@ var encbufferPool = sync.Pool{
 }
 
 func encBufferPut(e *EncBuffer) {
+    e.err = nil
+    e.depth = 0
     if cap(e.data) > 1024 {
         e.data = e.scratch[0:0]
     } else {
@@ -53,6 +60,8 @@ func (e *EncBuffer) Bytes() []byte {
 }
 
 func (e *EncBuffer) Reset() {
+    e.err = nil
+    e.depth = 0
     if len(e.data) >= tooBig {
         e.data = e.scratch[0:0]
     } else {
@@ -133,130 +142,215 @@ func (e *EncBuffer) PackFieldNum(val int) {
     e.writeByte(byte(val))
 }
 
+// encodeValueNoType writes the value without its field type, the reader knows the
+// type from the container. A nil pointer is written as the zero value it points to
+// and a nil interface as FT_PACK.
 func (e *EncBuffer) encodeValueNoType(value reflect.Value) {
-    if value.Kind() == reflect.Invalid {
-        panic("ace: cannot encode nil value")
+    if e.err != nil {
+        return
     }
-    if value.Kind() == reflect.Pointer && value.IsNil() {
-        panic("ace: cannot encode nil pointer of type: " + value.Type().String())
+    if e.depth >= maxDepth {
+        e.setError(errors.New("ace: value nests too deep, it may be cyclic"))
+        return
     }
-    value = reflect.Indirect(value)
+    e.depth++
+    defer func() { e.depth-- }()
 
-    switch value.Kind() {
-    case reflect.Bool:
-        e.PackBool(value.Bool())
-
-    case reflect.Uint8:
-        e.PackByte(value.Bytes()[0])
-
-    case reflect.Int,reflect.Int16,reflect.Int32,reflect.Int64:
-        e.PackInt(value.Int())
-
-    case reflect.Uint,reflect.Uint16,reflect.Uint32,reflect.Uint64:
-        e.PackUint(value.Uint())
-
-    case reflect.Float32, reflect.Float64:
-        e.PackFloat(value.Float())
-
-    case reflect.String:
-        e.PackString(value.String())
-
-    case reflect.Struct:
-        fieldnum := value.NumField()
-        e.PackFieldNum(fieldnum)
-        for i:=0; i<fieldnum; i++ {
-            e.EncodeValue(value.Field(i))
+    for value.Kind() == reflect.Pointer {
+        if value.IsNil() {
+            value = reflect.Zero(value.Type().Elem())
+        } else {
+            value = value.Elem()
         }
     }
-}
 
-func (e *EncBuffer) EncodeValue(value reflect.Value) {
-    if value.Kind() == reflect.Invalid {
-        panic("ace: cannot encode nil value")
+    vt := value.Type()
+    if isAceMarshaler(vt) {
+        e.encodeAce(value)
+        return
     }
-    if value.Kind() == reflect.Pointer && value.IsNil() {
-        panic("ace: cannot encode nil pointer of type: " + value.Type().String())
+    if vt == timeType {
+        e.PackInt(value.Interface().(time.Time).UnixMilli())
+        return
+    }
+    if isBinaryMarshaler(vt) {
+        e.encodeBinary(value)
+        return
     }
-    value = reflect.Indirect(value)
 
     switch value.Kind() {
     case reflect.Bool:
-        e.PackFieldType(FT_CHAR)
         e.PackBool(value.Bool())
 
     case reflect.Uint8:
-        e.PackFieldType(FT_CHAR)
-        e.PackByte(value.Bytes()[0])
+        e.PackByte(byte(value.Uint()))
 
-    case reflect.Int,reflect.Int16,reflect.Int32,reflect.Int64:
-        e.PackFieldType(FT_NUMBER)
+    case reflect.Int,reflect.Int8,reflect.Int16,reflect.Int32,reflect.Int64:
         e.PackInt(value.Int())
 
     case reflect.Uint,reflect.Uint16,reflect.Uint32,reflect.Uint64:
-        e.PackFieldType(FT_NUMBER)
         e.PackUint(value.Uint())
 
     case reflect.Float32, reflect.Float64:
-        e.PackFieldType(FT_FLOAT)
         e.PackFloat(value.Float())
 
     case reflect.String:
-        e.PackFieldType(FT_STRING)
         e.PackString(value.String())
 
     case reflect.Struct:
-        e.PackFieldType(FT_STRUCT)
-        fieldnum := value.NumField()
-        e.PackFieldNum(fieldnum)
-        for i:=0; i<fieldnum; i++ {
-            e.EncodeValue(value.Field(i))
-        }
+        e.encodeStruct(value)
 
     case reflect.Slice,reflect.Array:
-        vt := value.Type()
-        vt = vt.Elem()
-        if vt.Kind() == reflect.Uint8 {
-            e.PackFieldType(FT_BYTES)
-            e.PackBytes(value.Bytes())
-        } else {
-            e.PackFieldType(FT_ARRAY)
-            e.PackFieldType(getValFieldType(vt))
-
-            n_ := value.Len()
-            e.PackNum(n_)
-            for i:=0; i<n_; i++ {
-                e.encodeValueNoType(value.Index(i))
-            }
+        if vt.Elem().Kind() == reflect.Uint8 {
+            e.PackBytes(bytesOf(value))
+            return
         }
-
-    case reflect.Map:
-        e.PackFieldType(FT_MAP)
-        vt := value.Type()
-        kt := vt.Key()
-        dt := vt.Elem()
-        e.PackFieldType(getValFieldType(kt))
-        e.PackFieldType(getValFieldType(dt))
-
         n_ := value.Len()
         e.PackNum(n_)
+        for i:=0; i<n_; i++ {
+            e.encodeValueNoType(value.Index(i))
+        }
 
+    case reflect.Map:
+        e.PackNum(value.Len())
         mi := value.MapRange()
         for mi.Next() {
             e.encodeValueNoType(mi.Key())
             e.encodeValueNoType(mi.Value())
         }
+
+    case reflect.Interface:
+        // the dynamic type is written before the value
+        e.EncodeValue(value)
+
+    default:
+        e.setError(errors.New("ace: unsupported type " + vt.String()))
+    }
+}
+
+// encodeAce lets the AceMarshaler of value write its fields.
+func (e *EncBuffer) encodeAce(value reflect.Value) {
+    var m AceMarshaler
+    switch {
+    case value.Type().Implements(aceMarshalerType):
+        m = value.Interface().(AceMarshaler)
+    case value.CanAddr():
+        m = value.Addr().Interface().(AceMarshaler)
+    default:
+        // MarshalAce has a pointer receiver
+        pv := reflect.New(value.Type())
+        pv.Elem().Set(value)
+        m = pv.Interface().(AceMarshaler)
+    }
+    if err := m.MarshalAce(e); err != nil {
+        e.setError(err)
+    }
+}
+
+// encodeBinary writes a encoding.BinaryMarshaler as bytes.
+func (e *EncBuffer) encodeBinary(value reflect.Value) {
+    m, ok := value.Interface().(encoding.BinaryMarshaler)
+    if !ok {
+        // MarshalBinary has a pointer receiver
+        pv := reflect.New(value.Type())
+        pv.Elem().Set(value)
+        m = pv.Interface().(encoding.BinaryMarshaler)
+    }
+    data, err := m.MarshalBinary()
+    if err != nil {
+        e.setError(err)
+        return
+    }
+    e.PackBytes(data)
+}
+
+// bytesOf returns the bytes of a []byte or [N]byte value.
+func bytesOf(value reflect.Value) []byte {
+    if value.Kind() == reflect.Slice {
+        return value.Bytes()
+    }
+    if value.CanAddr() {
+        return value.Slice(0, value.Len()).Bytes()
+    }
+    b := make([]byte, value.Len())
+    reflect.Copy(reflect.ValueOf(b), value)
+    return b
+}
+
+// packType writes the field type of t, containers are followed by the types of their elements.
+func (e *EncBuffer) packType(t reflect.Type, depth int) {
+    if depth >= maxDepth {
+        e.setError(errors.New("ace: type nests too deep: " + t.String()))
+        return
+    }
+    ft := fieldTypeOf(t)
+    if ft == FT_PACK {
+        e.setError(errors.New("ace: unsupported type " + t.String()))
+        return
+    }
+    e.PackFieldType(ft)
+    t = elemOf(t)
+    switch ft {
+    case FT_ARRAY:
+        e.packType(t.Elem(), depth+1)
+    case FT_MAP:
+        e.packType(t.Key(), depth+1)
+        e.packType(t.Elem(), depth+1)
+    }
+}
+
+// encodeStruct writes the field number and the fields of value by its layout.
+func (e *EncBuffer) encodeStruct(value reflect.Value) {
+    layout := layoutOf(value.Type())
+    if layout.err != nil {
+        e.setError(layout.err)
+        return
+    }
+    fieldnum := layout.fieldNum(value)
+    e.PackFieldNum(fieldnum)
+    for i:=0; i<fieldnum; i++ {
+        if layout.skip(value, i) {
+            e.PackFieldType(FT_PACK)
+            continue
+        }
+        e.EncodeValue(value.Field(layout.fields[i].index))
     }
 }
 
-func (e *EncBuffer) Encode(args... any) {
+// EncodeValue writes the field type of value and then the value.
+// Nil pointers and interfaces are written as FT_PACK, they are decoded as nil.
+func (e *EncBuffer) EncodeValue(value reflect.Value) {
+    for value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer {
+        if value.IsNil() {
+            break
+        }
+        value = value.Elem()
+    }
+    if !value.IsValid() || (value.Kind() == reflect.Interface || value.Kind() == reflect.Pointer) && value.IsNil() {
+        e.PackFieldType(FT_PACK)
+        return
+    }
+
+    e.packType(value.Type(), 0)
+    e.encodeValueNoType(value)
+}
+
+func (e *EncBuffer) Encode(args... any) error {
     n := len(args)
     e.PackFieldNum(n)
     for i:=0; i<n; i++ {
         e.EncodeValue(reflect.ValueOf(args[i]))
     }
+    return e.err
 }
 
-func (e *EncBuffer) EncodeArgs(args any) {
+func (e *EncBuffer) EncodeArgs(args any) error {
+    if m, ok := args.(AceMarshaler); ok {
+        if err := m.MarshalAce(e); err != nil {
+            e.setError(err)
+        }
+        return e.err
+    }
     lt, ok := args.([]any)
     if ok {
         n := len(lt)
@@ -264,38 +358,34 @@ func (e *EncBuffer) EncodeArgs(args any) {
         for i:=0; i<n; i++ {
             e.EncodeValue(reflect.ValueOf(lt[i]))
         }
-    } else {
-        e.PackFieldNum(1)
-        e.EncodeValue(reflect.ValueOf(args))
+        return e.err
     }
+    // anything else is the only parameter, a struct too: the generated Args types
+    // are the parameter lists of their methods, other structs are not
+    e.PackFieldNum(1)
+    e.EncodeValue(reflect.ValueOf(args))
+    return e.err
 }
 
-func getValFieldType(vt reflect.Type) FIELDTYPE {
-    if vt.Kind() == reflect.Ptr {
-        vt = vt.Elem()
-    }
-    switch vt.Kind() {
-    case reflect.String:
-        return FT_STRING
-    case reflect.Int,reflect.Int16,reflect.Int32,reflect.Int64:
-        return FT_NUMBER
-    case reflect.Uint,reflect.Uint16,reflect.Uint32,reflect.Uint64:
-        return FT_NUMBER
-    case reflect.Float32,reflect.Float64:
-        return FT_FLOAT
-    case reflect.Struct:
-        return FT_STRUCT
+// Err returns the first error met while encoding.
+func (e *EncBuffer) Err() error {
+    return e.err
+}
+
+func (e *EncBuffer) setError(err error) {
+    if e.err == nil {
+        e.err = err
     }
-    panic("get value field type error."+vt.Kind().String())
-    return FT_PACK
 }
 
-func EncodeArgs(args any) []byte {
+func EncodeArgs(args any) ([]byte, error) {
     enc := encbufferPool.Get().(*EncBuffer)
     defer encBufferPut(enc)
-    enc.EncodeArgs(args)
+    if err := enc.EncodeArgs(args); err != nil {
+        return nil, err
+    }
     data := enc.Bytes()
-    return data
+    return data, nil
 }
 
 func EncodeRetArgs(retcode int32, data []byte) []byte {
@@ -0,0 +1,122 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTruncated means the data ends in the middle of a field.
+	ErrTruncated = errors.New("truncated data")
+	// ErrTypeMismatch means a field can't be decoded into the value of its position.
+	ErrTypeMismatch = errors.New("field type mismatch")
+	// ErrInvalidData means the data is not valid AcePack, such as an unknown field type.
+	ErrInvalidData = errors.New("invalid data")
+)
+
+// maxDepth limits nested containers and structs so bad data can't exhaust the stack.
+const maxDepth = 100
+
+var fieldTypeNames = [...]string{
+	FT_PACK:   "pack",
+	FT_CHAR:   "char",
+	FT_NUMBER: "number",
+	FT_STRING: "string",
+	FT_ARRAY:  "array",
+	FT_MAP:    "map",
+	FT_STRUCT: "struct",
+	FT_FLOAT:  "float",
+	FT_BYTES:  "bytes",
+	FT_DATE:   "date",
+	FT_ANY:    "any",
+}
+
+func (ft FIELDTYPE) String() string {
+	if int(ft) < len(fieldTypeNames) {
+		return fieldTypeNames[ft]
+	}
+	return "fieldtype(" + strconv.Itoa(int(ft)) + ")"
+}
+
+func (ft FIELDTYPE) valid() bool {
+	return int(ft) < len(fieldTypeNames)
+}
+
+// DecodeError describes where and why AcePack data can't be decoded.
+type DecodeError struct {
+	// Path is the field being decoded, such as "Args.Items[2].Name". It is empty
+	// if the failure is outside of any field.
+	Path string
+	// Expected is the field type the value can be decoded from, FT_PACK if unknown.
+	Expected FIELDTYPE
+	// Actual is the field type in the data, FT_PACK if it wasn't read yet.
+	Actual FIELDTYPE
+	// Offset is the byte offset in the data where decoding failed.
+	Offset int
+	// Err is one of ErrTruncated, ErrTypeMismatch and ErrInvalidData.
+	Err error
+	// Msg gives more details, it may be empty.
+	Msg string
+}
+
+func (e *DecodeError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("ace: decode")
+	if e.Path != "" {
+		sb.WriteString(" ")
+		sb.WriteString(e.Path)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+	if e.Msg != "" {
+		sb.WriteString(", ")
+		sb.WriteString(e.Msg)
+	}
+	if e.Err == ErrTypeMismatch {
+		fmt.Fprintf(&sb, " (expected %s, got %s)", e.Expected, e.Actual)
+	}
+	fmt.Fprintf(&sb, " at offset %d", e.Offset)
+	return sb.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// pathElem is one step of the path to the field being decoded.
+// It is formatted only when an error happens.
+type pathElem struct {
+	name  string
+	index int
+	key   reflect.Value
+}
+
+func formatPath(root string, path []pathElem) string {
+	var sb strings.Builder
+	sb.WriteString(root)
+	for _, p := range path {
+		switch {
+		case p.name != "":
+			if sb.Len() > 0 {
+				sb.WriteByte('.')
+			}
+			sb.WriteString(p.name)
+		case p.key.IsValid():
+			sb.WriteByte('[')
+			if p.key.Kind() == reflect.String {
+				sb.WriteString(strconv.Quote(p.key.String()))
+			} else {
+				fmt.Fprint(&sb, p.key)
+			}
+			sb.WriteByte(']')
+		default:
+			sb.WriteByte('[')
+			sb.WriteString(strconv.Itoa(p.index))
+			sb.WriteByte(']')
+		}
+	}
+	return sb.String()
+}
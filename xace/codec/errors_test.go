@@ -0,0 +1,120 @@
+package codec
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Name  string
+	Score float64
+	Tags  map[string]int32
+}
+
+type outer struct {
+	ID    int64
+	Items []*inner
+	Blob  []byte
+	Ok    bool
+}
+
+func sample() *outer {
+	return &outer{
+		ID:    -7,
+		Items: []*inner{{Name: "a", Score: 1.5, Tags: map[string]int32{"x": 1}}, {Name: "b", Tags: map[string]int32{"y": -2}}},
+		Blob:  []byte{1, 2},
+		Ok:    true,
+	}
+}
+
+// mustEncode returns a copy of the args encoding of v.
+func mustEncode(t testing.TB, v any) []byte {
+	t.Helper()
+	data, err := EncodeArgs(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), data...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := sample()
+	var got outer
+	if err := DecodeArgs(mustEncode(t, src), []any{&got}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, src) {
+		t.Fatalf("got %+v, want %+v", got, src)
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	data := mustEncode(t, sample())
+	for i := 1; i < len(data); i++ {
+		var o outer
+		err := DecodeArgs(data[:i], []any{&o})
+		var de *DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, ErrTruncated) {
+			t.Fatalf("%d of %d bytes: %v", i, len(data), err)
+		}
+		if de.Offset > i {
+			t.Fatalf("%d of %d bytes: offset %d is past the end", i, len(data), de.Offset)
+		}
+	}
+}
+
+type mismatch struct {
+	ID    int64
+	Items []*struct {
+		Name  int
+		Score float64
+		Tags  map[string]int32
+	}
+}
+
+func TestDecodeMismatch(t *testing.T) {
+	var m mismatch
+	err := DecodeArgs(mustEncode(t, sample()), []any{&m})
+	var de *DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, ErrTypeMismatch) {
+		t.Fatal(err)
+	}
+	if de.Path != "args[0].Items[0].Name" || de.Expected != FT_NUMBER || de.Actual != FT_STRING {
+		t.Fatalf("%+v: %v", de, err)
+	}
+}
+
+func TestDecodeTooDeep(t *testing.T) {
+	data := []byte{1}
+	for i := 0; i < 10000; i++ {
+		data = append(data, byte(FT_ARRAY))
+	}
+	var v []any
+	if err := DecodeArgs(data, &v); !errors.Is(err, ErrInvalidData) {
+		t.Fatal(err)
+	}
+}
+
+// TestDecodeGarbage checks that damaged and random data return errors rather than panic.
+func TestDecodeGarbage(t *testing.T) {
+	base := mustEncode(t, sample())
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		data := append([]byte(nil), base...)
+		for j := 0; j < 1+r.Intn(4); j++ {
+			data[r.Intn(len(data))] = byte(r.Intn(256))
+		}
+		var o outer
+		DecodeArgs(data, []any{&o})
+
+		data = make([]byte, r.Intn(40))
+		r.Read(data)
+		DecodeArgs(data, []any{&o})
+		DecodeArgs(data, []any{new(int), new(map[string][]string)})
+		p := NewPackData()
+		p.ResetBytes(data)
+		p.PeekField()
+	}
+}
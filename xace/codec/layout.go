@@ -0,0 +1,120 @@
+package codec
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxFieldNum is the most fields a struct can have on the wire, the field number is one byte.
+const maxFieldNum = 255
+
+// structField is a field of a Go struct that is on the wire.
+//
+// The position of a field is set by the ace tag:
+//
+//	Name  string `ace:"name"`              // renamed, the name is shown in decode errors
+//	Count int    `ace:",order=3"`          // written at position 3
+//	Note  string `ace:"note,omitempty"`    // written as FT_PACK when it is empty
+//	Cache []byte `ace:"-"`                 // never written
+//
+// A field without order takes the position after the field before it, so
+// untagged structs keep the positional layout. Unexported fields are skipped.
+type structField struct {
+	index     int
+	name      string
+	omitEmpty bool
+}
+
+// structLayout maps the positions on the wire to the fields of a struct.
+// Positions without field are written as FT_PACK and skipped when decoding.
+type structLayout struct {
+	fields []*structField // indexed by position, nil for gaps
+	err    error
+}
+
+var layoutCache sync.Map // map[reflect.Type]*structLayout
+
+// layoutOf returns the cached layout of struct type t.
+func layoutOf(t reflect.Type) *structLayout {
+	if l, ok := layoutCache.Load(t); ok {
+		return l.(*structLayout)
+	}
+	l, _ := layoutCache.LoadOrStore(t, newStructLayout(t))
+	return l.(*structLayout)
+}
+
+func newStructLayout(t reflect.Type) *structLayout {
+	l := &structLayout{}
+	order := -1
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("ace")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+
+		f := &structField{index: i, name: sf.Name}
+		order++
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			f.name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			switch {
+			case opt == "omitempty":
+				f.omitEmpty = true
+			case strings.HasPrefix(opt, "order="):
+				n, err := strconv.Atoi(strings.TrimPrefix(opt, "order="))
+				if err != nil || n < 0 {
+					l.err = fmt.Errorf("ace: invalid order %q of field %s.%s", opt, t.String(), sf.Name)
+					return l
+				}
+				order = n
+			case opt == "":
+			default:
+				l.err = fmt.Errorf("ace: unknown tag option %q of field %s.%s", opt, t.String(), sf.Name)
+				return l
+			}
+		}
+
+		if order >= maxFieldNum {
+			l.err = fmt.Errorf("ace: order %d of field %s.%s is out of range, the limit is %d", order, t.String(), sf.Name, maxFieldNum-1)
+			return l
+		}
+		for len(l.fields) <= order {
+			l.fields = append(l.fields, nil)
+		}
+		if prev := l.fields[order]; prev != nil {
+			l.err = fmt.Errorf("ace: fields %s and %s of %s have the same order %d", t.Field(prev.index).Name, sf.Name, t.String(), order)
+			return l
+		}
+		l.fields[order] = f
+	}
+	return l
+}
+
+// skip reports whether the field at position i of v is written as FT_PACK.
+func (l *structLayout) skip(v reflect.Value, i int) bool {
+	f := l.fields[i]
+	return f == nil || f.omitEmpty && isEmptyValue(v.Field(f.index))
+}
+
+// fieldNum returns how many positions of v are written, the skipped ones at the end are trimmed.
+func (l *structLayout) fieldNum(v reflect.Value) int {
+	n := len(l.fields)
+	for n > 0 && l.skip(v, n-1) {
+		n--
+	}
+	return n
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// v1 and v2 are two versions of a struct, v2 reorders the fields in Go, renames
+// one and adds others while keeping the positions of v1.
+type v1 struct {
+	A string
+	B int64
+	C string `ace:"c,omitempty"`
+	d int
+	P *inner `ace:",order=4,omitempty"`
+}
+
+type v2 struct {
+	C     string  `ace:"cc,order=2"`
+	B     int64   `ace:",order=1"`
+	A     string  `ace:",order=0"`
+	Skip  int     `ace:"-"`
+	P     *inner  `ace:",order=4,omitempty"`
+	Extra []int32 `ace:",order=6"`
+}
+
+func TestLayoutVersions(t *testing.T) {
+	src := &v1{A: "a", B: 5, d: 3}
+	data := mustEncode(t, src)
+	// the empty fields at the end are not written
+	if data[2] != 2 {
+		t.Fatalf("%d fields are written: % x", data[2], data)
+	}
+	var dst v2
+	if err := DecodeArgs(data, []any{&dst}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.A != "a" || dst.B != 5 || dst.C != "" {
+		t.Fatalf("%+v", dst)
+	}
+
+	src.C = "c"
+	src.P = &inner{Name: "n"}
+	dst = v2{}
+	if err := DecodeArgs(mustEncode(t, src), []any{&dst}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.C != "c" || dst.P == nil || dst.P.Name != "n" {
+		t.Fatalf("%+v", dst)
+	}
+
+	// v2 leaves gaps at 3 and 5, v1 skips the position it doesn't know
+	back := mustEncode(t, &v2{A: "x", Extra: []int32{1}, Skip: 9})
+	var b1 v1
+	if err := DecodeArgs(back, []any{&b1}); err != nil || b1.A != "x" || b1.P != nil {
+		t.Fatal(err, b1)
+	}
+	var b2 v2
+	if err := DecodeArgs(back, []any{&b2}); err != nil || b2.A != "x" || len(b2.Extra) != 1 || b2.Skip != 0 {
+		t.Fatal(err, b2)
+	}
+}
+
+type renamed struct {
+	Num int `ace:"num_field"`
+}
+
+func TestLayoutErrors(t *testing.T) {
+	tests := []struct {
+		v   any
+		err string
+	}{
+		{&struct {
+			X string `ace:"x,order=1"`
+			Y string `ace:"y,order=1"`
+		}{}, "same order 1"},
+		{&struct {
+			X string `ace:",order=-1"`
+		}{}, "invalid order"},
+		{&struct {
+			X string `ace:",omitempt"`
+		}{}, "unknown tag option"},
+		{&struct {
+			X string `ace:",order=255"`
+		}{}, "out of range"},
+	}
+	for _, tt := range tests {
+		if _, err := EncodeArgs(tt.v); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("encode %T: got %v, want %q", tt.v, err, tt.err)
+		}
+		if err := DecodeArgs([]byte{0}, tt.v); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("decode %T: got %v, want %q", tt.v, err, tt.err)
+		}
+	}
+
+	// decode errors name the field by its tag
+	err := DecodeArgs(mustEncode(t, &struct{ S string }{"zz"}), []any{&renamed{}})
+	var de *DecodeError
+	if !errors.As(err, &de) || de.Path != "args[0].num_field" {
+		t.Fatal(err)
+	}
+}
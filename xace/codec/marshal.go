@@ -0,0 +1,243 @@
+package codec
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// AceMarshaler is implemented by types that write themselves without reflection,
+// such as the ones acegen generates methods for. MarshalAce writes the field number
+// and then the fields, as a struct is written. The codec checks it before any other
+// way of writing a value, so a type implementing it is always an FT_STRUCT, both as
+// a parameter list and nested in other values.
+type AceMarshaler interface {
+	MarshalAce(e *EncBuffer) error
+}
+
+// AceUnmarshaler is the decoding side of AceMarshaler, it reads what MarshalAce writes.
+// It is used for FT_STRUCT fields, the other field types are decoded as usual.
+type AceUnmarshaler interface {
+	UnmarshalAce(d *DecBuffer) error
+}
+
+// The methods below decode one field into a Go value with the conversions
+// decodeByField does, so generated code reads the same data as reflection.
+// FT_PACK gives the zero value and FT_ANY is followed to the type of the value.
+
+// valueField returns the field the value is written as.
+func (d *DecBuffer) valueField(field *FieldType) *FieldType {
+	for field.BaseType == FT_ANY && d.err == nil {
+		field = d.UnpackField()
+	}
+	return field
+}
+
+// DecodeInt reads a field into a signed integer.
+func (d *DecBuffer) DecodeInt(field *FieldType) int64 {
+	field = d.valueField(field)
+	if d.err != nil {
+		return 0
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return 0
+	case FT_CHAR:
+		return int64(d.UnpackUint8())
+	case FT_NUMBER, FT_DATE:
+		return d.UnpackInt64()
+	}
+	return d.Unpack2Number(field.BaseType)
+}
+
+// DecodeUint reads a field into an unsigned integer.
+func (d *DecBuffer) DecodeUint(field *FieldType) uint64 {
+	field = d.valueField(field)
+	if d.err != nil {
+		return 0
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return 0
+	case FT_CHAR:
+		return uint64(d.UnpackUint8())
+	case FT_NUMBER, FT_DATE:
+		return uint64(d.UnpackInt64())
+	case FT_STRING:
+		start := d.curpos
+		v, err := parseUint(d.UnpackString())
+		if err != nil {
+			d.fail(ErrTypeMismatch, FT_NUMBER, field.BaseType, start, err.Error())
+		}
+		return v
+	}
+	d.fail(ErrTypeMismatch, FT_NUMBER, field.BaseType, d.curpos, "")
+	return 0
+}
+
+// DecodeBool reads a field into a bool, any non-zero number is true.
+func (d *DecBuffer) DecodeBool(field *FieldType) bool {
+	field = d.valueField(field)
+	if d.err != nil {
+		return false
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return false
+	case FT_CHAR:
+		return d.UnpackUint8() != 0
+	case FT_NUMBER, FT_DATE:
+		return d.UnpackInt64() != 0
+	}
+	d.fail(ErrTypeMismatch, FT_CHAR, field.BaseType, d.curpos, "")
+	return false
+}
+
+// DecodeFloat reads a field into a float.
+func (d *DecBuffer) DecodeFloat(field *FieldType) float64 {
+	field = d.valueField(field)
+	if d.err != nil {
+		return 0
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return 0
+	case FT_FLOAT:
+		return d.UnpackFloat()
+	}
+	d.fail(ErrTypeMismatch, FT_FLOAT, field.BaseType, d.curpos, "")
+	return 0
+}
+
+// DecodeString reads a field into a string, numbers are formatted in decimal.
+func (d *DecBuffer) DecodeString(field *FieldType) string {
+	field = d.valueField(field)
+	if d.err != nil {
+		return ""
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return ""
+	case FT_STRING:
+		return d.UnpackString()
+	case FT_NUMBER, FT_DATE:
+		return strconv.Itoa(int(d.UnpackInt64()))
+	}
+	d.fail(ErrTypeMismatch, FT_STRING, field.BaseType, d.curpos, "")
+	return ""
+}
+
+// DecodeBytes reads a field into a byte slice.
+func (d *DecBuffer) DecodeBytes(field *FieldType) []byte {
+	field = d.valueField(field)
+	if d.err != nil {
+		return nil
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return nil
+	case FT_BYTES:
+		return d.UnpackBytes()
+	}
+	d.fail(ErrTypeMismatch, FT_BYTES, field.BaseType, d.curpos, "")
+	return nil
+}
+
+// DecodeTime reads a field into a time.
+func (d *DecBuffer) DecodeTime(field *FieldType) time.Time {
+	field = d.valueField(field)
+	if d.err != nil {
+		return time.Time{}
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return time.Time{}
+	case FT_DATE, FT_NUMBER:
+		return time.UnixMilli(d.UnpackInt64())
+	}
+	d.fail(ErrTypeMismatch, FT_DATE, field.BaseType, d.curpos, "")
+	return time.Time{}
+}
+
+// DecodeField reads a field into the value arg points to by reflection,
+// for the types the methods above don't cover.
+func (d *DecBuffer) DecodeField(field *FieldType, arg any) {
+	value, err := target(arg)
+	if err != nil {
+		if d.err == nil {
+			d.err = err
+		}
+		return
+	}
+	d.decodeByField(field, value)
+}
+
+// IsEmpty reports whether v is empty for an omitempty field.
+func IsEmpty(v any) bool {
+	value := reflect.ValueOf(v)
+	return !value.IsValid() || isEmptyValue(value)
+}
+
+// SkipField reads over a field that has no place in the value, such as one added by a newer peer.
+func (d *DecBuffer) SkipField(field *FieldType) {
+	d.peekField(field, 0)
+}
+
+// DecodeAce reads a struct field into v with its UnmarshalAce.
+func (d *DecBuffer) DecodeAce(field *FieldType, v AceUnmarshaler) {
+	field = d.valueField(field)
+	if d.err != nil {
+		return
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		value := reflect.ValueOf(v).Elem()
+		value.Set(reflect.Zero(value.Type()))
+	case FT_STRUCT:
+		if d.depth >= maxDepth {
+			d.fail(ErrInvalidData, FT_STRUCT, field.BaseType, d.curpos, "value nests too deep")
+			return
+		}
+		d.depth++
+		defer func() { d.depth-- }()
+		if err := v.UnmarshalAce(d); err != nil && d.err == nil {
+			d.err = err
+		}
+	default:
+		d.fail(ErrTypeMismatch, FT_STRUCT, field.BaseType, d.curpos, "")
+	}
+}
+
+// DecodeArray reads the count of an array field and returns it with the field type of
+// the elements. The count is -1 for FT_PACK, the slice is nil then.
+func (d *DecBuffer) DecodeArray(field *FieldType) (int, *FieldType) {
+	field = d.valueField(field)
+	if d.err != nil {
+		return 0, field
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return -1, field
+	case FT_ARRAY:
+		return d.UnpackCount(), field.SubType[0]
+	}
+	d.fail(ErrTypeMismatch, FT_ARRAY, field.BaseType, d.curpos, "")
+	return 0, field
+}
+
+// DecodeMap reads the count of a map field and returns it with the field types of
+// the keys and the values. The count is -1 for FT_PACK, the map is nil then.
+func (d *DecBuffer) DecodeMap(field *FieldType) (int, *FieldType, *FieldType) {
+	field = d.valueField(field)
+	if d.err != nil {
+		return 0, field, field
+	}
+	switch field.BaseType {
+	case FT_PACK:
+		return -1, field, field
+	case FT_MAP:
+		return d.UnpackCount(), field.SubType[0], field.SubType[1]
+	}
+	d.fail(ErrTypeMismatch, FT_MAP, field.BaseType, d.curpos, "")
+	return 0, field, field
+}
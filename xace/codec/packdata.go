@@ -19,6 +19,7 @@ const (
 	FT_FLOAT	FIELDTYPE = 7
 	FT_BYTES	FIELDTYPE = 8
 	FT_DATE		FIELDTYPE = 9
+	FT_ANY		FIELDTYPE = 10	// followed by the field type of the value
 )
 
 
@@ -33,22 +34,48 @@ func  NewFieldType(ft FIELDTYPE) *FieldType {
 }
 
 //------------------------------------------
+// PackData packs and unpacks AcePack data. The Unpack methods never read past
+// the data, the first failure is kept in Err and the later reads return zero values.
 type PackData	struct {
 	m_value		[]byte
 	m_curPos	int
+	m_err		error
 }
 
 func  NewPackData() *PackData {
-	return &PackData{[]byte{},0}
+	return &PackData{m_value: []byte{}}
 }
 
 func (pk *PackData) ResetBuff(val string) {
 	pk.m_value = []byte(val)
 	pk.m_curPos = 0
+	pk.m_err = nil
 }
 func (pk *PackData) ResetBytes(val []byte) {
 	pk.m_value =  val
 	pk.m_curPos = 0
+	pk.m_err = nil
+}
+
+// Err returns the first error met by the Unpack methods, it is a *DecodeError.
+func (pk *PackData) Err() error {
+	return pk.m_err
+}
+
+// Pos returns the offset of the next byte to unpack.
+func (pk *PackData) Pos() int {
+	return pk.m_curPos
+}
+
+// Fail records err if there is no error yet, so callers can report their own checks the same way.
+func (pk *PackData) Fail(err *DecodeError) {
+	if pk.m_err == nil {
+		pk.m_err = err
+	}
+}
+
+func (pk *PackData) fail(err error, expected, actual FIELDTYPE, offset int, msg string) {
+	pk.Fail(&DecodeError{Expected: expected, Actual: actual, Offset: offset, Err: err, Msg: msg})
 }
 
 func (pk *PackData) PackChar(val string) {
@@ -160,25 +187,36 @@ func (pk *PackData) SurData() []byte {
 }
 
 func (pk *PackData) UnpackByte() byte {
+	if pk.m_curPos >= len(pk.m_value) {
+		pk.fail(ErrTruncated, FT_PACK, FT_PACK, pk.m_curPos, "no bytes left")
+		return 0
+	}
 	ch := pk.m_value[pk.m_curPos]
 	pk.m_curPos += 1
 	return ch
 }
 
 func (pk *PackData) UnpackUint64()  uint64 {
-	var exp uint64 = 1
 	var val uint64 = 0
-	var slen int = len(pk.m_value)
-	for pk.m_curPos < slen  {
-		var ch byte = pk.UnpackByte()
+	var shift uint = 0
+	start := pk.m_curPos
+	for shift < 64 {
+		if pk.m_curPos >= len(pk.m_value) {
+			pk.fail(ErrTruncated, FT_PACK, FT_PACK, start, "varint is not terminated")
+			return 0
+		}
+		var ch byte = pk.m_value[pk.m_curPos]
+		pk.m_curPos += 1
+		if shift == 63 && ch > 1 {
+			break
+		}
+		val |= uint64(ch&0x7f) << shift
 		if (ch&0x80) == 0 {
-			val += uint64(ch) * exp
 			return val
 		}
-		ch &= 0x7f
-		val += uint64(ch) * exp
-		exp <<= 7
+		shift += 7
 	}
+	pk.fail(ErrInvalidData, FT_PACK, FT_PACK, start, "varint overflows 64 bits")
 	return 0
 }
 func (pk *PackData) UnpackInt64() int64 {
@@ -222,23 +260,108 @@ func (pk *PackData) UnpackFieldType() FIELDTYPE {
 }
 
 func (pk *PackData) UnpackField() *FieldType {
+    return pk.unpackField(0)
+}
+
+func (pk *PackData) unpackField(depth int) *FieldType {
     field := NewFieldType(FT_PACK)
+    start := pk.m_curPos
     field.BaseType = pk.UnpackFieldType()
-    if field.BaseType == FT_ARRAY {
-        field.SubType = append(field.SubType, pk.UnpackField())
-    } else if field.BaseType == FT_MAP {
-        field.SubType = append(field.SubType, pk.UnpackField())
-        field.SubType = append(field.SubType, pk.UnpackField())
+    if !field.BaseType.valid() {
+        pk.fail(ErrInvalidData, FT_PACK, field.BaseType, start, "unknown field type")
+    }
+    if field.BaseType == FT_ARRAY || field.BaseType == FT_MAP {
+        if depth >= maxDepth {
+            pk.fail(ErrInvalidData, FT_PACK, field.BaseType, start, "field type nests too deep")
+        }
+        field.SubType = append(field.SubType, pk.subField(depth))
+        if field.BaseType == FT_MAP {
+            field.SubType = append(field.SubType, pk.subField(depth))
+        }
     }
     return field
 }
 
+func (pk *PackData) subField(depth int) *FieldType {
+    if pk.m_err != nil {
+        return NewFieldType(FT_PACK)
+    }
+    return pk.unpackField(depth + 1)
+}
+
+func (pk *PackData) peekField(field *FieldType, depth int) {
+    if pk.m_err != nil {
+        return
+    }
+    if depth > maxDepth {
+        pk.fail(ErrInvalidData, FT_PACK, field.BaseType, pk.m_curPos, "value nests too deep")
+        return
+    }
+    switch field.BaseType {
+    case FT_CHAR:
+        pk.UnpackByte()
+    case FT_NUMBER,FT_FLOAT,FT_DATE:
+        pk.UnpackUint64()
+    case FT_STRING:
+        pk.UnpackString()
+    case FT_BYTES:
+        pk.UnpackBytes()
+    case FT_STRUCT:
+        plen := int(pk.UnpackFieldNum())
+        for i:=0; i < plen && pk.m_err == nil; i++ {
+            pk.peekField(pk.UnpackField(), depth+1)
+        }
+    case FT_ANY:
+        pk.peekField(pk.UnpackField(), depth+1)
+    case FT_ARRAY:
+        un := pk.UnpackCount()
+        for i:=0; i<un && pk.m_err == nil; i++ {
+            pk.peekField(field.SubType[0], depth+1)
+        }
+    case FT_MAP:
+        un := pk.UnpackCount()
+        for i:=0; i<un && pk.m_err == nil; i++ {
+            pk.peekField(field.SubType[0], depth+1)
+            pk.peekField(field.SubType[1], depth+1)
+        }
+    }
+}
+
+// PeekField skips one typed field.
+func (pk *PackData) PeekField() {
+    field := pk.UnpackField()
+    pk.peekField(field, 0)
+}
+
+// UnpackCount reads the number of elements of an array or map. Every element
+// takes at least one byte, so a count larger than the data left is broken.
+func (pk *PackData) UnpackCount() int {
+	start := pk.m_curPos
+	n := pk.UnpackUint32()
+	if left := len(pk.m_value) - pk.m_curPos; uint64(n) > uint64(left) {
+		pk.fail(ErrTruncated, FT_PACK, FT_PACK, start, fmt.Sprintf("count %d exceeds the %d bytes left", n, left))
+		return 0
+	}
+	return int(n)
+}
+
+// unpackLen reads the length of a string or bytes and checks it against the data left.
+func (pk *PackData) unpackLen() int {
+	start := pk.m_curPos
+	n := pk.UnpackUint64()
+	if left := len(pk.m_value) - pk.m_curPos; n > uint64(left) {
+		pk.fail(ErrTruncated, FT_PACK, FT_PACK, start, fmt.Sprintf("length %d exceeds the %d bytes left", n, left))
+		return 0
+	}
+	return int(n)
+}
+
 func (pk *PackData) UnpackFieldNum() uint8 {
 	return  pk.UnpackByte()
 }
 
 func (pk *PackData) UnpackString() string {
-	var slen int = pk.UnpackInt()
+	var slen int = pk.unpackLen()
 	if slen == 0 {
 		return string("")
 	}
@@ -255,7 +378,8 @@ func (pk *PackData) Unpack2String(fieldtype FIELDTYPE) string {
         val := pk.UnpackInt64()
         return strconv.Itoa(int(val))
     }
-    panic("unpack to string type error"+string(fieldtype))
+    pk.fail(ErrTypeMismatch, FT_STRING, fieldtype, pk.m_curPos, "")
+    return ""
 }
 
 func (pk *PackData) Unpack2UNumber(fieldtype FIELDTYPE) uint64 {
@@ -263,27 +387,36 @@ func (pk *PackData) Unpack2UNumber(fieldtype FIELDTYPE) uint64 {
         return pk.UnpackUint64()
     }
     if fieldtype == FT_STRING {
+        start := pk.m_curPos
         str := pk.UnpackString()
-        val,_ := strconv.ParseUint(str,10,64)
+        val, err := parseUint(str)
+        if err != nil {
+            pk.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, start, err.Error())
+        }
         return val
     }
-    panic("unpack to number error"+string(fieldtype))
+    pk.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, pk.m_curPos, "")
+    return 0
 }
 func (pk *PackData) Unpack2Number(fieldtype FIELDTYPE) int64 {
     if fieldtype == FT_NUMBER {
         return pk.UnpackInt64()
     }
     if fieldtype == FT_STRING {
+        start := pk.m_curPos
         str := pk.UnpackString()
-        val,_ := strconv.ParseInt(str,10,64)
+        val, err := parseInt(str)
+        if err != nil {
+            pk.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, start, err.Error())
+        }
         return val
     }
-    fmt.Println("unpack to number eror")
-    panic("unpack to number error"+string(fieldtype))
+    pk.fail(ErrTypeMismatch, FT_NUMBER, fieldtype, pk.m_curPos, "")
+    return 0
 }
 
 func (pk *PackData) UnpackBytes() []byte {
-	var slen int = pk.UnpackInt()
+	var slen int = pk.unpackLen()
 	if slen == 0 {
 		return []byte{}
 	}
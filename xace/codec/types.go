@@ -0,0 +1,123 @@
+package codec
+
+import (
+	"encoding"
+	"reflect"
+	"time"
+)
+
+var (
+	timeType              = reflect.TypeOf(time.Time{})
+	anyType               = reflect.TypeOf((*any)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	aceMarshalerType      = reflect.TypeOf((*AceMarshaler)(nil)).Elem()
+	aceUnmarshalerType    = reflect.TypeOf((*AceUnmarshaler)(nil)).Elem()
+)
+
+// isAceMarshaler reports whether t or *t implements AceMarshaler.
+func isAceMarshaler(t reflect.Type) bool {
+	return t.Implements(aceMarshalerType) || t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(aceMarshalerType)
+}
+
+// isBinaryMarshaler reports whether t or *t implements encoding.BinaryMarshaler.
+// time.Time is excluded, it is written as FT_DATE.
+func isBinaryMarshaler(t reflect.Type) bool {
+	if t == timeType {
+		return false
+	}
+	return t.Implements(binaryMarshalerType) || t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(binaryMarshalerType)
+}
+
+// fieldTypeOf returns the field type a value of t is written as, FT_PACK if t can't be written.
+func fieldTypeOf(t reflect.Type) FIELDTYPE {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isAceMarshaler(t) {
+		return FT_STRUCT
+	}
+	if t == timeType {
+		return FT_DATE
+	}
+	if isBinaryMarshaler(t) {
+		return FT_BYTES
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Uint8:
+		return FT_CHAR
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return FT_NUMBER
+	case reflect.Float32, reflect.Float64:
+		return FT_FLOAT
+	case reflect.String:
+		return FT_STRING
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return FT_BYTES
+		}
+		return FT_ARRAY
+	case reflect.Map:
+		return FT_MAP
+	case reflect.Struct:
+		return FT_STRUCT
+	case reflect.Interface:
+		return FT_ANY
+	}
+	return FT_PACK
+}
+
+// elemOf returns the element type of a container type, pointers are followed.
+func elemOf(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// anyTypeOf returns the Go type a field is decoded into when the target is an empty interface:
+//
+//	FT_CHAR   uint8           FT_NUMBER  int64
+//	FT_FLOAT  float64         FT_STRING  string
+//	FT_BYTES  []byte          FT_DATE    time.Time
+//	FT_ARRAY  []T             FT_MAP     map[K]V
+//	FT_STRUCT []any           FT_ANY     any
+//
+// where T, K and V are the types of the sub fields. FT_PACK returns nil.
+func anyTypeOf(field *FieldType) reflect.Type {
+	switch field.BaseType {
+	case FT_CHAR:
+		return reflect.TypeOf(uint8(0))
+	case FT_NUMBER:
+		return reflect.TypeOf(int64(0))
+	case FT_FLOAT:
+		return reflect.TypeOf(float64(0))
+	case FT_STRING:
+		return reflect.TypeOf("")
+	case FT_BYTES:
+		return reflect.TypeOf([]byte(nil))
+	case FT_DATE:
+		return timeType
+	case FT_ARRAY:
+		if et := anyTypeOf(field.SubType[0]); et != nil {
+			return reflect.SliceOf(et)
+		}
+		return reflect.SliceOf(anyType)
+	case FT_MAP:
+		kt := anyTypeOf(field.SubType[0])
+		if kt == nil || !kt.Comparable() {
+			kt = anyType
+		}
+		vt := anyTypeOf(field.SubType[1])
+		if vt == nil {
+			vt = anyType
+		}
+		return reflect.MapOf(kt, vt)
+	case FT_STRUCT:
+		return reflect.SliceOf(anyType)
+	case FT_ANY:
+		return anyType
+	}
+	return nil
+}
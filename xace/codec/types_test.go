@@ -0,0 +1,118 @@
+package codec
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ver is written as FT_BYTES by its MarshalBinary.
+type ver struct{ Major, Minor int }
+
+func (v ver) MarshalBinary() ([]byte, error) { return []byte{byte(v.Major), byte(v.Minor)}, nil }
+
+func (v *ver) UnmarshalBinary(b []byte) error {
+	v.Major, v.Minor = int(b[0]), int(b[1])
+	return nil
+}
+
+type allTypes struct {
+	I8     int8
+	U8     uint8
+	Bools  []bool
+	Nested [][]int32
+	MS     map[string][]string
+	MM     map[string]map[int]float64
+	Arr    [3]int16
+	BArr   [4]byte
+	When   time.Time
+	WhenP  *time.Time
+	Any    any
+	Anys   []any
+	AnyMap map[string]any
+	Ptrs   []*ver
+	V      ver
+	VP     *ver
+	URL    *url.URL
+	Nil    *ver
+}
+
+func TestTypes(t *testing.T) {
+	now := time.UnixMilli(time.Now().UnixMilli())
+	src := &allTypes{
+		I8:     -3,
+		U8:     200,
+		Bools:  []bool{true, false, true},
+		Nested: [][]int32{{1, 2}, {3}},
+		MS:     map[string][]string{"a": {"x", "y"}},
+		MM:     map[string]map[int]float64{"m": {1: 1.5}},
+		Arr:    [3]int16{1, -2, 3},
+		BArr:   [4]byte{9, 8, 7, 6},
+		When:   now,
+		WhenP:  &now,
+		Any:    map[string]any{"k": []int{1, 2}, "s": "v"},
+		Anys:   []any{int64(1), "two", 3.0, nil, []byte{4}, true, &ver{1, 2}},
+		AnyMap: map[string]any{"n": nil, "t": now},
+		Ptrs:   []*ver{{1, 1}, nil, {2, 2}},
+		V:      ver{3, 4},
+		VP:     &ver{5, 6},
+	}
+	var dst allTypes
+	if err := DecodeArgs(mustEncode(t, src), []any{&dst}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.I8 != -3 || dst.U8 != 200 || !reflect.DeepEqual(dst.Bools, src.Bools) || !reflect.DeepEqual(dst.Nested, src.Nested) ||
+		!reflect.DeepEqual(dst.MS, src.MS) || !reflect.DeepEqual(dst.MM, src.MM) || dst.Arr != src.Arr || dst.BArr != src.BArr {
+		t.Fatalf("containers: %+v", dst)
+	}
+	if !dst.When.Equal(now) || !dst.WhenP.Equal(now) {
+		t.Fatalf("times: %v %v", dst.When, dst.WhenP)
+	}
+	if dst.V != src.V || *dst.VP != *src.VP || dst.Nil != nil || dst.URL != nil {
+		t.Fatalf("pointers: %+v", dst)
+	}
+	// a nil pointer in a container is written as the zero value
+	if !reflect.DeepEqual(dst.Ptrs, []*ver{{1, 1}, {}, {2, 2}}) {
+		t.Fatalf("%v", dst.Ptrs)
+	}
+
+	// interfaces take the Go type of the field type they are written as
+	m := dst.Any.(map[string]any)
+	if !reflect.DeepEqual(m["k"], []int64{1, 2}) || m["s"] != "v" {
+		t.Fatalf("%#v", m)
+	}
+	wantAnys := []any{int64(1), "two", 3.0, nil, []byte{4}, uint8(1), []byte{1, 2}}
+	if !reflect.DeepEqual(dst.Anys, wantAnys) {
+		t.Fatalf("%#v", dst.Anys)
+	}
+	if when, ok := dst.AnyMap["t"].(time.Time); !ok || !when.Equal(now) || dst.AnyMap["n"] != nil {
+		t.Fatalf("%#v", dst.AnyMap)
+	}
+}
+
+func TestTypesAnyParams(t *testing.T) {
+	var x, list, st any
+	data := mustEncode(t, []any{ver{3, 4}, []any{"x", 1}, struct{ A, B string }{"a", "b"}})
+	if err := DecodeArgs(data, []any{&x, &list, &st}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(x, []byte{3, 4}) || !reflect.DeepEqual(list, []any{"x", int64(1)}) || !reflect.DeepEqual(st, []any{"a", "b"}) {
+		t.Fatalf("%#v %#v %#v", x, list, st)
+	}
+}
+
+func TestTypesErrors(t *testing.T) {
+	if _, err := EncodeArgs(&struct{ C chan int }{}); err == nil {
+		t.Fatal("a chan is encoded")
+	}
+	type cycle struct{ Next []*cycle }
+	c := &cycle{}
+	c.Next = []*cycle{c}
+	if _, err := EncodeArgs(c); err == nil {
+		t.Fatal("a cycle is encoded")
+	}
+	if err := DecodeArgs(mustEncode(t, &struct{ A [5]int }{}), []any{&struct{ A [2]int }{}}); err == nil {
+		t.Fatal("5 elements are decoded into [2]int")
+	}
+}
@@ -6,6 +6,7 @@ import (
     "context"
 
     "xace/client"
+    "xace/example/publicconf"
     "xace/protocol"
     //"xace/codec"
 )
@@ -90,6 +91,21 @@ func runx() {
     cancel()
 }
 
+// runtyped calls PublicConf.add through the client generated by acegen.
+func runtyped() {
+    pc := publicconf.NewPublicConfClient(client.GetAceClient())
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Second)
+    str, retcode, err := pc.Add(ctx, 13, "tst desc")
+    if err != nil {
+        fmt.Println("call error. ", err)
+    } else {
+        fmt.Println(retcode, str)
+    }
+
+    cancel()
+}
+
 func main() {
     client.InitializeAceCenter("10.0.10.103:16999", "AaceCenter")
     runx()
@@ -97,6 +113,8 @@ func main() {
     runx()
     time.Sleep(1*time.Second)
     runx()
+    time.Sleep(1*time.Second)
+    runtyped()
     time.Sleep(100*time.Second)
 }
 
@@ -0,0 +1,17 @@
+// Package publicconf describes the PublicConf service the examples call,
+// publicconf_ace.go is generated from it by acegen.
+package publicconf
+
+//go:generate go run xace/cmd/acegen publicconf.go
+
+import (
+	"context"
+)
+
+// PublicConf keeps the public configuration.
+//
+//ace:service PublicConf.PublicConf
+type PublicConf interface {
+	// Add stores desc under dest and returns the stored description.
+	Add(ctx context.Context, dest int64, desc string) (str string, retcode int32)
+}
@@ -0,0 +1,115 @@
+// Code generated by acegen from publicconf.go. DO NOT EDIT.
+
+package publicconf
+
+import (
+	"context"
+
+	"xace/client"
+	"xace/codec"
+	"xace/server"
+)
+
+// PublicConfAddArgs are the args of PublicConf.Add.
+type PublicConfAddArgs struct {
+	Dest int64
+	Desc string
+}
+
+func (x *PublicConfAddArgs) Reset() { *x = PublicConfAddArgs{} }
+
+func (x *PublicConfAddArgs) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(2)
+	e.PackFieldType(codec.FT_NUMBER)
+	e.PackInt(x.Dest)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Desc)
+	return e.Err()
+}
+
+func (x *PublicConfAddArgs) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Dest = d.DecodeInt(field)
+		case 1:
+			x.Desc = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// PublicConfAddReply is the reply of PublicConf.Add.
+type PublicConfAddReply struct {
+	Str     string
+	retcode int32
+}
+
+func (x *PublicConfAddReply) Reset() { *x = PublicConfAddReply{} }
+
+func (x *PublicConfAddReply) SetRetcode(retcode int32) { x.retcode = retcode }
+
+func (x *PublicConfAddReply) MarshalAce(e *codec.EncBuffer) error {
+	e.PackFieldNum(1)
+	e.PackFieldType(codec.FT_STRING)
+	e.PackString(x.Str)
+	return e.Err()
+}
+
+func (x *PublicConfAddReply) UnmarshalAce(d *codec.DecBuffer) error {
+	n := int(d.UnpackFieldNum())
+	for i := 0; i < n && d.Err() == nil; i++ {
+		field := d.UnpackField()
+		switch i {
+		case 0:
+			x.Str = d.DecodeString(field)
+		default:
+			d.SkipField(field)
+		}
+	}
+	return d.Err()
+}
+
+// PublicConfServicePath is the service path PublicConf clients call.
+const PublicConfServicePath = "PublicConf.PublicConf"
+
+// PublicConfClient calls the PublicConf service through an AceClient.
+type PublicConfClient struct {
+	c *client.AceClient
+}
+
+// NewPublicConfClient returns a PublicConfClient that calls PublicConfServicePath.
+func NewPublicConfClient(c *client.AceClient) *PublicConfClient {
+	return &PublicConfClient{c: c}
+}
+
+// Add calls PublicConf.Add.
+func (c *PublicConfClient) Add(ctx context.Context, dest int64, desc string) (string, int32, error) {
+	args := &PublicConfAddArgs{
+		Dest: dest,
+		Desc: desc,
+	}
+	reply := &PublicConfAddReply{}
+	err := c.c.Call(ctx, PublicConfServicePath, "add", args, reply)
+	return reply.Str, reply.retcode, err
+}
+
+// RegisterPublicConf registers impl as the PublicConf service of s.
+func RegisterPublicConf(s *server.Server, impl PublicConf, metadata string) error {
+	return s.RegisterName("PublicConf", &publicConfService{impl: impl}, metadata)
+}
+
+// publicConfService calls a PublicConf with the args and reply the server decodes.
+type publicConfService struct {
+	impl PublicConf
+}
+
+func (s *publicConfService) Add(ctx context.Context, args *PublicConfAddArgs, reply *PublicConfAddReply) int32 {
+	var retcode int32
+	reply.Str, retcode = s.impl.Add(ctx, args.Dest, args.Desc)
+	return retcode
+}
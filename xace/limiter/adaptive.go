@@ -0,0 +1,237 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"xace/protocol"
+	"xace/server"
+	"xace/share"
+)
+
+// maxMethods is how many methods an AdaptivePlugin keeps a limit for, the requests
+// of further methods share one.
+const maxMethods = 4096
+
+// AdaptivePlugin is a server plugin that keeps a concurrency limit for each method
+// that follows its latency, like Netflix's gradient limiter. While the recent RTT
+// stays within Tolerance of the long term RTT the limit grows, when it gets longer
+// the limit shrinks by their ratio. The long term RTT only grows while no requests
+// are rejected. Requests over the limit are rejected with server.ErrReqReachLimit
+// in PreHandleRequest.
+//
+// The RTT counts from when the request was read, so the time it waits for a worker
+// of the pool lowers the limit too. Rejected requests leave the pool at once, which
+// keeps its queue from growing while the server is overloaded.
+type AdaptivePlugin struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// Tolerance is how many times the long term RTT the recent RTT may take before
+	// the limit shrinks.
+	Tolerance float64
+	// Window is how many requests the recent RTT is averaged over.
+	Window int
+	// Smoothing is how much of a new limit is taken at an update, in (0, 1].
+	Smoothing float64
+
+	mu      sync.Mutex
+	methods map[string]*adaptive
+}
+
+// NewAdaptivePlugin returns an AdaptivePlugin with defaults for services of a few
+// milliseconds. Its fields may be changed before it is added to a server.
+func NewAdaptivePlugin() *AdaptivePlugin {
+	return &AdaptivePlugin{
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     1000,
+		Tolerance:    1.5,
+		Window:       10,
+		Smoothing:    0.2,
+		methods:      make(map[string]*adaptive),
+	}
+}
+
+// adaptive is the limit of a method.
+type adaptive struct {
+	mu          sync.Mutex
+	limit       float64
+	inFlight    int
+	maxInFlight int  // of the window, a limit the load doesn't reach isn't raised
+	shed        bool // the window rejected requests
+	longRTT     float64
+	shortRTT    float64
+	sum         float64
+	n           int
+	allowed     uint64
+	rejected    uint64
+}
+
+// AdaptiveStats is the limit and usage of a method.
+type AdaptiveStats struct {
+	ServicePath string
+	Method      string
+	Limit       int
+	InFlight    int
+	RTT         time.Duration // of the last window
+	LongRTT     time.Duration
+	Allowed     uint64
+	Rejected    uint64
+}
+
+// adaptiveKey keeps the request an AdaptivePlugin took in its context.
+type adaptiveKey struct {
+	p *AdaptivePlugin
+}
+
+type adaptiveRequest struct {
+	a     *adaptive
+	start time.Time
+}
+
+func (p *AdaptivePlugin) get(servicePath, method string) *adaptive {
+	key := servicePath + "." + strings.ToLower(method)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.methods[key]
+	if ok {
+		return a
+	}
+	if len(p.methods) >= maxMethods {
+		key = ""
+		if a, ok = p.methods[key]; ok {
+			return a
+		}
+	}
+	a = &adaptive{limit: float64(p.InitialLimit)}
+	p.methods[key] = a
+	return a
+}
+
+// PreHandleRequest takes the request if the method is under its limit. Streams
+// aren't limited, they last as long as the client wants.
+func (p *AdaptivePlugin) PreHandleRequest(ctx context.Context, req *protocol.Message) error {
+	if protocol.StreamWindowOf(req.Metadata) > 0 {
+		return nil
+	}
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	a := p.get(req.ServicePath, req.ServiceMethod)
+	a.mu.Lock()
+	limit := int(a.limit)
+	ok = a.inFlight < limit
+	if ok {
+		a.inFlight++
+		if a.inFlight > a.maxInFlight {
+			a.maxInFlight = a.inFlight
+		}
+		a.allowed++
+	} else {
+		a.rejected++
+		a.shed = true
+	}
+	a.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s.%s over the limit %d", server.ErrReqReachLimit, req.ServicePath, req.ServiceMethod, limit)
+	}
+
+	start := time.Now()
+	if t, ok := ctx.Value(server.StartRequestContextKey).(int64); ok {
+		start = time.Unix(0, t)
+	}
+	sctx.SetValue(adaptiveKey{p}, &adaptiveRequest{a: a, start: start})
+	return nil
+}
+
+// PostWriteResponse ends the request and samples its RTT.
+func (p *AdaptivePlugin) PostWriteResponse(ctx context.Context, req, res *protocol.Message, e error) error {
+	p.done(ctx)
+	return nil
+}
+
+// PostHandleRequest ends the requests without responses.
+func (p *AdaptivePlugin) PostHandleRequest(ctx context.Context, req *protocol.Message, e error) error {
+	p.done(ctx)
+	return nil
+}
+
+func (p *AdaptivePlugin) done(ctx context.Context) {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return
+	}
+	r, ok := sctx.Value(adaptiveKey{p}).(*adaptiveRequest)
+	if !ok {
+		return
+	}
+	sctx.DeleteKey(adaptiveKey{p})
+
+	rtt := float64(time.Since(r.start))
+	a := r.a
+	a.mu.Lock()
+	a.inFlight--
+	a.sum += rtt
+	a.n++
+	if a.n >= p.Window {
+		p.updateLocked(a)
+	}
+	a.mu.Unlock()
+}
+
+// updateLocked sets the limit of the RTT of the window. a.mu is held.
+func (p *AdaptivePlugin) updateLocked(a *adaptive) {
+	short := a.sum / float64(a.n)
+	maxInFlight, shed := a.maxInFlight, a.shed
+	a.sum, a.n, a.maxInFlight, a.shed = 0, 0, a.inFlight, false
+	a.shortRTT = short
+
+	switch {
+	case a.longRTT == 0:
+		a.longRTT = short
+	case short < a.longRTT:
+		a.longRTT += (short - a.longRTT) / 2
+	case !shed:
+		// the RTT of an overload that sheds requests would become the norm
+		a.longRTT += (short - a.longRTT) / 20
+	}
+
+	gradient := math.Max(0.5, math.Min(1, p.Tolerance*a.longRTT/short))
+	if gradient == 1 && float64(maxInFlight) < a.limit/2 {
+		return
+	}
+	limit := a.limit*gradient + math.Sqrt(a.limit)
+	limit = a.limit*(1-p.Smoothing) + limit*p.Smoothing
+	a.limit = math.Max(float64(p.MinLimit), math.Min(float64(p.MaxLimit), limit))
+}
+
+// Stats returns the limits and usage of the methods.
+func (p *AdaptivePlugin) Stats() []AdaptiveStats {
+	p.mu.Lock()
+	stats := make([]AdaptiveStats, 0, len(p.methods))
+	for key, a := range p.methods {
+		var st AdaptiveStats
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			st.ServicePath, st.Method = key[:i], key[i+1:]
+		}
+		a.mu.Lock()
+		st.Limit = int(a.limit)
+		st.InFlight = a.inFlight
+		st.RTT = time.Duration(a.shortRTT)
+		st.LongRTT = time.Duration(a.longRTT)
+		st.Allowed = a.allowed
+		st.Rejected = a.rejected
+		a.mu.Unlock()
+		stats = append(stats, st)
+	}
+	p.mu.Unlock()
+	return stats
+}
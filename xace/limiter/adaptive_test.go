@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// window ends a window of requests of rtt with up to inFlight at a time.
+func window(p *AdaptivePlugin, a *adaptive, rtt time.Duration, inFlight int, shed bool) {
+	a.mu.Lock()
+	a.maxInFlight, a.shed = inFlight, shed
+	a.sum, a.n = float64(rtt)*float64(p.Window), p.Window
+	p.updateLocked(a)
+	a.mu.Unlock()
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	p := NewAdaptivePlugin()
+	p.MaxLimit = 100
+	a := p.get("Svc", "Work")
+
+	// a limit the load doesn't reach isn't raised
+	window(p, a, time.Millisecond, 1, false)
+	if a.limit != 20 {
+		t.Fatalf("an idle method changed the limit to %v", a.limit)
+	}
+
+	// the limit grows while the RTT stays, up to MaxLimit
+	for i := 0; i < 10; i++ {
+		window(p, a, time.Millisecond, int(a.limit), false)
+	}
+	if a.limit <= 25 {
+		t.Fatalf("the limit grew to %v", a.limit)
+	}
+	for i := 0; i < 100; i++ {
+		window(p, a, time.Millisecond, int(a.limit), false)
+	}
+	if a.limit != 100 {
+		t.Fatalf("the limit is %v over MaxLimit", a.limit)
+	}
+
+	// an overload that sheds requests doesn't become the norm, the limit keeps shrinking
+	for i := 0; i < 50; i++ {
+		window(p, a, 5*time.Millisecond, int(a.limit), true)
+	}
+	if a.limit > 10 || a.limit < float64(p.MinLimit) {
+		t.Fatalf("the limit shrank to %v", a.limit)
+	}
+	if time.Duration(a.longRTT) > 2*time.Millisecond {
+		t.Fatalf("the long term RTT grew to %v", time.Duration(a.longRTT))
+	}
+
+	// and it recovers with the RTT
+	low := a.limit
+	for i := 0; i < 10; i++ {
+		window(p, a, time.Millisecond, int(a.limit), false)
+	}
+	if a.limit <= low {
+		t.Fatalf("the limit stays at %v", a.limit)
+	}
+}
+
+type Busy struct {
+	mu sync.Mutex
+	n  int
+}
+
+// Work takes longer the more run at once, like a saturated host.
+func (b *Busy) Work(ctx context.Context, args *Args, reply *Reply) int32 {
+	b.mu.Lock()
+	b.n++
+	n := b.n
+	b.mu.Unlock()
+	d := time.Millisecond
+	if n > 8 {
+		d = time.Duration(n) * time.Millisecond
+	}
+	time.Sleep(d)
+	b.mu.Lock()
+	b.n--
+	b.mu.Unlock()
+	return 0
+}
+
+func TestAdaptivePlugin(t *testing.T) {
+	p := NewAdaptivePlugin()
+	xc := startServer(t, "Busy", &Busy{}, p)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rejected := 0
+	stop := time.Now().Add(time.Second)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(stop) {
+				err := xc.Call(context.Background(), "work", []any{0}, []any{&Reply{}})
+				if err == nil {
+					continue
+				}
+				if !isLimited(err) {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := p.Stats()
+	if len(stats) != 1 || rejected == 0 {
+		t.Fatalf("%+v, %d rejected", stats, rejected)
+	}
+	st := stats[0]
+	if st.ServicePath != "Busy" || st.Method != "work" || st.InFlight != 0 || st.Limit > 40 || st.Rejected != uint64(rejected) {
+		t.Fatalf("%+v, %d rejected", st, rejected)
+	}
+}
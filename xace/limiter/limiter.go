@@ -0,0 +1,232 @@
+// Package limiter limits the requests a server takes by rate and concurrency.
+//
+// A Plugin holds rules that match requests by service path and method and give
+// them a Limiter, shared by all clients or one for each remote IP. A request any
+// of its limiters rejects is answered with server.ErrReqReachLimit.
+//
+//	p := limiter.NewPlugin(
+//		limiter.Rule{New: func() limiter.Limiter { return limiter.NewMaxInFlight(1000) }},
+//		limiter.Rule{ServicePath: "Arith", Method: "mul", PerIP: true,
+//			New: func() limiter.Limiter { return limiter.NewTokenBucket(100, 20) }},
+//	)
+//	s.Plugins.Add(p)
+//
+// An AdaptivePlugin needs no limits, it finds the concurrency each method takes
+// from the latency of its requests.
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds of the limiters.
+const (
+	KindTokenBucket = "token_bucket"
+	KindLeakyBucket = "leaky_bucket"
+	KindMaxInFlight = "max_in_flight"
+)
+
+// Limiter decides whether a request is taken.
+type Limiter interface {
+	// Acquire takes a request, false if it reaches the limit.
+	Acquire() bool
+	// Release is called when a request Acquire took is done.
+	Release()
+	// Stats returns the current usage.
+	Stats() Stats
+}
+
+// Stats is the usage of a limiter.
+type Stats struct {
+	Kind string
+	// Limit is the requests per second of the buckets, the requests in flight of MaxInFlight.
+	Limit float64
+	// Used is the tokens taken of the burst of TokenBucket, the requests waiting in
+	// LeakyBucket or the requests in flight of MaxInFlight. A limiter that isn't used
+	// has 0.
+	Used     float64
+	Allowed  uint64
+	Rejected uint64
+}
+
+// counters counts the allowed and rejected requests.
+type counters struct {
+	allowed  uint64
+	rejected uint64
+}
+
+func (c *counters) count(ok bool) bool {
+	if ok {
+		atomic.AddUint64(&c.allowed, 1)
+	} else {
+		atomic.AddUint64(&c.rejected, 1)
+	}
+	return ok
+}
+
+func (c *counters) stats(kind string, limit, used float64) Stats {
+	return Stats{
+		Kind:     kind,
+		Limit:    limit,
+		Used:     used,
+		Allowed:  atomic.LoadUint64(&c.allowed),
+		Rejected: atomic.LoadUint64(&c.rejected),
+	}
+}
+
+// TokenBucket takes rate requests per second and bursts of up to burst requests.
+type TokenBucket struct {
+	counters // first for the alignment of its atomics
+
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket that starts full.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refillLocked adds the tokens of the time since the last refill.
+func (b *TokenBucket) refillLocked(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Acquire takes a token.
+func (b *TokenBucket) Acquire() bool {
+	b.mu.Lock()
+	b.refillLocked(time.Now())
+	ok := b.tokens >= 1
+	if ok {
+		b.tokens--
+	}
+	b.mu.Unlock()
+	return b.count(ok)
+}
+
+// Release does nothing, tokens come back with time.
+func (b *TokenBucket) Release() {}
+
+// Stats returns the usage of the bucket.
+func (b *TokenBucket) Stats() Stats {
+	b.mu.Lock()
+	b.refillLocked(time.Now())
+	used := b.burst - b.tokens
+	b.mu.Unlock()
+	return b.stats(KindTokenBucket, b.rate, used)
+}
+
+// LeakyBucket lets requests through at rate per second evenly, without bursts.
+// A request waits in Acquire for its turn, a request that would find capacity
+// requests waiting is rejected. The server reads no more requests of the
+// connection while one waits.
+type LeakyBucket struct {
+	counters // first for the alignment of its atomics
+
+	interval time.Duration
+	maxWait  time.Duration
+	rate     float64
+
+	mu   sync.Mutex
+	next time.Time // when the next request may pass
+}
+
+// NewLeakyBucket returns a LeakyBucket that holds up to capacity waiting requests.
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	interval := time.Duration(float64(time.Second) / rate)
+	return &LeakyBucket{
+		interval: interval,
+		maxWait:  time.Duration(capacity) * interval,
+		rate:     rate,
+	}
+}
+
+// Acquire waits for the turn of the request.
+func (b *LeakyBucket) Acquire() bool {
+	now := time.Now()
+	b.mu.Lock()
+	at := b.next
+	if at.Before(now) {
+		at = now
+	}
+	wait := at.Sub(now)
+	ok := wait <= b.maxWait
+	if ok {
+		b.next = at.Add(b.interval)
+	}
+	b.mu.Unlock()
+
+	if ok && wait > 0 {
+		time.Sleep(wait)
+	}
+	return b.count(ok)
+}
+
+// Release does nothing, the bucket leaks with time.
+func (b *LeakyBucket) Release() {}
+
+// Stats returns the usage of the bucket.
+func (b *LeakyBucket) Stats() Stats {
+	b.mu.Lock()
+	waiting := time.Until(b.next)
+	b.mu.Unlock()
+	var used float64
+	if waiting > 0 {
+		used = float64(waiting) / float64(b.interval)
+	}
+	return b.stats(KindLeakyBucket, b.rate, used)
+}
+
+// MaxInFlight takes up to max requests at a time.
+type MaxInFlight struct {
+	counters // first for the alignment of its atomics
+
+	max      int64
+	inFlight int64
+}
+
+// NewMaxInFlight returns a MaxInFlight of max requests.
+func NewMaxInFlight(max int) *MaxInFlight {
+	return &MaxInFlight{max: int64(max)}
+}
+
+// Acquire takes a request if fewer than max are in flight.
+func (m *MaxInFlight) Acquire() bool {
+	for {
+		n := atomic.LoadInt64(&m.inFlight)
+		if n >= m.max {
+			return m.count(false)
+		}
+		if atomic.CompareAndSwapInt64(&m.inFlight, n, n+1) {
+			return m.count(true)
+		}
+	}
+}
+
+// Release ends a request.
+func (m *MaxInFlight) Release() {
+	atomic.AddInt64(&m.inFlight, -1)
+}
+
+// Stats returns the requests in flight.
+func (m *MaxInFlight) Stats() Stats {
+	return m.stats(KindMaxInFlight, float64(m.max), float64(atomic.LoadInt64(&m.inFlight)))
+}
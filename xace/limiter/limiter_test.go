@@ -0,0 +1,81 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(20, 3)
+	for i := 0; i < 3; i++ {
+		if !b.Acquire() {
+			t.Fatalf("request %d of the burst is rejected", i)
+		}
+	}
+	if b.Acquire() {
+		t.Fatal("a request over the burst is taken")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !b.Acquire() {
+		t.Fatal("the bucket doesn't refill")
+	}
+	st := b.Stats()
+	if st.Kind != KindTokenBucket || st.Allowed != 4 || st.Rejected != 1 || st.Used < 2 || st.Used > 3 {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestLeakyBucket(t *testing.T) {
+	b := NewLeakyBucket(100, 5)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if !b.Acquire() {
+			t.Fatalf("request %d is rejected", i)
+		}
+	}
+	// evenly at 100 per second, without a burst
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Fatalf("10 requests passed in %v", d)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rejected := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !b.Acquire() {
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// 5 wait and about one more passes while they do
+	if rejected < 10 {
+		t.Fatalf("%d of 20 requests are rejected", rejected)
+	}
+	if st := b.Stats(); st.Kind != KindLeakyBucket || st.Rejected != uint64(rejected) {
+		t.Fatalf("%+v", st)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	m := NewMaxInFlight(2)
+	if !m.Acquire() || !m.Acquire() {
+		t.Fatal("requests under the limit are rejected")
+	}
+	if m.Acquire() {
+		t.Fatal("a request over the limit is taken")
+	}
+	if st := m.Stats(); st.Used != 2 || st.Limit != 2 || st.Allowed != 2 || st.Rejected != 1 {
+		t.Fatalf("%+v", st)
+	}
+	m.Release()
+	if !m.Acquire() {
+		t.Fatal("a released request is not taken again")
+	}
+}
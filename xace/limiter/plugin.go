@@ -0,0 +1,196 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"xace/protocol"
+	"xace/server"
+	"xace/share"
+)
+
+// sweepSize is how many per IP limiters a rule holds before the unused ones are dropped.
+const sweepSize = 1024
+
+// Rule gives the requests it matches a Limiter.
+type Rule struct {
+	ServicePath string // all services if empty
+	Method      string // all methods if empty, case insensitive like the server
+	// PerIP gives each remote IP a limiter of its own, otherwise all clients share one.
+	PerIP bool
+	// New makes the limiter.
+	New func() Limiter
+}
+
+func (r *Rule) match(servicePath, method string) bool {
+	return (r.ServicePath == "" || r.ServicePath == servicePath) &&
+		(r.Method == "" || strings.EqualFold(r.Method, method))
+}
+
+type rule struct {
+	Rule
+
+	mu        sync.Mutex
+	shared    Limiter
+	ips       map[string]Limiter
+	nextSweep int
+}
+
+func (r *rule) limiter(ip string) Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.PerIP {
+		if r.shared == nil {
+			r.shared = r.New()
+		}
+		return r.shared
+	}
+
+	l, ok := r.ips[ip]
+	if ok {
+		return l
+	}
+	if len(r.ips) >= r.nextSweep {
+		for k, l := range r.ips {
+			if l.Stats().Used == 0 {
+				delete(r.ips, k)
+			}
+		}
+		r.nextSweep = 2 * len(r.ips)
+		if r.nextSweep < sweepSize {
+			r.nextSweep = sweepSize
+		}
+	}
+	l = r.New()
+	r.ips[ip] = l
+	return l
+}
+
+// RuleStats is the usage of the limiter of a rule, for one remote IP if the rule is PerIP.
+type RuleStats struct {
+	ServicePath string
+	Method      string
+	RemoteIP    string
+	Stats
+}
+
+// acquiredKey keeps the limiters a request acquired in its context.
+type acquiredKey struct {
+	p *Plugin
+}
+
+// Plugin is a server plugin that limits the requests by its rules. A request is
+// checked against all the rules it matches, in order, when it is read.
+type Plugin struct {
+	mu    sync.RWMutex
+	rules []*rule
+}
+
+// NewPlugin returns a plugin of the rules.
+func NewPlugin(rules ...Rule) *Plugin {
+	p := &Plugin{}
+	for _, r := range rules {
+		p.Add(r)
+	}
+	return p
+}
+
+// Add appends a rule.
+func (p *Plugin) Add(r Rule) {
+	p.mu.Lock()
+	p.rules = append(p.rules, &rule{Rule: r, ips: make(map[string]Limiter), nextSweep: sweepSize})
+	p.mu.Unlock()
+}
+
+// Stats returns the usage of the limiters of all rules.
+func (p *Plugin) Stats() []RuleStats {
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	var stats []RuleStats
+	for _, r := range rules {
+		r.mu.Lock()
+		if r.shared != nil {
+			stats = append(stats, RuleStats{ServicePath: r.ServicePath, Method: r.Method, Stats: r.shared.Stats()})
+		}
+		for ip, l := range r.ips {
+			stats = append(stats, RuleStats{ServicePath: r.ServicePath, Method: r.Method, RemoteIP: ip, Stats: l.Stats()})
+		}
+		r.mu.Unlock()
+	}
+	return stats
+}
+
+// PostReadRequest acquires the limiters of the request, it returns server.ErrReqReachLimit
+// if one rejects it.
+func (p *Plugin) PostReadRequest(ctx context.Context, req *protocol.Message, e error) error {
+	if e != nil || req.MessageType() == protocol.Response || req.MessageType() == protocol.Cancel ||
+		req.IsHeartbeat() || req.IsStreamFrame() {
+		return nil
+	}
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	var ip string
+	var acquired []Limiter
+	for _, r := range rules {
+		if !r.match(req.ServicePath, req.ServiceMethod) {
+			continue
+		}
+		if r.PerIP && ip == "" {
+			ip = remoteIP(ctx)
+		}
+		l := r.limiter(ip)
+		if !l.Acquire() {
+			release(acquired)
+			return fmt.Errorf("%w: %s.%s", server.ErrReqReachLimit, req.ServicePath, req.ServiceMethod)
+		}
+		acquired = append(acquired, l)
+	}
+	if len(acquired) > 0 {
+		sctx.SetValue(acquiredKey{p}, acquired)
+	}
+	return nil
+}
+
+// PostHandleRequest releases the limiters of the request.
+func (p *Plugin) PostHandleRequest(ctx context.Context, req *protocol.Message, e error) error {
+	sctx, ok := ctx.(*share.Context)
+	if !ok {
+		return nil
+	}
+	if acquired, ok := sctx.Value(acquiredKey{p}).([]Limiter); ok {
+		sctx.DeleteKey(acquiredKey{p})
+		release(acquired)
+	}
+	return nil
+}
+
+func release(acquired []Limiter) {
+	for _, l := range acquired {
+		l.Release()
+	}
+}
+
+func remoteIP(ctx context.Context) string {
+	conn, ok := ctx.Value(server.RemoteConnContextKey).(net.Conn)
+	if !ok {
+		return ""
+	}
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
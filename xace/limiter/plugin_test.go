@@ -0,0 +1,114 @@
+package limiter
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+)
+
+type Args struct{ Sleep int }
+
+type Reply struct{}
+
+type Svc struct{}
+
+func (s *Svc) Slow(ctx context.Context, args *Args, reply *Reply) int32 {
+	time.Sleep(time.Duration(args.Sleep) * time.Millisecond)
+	return 0
+}
+
+func (s *Svc) Fast(ctx context.Context, args *Args, reply *Reply) int32 { return 0 }
+
+// startServer serves rcvr as name with plugin p and returns a client of it.
+func startServer(t *testing.T, name string, rcvr interface{}, p server.Plugin) client.XClient {
+	s := server.NewServer()
+	s.Plugins.Add(p)
+	s.RegisterName(name, rcvr, "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+s.Address().String(), "")
+	xc := client.NewXClient(name, client.Failfast, client.RandomSelect, d, client.AceOption)
+	t.Cleanup(func() { xc.Close() })
+	return xc
+}
+
+func isLimited(err error) bool {
+	return err != nil && strings.Contains(err.Error(), server.ErrReqReachLimit.Error())
+}
+
+func TestPlugin(t *testing.T) {
+	p := NewPlugin(
+		Rule{ServicePath: "Svc", Method: "slow", New: func() Limiter { return NewMaxInFlight(2) }},
+		Rule{ServicePath: "Svc", Method: "Fast", PerIP: true, New: func() Limiter { return NewTokenBucket(1, 3) }},
+	)
+	xc := startServer(t, "Svc", &Svc{}, p)
+
+	taken, rejected := 0, 0
+	for i := 0; i < 5; i++ {
+		err := xc.Call(context.Background(), "fast", []any{0}, []any{&Reply{}})
+		switch {
+		case err == nil:
+			taken++
+		case isLimited(err):
+			rejected++
+		default:
+			t.Fatal(err)
+		}
+	}
+	if taken != 3 || rejected != 2 {
+		t.Fatalf("the burst of 3 took %d and rejected %d", taken, rejected)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	rejected = 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := xc.Call(context.Background(), "slow", []any{300}, []any{&Reply{}})
+			if err != nil && !isLimited(err) {
+				t.Error(err)
+			}
+			mu.Lock()
+			if err != nil {
+				rejected++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if rejected != 3 {
+		t.Fatalf("2 in flight rejected %d of 5", rejected)
+	}
+
+	stats := p.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("%+v", stats)
+	}
+	for _, st := range stats {
+		switch st.Kind {
+		case KindMaxInFlight:
+			if st.RemoteIP != "" || st.Used != 0 || st.Allowed != 2 || st.Rejected != 3 {
+				t.Fatalf("%+v", st)
+			}
+		case KindTokenBucket:
+			if st.RemoteIP != "127.0.0.1" || st.Allowed != 3 || st.Rejected != 2 {
+				t.Fatalf("%+v", st)
+			}
+		}
+	}
+	// the limiter is released
+	if err := xc.Call(context.Background(), "slow", []any{1}, []any{&Reply{}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,9 +2,14 @@ package protocol
 
 import (
 	"bytes"
-	"io/ioutil"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
 	"xace/util"
 )
 
@@ -14,6 +19,28 @@ type Compressor interface {
 	Unzip([]byte) ([]byte, error)
 }
 
+// unzipLimit is the largest payload Unzip returns, a larger one fails with
+// ErrMessageTooLong.
+func unzipLimit() int {
+	if MaxMessageLength > 0 {
+		return MaxMessageLength
+	}
+	return DefaultMaxBodyLen
+}
+
+// readUnzipped reads the unzipped payload from r, up to unzipLimit bytes.
+func readUnzipped(r io.Reader) ([]byte, error) {
+	limit := unzipLimit()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrMessageTooLong
+	}
+	return out, nil
+}
+
 // GzipCompressor implements gzip compressor.
 type GzipCompressor struct {
 }
@@ -23,7 +50,12 @@ func (c GzipCompressor) Zip(data []byte) ([]byte, error) {
 }
 
 func (c GzipCompressor) Unzip(data []byte) ([]byte, error) {
-	return util.Unzip(data)
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return readUnzipped(gr)
 }
 
 type RawDataCompressor struct {
@@ -68,11 +100,90 @@ func (c *SnappyCompressor) Unzip(data []byte) ([]byte, error) {
 	}
 
 	reader := snappy.NewReader(bytes.NewReader(data))
-	out, err := ioutil.ReadAll(reader)
+	return readUnzipped(reader)
+}
+
+// ZstdCompressor implements zstd compressor.
+// The encoder and decoder are safe for concurrent EncodeAll/DecodeAll.
+// The decoder takes the limit of Unzip when it is first used.
+type ZstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *ZstdCompressor) init() {
+	c.once.Do(func() {
+		c.encoder, c.err = zstd.NewWriter(nil)
+		if c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(unzipLimit())))
+	})
+}
+
+func (c *ZstdCompressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	c.init()
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.encoder.EncodeAll(data, make([]byte, 0, len(data))), nil
+}
+
+func (c *ZstdCompressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	c.init()
+	if c.err != nil {
+		return nil, c.err
+	}
+	out, err := c.decoder.DecodeAll(data, nil)
+	if err != nil {
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, ErrMessageTooLong
+		}
+		return nil, err
+	}
+	if len(out) > unzipLimit() {
+		return nil, ErrMessageTooLong
+	}
+	return out, nil
+}
+
+// Lz4Compressor implements lz4 compressor with the lz4 frame format.
+type Lz4Compressor struct {
+}
+
+func (c *Lz4Compressor) Zip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	var buffer bytes.Buffer
+	writer := lz4.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	err = writer.Close()
 	if err != nil {
 		return nil, err
 	}
 
-	return out, err
+	return buffer.Bytes(), nil
 }
 
+func (c *Lz4Compressor) Unzip(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	reader := lz4.NewReader(bytes.NewReader(data))
+	return readUnzipped(reader)
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, ct := range []CompressType{None, Gzip, Snappy, Zstd, Lz4} {
+		for _, size := range []int{10, 5000} {
+			m := NewMessage()
+			m.ServicePath, m.ServiceMethod = "svc", "m"
+			m.Compress = ct
+			m.Payload = []byte(strings.Repeat("a", size))
+			var buf bytes.Buffer
+			if _, err := m.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if ct == Gzip && size == 5000 && buf.Len() > 1000 {
+				t.Fatalf("%d bytes are not compressed", buf.Len())
+			}
+			r, err := Read(&buf)
+			if err != nil {
+				t.Fatal(ct, err)
+			}
+			if !bytes.Equal(r.Payload, m.Payload) {
+				t.Fatalf("compress %d: %d bytes read back as %d", ct, size, len(r.Payload))
+			}
+			if m.Compress != ct {
+				t.Fatal("WriteTo changed the message")
+			}
+		}
+	}
+}
+
+func TestUnsupportedCompressor(t *testing.T) {
+	m := NewMessage()
+	m.ServicePath, m.ServiceMethod = "svc", "m"
+	m.Compress = CompressType(99)
+	m.Payload = []byte("a")
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != ErrUnsupportedCompressor || buf.Len() != 0 {
+		t.Fatal(err, buf.Len())
+	}
+}
+
+// TestUnzipLimit checks that a small payload can't unzip to more than a message may hold.
+func TestUnzipLimit(t *testing.T) {
+	defer func(n int) { MaxMessageLength = n }(MaxMessageLength)
+	MaxMessageLength = 1 << 20
+
+	for _, ct := range []CompressType{Gzip, Snappy, Zstd, Lz4} {
+		// a fresh compressor, zstd takes the limit when it is first used
+		var c Compressor
+		switch ct {
+		case Gzip:
+			c = GzipCompressor{}
+		case Snappy:
+			c = &SnappyCompressor{}
+		case Zstd:
+			c = &ZstdCompressor{}
+		case Lz4:
+			c = &Lz4Compressor{}
+		}
+		ok := bytes.Repeat([]byte("a"), MaxMessageLength)
+		zipped, err := c.Zip(ok)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := c.Unzip(zipped); err != nil || len(out) != len(ok) {
+			t.Fatalf("compress %d: %v %d", ct, err, len(out))
+		}
+
+		bomb, _ := c.Zip(bytes.Repeat([]byte("a"), MaxMessageLength+1))
+		if _, err := c.Unzip(bomb); !errors.Is(err, ErrMessageTooLong) {
+			t.Fatalf("compress %d: %v", ct, err)
+		}
+	}
+
+	m := NewMessage()
+	m.ServicePath = "svc"
+	m.Payload = make([]byte, MaxMessageLength)
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	if _, err := Read(&buf); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatal(err)
+	}
+}
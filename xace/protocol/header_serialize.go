@@ -9,12 +9,57 @@ const (
     DefaultMaxBodyLen int = 1024*1024*64 - 16
 )
 
+// headExtVersion is the version of the header extension written as the 6th
+// header field. The extension is one FT_NUMBER laid out from the low byte up:
+//
+//	bits  0-7   extension version
+//	bits  8-15  CompressType
+//	bits 16-23  SerializeType
+//	bits 24-31  MessageStatusType
+//
+// Later versions may only append bits above 31, so a v1 reader can still pick
+// the flags out of a newer extension.
+const headExtVersion uint64 = 1
+
+// needExt reports whether the header carries flags an old-style header can't express.
+// A header without extension is read back as uncompressed AcePack with Normal status,
+// or Error status if its metadata has a ServiceError. A header made from one that
+// came without extension is written without one too, the peer may not read it.
+func (head *Header) needExt() bool {
+    if head.noExt {
+        return false
+    }
+    if head.Compress != None || head.Status != Normal {
+        return true
+    }
+    return head.Serialize != SerializeNone && head.Serialize != AcePack
+}
+
+func (head *Header) packExt() uint64 {
+    return headExtVersion |
+        uint64(head.Compress)<<8 |
+        uint64(head.Serialize)<<16 |
+        uint64(head.Status)<<24
+}
+
+func (head *Header) unpackExt(ext uint64) {
+    if ext&0xff < headExtVersion {
+        return
+    }
+    head.Compress = CompressType(ext >> 8)
+    head.Serialize = SerializeType(ext >> 16)
+    head.Status = MessageStatusType(ext >> 24)
+}
+
 
 // Values returns Metadata.
 func (head *Header) PackData() []byte {
     packer := codec.NewPackData()
     var fieldnum uint8 = 5
-    for {
+    if head.needExt() {
+        fieldnum = 6
+    }
+    for fieldnum == 5 {
         if len(head.Metadata) == 0 {
             fieldnum--
         } else {
@@ -64,53 +109,112 @@ func (head *Header) PackData() []byte {
         packer.PackString(_k)
         packer.PackString(_v)
     }
+
+    if fieldnum == 5 {
+        return packer.Data()
+    }
+    packer.PackFieldType(codec.FT_NUMBER)
+    packer.PackUint64(head.packExt())
     return packer.Data()
 }
 
-func (head *Header) UnpackData(packer *codec.PackData)  {
+// UnpackData reads the header from packer. It returns a *codec.DecodeError
+// naming the header field if the data is broken.
+func (head *Header) UnpackData(packer *codec.PackData) error {
 	if head.Metadata == nil {
 		head.Metadata = map[string]string{}
 	}
     fieldnum := packer.UnpackFieldNum()
-    if fieldnum < 1 {
-        panic("aacehead fieldnum")
+    if packer.Err() == nil && fieldnum < 1 {
+        packer.Fail(&codec.DecodeError{Offset: packer.Pos() - 1, Err: codec.ErrInvalidData, Msg: "header has no fields"})
+    }
+    if err := headerError(packer, "fieldnum"); err != nil {
+        return err
     }
+    head.noExt = fieldnum < 6
     fieldtype := packer.UnpackFieldType()
     head.ServicePath = packer.Unpack2String(fieldtype)
+    if err := headerError(packer, "ServicePath"); err != nil {
+        return err
+    }
 
     if fieldnum < 2 {
-        return
+        return nil
     }
     fieldtype = packer.UnpackFieldType()
     head.ServiceMethod = packer.Unpack2String(fieldtype)
+    if err := headerError(packer, "ServiceMethod"); err != nil {
+        return err
+    }
 
     if fieldnum < 3 {
-        return
+        return nil
     }
     fieldtype = packer.UnpackFieldType()
-    if fieldtype != codec.FT_CHAR {
-        panic("unpack fieldtype error not char.")
+    if packer.Err() == nil && fieldtype != codec.FT_CHAR {
+        packer.Fail(&codec.DecodeError{Expected: codec.FT_CHAR, Actual: fieldtype, Offset: packer.Pos() - 1, Err: codec.ErrTypeMismatch})
     }
     head.CallType = MessageType(packer.UnpackUint8())
+    if err := headerError(packer, "CallType"); err != nil {
+        return err
+    }
 
     if fieldnum < 4 {
-        return
+        return nil
     }
     fieldtype = packer.UnpackFieldType()
     head.SeqId = packer.Unpack2UNumber(fieldtype)
+    if err := headerError(packer, "SeqId"); err != nil {
+        return err
+    }
 
     if fieldnum < 5 {
-        return
+        return nil
     }
+    offset := packer.Pos()
     field := packer.UnpackField()
-    if field.BaseType != codec.FT_MAP || field.SubType[0].BaseType != codec.FT_STRING || field.SubType[1].BaseType != codec.FT_STRING {
-        panic("aacehead unpack reserved fieldtype error")
+    if packer.Err() == nil && (field.BaseType != codec.FT_MAP || field.SubType[0].BaseType != codec.FT_STRING || field.SubType[1].BaseType != codec.FT_STRING) {
+        packer.Fail(&codec.DecodeError{Expected: codec.FT_MAP, Actual: field.BaseType, Offset: offset, Err: codec.ErrTypeMismatch, Msg: "metadata must be map<string,string>"})
     }
-    rnum := packer.UnpackUint32()
-    for  ; rnum > 0 ; rnum-- {
+    rnum := packer.UnpackCount()
+    for  ; rnum > 0 && packer.Err() == nil; rnum-- {
         key := packer.UnpackString()
         val := packer.UnpackString()
         head.Metadata[key] = val
     }
+    if err := headerError(packer, "Metadata"); err != nil {
+        return err
+    }
+
+    if fieldnum < 6 {
+        if head.Metadata[ServiceError] != "" {
+            // an old peer sends an error with Normal status
+            head.Status = Error
+        }
+        return nil
+    }
+    fieldtype = packer.UnpackFieldType()
+    head.unpackExt(packer.Unpack2UNumber(fieldtype))
+    if err := headerError(packer, "ext"); err != nil {
+        return err
+    }
+
+    // fields added by newer peers
+    for i := 6; i < int(fieldnum) && packer.Err() == nil; i++ {
+        packer.PeekField()
+    }
+    return headerError(packer, "")
+}
+
+// headerError returns the error of packer with the path of the header field.
+func headerError(packer *codec.PackData, name string) error {
+    err := packer.Err()
+    if de, ok := err.(*codec.DecodeError); ok && de.Path == "" {
+        de.Path = "header"
+        if name != "" {
+            de.Path += "." + name
+        }
+    }
+    return err
 }
 
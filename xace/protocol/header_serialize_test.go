@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"xace/codec"
+)
+
+func TestHeaderExt(t *testing.T) {
+	for _, tc := range []struct {
+		c    CompressType
+		s    SerializeType
+		st   MessageStatusType
+		meta map[string]string
+		seq  uint64
+		ext  bool
+	}{
+		{None, AcePack, Normal, nil, 0, false},
+		{None, SerializeNone, Normal, map[string]string{"a": "b"}, 5, false},
+		{Gzip, JSON, Error, nil, 0, true},
+		{None, AcePack, Error, map[string]string{"a": "b"}, 5, true},
+	} {
+		m := NewMessage()
+		m.ServicePath, m.ServiceMethod = "svc", "m"
+		m.CallType = Request
+		m.SeqId = tc.seq
+		m.Metadata = tc.meta
+		m.Compress, m.Serialize, m.Status = tc.c, tc.s, tc.st
+		m.Payload = []byte("hello world payload")
+		if ext := m.PackData()[0] == 6; ext != tc.ext {
+			t.Fatalf("%+v: ext %v", tc, ext)
+		}
+		var buf bytes.Buffer
+		m.WriteTo(&buf)
+		r, err := Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != tc.st || string(r.Payload) != "hello world payload" || r.SeqId != tc.seq {
+			t.Fatalf("%+v %+v %q", tc, r.Header, r.Payload)
+		}
+		if tc.ext && r.Serialize != tc.s {
+			t.Fatalf("%+v: serialize %d", tc, r.Serialize)
+		}
+	}
+}
+
+// TestHeaderExtOldPeer checks that the responses to an old peer, which neither reads
+// nor writes the ext, are laid out as it expects.
+func TestHeaderExtOldPeer(t *testing.T) {
+	read := func(h *Header) *Header {
+		pk := codec.NewPackData()
+		pk.ResetBytes(h.PackData())
+		r := &Header{}
+		if err := r.UnpackData(pk); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// a request of an old peer gets an error response without ext
+	req := read(&Header{ServicePath: "svc", ServiceMethod: "m", SeqId: 1, Metadata: map[string]string{"a": "b"}})
+	res := (&Message{Header: req}).Clone()
+	res.CallType = Response
+	res.Serialize = JSON
+	res.Status = Error
+	res.Metadata = map[string]string{ServiceError: "failed"}
+	if n := res.PackData()[0]; n != 5 {
+		t.Fatalf("response to an old peer has %d fields", n)
+	}
+	// and the error is still read as one
+	if r := read(res.Header); r.Status != Error || r.Serialize != SerializeNone {
+		t.Fatalf("%+v", r)
+	}
+
+	// a request with ext gets the flags of its response
+	req = read(&Header{ServicePath: "svc", ServiceMethod: "m", SeqId: 1, Serialize: JSON})
+	res = (&Message{Header: req}).Clone()
+	res.CallType = Response
+	res.Status = Error
+	if r := read(res.Header); r.Status != Error || r.Serialize != JSON {
+		t.Fatalf("%+v", r)
+	}
+}
+
+func TestHeaderDecodeError(t *testing.T) {
+	h := &Header{ServicePath: "Arith", ServiceMethod: "Mul", CallType: Request, SeqId: 9, Metadata: map[string]string{"k": "v"}, Serialize: JSON}
+	data := h.PackData()
+	for cut := 0; cut < len(data); cut++ {
+		pk := codec.NewPackData()
+		pk.ResetBytes(append([]byte(nil), data[:cut]...))
+		err := (&Header{}).UnpackData(pk)
+		var de *codec.DecodeError
+		if !errors.As(err, &de) || !errors.Is(err, codec.ErrTruncated) {
+			t.Fatalf("cut %d: %v", cut, err)
+		}
+	}
+	pk := codec.NewPackData()
+	pk.ResetBytes(data)
+	if err := (&Header{}).UnpackData(pk); err != nil {
+		t.Fatal(err)
+	}
+}
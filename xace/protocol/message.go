@@ -16,13 +16,21 @@ var bufferPool = util.NewLimitedPool(512, 4096)
 
 // Compressors are compressors supported by rpcx. You can add customized compressor in Compressors.
 var Compressors = map[CompressType]Compressor{
-	None: &RawDataCompressor{},
-	Gzip: &GzipCompressor{},
+	None:   &RawDataCompressor{},
+	Gzip:   &GzipCompressor{},
+	Snappy: &SnappyCompressor{},
+	Zstd:   &ZstdCompressor{},
+	Lz4:    &Lz4Compressor{},
 }
 
+// DefaultCompressThreshold is the min payload size the clients and servers compress
+// unless their options say otherwise.
+const DefaultCompressThreshold = 1024
+
 // MaxMessageLength is the max length of a message.
 // Default is 0 that means does not limit length of messages.
-// It is used to validate when read messages from io.Reader.
+// It is used to validate when read messages from io.Reader,
+// and limits the unzipped payload too, DefaultMaxBodyLen if it is 0.
 var MaxMessageLength = 0
 
 const (
@@ -47,6 +55,24 @@ const (
 	ServiceError = "__rpcx_error__"
 )
 
+// GOAWAY is the Notify message a server sends on its connections when it shuts down.
+// The client sends no new requests on the connection, the requests in flight still
+// get their responses.
+const (
+    GoAwayServicePath   = "AaceCheck"
+    GoAwayServiceMethod = "goaway"
+    // GoAwayRestartKey in the metadata of GOAWAY means a new process of the server
+    // takes over the listener, the client can connect to the same address again.
+    GoAwayRestartKey = "restart"
+
+    // CancelableKey in the metadata of a Request lets the client cancel it by Cancel.
+    CancelableKey = "__cancelable"
+
+    // ShedKey in the metadata of an Error response means the server rejected the
+    // request for its load, before handling it.
+    ShedKey = "__shed"
+)
+
 // MessageType is message type of requests and responses.
 type MessageType uint8
 
@@ -57,6 +83,17 @@ const (
 	Response
 
     Notify
+
+	// StreamData carries one message of a stream opened by the Request with the same seq.
+	StreamData
+	// StreamEnd means the sender won't send more data of the stream,
+	// with Error status it cancels the stream.
+	StreamEnd
+	// StreamWindow grants the peer credits to send more StreamData, see StreamCredit.
+	StreamWindow
+	// Cancel abandons the Request with the same seq if it carries CancelableKey,
+	// the server cancels its context and sends no response.
+	Cancel
 )
 
 // MessageStatusType is status of messages.
@@ -77,6 +114,12 @@ const (
 	None CompressType = iota
 	// Gzip uses gzip compression.
 	Gzip
+	// Snappy uses snappy compression.
+	Snappy
+	// Zstd uses zstd compression.
+	Zstd
+	// Lz4 uses lz4 compression.
+	Lz4
 )
 
 // SerializeType defines serialization type of payload.
@@ -127,9 +170,16 @@ func (m *Message) Reset() {
     m.Retcode = 0
 }
 
-// Header is the first part of Message and has fixed size.
-// Format:
+// Header is the first part of Message.
+// Format (AcePack fields, trailing default fields may be omitted):
+//
+//	fieldnum | ServicePath | ServiceMethod | CallType | SeqId | Metadata | ext
 //
+// ext is only written when Compress, Serialize or Status differ from the
+// defaults, see headExtVersion. Peers reading only five fields drop the ext
+// with its flags, so the responses to a request without ext have none either.
+// A server upgrades first: a client setting CompressType or a SerializeType
+// other than AcePack needs a server that reads the ext.
 type Header struct {
     ServicePath     string
 	ServiceMethod   string
@@ -139,6 +189,8 @@ type Header struct {
     Status          MessageStatusType //byte
     SeqId           uint64
     Metadata        map[string]string
+
+    noExt           bool // read without ext, Clone passes it on to the response
 }
 
 func (h *Header) SetData(inter,method string, callType MessageType, seq uint64, params map[string]string) {
@@ -192,6 +244,12 @@ func (h Header) IsHeartbeat() bool {
     return h.ServicePath == ""
 }
 
+// IsGoAway returns whether the message is the GOAWAY a server sends on its connections
+// when it shuts down.
+func (h Header) IsGoAway() bool {
+    return h.CallType == Notify && h.ServicePath == GoAwayServicePath && h.ServiceMethod == GoAwayServiceMethod
+}
+
 // IsOneway returns whether the message is one-way message.
 // If true, server won't send responses.
 func (h Header) IsOneway() bool {
@@ -255,26 +313,47 @@ func (m Message) Clone() *Message {
 }
 
 // Encode encodes messages.
-func (m Message) Encode() []byte {
-	data := m.EncodeSlicePointer()
-	return *data
+func (m Message) Encode() ([]byte, error) {
+	data, err := m.EncodeSlicePointer()
+	if err != nil {
+		return nil, err
+	}
+	return *data, nil
 }
 
 // EncodeSlicePointer encodes messages as a byte slice pointer we can use pool to improve.
-func (m Message) EncodeSlicePointer() *[]byte {
+// The payload is zipped by the compressor of the header, ErrUnsupportedCompressor is
+// returned if there is none.
+func (m Message) EncodeSlicePointer() (*[]byte, error) {
+    payload := m.Payload
+    if m.Header.Compress != None {
+        var err error
+        if payload, err = m.compressPayload(); err != nil {
+            return nil, err
+        }
+    }
 
     headbuf := m.Header.PackData()
     lh := len(headbuf)
-    ld := len(m.Payload)
+    ld := len(payload)
     lbuf := codec.PackLen(lh+ld)
     lf := len(lbuf)
 
 	data := bufferPool.Get(lf+lh+ld)
     copy(*data, lbuf)
 	copy((*data)[lf:lf+lh], headbuf)
-    copy((*data)[lf+lh:], m.Payload)
+    copy((*data)[lf+lh:], payload)
+
+	return data, nil
+}
 
-	return data
+// compressPayload zips the payload with the compressor of the header.
+func (m Message) compressPayload() ([]byte, error) {
+    compressor, ok := Compressors[m.Header.Compress]
+    if !ok {
+        return nil, ErrUnsupportedCompressor
+    }
+    return compressor.Zip(m.Payload)
 }
 
 // PutData puts the byte slice into pool.
@@ -284,7 +363,10 @@ func PutData(data *[]byte) {
 
 // WriteTo writes message to writers.
 func (m Message) WriteTo(w io.Writer) (int64, error) {
-    data := m.EncodeSlicePointer()
+    data, err := m.EncodeSlicePointer()
+    if err != nil {
+        return 0, err
+    }
     defer PutData(data)
     nn, err := w.Write(*data)
 	n := int64(nn)
@@ -306,11 +388,6 @@ func Read(r io.Reader) (*Message, error) {
 
 // Decode decodes a message from reader.
 func (m *Message) Decode(r io.Reader) (err error) {
-    defer func() {
-        if errt := recover(); errt != nil {
-            err = fmt.Errorf("decode unpack err. %v", errt)
-        }
-    }()
     tmpbuf := make([]byte, 8)
 
 	// parse len
@@ -322,6 +399,9 @@ func (m *Message) Decode(r io.Reader) (err error) {
     if alen <= 0 {
 		return fmt.Errorf("decode len error. %d", alen)
     }
+    if MaxMessageLength > 0 && alen > MaxMessageLength {
+        return ErrMessageTooLong
+    }
 
     if cap(m.data) >= alen {
         m.data = m.data[0:alen]
@@ -344,21 +424,43 @@ func (m *Message) Decode(r io.Reader) (err error) {
 
     packer := codec.NewPackData()
     packer.ResetBytes(m.data)
-    m.Header.UnpackData(packer)
+    if err = m.Header.UnpackData(packer); err != nil {
+        return err
+    }
     //log.Debugf("unpack head %s:%s %d %d", m.Header.ServicePath, m.Header.ServiceMethod, m.Header.SeqId, m.Header.CallType)
     m.Payload = packer.SurData()
+    if m.Compress != None {
+        compressor, ok := Compressors[m.Compress]
+        if !ok {
+            return ErrUnsupportedCompressor
+        }
+        m.Payload, err = compressor.Unzip(m.Payload)
+        if err != nil {
+            return err
+        }
+    }
 	return err
 }
 
+// UnpackRetCode takes the retcode off the front of the payload, -90006 if there is none.
+// The retcode is a varint, 0 takes a single byte: a retcode 0 with an empty reply is
+// one or two bytes long, so only an empty payload lacks the retcode.
 func (m *Message) UnpackRetCode() {
-    if len(m.Payload) < 4 {
-        log.Warn("unpack retcode error.")
+    if len(m.Payload) == 0 {
+        if m.Status != Error {
+            log.Warn("unpack retcode error.")
+        }
         m.Retcode = -90006
         return
     }
     packer := codec.NewPackData()
     packer.ResetBytes(m.Payload)
     m.Retcode = packer.UnpackInt32()
+    if err := packer.Err(); err != nil {
+        log.Warnf("unpack retcode error: %v", err)
+        m.Retcode = -90006
+        return
+    }
     m.Payload = packer.SurData()
 }
 
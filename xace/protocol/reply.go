@@ -9,6 +9,11 @@ type AceReply struct {
     Args        []any
 }
 
+// RetcodeReply is implemented by typed replies that keep the retcode of the response,
+// the client sets it before decoding the payload into the reply.
+type RetcodeReply interface {
+    SetRetcode(retcode int32)
+}
 
 func NewAceReply() *AceReply {
     return &AceReply{
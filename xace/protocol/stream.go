@@ -0,0 +1,222 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"xace/codec"
+)
+
+// StreamWindowKey is the metadata key of a Request that opens a stream,
+// the value is the window of the client, see StreamCredit.
+const StreamWindowKey = "__stream_window"
+
+// DefaultStreamWindow is how many StreamData messages one side can send
+// before the other side grants more credits.
+var DefaultStreamWindow = 64
+
+// ErrStreamClosed is returned when sending on a closed stream.
+var ErrStreamClosed = errors.New("stream is closed")
+
+// IsStreamFrame returns true for messages of an opened stream.
+func (h Header) IsStreamFrame() bool {
+	return h.CallType == StreamData || h.CallType == StreamEnd || h.CallType == StreamWindow
+}
+
+// StreamWindowOf returns the window in metadata of a Request, 0 means it doesn't open a stream.
+func StreamWindowOf(meta map[string]string) int {
+	v := meta[StreamWindowKey]
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return DefaultStreamWindow
+	}
+	return n
+}
+
+// PackWindow encodes credits as the payload of StreamWindow.
+func PackWindow(n int) []byte {
+	packer := codec.NewPackData()
+	packer.PackUint32(uint32(n))
+	return packer.Data()
+}
+
+// UnpackWindow decodes credits from the payload of StreamWindow.
+func UnpackWindow(data []byte) (int, error) {
+	packer := codec.NewPackData()
+	packer.ResetBytes(data)
+	n := packer.UnpackUint32()
+	if err := packer.Err(); err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// StreamCredit is the send side of the flow control of a stream.
+// Each StreamData takes one credit and the receiver grants credits back
+// by StreamWindow after its application has read the messages.
+type StreamCredit struct {
+	mu     sync.Mutex
+	credit int
+	closed bool
+	notify chan struct{}
+}
+
+// NewStreamCredit creates StreamCredit with n credits.
+func NewStreamCredit(n int) *StreamCredit {
+	return &StreamCredit{credit: n, notify: make(chan struct{}, 1)}
+}
+
+// Acquire takes one credit, it blocks until there is one.
+func (c *StreamCredit) Acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			c.wakeup() // pass it on to other waiters
+			return ErrStreamClosed
+		}
+		if c.credit > 0 {
+			c.credit--
+			left := c.credit
+			c.mu.Unlock()
+			if left > 0 {
+				c.wakeup()
+			}
+			return nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.notify:
+		}
+	}
+}
+
+// Grant adds n credits.
+func (c *StreamCredit) Grant(n int) {
+	c.mu.Lock()
+	c.credit += n
+	c.mu.Unlock()
+	c.wakeup()
+}
+
+// Close makes the blocked and later Acquire fail.
+func (c *StreamCredit) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.wakeup()
+}
+
+func (c *StreamCredit) wakeup() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// StreamReceiver is the receive side of a stream. It buffers at most window
+// messages which is what the sender can send without new credits.
+type StreamReceiver struct {
+	window int
+	ch     chan []byte
+
+	mu       sync.Mutex
+	consumed int
+	err      error
+	done     chan struct{}
+}
+
+// NewStreamReceiver creates StreamReceiver with window.
+func NewStreamReceiver(window int) *StreamReceiver {
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	return &StreamReceiver{window: window, ch: make(chan []byte, window), done: make(chan struct{})}
+}
+
+// Window returns the window of the receiver.
+func (r *StreamReceiver) Window() int {
+	return r.window
+}
+
+// Push adds a message, data is copied. It returns false if the sender
+// doesn't follow the flow control or the stream is ended.
+func (r *StreamReceiver) Push(data []byte) bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+	}
+
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	select {
+	case r.ch <- buf:
+		return true
+	default:
+		return false
+	}
+}
+
+// End means no more messages, Recv returns err after the buffered messages.
+// A nil err is reported as io.EOF.
+func (r *StreamReceiver) End(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	close(r.done)
+}
+
+// Recv returns the next message. grant is the credits should be sent back
+// to the sender, it is 0 if it is not worth a StreamWindow yet.
+func (r *StreamReceiver) Recv(ctx context.Context) (data []byte, grant int, err error) {
+	select {
+	case data = <-r.ch:
+		return data, r.consume(), nil
+	default:
+	}
+
+	select {
+	case data = <-r.ch:
+		return data, r.consume(), nil
+	case <-r.done:
+		select {
+		case data = <-r.ch:
+			return data, r.consume(), nil
+		default:
+		}
+		r.mu.Lock()
+		err = r.err
+		r.mu.Unlock()
+		return nil, 0, err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+func (r *StreamReceiver) consume() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.consumed++
+	if r.consumed*2 < r.window {
+		return 0
+	}
+	n := r.consumed
+	r.consumed = 0
+	return n
+}
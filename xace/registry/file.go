@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"xace/log"
+)
+
+// FileStore keeps the instances of services in a file, which is YAML if its
+// extension is .yaml or .yml and JSON otherwise:
+//
+//	{
+//	  "Arith": [
+//	    {"address": "tcp@127.0.0.1:8972", "group": "a", "weight": 10}
+//	  ]
+//	}
+//
+// The file can be written by a RegistryPlugin or by hand, it is polled for
+// changes while the store is watched. A missing file has no services.
+type FileStore struct {
+	path     string
+	interval time.Duration
+
+	mu   sync.Mutex // serializes the writes of this process
+	wmu  sync.Mutex
+	last map[string][]*Instance // read by the last poll
+	mod  time.Time
+	size int64
+
+	watchers watchers
+	polling  bool
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// NewFileStore returns the store kept in the file at path, polled every interval while watched.
+func NewFileStore(path string, interval time.Duration) *FileStore {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &FileStore{path: path, interval: interval, stopCh: make(chan struct{})}
+}
+
+func (s *FileStore) isYAML() bool {
+	ext := strings.ToLower(filepath.Ext(s.path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// read returns all services in the file, the instances are sorted by address.
+func (s *FileStore) read() (map[string][]*Instance, error) {
+	services, err := s.readFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, insts := range services {
+		sortInstances(insts)
+	}
+	return services, nil
+}
+
+func (s *FileStore) readFile() (map[string][]*Instance, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return map[string][]*Instance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.isYAML() {
+		return unmarshalYAML(data)
+	}
+	services := make(map[string][]*Instance)
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return services, nil
+	}
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (s *FileStore) write(services map[string][]*Instance) error {
+	var data []byte
+	if s.isYAML() {
+		data = marshalYAML(services)
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(services, "", "  "); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// update changes the instances of servicePath in the file.
+func (s *FileStore) update(servicePath string, fn func([]*Instance) []*Instance) error {
+	s.mu.Lock()
+	services, err := s.read()
+	if err == nil {
+		insts := fn(services[servicePath])
+		if len(insts) == 0 {
+			delete(services, servicePath)
+		} else {
+			sortInstances(insts)
+			services[servicePath] = insts
+		}
+		err = s.write(services)
+	}
+	s.mu.Unlock()
+	if err == nil {
+		s.poll()
+	}
+	return err
+}
+
+// Put implements Store.
+func (s *FileStore) Put(servicePath string, inst *Instance) error {
+	if inst.Address == "" {
+		return ErrNoAddress
+	}
+	return s.update(servicePath, func(insts []*Instance) []*Instance {
+		for i, in := range insts {
+			if in.Address == inst.Address {
+				insts[i] = inst
+				return insts
+			}
+		}
+		return append(insts, inst)
+	})
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(servicePath, address string) error {
+	return s.update(servicePath, func(insts []*Instance) []*Instance {
+		for i, in := range insts {
+			if in.Address == address {
+				return append(insts[:i], insts[i+1:]...)
+			}
+		}
+		return insts
+	})
+}
+
+// Instances implements Store.
+func (s *FileStore) Instances(servicePath string) ([]*Instance, error) {
+	services, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return services[servicePath], nil
+}
+
+// Watch implements Store, the first watcher starts polling the file.
+func (s *FileStore) Watch(servicePath string) (<-chan struct{}, func()) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	ch := s.watchers.add(servicePath)
+	if !s.polling {
+		s.polling = true
+		s.last, _ = s.read()
+		if fi, err := os.Stat(s.path); err == nil {
+			s.mod, s.size = fi.ModTime(), fi.Size()
+		}
+		go s.watch()
+	}
+	return ch, func() {
+		s.wmu.Lock()
+		s.watchers.remove(servicePath, ch)
+		s.wmu.Unlock()
+	}
+}
+
+func (s *FileStore) watch() {
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-tick.C:
+			s.poll()
+		}
+	}
+}
+
+// poll reads the file if it changed and notifies the watchers of the services that changed.
+func (s *FileStore) poll() {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if !s.polling {
+		return
+	}
+
+	var mod time.Time
+	var size int64
+	if fi, err := os.Stat(s.path); err == nil {
+		mod, size = fi.ModTime(), fi.Size()
+	}
+	if mod.Equal(s.mod) && size == s.size {
+		return
+	}
+	services, err := s.read()
+	if err != nil {
+		// a file being edited by hand, it is read again by the next change
+		log.Warnf("registry: failed to read %s: %v", s.path, err)
+		return
+	}
+	s.mod, s.size = mod, size
+
+	for path := range s.watchers.m {
+		if !reflect.DeepEqual(s.last[path], services[path]) {
+			s.watchers.notify(path)
+		}
+	}
+	s.last = services
+}
+
+// Close stops polling the file.
+func (s *FileStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	return nil
+}
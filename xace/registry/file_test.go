@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitChange waits for a notification of ch.
+func waitChange(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s: no notification", what)
+	}
+}
+
+// noChange checks that ch has no notification.
+func noChange(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+		t.Fatalf("%s: unexpected notification", what)
+	default:
+	}
+}
+
+func TestFileStoreWatch(t *testing.T) {
+	edits := map[string][2]string{
+		"reg.json": {`{"Arith": [`, `{"Arith": [{"address": "tcp@y:2", "weight": 3}]}`},
+		"reg.yaml": {"Arith:\n\t- address: tcp@y:2\n", "Arith:\n  - address: tcp@y:2\n    weight: 3\n"},
+	}
+	for name, edit := range edits {
+		path := filepath.Join(t.TempDir(), name)
+		s := NewFileStore(path, 10*time.Millisecond)
+		ch, stop := s.Watch("Arith")
+		other, stopOther := s.Watch("Other")
+
+		p := NewRegistryPlugin("tcp@x:1", s)
+		p.Group = "g"
+		if err := p.Register("Arith", nil, "weight=7&region=eu"); err != nil {
+			t.Fatal(err)
+		}
+		waitChange(t, ch, name+" register")
+		insts, err := s.Instances("Arith")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(insts) != 1 || insts[0].Weight != 7 || insts[0].Group != "g" || insts[0].Metadata["region"] != "eu" {
+			t.Fatalf("%s: registered %+v", name, insts)
+		}
+
+		p.SetState(StateInactive)
+		waitChange(t, ch, name+" state")
+		if insts, _ = s.Instances("Arith"); insts[0].State != StateInactive {
+			t.Fatalf("%s: state is %q", name, insts[0].State)
+		}
+		// writing the same instances is no change
+		p.SetState(StateInactive)
+		noChange(t, ch, name+" same state")
+
+		p.Unregister("Arith")
+		waitChange(t, ch, name+" unregister")
+		if insts, _ = s.Instances("Arith"); len(insts) != 0 {
+			t.Fatalf("%s: unregistered %+v", name, insts)
+		}
+
+		// a file being edited by hand is read once it is valid
+		if err := writeFileAtomic(path, []byte(edit[0])); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		noChange(t, ch, name+" malformed edit")
+		if err := writeFileAtomic(path, []byte(edit[1])); err != nil {
+			t.Fatal(err)
+		}
+		waitChange(t, ch, name+" edit")
+		if insts, _ = s.Instances("Arith"); len(insts) != 1 || insts[0].Address != "tcp@y:2" || insts[0].Weight != 3 {
+			t.Fatalf("%s: edited %+v", name, insts)
+		}
+		noChange(t, other, name+" other service")
+
+		stop()
+		stopOther()
+		s.Close()
+	}
+}
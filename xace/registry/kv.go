@@ -0,0 +1,297 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrStoreClosed means the store is used after Close.
+var ErrStoreClosed = errors.New("registry: store is closed")
+
+// errReadOnly means a bucket of a View transaction is written.
+var errReadOnly = errors.New("registry: transaction is read-only")
+
+// KVStore is an embedded key-value store in the style of bbolt. Keys live in
+// named buckets and are read and written in transactions. The data is kept in
+// memory and, if the store has a path, written to the file after every update,
+// so it survives restarts of a local test setup.
+//
+// As a Store, a bucket is a service path, its keys are the addresses of the
+// instances and the values are their query form values.
+type KVStore struct {
+	path string
+
+	mu       sync.RWMutex // held by the transactions
+	buckets  map[string]map[string]string
+	closed   bool
+	wmu      sync.Mutex
+	watchers watchers
+}
+
+// OpenKVStore opens the store kept in the file at path, the file is created by the
+// first update. An empty path opens a store kept only in memory.
+func OpenKVStore(path string) (*KVStore, error) {
+	s := &KVStore{path: path, buckets: make(map[string]map[string]string)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.buckets); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Tx is a transaction of a KVStore.
+type Tx struct {
+	s        *KVStore
+	writable bool
+	changes  map[string]map[string]*string // nil values are deletes
+}
+
+// Bucket is a bucket read and written in a transaction.
+type Bucket struct {
+	tx   *Tx
+	name string
+}
+
+// Update runs fn in a read-write transaction. The changes are applied when fn
+// returns nil and discarded when it returns an error. Updates run one at a time.
+func (s *KVStore) Update(fn func(tx *Tx) error) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStoreClosed
+	}
+	tx := &Tx{s: s, writable: true, changes: make(map[string]map[string]*string)}
+	if err := fn(tx); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	changed, err := tx.commit()
+	s.mu.Unlock()
+
+	s.wmu.Lock()
+	for _, name := range changed {
+		s.watchers.notify(name)
+	}
+	s.wmu.Unlock()
+	return err
+}
+
+// View runs fn in a read-only transaction.
+func (s *KVStore) View(fn func(tx *Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	return fn(&Tx{s: s})
+}
+
+// Bucket returns the bucket of name, it is created by the first Put.
+func (tx *Tx) Bucket(name string) *Bucket {
+	return &Bucket{tx: tx, name: name}
+}
+
+// Buckets returns the names of the buckets that have keys.
+func (tx *Tx) Buckets() []string {
+	var names []string
+	for name := range tx.s.buckets {
+		if _, ok := tx.changes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range tx.changes {
+		if tx.Bucket(name).Len() > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commit applies the changes of tx and writes the store to its file.
+// It returns the buckets that changed.
+func (tx *Tx) commit() ([]string, error) {
+	s := tx.s
+	var changed []string
+	for name, keys := range tx.changes {
+		b := s.buckets[name]
+		modified := false
+		for k, v := range keys {
+			old, ok := b[k]
+			if v == nil {
+				if ok {
+					delete(b, k)
+					modified = true
+				}
+				continue
+			}
+			if ok && old == *v {
+				continue
+			}
+			if b == nil {
+				b = make(map[string]string)
+				s.buckets[name] = b
+			}
+			b[k] = *v
+			modified = true
+		}
+		if len(b) == 0 {
+			delete(s.buckets, name)
+		}
+		if modified {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 || s.path == "" {
+		return changed, nil
+	}
+	data, err := json.MarshalIndent(s.buckets, "", "  ")
+	if err != nil {
+		return changed, err
+	}
+	return changed, writeFileAtomic(s.path, data)
+}
+
+// Get returns the value of key and whether it exists.
+func (b *Bucket) Get(key string) (string, bool) {
+	if v, ok := b.tx.changes[b.name][key]; ok {
+		if v == nil {
+			return "", false
+		}
+		return *v, true
+	}
+	v, ok := b.tx.s.buckets[b.name][key]
+	return v, ok
+}
+
+// Put sets the value of key.
+func (b *Bucket) Put(key, value string) error {
+	return b.set(key, &value)
+}
+
+// Delete removes key, it is not an error if the key doesn't exist.
+func (b *Bucket) Delete(key string) error {
+	return b.set(key, nil)
+}
+
+func (b *Bucket) set(key string, value *string) error {
+	if !b.tx.writable {
+		return errReadOnly
+	}
+	keys := b.tx.changes[b.name]
+	if keys == nil {
+		keys = make(map[string]*string)
+		b.tx.changes[b.name] = keys
+	}
+	keys[key] = value
+	return nil
+}
+
+// ForEach calls fn for the keys of the bucket in order, it stops at the first error.
+func (b *Bucket) ForEach(fn func(key, value string) error) error {
+	for _, k := range b.keys() {
+		v, _ := b.Get(k)
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Len returns the number of keys in the bucket.
+func (b *Bucket) Len() int {
+	return len(b.keys())
+}
+
+func (b *Bucket) keys() []string {
+	var keys []string
+	for k := range b.tx.s.buckets[b.name] {
+		if _, ok := b.tx.changes[b.name][k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range b.tx.changes[b.name] {
+		if v != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Put implements Store.
+func (s *KVStore) Put(servicePath string, inst *Instance) error {
+	if inst.Address == "" {
+		return ErrNoAddress
+	}
+	return s.Update(func(tx *Tx) error {
+		return tx.Bucket(servicePath).Put(inst.Address, inst.Value())
+	})
+}
+
+// Delete implements Store.
+func (s *KVStore) Delete(servicePath, address string) error {
+	return s.Update(func(tx *Tx) error {
+		return tx.Bucket(servicePath).Delete(address)
+	})
+}
+
+// Instances implements Store.
+func (s *KVStore) Instances(servicePath string) ([]*Instance, error) {
+	var insts []*Instance
+	err := s.View(func(tx *Tx) error {
+		return tx.Bucket(servicePath).ForEach(func(key, value string) error {
+			in, err := ParseInstance(key, value)
+			if err != nil {
+				return err
+			}
+			insts = append(insts, in)
+			return nil
+		})
+	})
+	return insts, err
+}
+
+// Watch implements Store.
+func (s *KVStore) Watch(servicePath string) (<-chan struct{}, func()) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	ch := s.watchers.add(servicePath)
+	return ch, func() {
+		s.wmu.Lock()
+		s.watchers.remove(servicePath, ch)
+		s.wmu.Unlock()
+	}
+}
+
+// Close closes the store, the transactions after it fail with ErrStoreClosed.
+func (s *KVStore) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path,
+// so readers never see a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
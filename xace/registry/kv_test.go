@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVStoreWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.json")
+	s, err := OpenKVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, stop := s.Watch("Arith")
+	defer stop()
+	other, stopOther := s.Watch("Other")
+	defer stopOther()
+
+	if err := s.Put("Arith", &Instance{Address: "b", Weight: 2}); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, ch, "put")
+	if err := s.Put("Arith", &Instance{Address: "b", Weight: 2}); err != nil {
+		t.Fatal(err)
+	}
+	noChange(t, ch, "same put")
+	if err := s.Put("Arith", &Instance{Address: "a", Group: "g"}); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, ch, "second put")
+	if err := s.Delete("Arith", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	noChange(t, ch, "delete of a missing instance")
+	noChange(t, other, "other service")
+
+	// a failed transaction is rolled back
+	err = s.Update(func(tx *Tx) error {
+		b := tx.Bucket("Arith")
+		b.Delete("a")
+		if b.Len() != 1 {
+			t.Fatalf("the transaction sees %d instances", b.Len())
+		}
+		return errReadOnly
+	})
+	if err != errReadOnly {
+		t.Fatal(err)
+	}
+	noChange(t, ch, "rollback")
+
+	// the instances survive reopening
+	s2, err := OpenKVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insts, err := s2.Instances("Arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(insts) != 2 || insts[0].Address != "a" || insts[0].Group != "g" || insts[1].Weight != 2 {
+		t.Fatalf("reopened %+v", insts)
+	}
+
+	if err := s.Delete("Arith", "a"); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, ch, "delete")
+
+	err = s.View(func(tx *Tx) error { return tx.Bucket("Arith").Put("c", "") })
+	if !errors.Is(err, errReadOnly) {
+		t.Fatalf("a view writes: %v", err)
+	}
+	s.Close()
+	if err := s.Put("Arith", &Instance{Address: "c"}); err != ErrStoreClosed {
+		t.Fatalf("put after close: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package registry
+
+import "sync"
+
+// RegistryPlugin is a server plugin that publishes the services of the server to a
+// Store. Add it to the plugins of the server before registering the services:
+//
+//	p := registry.NewRegistryPlugin("tcp@10.0.0.1:8972", store)
+//	p.Group = "blue"
+//	s.Plugins.Add(p)
+//	s.RegisterName("Arith", new(Arith), "weight=10")
+//
+// The metadata of the service is parsed by ParseInstance, its group, weight, state and
+// keys override the ones of the plugin. Services are removed from the store
+// when they are unregistered, which the server does in Shutdown.
+type RegistryPlugin struct {
+	// ServiceAddress is the address clients connect to, such as tcp@10.0.0.1:8972.
+	ServiceAddress string
+	Store          Store
+	Group          string
+	Weight         int
+	State          string
+	Metadata       map[string]string
+
+	mu       sync.Mutex
+	services map[string]*Instance
+}
+
+// NewRegistryPlugin returns a plugin that publishes the services at address to store.
+func NewRegistryPlugin(address string, store Store) *RegistryPlugin {
+	return &RegistryPlugin{ServiceAddress: address, Store: store}
+}
+
+// instance returns the instance of a service registered with metadata.
+func (p *RegistryPlugin) instance(metadata string) (*Instance, error) {
+	in, err := ParseInstance(p.ServiceAddress, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if in.Group == "" {
+		in.Group = p.Group
+	}
+	if in.Weight == 0 {
+		in.Weight = p.Weight
+	}
+	if in.State == "" {
+		in.State = p.State
+	}
+	for k, v := range p.Metadata {
+		if _, ok := in.Metadata[k]; ok {
+			continue
+		}
+		if in.Metadata == nil {
+			in.Metadata = make(map[string]string)
+		}
+		in.Metadata[k] = v
+	}
+	return in, nil
+}
+
+// Register publishes the service name.
+func (p *RegistryPlugin) Register(name string, rcvr interface{}, metadata string) error {
+	in, err := p.instance(metadata)
+	if err != nil {
+		return err
+	}
+	if err := p.Store.Put(name, in); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.services == nil {
+		p.services = make(map[string]*Instance)
+	}
+	p.services[name] = in
+	p.mu.Unlock()
+	return nil
+}
+
+// RegisterFunction publishes the service of a function, the first one of a service does it.
+func (p *RegistryPlugin) RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error {
+	p.mu.Lock()
+	_, ok := p.services[serviceName]
+	p.mu.Unlock()
+	if ok {
+		return nil
+	}
+	return p.Register(serviceName, fn, metadata)
+}
+
+// Unregister removes the service name.
+func (p *RegistryPlugin) Unregister(name string) error {
+	p.mu.Lock()
+	_, ok := p.services[name]
+	delete(p.services, name)
+	p.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return p.Store.Delete(name, p.ServiceAddress)
+}
+
+// SetState publishes all services again with state, such as StateInactive to let
+// clients move away before the server stops.
+func (p *RegistryPlugin) SetState(state string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for name, in := range p.services {
+		in.State = state
+		if e := p.Store.Put(name, in); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
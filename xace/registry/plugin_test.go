@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPluginInstance(t *testing.T) {
+	p := NewRegistryPlugin("tcp@10.0.0.1:8972", nil)
+	p.Group, p.Weight, p.State = "blue", 5, StateActive
+	p.Metadata = map[string]string{"region": "eu", "zone": "a"}
+
+	tests := []struct {
+		metadata string
+		want     *Instance
+	}{
+		{"", &Instance{Address: p.ServiceAddress, Group: "blue", Weight: 5, State: StateActive,
+			Metadata: map[string]string{"region": "eu", "zone": "a"}}},
+		{"weight=7&group=green&state=inactive&zone=b&tier=1", &Instance{Address: p.ServiceAddress, Group: "green", Weight: 7, State: StateInactive,
+			Metadata: map[string]string{"region": "eu", "zone": "b", "tier": "1"}}},
+	}
+	for _, tt := range tests {
+		in, err := p.instance(tt.metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(in, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.metadata, in, tt.want)
+		}
+		// what the plugin publishes is what the stores read back
+		back, err := ParseInstance(in.Address, in.Value())
+		if err != nil || !reflect.DeepEqual(back, in) {
+			t.Errorf("%q: read back as %+v, %v", tt.metadata, back, err)
+		}
+	}
+	if p.Metadata["zone"] != "a" {
+		t.Fatal("the metadata of the plugin is changed")
+	}
+
+	if _, err := p.instance("weight=x"); err == nil {
+		t.Fatal("an invalid weight is accepted")
+	}
+}
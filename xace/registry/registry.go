@@ -0,0 +1,142 @@
+// Package registry publishes the services of servers and lets clients discover them.
+//
+// A server adds a RegistryPlugin to publish the address, group, weight, state and
+// metadata of its services to a Store. Clients read the same Store through the
+// discoveries of the client package, which push every change to their watchers.
+//
+// Two stores are provided: FileStore keeps the services in a JSON or YAML file and
+// KVStore is an embedded key-value store for local testing.
+package registry
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// The states of an instance, clients skip the inactive ones.
+const (
+	StateActive   = "active"
+	StateInactive = "inactive"
+)
+
+// The keys of the query form value that are fields of Instance.
+const (
+	keyGroup  = "group"
+	keyWeight = "weight"
+	keyState  = "state"
+)
+
+// ErrNoAddress means an instance is published without address.
+var ErrNoAddress = errors.New("registry: instance has no address")
+
+// Instance is a server that provides a service.
+type Instance struct {
+	// Address is the address clients connect to, such as tcp@127.0.0.1:8972.
+	Address  string            `json:"address"`
+	Group    string            `json:"group,omitempty"`
+	Weight   int               `json:"weight,omitempty"`
+	State    string            `json:"state,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Value returns the instance in the query form clients read,
+// such as "group=a&state=active&weight=10".
+func (in *Instance) Value() string {
+	v := make(url.Values, len(in.Metadata)+3)
+	for k, m := range in.Metadata {
+		v.Set(k, m)
+	}
+	if in.Group != "" {
+		v.Set(keyGroup, in.Group)
+	}
+	if in.Weight > 0 {
+		v.Set(keyWeight, strconv.Itoa(in.Weight))
+	}
+	if in.State != "" {
+		v.Set(keyState, in.State)
+	}
+	return v.Encode()
+}
+
+// ParseInstance returns the instance at address from its query form value.
+func ParseInstance(address, value string) (*Instance, error) {
+	v, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, err
+	}
+	in := &Instance{Address: address}
+	for k := range v {
+		switch k {
+		case keyGroup:
+			in.Group = v.Get(k)
+		case keyWeight:
+			if in.Weight, err = strconv.Atoi(v.Get(k)); err != nil {
+				return nil, errors.New("registry: invalid weight " + v.Get(k))
+			}
+		case keyState:
+			in.State = v.Get(k)
+		default:
+			if in.Metadata == nil {
+				in.Metadata = make(map[string]string)
+			}
+			in.Metadata[k] = v.Get(k)
+		}
+	}
+	return in, nil
+}
+
+// Store keeps the instances of services.
+type Store interface {
+	// Put adds or replaces the instance of servicePath at inst.Address.
+	Put(servicePath string, inst *Instance) error
+	// Delete removes the instance of servicePath at address.
+	Delete(servicePath, address string) error
+	// Instances returns the instances of servicePath sorted by address.
+	Instances(servicePath string) ([]*Instance, error)
+	// Watch returns a channel that receives after the instances of servicePath
+	// change. Changes that happen before the receiver is ready are merged into
+	// one. stop releases the channel.
+	Watch(servicePath string) (ch <-chan struct{}, stop func())
+}
+
+func sortInstances(insts []*Instance) {
+	sort.Slice(insts, func(i, j int) bool {
+		return insts[i].Address < insts[j].Address
+	})
+}
+
+// watchers notifies the watchers of service paths.
+type watchers struct {
+	m map[string]map[chan struct{}]struct{}
+}
+
+func (w *watchers) add(servicePath string) chan struct{} {
+	if w.m == nil {
+		w.m = make(map[string]map[chan struct{}]struct{})
+	}
+	if w.m[servicePath] == nil {
+		w.m[servicePath] = make(map[chan struct{}]struct{})
+	}
+	ch := make(chan struct{}, 1)
+	w.m[servicePath][ch] = struct{}{}
+	return ch
+}
+
+func (w *watchers) remove(servicePath string, ch chan struct{}) {
+	delete(w.m[servicePath], ch)
+	if len(w.m[servicePath]) == 0 {
+		delete(w.m, servicePath)
+	}
+}
+
+func (w *watchers) notify(servicePath string) {
+	for ch := range w.m[servicePath] {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// the receiver has a change to read already
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The file of a FileStore can be YAML. Only the subset needed for it is supported:
+// block mappings and sequences, plain and quoted scalars and comments.
+//
+//	Arith:
+//	  - address: tcp@127.0.0.1:8972
+//	    group: a
+//	    weight: 10
+//	    metadata:
+//	      region: eu
+
+// marshalYAML writes the services in the form unmarshalYAML reads.
+func marshalYAML(services map[string][]*Instance) []byte {
+	var buf bytes.Buffer
+	paths := make([]string, 0, len(services))
+	for path := range services {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&buf, "%s:\n", yamlScalar(path))
+		for _, in := range services[path] {
+			fmt.Fprintf(&buf, "  - address: %s\n", yamlScalar(in.Address))
+			if in.Group != "" {
+				fmt.Fprintf(&buf, "    group: %s\n", yamlScalar(in.Group))
+			}
+			if in.Weight != 0 {
+				fmt.Fprintf(&buf, "    weight: %d\n", in.Weight)
+			}
+			if in.State != "" {
+				fmt.Fprintf(&buf, "    state: %s\n", yamlScalar(in.State))
+			}
+			if len(in.Metadata) > 0 {
+				buf.WriteString("    metadata:\n")
+				keys := make([]string, 0, len(in.Metadata))
+				for k := range in.Metadata {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					fmt.Fprintf(&buf, "      %s: %s\n", yamlScalar(k), yamlScalar(in.Metadata[k]))
+				}
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// yamlScalar quotes s if it would not be read back as the same plain scalar.
+func yamlScalar(s string) string {
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, "#\"'\n\t") ||
+		strings.Contains(s, ": ") || strings.HasSuffix(s, ":") || strings.ContainsAny(s[:1], "-?[]{},&*!|>%@`") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// yamlLine is a line with content, without its indent and comment.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// unmarshalYAML reads the services from YAML.
+func unmarshalYAML(data []byte) (map[string][]*Instance, error) {
+	var lines []*yamlLine
+	for i, l := range strings.Split(string(data), "\n") {
+		l = strings.TrimRight(stripComment(l), " \t\r")
+		text := strings.TrimLeft(l, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("registry: yaml line %d: tabs can't indent", i+1)
+		}
+		lines = append(lines, &yamlLine{num: i + 1, indent: len(l) - len(text), text: text})
+	}
+	services := make(map[string][]*Instance)
+	if len(lines) == 0 {
+		return services, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	root, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, p.errorf(lines[p.pos], "unexpected indent")
+	}
+	m, ok := root.(map[string]any)
+	if !ok {
+		return nil, p.errorf(lines[0], "the services must be a mapping")
+	}
+	for path, v := range m {
+		list, ok := v.([]any)
+		if !ok && v != "" {
+			return nil, fmt.Errorf("registry: yaml: the instances of %s must be a sequence", path)
+		}
+		for _, item := range list {
+			in, err := yamlInstance(item)
+			if err != nil {
+				return nil, fmt.Errorf("registry: yaml: instance of %s: %v", path, err)
+			}
+			services[path] = append(services[path], in)
+		}
+	}
+	return services, nil
+}
+
+func yamlInstance(v any) (*Instance, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("must be a mapping")
+	}
+	in := &Instance{}
+	for k, v := range m {
+		if k == "metadata" {
+			md, ok := v.(map[string]any)
+			if !ok && v != "" {
+				return nil, fmt.Errorf("metadata must be a mapping")
+			}
+			for mk, mv := range md {
+				s, ok := mv.(string)
+				if !ok {
+					return nil, fmt.Errorf("metadata %s must be a scalar", mk)
+				}
+				if in.Metadata == nil {
+					in.Metadata = make(map[string]string)
+				}
+				in.Metadata[mk] = s
+			}
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a scalar", k)
+		}
+		switch k {
+		case "address":
+			in.Address = s
+		case "group":
+			in.Group = s
+		case "state":
+			in.State = s
+		case "weight":
+			w, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight %q", s)
+			}
+			in.Weight = w
+		default:
+			return nil, fmt.Errorf("unknown key %s", k)
+		}
+	}
+	return in, nil
+}
+
+// stripComment removes a comment that starts outside of quotes.
+func stripComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+type yamlParser struct {
+	lines []*yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(l *yamlLine, format string, args ...any) error {
+	return fmt.Errorf("registry: yaml line %d: %s", l.num, fmt.Sprintf(format, args...))
+}
+
+// block reads the mapping or the sequence whose lines are at indent.
+func (p *yamlParser) block(indent int) (any, error) {
+	l := p.lines[p.pos]
+	if l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) sequence(indent int) (any, error) {
+	var list []any
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !(l.text == "-" || strings.HasPrefix(l.text, "- ")) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			v, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		if _, _, ok := splitKey(rest); !ok {
+			p.pos++
+			s, err := unquote(rest)
+			if err != nil {
+				return nil, p.errorf(l, "%v", err)
+			}
+			list = append(list, s)
+			continue
+		}
+		// the item is a mapping that starts on the line of the dash
+		l.indent += len(l.text) - len(rest)
+		l.text = rest
+		v, err := p.mapping(l.indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || l.text == "-" || strings.HasPrefix(l.text, "- ") {
+			break
+		}
+		k, v, ok := splitKey(l.text)
+		if !ok {
+			return nil, p.errorf(l, "expected a key")
+		}
+		key, err := unquote(k)
+		if err != nil {
+			return nil, p.errorf(l, "%v", err)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf(l, "duplicate key %s", key)
+		}
+		p.pos++
+		if v != "" {
+			if m[key], err = unquote(v); err != nil {
+				return nil, p.errorf(l, "%v", err)
+			}
+			continue
+		}
+		if m[key], err = p.nested(indent); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// nested reads the value of a key or a dash without value on its line. A sequence
+// of a key can be at the indent of the key, other values must be indented more.
+// It is an empty scalar if there are none.
+func (p *yamlParser) nested(indent int) (any, error) {
+	if p.pos == len(p.lines) {
+		return "", nil
+	}
+	l := p.lines[p.pos]
+	isSeq := l.text == "-" || strings.HasPrefix(l.text, "- ")
+	if l.indent > indent || l.indent == indent && isSeq {
+		return p.block(l.indent)
+	}
+	return "", nil
+}
+
+// splitKey splits "key: value" and "key:".
+func splitKey(text string) (key, value string, ok bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 || end+1 >= len(text) || text[end+1] != ':' {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return text[:end+1], strings.TrimSpace(rest), true
+	}
+	if i := strings.Index(text, ": "); i >= 0 {
+		return text[:i], strings.TrimSpace(text[i+2:]), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return text[:len(text)-1], "", true
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote closing the one text starts with.
+func closingQuote(text string) int {
+	q := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && q == '"':
+			i++
+		case text[i] == q:
+			if q == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func unquote(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"':
+		if closingQuote(s) != len(s)-1 {
+			return "", fmt.Errorf("invalid quoted scalar %s", s)
+		}
+		return strconv.Unquote(s)
+	case '\'':
+		if closingQuote(s) != len(s)-1 {
+			return "", fmt.Errorf("invalid quoted scalar %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case '{', '[':
+		if s == "{}" || s == "[]" {
+			return "", nil
+		}
+		return "", fmt.Errorf("flow collections are not supported: %s", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	services := map[string][]*Instance{
+		"Arith": {
+			{Address: "tcp@127.0.0.1:8972", Group: "a", Weight: 10, State: StateActive,
+				Metadata: map[string]string{"region": "eu", "note": "a: b #c", "quote": `'"`}},
+			{Address: "tcp@127.0.0.1:8973"},
+		},
+		"Proxy.Inter": {{Address: "ace@1.2.3.4:5", Group: "#g", Metadata: map[string]string{"": " x"}}},
+		"- dash":      {{Address: "tcp@h:1", State: "-"}},
+	}
+	data := marshalYAML(services)
+	got, err := unmarshalYAML(data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, services) {
+		t.Fatalf("read back differently:\n%s", data)
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string][]*Instance
+	}{
+		{"empty", "# nothing\n---\n", map[string][]*Instance{}},
+		{
+			"comments and quotes",
+			"# services\nArith:   # comment\n- address: 'tcp@h:1'  # x\n  weight: 3\n  metadata:\n    k: \"v # not a comment\"\n    it's: 'it''s'\n",
+			map[string][]*Instance{"Arith": {{Address: "tcp@h:1", Weight: 3, Metadata: map[string]string{"k": "v # not a comment", "it's": "it's"}}}},
+		},
+		{
+			"dash on its own line",
+			"Arith:\n  -\n    address: tcp@h:1\n    group: g\n  - address: tcp@h:2\n",
+			map[string][]*Instance{"Arith": {{Address: "tcp@h:1", Group: "g"}, {Address: "tcp@h:2"}}},
+		},
+		{"no instances", "Arith: []\nEmpty:\n", map[string][]*Instance{}},
+		{
+			"crlf",
+			"Arith:\r\n  - address: tcp@h:1\r\n    state: inactive\r\n",
+			map[string][]*Instance{"Arith": {{Address: "tcp@h:1", State: StateInactive}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := unmarshalYAML([]byte(tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalYAMLMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"tab indent", "Arith:\n\t- address: a\n", "line 2: tabs can't indent"},
+		{"dedent", "Arith:\n  - address: a\n bad: x\n", "line 3: unexpected indent"},
+		{"sequence root", "- a\n- b\n", "the services must be a mapping"},
+		{"scalar instances", "Arith: x\n", "instances of Arith must be a sequence"},
+		{"scalar instance", "Arith:\n  - just\n", "instance of Arith: must be a mapping"},
+		{"no key", "Arith\n", "line 1: expected a key"},
+		{"duplicate key", "Arith: []\nArith: []\n", "line 2: duplicate key Arith"},
+		{"unknown key", "Arith:\n  - address: a\n    port: 1\n", "unknown key port"},
+		{"invalid weight", "Arith:\n  - address: a\n    weight: ten\n", `invalid weight "ten"`},
+		{"flow mapping", "Arith:\n  - address: a\n    metadata: {k: v}\n", "flow collections are not supported"},
+		{"open quote", "Arith:\n  - address: \"a\n", "invalid quoted scalar"},
+		{"nested metadata", "Arith:\n  - address: a\n    metadata:\n      k:\n        x: y\n", "metadata k must be a scalar"},
+		{"scalar metadata", "Arith:\n  - address: a\n    metadata: eu\n", "metadata must be a mapping"},
+		{"mapping address", "Arith:\n  - address:\n      host: h\n", "address must be a scalar"},
+	}
+	for _, tt := range tests {
+		_, err := unmarshalYAML([]byte(tt.src))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
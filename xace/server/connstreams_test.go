@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"xace/protocol"
+)
+
+// TestConnStreamsWrite checks that a write waiting for the writing goroutine of the
+// connection doesn't keep closeAll from ending the streams.
+func TestConnStreamsWrite(t *testing.T) {
+	s := NewServer()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	// nobody reads writeCh, as if the connection is stuck
+	cs := newConnStreams(s, c1, make(chan *[]byte))
+
+	errc := make(chan error, 1)
+	go func() {
+		data := []byte("frame")
+		errc <- cs.write(context.Background(), &data)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		cs.closeAll()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closeAll is blocked by the write")
+	}
+	if err := <-errc; !errors.Is(err, protocol.ErrStreamClosed) {
+		t.Fatal(err)
+	}
+	data := []byte("frame")
+	if err := cs.write(context.Background(), &data); !errors.Is(err, protocol.ErrStreamClosed) {
+		t.Fatal(err)
+	}
+
+	// the context of the stream ends the wait as well
+	cs = newConnStreams(s, c1, make(chan *[]byte))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cs.write(ctx, &data); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
@@ -15,11 +15,13 @@ type Context struct {
 	ctx  *share.Context
 
 	writeCh chan *[]byte
+
+	compressThreshold int
 }
 
 // NewContext creates a server.Context for Handler.
 func NewContext(ctx *share.Context, conn net.Conn, req *protocol.Message, writeCh chan *[]byte) *Context {
-	return &Context{conn: conn, req: req, ctx: ctx, writeCh: writeCh}
+	return &Context{conn: conn, req: req, ctx: ctx, writeCh: writeCh, compressThreshold: protocol.DefaultCompressThreshold}
 }
 
 // Get returns value for key.
@@ -119,14 +121,16 @@ func (ctx *Context) Write(v interface{}) error {
 		}
 	}
 
-	if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+	if len(res.Payload) >= ctx.compressThreshold && req.CompressType() != protocol.None {
 		res.SetCompressType(req.CompressType())
 	}
-	respData := res.EncodeSlicePointer()
+	respData, err := res.EncodeSlicePointer()
+	if err != nil {
+		return err
+	}
 
-	var err error
 	if ctx.writeCh != nil {
-		ctx.writeCh <- respData
+		err = writeAsync(ctx.ctx, ctx.writeCh, respData)
 	} else {
 		_, err = ctx.conn.Write(*respData)
 		protocol.PutData(respData)
@@ -164,11 +168,17 @@ func (ctx *Context) WriteError(err error) error {
 			}
 		}
 	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]string)
+	}
 
 	res.SetMessageStatusType(protocol.Error)
 	res.Metadata[protocol.ServiceError] = err.Error()
 
-	respData := res.EncodeSlicePointer()
+	respData, err := res.EncodeSlicePointer()
+	if err != nil {
+		return err
+	}
 	ctx.conn.Write(*respData)
 	protocol.PutData(respData)
 
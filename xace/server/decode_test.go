@@ -0,0 +1,38 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"xace/client"
+	"xace/codec"
+)
+
+type BadReply struct{ C map[string]int }
+
+func TestDecodeErrors(t *testing.T) {
+	s, addr := startServer(t)
+	defer s.Close()
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	xc := client.NewXClient("Arith", client.Failtry, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+
+	// the server reports args it can't decode
+	err := xc.Call(context.Background(), "Div", []any{"x", "y"}, []any{&Reply{}})
+	if err == nil || !strings.Contains(err.Error(), "decode Args.A") {
+		t.Fatalf("bad args: %v", err)
+	}
+	// the client reports a reply it can't decode
+	err = xc.Call(context.Background(), "Div", []any{6, 3}, []any{&BadReply{}})
+	var de *codec.DecodeError
+	if !errors.As(err, &de) {
+		t.Fatalf("bad reply: %T %v", err, err)
+	}
+	// and the connection is still good
+	reply := &Reply{}
+	if err := xc.Call(context.Background(), "Div", []any{12, 4}, []any{reply}); err != nil || reply.C != 3 {
+		t.Fatal(err, reply)
+	}
+}
@@ -0,0 +1,385 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"xace/log"
+	"xace/share"
+)
+
+// FileTransferHandler handles uploading file. Must close the connection after it finished.
+// Data read from conn starts at args.Offset of the file, and the handler should write
+// share.FileTransferOK or share.FileTransferFailed back before closing.
+type FileTransferHandler func(conn net.Conn, args *share.FileTransferArgs)
+
+// DownloadFileHandler handles downloading file. Must close the connection after it finished.
+// Data written to conn must start at args.Offset of the file.
+type DownloadFileHandler func(conn net.Conn, args *share.DownloadFileArgs)
+
+// FileTransfer support transfer files from clients.
+// It registers a service to hand out tokens and listens on Addr for the file data,
+// clients must write the token first on the new connection.
+type FileTransfer struct {
+	Addr string
+	// AdvertiseAddr is the address returned to clients, the listened address is used if it is empty.
+	AdvertiseAddr string
+	// HandshakeTimeout limits how long to wait for the token of a new connection.
+	HandshakeTimeout time.Duration
+
+	// UploadOffset returns how many bytes of the file are already stored so the client
+	// can resume from there. Nil means uploads always start from the beginning.
+	UploadOffset func(args *share.FileTransferArgs) (int64, error)
+	// DownloadStat returns size and checksum of the requested file, they are sent to
+	// clients to verify the download. Nil means the client can't verify it.
+	DownloadStat func(args *share.DownloadFileArgs) (size int64, checksum string, err error)
+
+	handler             FileTransferHandler
+	downloadFileHandler DownloadFileHandler
+	cachedTokens        *tokenCache
+	service             *FileTransferService
+
+	mu        sync.Mutex
+	ln        net.Listener
+	startOnce sync.Once
+	done      chan struct{}
+}
+
+// The retcodes of the methods of FileTransferService.
+const (
+	// RetFileNotSupported means the server doesn't handle uploads or downloads.
+	RetFileNotSupported int32 = -90201
+	// RetFileInvalidOffset means the offset of a download is out of the file.
+	RetFileInvalidOffset int32 = -90202
+	// RetFileUnavailable means UploadOffset or DownloadStat failed, such as for a
+	// missing file. The server logs why.
+	RetFileUnavailable int32 = -90203
+)
+
+// FileTransferService is the rpc service of FileTransfer.
+type FileTransferService struct {
+	FileTransfer *FileTransfer
+}
+
+// NewFileTransfer creates a FileTransfer with given parameters.
+// waitNum is how many tokens can wait for their connections at the same time.
+func NewFileTransfer(addr string, handler FileTransferHandler, downloadFileHandler DownloadFileHandler, waitNum int) *FileTransfer {
+	fi := &FileTransfer{
+		Addr:                addr,
+		HandshakeTimeout:    10 * time.Second,
+		handler:             handler,
+		downloadFileHandler: downloadFileHandler,
+		cachedTokens:        newTokenCache(waitNum, DefaultTokenTTL),
+		done:                make(chan struct{}),
+	}
+
+	fi.service = &FileTransferService{
+		FileTransfer: fi,
+	}
+
+	return fi
+}
+
+// NewDirFileTransfer creates a FileTransfer that stores uploaded files in dir and serves downloads from it.
+// Uploads are written to a ".part" file first so they can be resumed, and it is renamed after
+// the size and checksum match.
+func NewDirFileTransfer(addr string, dir string, waitNum int) *FileTransfer {
+	d := fileDir(dir)
+	fi := NewFileTransfer(addr, d.upload, d.download, waitNum)
+	fi.UploadOffset = d.uploadOffset
+	fi.DownloadStat = d.stat
+	return fi
+}
+
+// EnableFileTransfer supports filetransfer service in this server.
+func (s *Server) EnableFileTransfer(serviceName string, fileTransfer *FileTransfer) error {
+	if serviceName == "" {
+		serviceName = share.SendFileServiceName
+	}
+	if err := fileTransfer.Start(); err != nil {
+		return err
+	}
+	s.RegisterOnShutdown(func(s *Server) {
+		fileTransfer.Stop()
+	})
+	return s.RegisterName(serviceName, fileTransfer.service, "")
+}
+
+// TransferFile returns a token and the address to upload the file.
+func (s *FileTransferService) TransferFile(ctx context.Context, args *share.FileTransferArgs, reply *share.FileTransferReply) int32 {
+	ft := s.FileTransfer
+	if ft.handler == nil {
+		return RetFileNotSupported
+	}
+
+	// args may go back to the pool after this call
+	a := *args
+	a.Offset = 0
+	if ft.UploadOffset != nil {
+		offset, err := ft.UploadOffset(&a)
+		if err != nil {
+			log.Errorf("failed to upload %s: %v", a.FileName, err)
+			return RetFileUnavailable
+		}
+		a.Offset = offset
+	}
+
+	token := generateToken()
+	ft.cachedTokens.Add(token, &a)
+
+	reply.Token = token
+	reply.Addr = ft.advertiseAddr()
+	reply.Offset = a.Offset
+	reply.FileSize = a.FileSize
+	reply.Checksum = a.Checksum
+	return 0
+}
+
+// DownloadFile returns a token and the address to download the file.
+func (s *FileTransferService) DownloadFile(ctx context.Context, args *share.DownloadFileArgs, reply *share.FileTransferReply) int32 {
+	ft := s.FileTransfer
+	if ft.downloadFileHandler == nil {
+		return RetFileNotSupported
+	}
+
+	a := *args
+	if a.Offset < 0 {
+		return RetFileInvalidOffset
+	}
+	if ft.DownloadStat != nil {
+		size, checksum, err := ft.DownloadStat(&a)
+		if err != nil {
+			log.Errorf("failed to download %s: %v", a.FileName, err)
+			return RetFileUnavailable
+		}
+		if a.Offset > size {
+			return RetFileInvalidOffset
+		}
+		reply.FileSize = size
+		reply.Checksum = checksum
+	}
+
+	token := generateToken()
+	ft.cachedTokens.Add(token, &a)
+
+	reply.Token = token
+	reply.Addr = ft.advertiseAddr()
+	reply.Offset = a.Offset
+	return 0
+}
+
+func (s *FileTransfer) advertiseAddr() string {
+	if s.AdvertiseAddr != "" {
+		return s.AdvertiseAddr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+	return s.Addr
+}
+
+// Start starts listening on Addr.
+func (s *FileTransfer) Start() error {
+	var err error
+	s.startOnce.Do(func() {
+		var ln net.Listener
+		ln, err = net.Listen("tcp", s.Addr)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.ln = ln
+		s.mu.Unlock()
+		go serveSideListener("filetransfer", ln, s.done, s.handleConn)
+	})
+	return err
+}
+
+func (s *FileTransfer) handleConn(conn net.Conn) {
+	token, err := readToken(conn, s.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		log.Errorf("failed to read token from %s: %v", conn.RemoteAddr().String(), err)
+		return
+	}
+
+	info, ok := s.cachedTokens.Take(token)
+	if !ok {
+		conn.Close()
+		log.Errorf("unexpected or expired token from %s", conn.RemoteAddr().String())
+		return
+	}
+
+	switch args := info.(type) {
+	case *share.FileTransferArgs:
+		s.handler(conn, args)
+	case *share.DownloadFileArgs:
+		s.downloadFileHandler(conn, args)
+	default:
+		conn.Close()
+	}
+}
+
+// Stop stops listening.
+func (s *FileTransfer) Stop() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// fileDir implements the handlers of NewDirFileTransfer.
+type fileDir string
+
+func (d fileDir) path(name string) (string, error) {
+	name = filepath.Base(filepath.Clean("/" + name))
+	if name == "/" || name == "." {
+		return "", errors.New("invalid file name")
+	}
+	return filepath.Join(string(d), name), nil
+}
+
+func (d fileDir) uploadOffset(args *share.FileTransferArgs) (int64, error) {
+	p, err := d.path(args.FileName)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(p + ".part")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if fi.Size() > args.FileSize {
+		return 0, nil
+	}
+	return fi.Size(), nil
+}
+
+func (d fileDir) upload(conn net.Conn, args *share.FileTransferArgs) {
+	defer conn.Close()
+
+	err := d.receive(conn, args)
+	if err != nil {
+		log.Errorf("failed to receive file %s: %v", args.FileName, err)
+		conn.Write([]byte{share.FileTransferFailed})
+		return
+	}
+	conn.Write([]byte{share.FileTransferOK})
+}
+
+func (d fileDir) receive(conn net.Conn, args *share.FileTransferArgs) error {
+	p, err := d.path(args.FileName)
+	if err != nil {
+		return err
+	}
+	part := p + ".part"
+
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = f.Truncate(args.Offset); err != nil {
+		return err
+	}
+	if _, err = f.Seek(args.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(f, conn, args.FileSize-args.Offset); err != nil {
+		return err
+	}
+
+	if args.Checksum != "" {
+		sum, err := fileChecksum(f)
+		if err != nil {
+			return err
+		}
+		if sum != args.Checksum {
+			// the data is broken, start from the beginning next time
+			f.Truncate(0)
+			return fmt.Errorf("checksum mismatch, expect %s but got %s", args.Checksum, sum)
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(part, p)
+}
+
+func (d fileDir) stat(args *share.DownloadFileArgs) (int64, string, error) {
+	p, err := d.path(args.FileName)
+	if err != nil {
+		return 0, "", err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	sum, err := fileChecksum(f)
+	if err != nil {
+		return 0, "", err
+	}
+	return fi.Size(), sum, nil
+}
+
+func (d fileDir) download(conn net.Conn, args *share.DownloadFileArgs) {
+	defer conn.Close()
+
+	p, err := d.path(args.FileName)
+	if err != nil {
+		log.Errorf("failed to send file %s: %v", args.FileName, err)
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		log.Errorf("failed to send file %s: %v", args.FileName, err)
+		return
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(args.Offset, io.SeekStart); err != nil {
+		log.Errorf("failed to send file %s: %v", args.FileName, err)
+		return
+	}
+	if _, err = io.Copy(conn, f); err != nil {
+		log.Errorf("failed to send file %s: %v", args.FileName, err)
+	}
+}
+
+// fileChecksum returns the hex sha256 of the whole file.
+func fileChecksum(f *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, 1<<62)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
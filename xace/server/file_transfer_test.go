@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/codec"
+	"xace/protocol"
+	"xace/server"
+)
+
+type Arith struct{}
+
+type Args struct{ A, B int }
+
+type Reply struct{ C int }
+
+func (a *Arith) Mul(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A * args.B
+	return nil
+}
+
+func (a *Arith) Div(ctx context.Context, args *Args, reply *Reply) int32 {
+	if args.B == 0 {
+		return 7
+	}
+	reply.C = args.A / args.B
+	return 0
+}
+
+func startServer(t *testing.T) (*server.Server, string) {
+	s := server.NewServer()
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s, s.Address().String()
+}
+
+func TestFileTransfer(t *testing.T) {
+	s, addr := startServer(t)
+	defer s.Close()
+	dir := t.TempDir()
+	if err := s.EnableFileTransfer("", server.NewDirFileTransfer("127.0.0.1:0", dir, 10)); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	fc := client.NewXClient("_filetransfer", client.Failtry, client.RandomSelect, d, client.DefaultOption)
+	defer fc.Close()
+
+	src := filepath.Join(t.TempDir(), "a.bin")
+	data := bytes.Repeat([]byte("0123456789"), 50000)
+	os.WriteFile(src, data, 0644)
+	// an upload that broke off is resumed
+	os.WriteFile(filepath.Join(dir, "a.bin.part"), data[:12345], 0644)
+	if err := fc.SendFile(context.Background(), src, 2_000_000, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "a.bin"))
+	if !bytes.Equal(got, data) {
+		t.Fatalf("uploaded %d bytes", len(got))
+	}
+
+	// a download into a file resumes at its end
+	dst := filepath.Join(t.TempDir(), "b.bin")
+	os.WriteFile(dst, data[:777], 0644)
+	f, _ := os.OpenFile(dst, os.O_RDWR, 0644)
+	if err := fc.DownloadFile(context.Background(), "a.bin", f, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	got, _ = os.ReadFile(dst)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded %d bytes", len(got))
+	}
+
+	var buf bytes.Buffer
+	if err := fc.DownloadFile(context.Background(), "a.bin", &buf, nil); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal(err)
+	}
+	var re *client.RetcodeError
+	if err := fc.DownloadFile(context.Background(), "nope", &buf, nil); !errors.As(err, &re) || re.Retcode != server.RetFileUnavailable {
+		t.Fatalf("downloaded a missing file: %v", err)
+	}
+}
+
+// TestReplyRetcode checks the replies on the wire: only the methods returning a
+// retcode put it in front of the reply, the file transfer doesn't change that.
+func TestReplyRetcode(t *testing.T) {
+	s, addr := startServer(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	call := func(method string, args *Args) *protocol.Message {
+		req := protocol.NewMessage()
+		req.SetMessageType(protocol.Request)
+		req.SetSerializeType(protocol.AcePack)
+		req.SetSeq(1)
+		req.ServicePath = "Arith"
+		req.ServiceMethod = method
+		// the fields of a struct are the parameters
+		req.Payload, _ = codec.EncodeArgs([]any{args.A, args.B})
+		if _, err := req.WriteTo(conn); err != nil {
+			t.Fatal(err)
+		}
+		res, err := protocol.Read(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	reply := func(c int) []byte {
+		data, _ := codec.EncodeArgs(&Reply{c})
+		return data
+	}
+
+	res := call("Mul", &Args{3, 4})
+	if !bytes.Equal(res.Payload, reply(12)) {
+		t.Fatalf("Mul: %v %v", res.Metadata, res.Payload)
+	}
+	res = call("Div", &Args{12, 4})
+	if !bytes.Equal(res.Payload, codec.EncodeRetArgs(0, reply(3))) {
+		t.Fatalf("Div: %v %v", res.Metadata, res.Payload)
+	}
+	res = call("Div", &Args{12, 0})
+	if !bytes.Equal(res.Payload, codec.EncodeRetArgs(7, reply(0))) {
+		t.Fatalf("Div by 0: %v", res.Payload)
+	}
+}
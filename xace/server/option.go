@@ -40,6 +40,14 @@ func WithWriteTimeout(writeTimeout time.Duration) OptionFn {
 	}
 }
 
+// WithRestartTimeout sets how long Restart waits for the new process to serve,
+// DefaultRestartTimeout if not set.
+func WithRestartTimeout(timeout time.Duration) OptionFn {
+	return func(s *Server) {
+		s.restartTimeout = timeout
+	}
+}
+
 // WithPool sets goroutine pool.
 func WithPool(maxWorkers, maxCapacity int, options ...pond.Option) OptionFn {
 	return func(s *Server) {
@@ -53,3 +61,20 @@ func WithAsyncWrite() OptionFn {
 		s.AsyncWrite = true
 	}
 }
+
+// WithStreamWindow sets how many messages a client can send on a stream before they are read.
+func WithStreamWindow(window int) OptionFn {
+	return func(s *Server) {
+		if window > 0 {
+			s.streamWindow = window
+		}
+	}
+}
+
+// WithCompressThreshold sets the min size of the replies compressed as the requests ask,
+// protocol.DefaultCompressThreshold if not set.
+func WithCompressThreshold(threshold int) OptionFn {
+	return func(s *Server) {
+		s.compressThreshold = threshold
+	}
+}
@@ -20,13 +20,18 @@ type PluginContainer interface {
 	DoRegisterFunction(serviceName, fname string, fn interface{}, metadata string) error
 	DoUnregister(name string) error
 
+	DoPostListen(ln net.Listener) error
 	DoPostConnAccept(net.Conn) (net.Conn, bool)
 	DoPostConnClose(net.Conn) bool
 
+	DoPreShutdown(ctx context.Context) error
+	DoPostDrain(ctx context.Context) error
+
 	DoPreReadRequest(ctx context.Context) error
 	DoPostReadRequest(ctx context.Context, r *protocol.Message, e error) error
 
 	DoPreHandleRequest(ctx context.Context, req *protocol.Message) error
+	DoPostHandleRequest(ctx context.Context, req *protocol.Message, e error) error
 	DoPreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error)
 	DoPostCall(ctx context.Context, serviceName, methodName string, args, reply interface{}) (interface{}, error)
 
@@ -56,6 +61,24 @@ type (
 		RegisterFunction(serviceName, fname string, fn interface{}, metadata string) error
 	}
 
+	// PostListenPlugin is invoked after the server listens, before it accepts connections.
+	// The server stops serving if it returns an error.
+	PostListenPlugin interface {
+		HandleListen(ln net.Listener) error
+	}
+
+	// PreShutdownPlugin is invoked at the start of Shutdown, before the services are
+	// unregistered and the server stops reading requests.
+	PreShutdownPlugin interface {
+		PreShutdown(ctx context.Context) error
+	}
+
+	// PostDrainPlugin is invoked by Shutdown after the in-flight requests finished,
+	// or the context of Shutdown expired, before the connections are closed.
+	PostDrainPlugin interface {
+		PostDrain(ctx context.Context) error
+	}
+
 	// PostConnAcceptPlugin represents connection accept plugin.
 	// if returns false, it means subsequent IPostConnAcceptPlugins should not continue to handle this conn
 	// and this conn has been closed.
@@ -83,6 +106,12 @@ type (
 		PreHandleRequest(ctx context.Context, r *protocol.Message) error
 	}
 
+	// PostHandleRequestPlugin is invoked once a request read without error is done with,
+	// handled or rejected, before it is freed. e is why it failed.
+	PostHandleRequestPlugin interface {
+		PostHandleRequest(ctx context.Context, r *protocol.Message, e error) error
+	}
+
 	PreCallPlugin interface {
 		PreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error)
 	}
@@ -205,6 +234,52 @@ func (p *pluginContainer) DoUnregister(name string) error {
 	return nil
 }
 
+// DoPostListen invokes PostListenPlugin.
+func (p *pluginContainer) DoPostListen(ln net.Listener) error {
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PostListenPlugin); ok {
+			if err := plugin.HandleListen(ln); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DoPreShutdown invokes PreShutdownPlugin.
+func (p *pluginContainer) DoPreShutdown(ctx context.Context) error {
+	var es []error
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PreShutdownPlugin); ok {
+			if err := plugin.PreShutdown(ctx); err != nil {
+				es = append(es, err)
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
+// DoPostDrain invokes PostDrainPlugin.
+func (p *pluginContainer) DoPostDrain(ctx context.Context) error {
+	var es []error
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PostDrainPlugin); ok {
+			if err := plugin.PostDrain(ctx); err != nil {
+				es = append(es, err)
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
 // DoPostConnAccept handles accepted conn
 func (p *pluginContainer) DoPostConnAccept(conn net.Conn) (net.Conn, bool) {
 	var flag bool
@@ -276,6 +351,23 @@ func (p *pluginContainer) DoPreHandleRequest(ctx context.Context, r *protocol.Me
 	return nil
 }
 
+// DoPostHandleRequest invokes PostHandleRequestPlugin, all of them even if some fail.
+func (p *pluginContainer) DoPostHandleRequest(ctx context.Context, r *protocol.Message, e error) error {
+	var es []error
+	for i := range p.plugins {
+		if plugin, ok := p.plugins[i].(PostHandleRequestPlugin); ok {
+			if err := plugin.PostHandleRequest(ctx, r, e); err != nil {
+				es = append(es, err)
+			}
+		}
+	}
+
+	if len(es) > 0 {
+		return errors.NewMultiError(es)
+	}
+	return nil
+}
+
 // DoPreCall invokes PreCallPlugin plugin.
 func (p *pluginContainer) DoPreCall(ctx context.Context, serviceName, methodName string, args interface{}) (interface{}, error) {
 	var err error
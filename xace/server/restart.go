@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xace/log"
+)
+
+// The environment a restarted process finds its listener and the readiness pipe in.
+const (
+	// envListener is network@address of the listener at fd envListenerFD.
+	envListener   = "XACE_LISTENER"
+	envListenerFD = "XACE_LISTENER_FD"
+	// envReadyFD is the pipe the new process writes a byte to once it serves.
+	envReadyFD = "XACE_READY_FD"
+)
+
+// DefaultRestartTimeout is how long Restart waits for the new process to serve.
+var DefaultRestartTimeout = 30 * time.Second
+
+var (
+	inheritedMu   sync.Mutex
+	inheritedDone bool
+	inheritedLn   net.Listener
+	inheritedKey  string
+	adoptedLn     net.Listener
+
+	readyOnce sync.Once
+)
+
+// InheritedListener returns the listener handed over by the process that restarted
+// this one, if it served network at address. It returns nil if there isn't one,
+// and each listener only once:
+//
+//	ln, err := server.InheritedListener("tcp", ":8972")
+//	if ln == nil && err == nil {
+//		ln, err = net.Listen("tcp", ":8972")
+//	}
+//	s.ServeListener("tcp", ln)
+//
+// Serve adopts it by itself.
+func InheritedListener(network, address string) (net.Listener, error) {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	if err := loadInheritedLocked(); err != nil {
+		return nil, err
+	}
+	if inheritedLn == nil {
+		return nil, nil
+	}
+	// the address the old process was given, or the one it listened on
+	key := network + "@" + address
+	if key != inheritedKey && key != inheritedLn.Addr().Network()+"@"+inheritedLn.Addr().String() {
+		return nil, nil
+	}
+	ln := inheritedLn
+	inheritedLn, adoptedLn = nil, ln
+	log.Infof("adopted the inherited listener %s", inheritedKey)
+	return ln, nil
+}
+
+func loadInheritedLocked() error {
+	if inheritedDone {
+		return nil
+	}
+	inheritedDone = true
+	key, fd := os.Getenv(envListener), os.Getenv(envListenerFD)
+	os.Unsetenv(envListener)
+	os.Unsetenv(envListenerFD)
+	if key == "" || fd == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("invalid inherited listener fd %q: %w", fd, err)
+	}
+	f := os.NewFile(uintptr(n), key)
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to adopt the inherited listener %s: %w", key, err)
+	}
+	inheritedLn, inheritedKey = ln, key
+	return nil
+}
+
+// notifyReady is called when the server serves ln. The process that restarted this
+// one is told once the inherited listener is served, the other listeners a program
+// serves before it don't make the new process ready.
+func notifyReady(ln net.Listener) {
+	inheritedMu.Lock()
+	loadInheritedLocked()
+	ready := inheritedLn == nil && (adoptedLn == nil || adoptedLn == ln)
+	inheritedMu.Unlock()
+	if ready {
+		Ready()
+	}
+}
+
+// Ready tells the process that restarted this one that it serves, Restart of that
+// process waits for it. Serve and ServeListener call it once they serve the inherited
+// listener. A program that serves the inherited listener in other ways, or doesn't
+// serve it at all, calls Ready itself. An inherited listener nothing adopted is then
+// closed, it would take connections nobody accepts.
+func Ready() {
+	readyOnce.Do(func() {
+		inheritedMu.Lock()
+		loadInheritedLocked()
+		if inheritedLn != nil {
+			log.Warnf("closed the inherited listener %s nothing served", inheritedKey)
+			inheritedLn.Close()
+			inheritedLn = nil
+		}
+		inheritedMu.Unlock()
+
+		fd := os.Getenv(envReadyFD)
+		os.Unsetenv(envReadyFD)
+		if fd == "" {
+			return
+		}
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			log.Warnf("invalid ready fd %q: %v", fd, err)
+			return
+		}
+		f := os.NewFile(uintptr(n), "ready")
+		if _, err := f.Write([]byte{1}); err != nil {
+			log.Warnf("failed to notify the restarting process: %v", err)
+		}
+		f.Close()
+	})
+}
+
+func (s *Server) setListenAddress(network, address string) {
+	s.mu.Lock()
+	s.lnNetwork, s.lnAddress = network, address
+	s.mu.Unlock()
+}
+
+// Restart restarts this server gracefully.
+// It starts the program again with the same arguments and hands the listener over,
+// the new process adopts it when it serves the same network and address. This
+// server shuts down gracefully once the new process serves it, see Ready, without
+// deregistering its services. If the new process fails to serve in time it is killed
+// and this server goes on serving.
+func (s *Server) Restart(ctx context.Context) error {
+	s.mu.RLock()
+	ln, network, address := s.ln, s.lnNetwork, s.lnAddress
+	s.mu.RUnlock()
+	if ln == nil {
+		return errors.New("restart: the server isn't listening")
+	}
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("restart: can't hand over a listener of %T", ln)
+	}
+	lnFile, err := filer.File()
+	if err != nil {
+		return fmt.Errorf("restart: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("restart: %w", err)
+	}
+	defer readyR.Close()
+
+	process, err := s.startProcess(network+"@"+address, lnFile, readyW)
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("restart: %w", err)
+	}
+	log.Infof("restart a new rpcx server: %d", process.Pid)
+
+	if err := s.waitReady(ctx, readyR); err != nil {
+		process.Kill()
+		process.Wait()
+		return fmt.Errorf("restart: the new process %d failed to serve: %w", process.Pid, err)
+	}
+	log.Infof("the new rpcx server %d serves", process.Pid)
+
+	s.mu.RLock()
+	onRestart := s.onRestart
+	s.mu.RUnlock()
+	for _, f := range onRestart {
+		f(s)
+	}
+
+	atomic.StoreInt32(&s.restarting, 1)
+	return s.Shutdown(ctx)
+}
+
+// waitReady waits for the byte of notifyReady. The new process exiting closes
+// the pipe without it.
+func (s *Server) waitReady(ctx context.Context, r *os.File) error {
+	timeout := s.restartTimeout
+	if timeout == 0 {
+		timeout = DefaultRestartTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	done := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := r.Read(b[:])
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-t.C:
+		return fmt.Errorf("not ready in %v", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) startProcess(listener string, lnFile, readyW *os.File) (*os.Process, error) {
+	argv0, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Pass on the environment and replace the markers of an earlier restart.
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envListener+"=") || strings.HasPrefix(kv, envListenerFD+"=") || strings.HasPrefix(kv, envReadyFD+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	// the files after stdin, stdout and stderr are fd 3 and 4
+	env = append(env, envListener+"="+listener, envListenerFD+"=3", envReadyFD+"=4")
+
+	originalWD, _ := os.Getwd()
+	allFiles := []*os.File{os.Stdin, os.Stdout, os.Stderr, lnFile, readyW}
+	return os.StartProcess(argv0, os.Args, &os.ProcAttr{
+		Dir:   originalWD,
+		Env:   env,
+		Files: allFiles,
+	})
+}
@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+)
+
+// TestMain runs the process Restart starts as the new server instead of the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("XACE_LISTENER") != "" {
+		restartedMain()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// restartedMain serves another listener before the inherited one, only the
+// inherited one makes it ready.
+func restartedMain() {
+	if os.Getenv("TEST_CHILD_FAIL") != "" {
+		os.Exit(1)
+	}
+	other := server.NewServer()
+	go other.Serve("tcp", "127.0.0.1:0")
+	time.Sleep(300 * time.Millisecond)
+
+	s := server.NewServer()
+	s.RegisterName("Pid", &Pid{}, "")
+	go s.Serve("tcp", os.Getenv("TEST_RESTART_ADDR"))
+	time.Sleep(4 * time.Second)
+}
+
+type Pid struct{}
+
+type PidArgs struct{ D int }
+
+type PidReply struct{ Pid int }
+
+func (p *Pid) Get(ctx context.Context, a *PidArgs, r *PidReply) int32 {
+	time.Sleep(time.Duration(a.D) * time.Millisecond)
+	r.Pid = os.Getpid()
+	return 0
+}
+
+func TestRestart(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	os.Setenv("TEST_RESTART_ADDR", addr)
+	defer os.Unsetenv("TEST_RESTART_ADDR")
+
+	s := server.NewServer(server.WithRestartTimeout(5 * time.Second))
+	s.RegisterName("Pid", &Pid{}, "")
+	go s.Serve("tcp", addr)
+	for s.Address() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	defer s.Close()
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	xc := client.NewXClient("Pid", client.Failfast, client.RandomSelect, d, client.AceOption)
+	defer xc.Close()
+	r := &PidReply{}
+	if err := xc.Call(context.Background(), "get", []any{0}, []any{r}); err != nil || r.Pid != os.Getpid() {
+		t.Fatal(err, r)
+	}
+
+	// a new process that fails is rolled back
+	os.Setenv("TEST_CHILD_FAIL", "1")
+	err = s.Restart(context.Background())
+	os.Unsetenv("TEST_CHILD_FAIL")
+	if err == nil {
+		t.Fatal("restarted with a failing process")
+	}
+	if err := xc.Call(context.Background(), "get", []any{0}, []any{r}); err != nil || r.Pid != os.Getpid() {
+		t.Fatal("after the rollback:", err, r)
+	}
+
+	// a call in flight is answered by this process, the calls after the restart by the new one
+	inflight := make(chan error, 1)
+	go func() {
+		r := &PidReply{}
+		err := xc.Call(context.Background(), "get", []any{1500}, []any{r})
+		if err == nil && r.Pid != os.Getpid() {
+			t.Error("the call in flight is answered by", r.Pid)
+		}
+		inflight <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	restarted := make(chan error, 1)
+	go func() { restarted <- s.Restart(context.Background()) }()
+
+	var failed, child int32
+	deadline := time.Now().Add(2500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		r := &PidReply{}
+		if err := xc.Call(context.Background(), "get", []any{0}, []any{r}); err != nil {
+			atomic.AddInt32(&failed, 1)
+			t.Log(err)
+		} else if r.Pid != os.Getpid() {
+			child++
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-inflight; err != nil {
+		t.Fatal("the call in flight:", err)
+	}
+	if err := <-restarted; err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 || child == 0 {
+		t.Fatalf("%d calls failed, %d answered by the new process", failed, child)
+	}
+}
@@ -9,8 +9,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"os"
-	"os/exec"
 	"reflect"
 	"regexp"
 	"runtime"
@@ -96,6 +94,14 @@ type Server struct {
 	onShutdown []func(s *Server)
 	onRestart  []func(s *Server)
 
+	// lnNetwork and lnAddress are what the listener was made for, Restart
+	// hands it to the new process under them.
+	lnNetwork  string
+	lnAddress  string
+	restarting int32
+	// restartTimeout is how long Restart waits for the new process to serve.
+	restartTimeout time.Duration
+
 	// TLSConfig for creating tls tcp connection.
 	tlsConfig *tls.Config
 	// BlockCrypt for kcp.BlockCrypt
@@ -111,6 +117,11 @@ type Server struct {
 
 	handlerMsgNum int32
 
+	// streamWindow is how many messages a client can send on a stream before it is read.
+	streamWindow int
+	// compressThreshold is the min size of the payloads compressed as the requests ask.
+	compressThreshold int
+
 	// HandleServiceError is used to get all service errors. You can use it write logs or others.
 	HandleServiceError func(error)
 
@@ -129,6 +140,8 @@ func NewServer(options ...OptionFn) *Server {
 		serviceMap: make(map[string]*service),
 		router:     make(map[string]Handler),
 		AsyncWrite: false, // 除非你想做进一步的优化测试，否则建议你设置为false
+		streamWindow: protocol.DefaultStreamWindow,
+		compressThreshold: protocol.DefaultCompressThreshold,
 	}
 
 	for _, op := range options {
@@ -189,15 +202,39 @@ func (s *Server) SendMessage(conn net.Conn, servicePath, serviceMethod string, m
 	req.Metadata = metadata
 	req.Payload = data
 
-	b := req.EncodeSlicePointer()
-	_, err := conn.Write(*b)
-	protocol.PutData(b)
+	b, err := req.EncodeSlicePointer()
+	if err == nil {
+		_, err = conn.Write(*b)
+		protocol.PutData(b)
+	}
 
 	s.Plugins.DoPostWriteRequest(ctx, req, err)
 	protocol.FreeMsg(req)
 	return err
 }
 
+// sendGoAway tells the client of conn to send its new requests to other servers,
+// or to a new connection if the server restarts.
+// A client that doesn't read doesn't hold up the shutdown longer than the write timeout,
+// or a second without one.
+func (s *Server) sendGoAway(conn net.Conn, restart bool) {
+	var md map[string]string
+	if restart {
+		md = map[string]string{protocol.GoAwayRestartKey: "1"}
+	}
+	d := s.writeTimeout
+	if d == 0 {
+		d = time.Second
+	}
+	conn.SetWriteDeadline(time.Now().Add(d))
+	if err := s.SendMessage(conn, protocol.GoAwayServicePath, protocol.GoAwayServiceMethod, md, nil); err != nil {
+		log.Warnf("failed to send goaway to %s: %v", conn.RemoteAddr(), err)
+	}
+	if s.writeTimeout == 0 {
+		conn.SetWriteDeadline(time.Time{})
+	}
+}
+
 func (s *Server) getDoneChan() <-chan struct{} {
 	return s.doneChan
 }
@@ -206,10 +243,17 @@ func (s *Server) getDoneChan() <-chan struct{} {
 // It is blocked until receiving connections from clients.
 func (s *Server) Serve(network, address string) (err error) {
 	var ln net.Listener
-	ln, err = s.makeListener(network, address)
+	ln, err = InheritedListener(network, address)
 	if err != nil {
 		return err
 	}
+	if ln == nil {
+		ln, err = s.makeListener(network, address)
+		if err != nil {
+			return err
+		}
+	}
+	s.setListenAddress(network, address)
 
 	if network == "http" {
 		s.serveByHTTP(ln, "")
@@ -229,6 +273,7 @@ func (s *Server) Serve(network, address string) (err error) {
 // ServeListener listens RPC requests.
 // It is blocked until receiving connections from clients.
 func (s *Server) ServeListener(network string, ln net.Listener) (err error) {
+	s.setListenAddress(network, ln.Addr().String())
 	if network == "http" {
 		s.serveByHTTP(ln, "")
 		return nil
@@ -250,6 +295,12 @@ func (s *Server) serveListener(ln net.Listener) error {
 	s.ln = ln
 	s.mu.Unlock()
 
+	if err := s.Plugins.DoPostListen(ln); err != nil {
+		ln.Close()
+		return err
+	}
+	notifyReady(ln)
+
 	for {
 		conn, e := ln.Accept()
 		if e != nil {
@@ -324,14 +375,21 @@ func (s *Server) serveByHTTP(ln net.Listener, rpcPath string) {
 }
 
 func (s *Server) sendResponse(ctx *share.Context, conn net.Conn, writeCh chan *[]byte, err error, req, res *protocol.Message) {
-	if len(res.Payload) > 1024 && req.CompressType() != protocol.None {
+	if len(res.Payload) >= s.compressThreshold && req.CompressType() != protocol.None {
 		res.SetCompressType(req.CompressType())
 	}
     res.SetMessageType(protocol.Response)
-	data := res.EncodeSlicePointer()
+	data, e := res.EncodeSlicePointer()
+	if e != nil {
+		// the reply can't be zipped, the client gets the error instead
+		res.SetCompressType(protocol.None)
+		res.Payload = nil
+		s.handleError(res, e)
+		data, _ = res.EncodeSlicePointer()
+	}
 	s.Plugins.DoPreWriteResponse(ctx, req, res, err)
 	if s.AsyncWrite {
-		writeCh <- data
+		writeAsync(ctx, writeCh, data)
 	} else {
 		if s.writeTimeout != 0 {
 			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
@@ -394,12 +452,12 @@ func (s *Server) serveConn(conn net.Conn) {
 		go s.serveAsyncWrite(conn, writeCh)
 	}
 
-	// read requests and handle it
-	for {
-		if s.isShutdown() {
-			return
-		}
+	streams := newConnStreams(s, conn, writeCh)
+	defer streams.closeAll()
 
+	// read requests and handle it. After GOAWAY the requests the client sent before it
+	// read it are still handled, Shutdown closes the connection once they are done.
+	for {
 		t0 := time.Now()
 		if s.readTimeout != 0 {
 			conn.SetReadDeadline(t0.Add(s.readTimeout))
@@ -407,6 +465,7 @@ func (s *Server) serveConn(conn net.Conn) {
 
 		// create a rpcx Context
 		ctx := share.WithValue(context.Background(), RemoteConnContextKey, conn)
+		ctx = share.WithLocalValue(ctx, streamsContextKey, streams)
 
 		// read a request from the underlying connection
 		req, err := s.readRequest(ctx, r)
@@ -426,12 +485,16 @@ func (s *Server) serveConn(conn net.Conn) {
 				} else { // Oneway and only call the plugins
 					s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
 				}
+				s.Plugins.DoPostHandleRequest(ctx, req, err)
 				protocol.FreeMsg(req)
 				continue
 			} else { // wrong data
 				log.Warnf("rpcx: failed to read request: %v", err)
 			}
 
+			if req != nil {
+				s.Plugins.DoPostHandleRequest(ctx, req, err)
+			}
 			protocol.FreeMsg(req)
 
 			if s.HandleServiceError != nil {
@@ -445,6 +508,19 @@ func (s *Server) serveConn(conn net.Conn) {
 			log.Debugf("server received an request %+v from conn: %v", req, conn.RemoteAddr().String())
 		}
 
+		// frames of open streams are handled in order here
+		if req.IsStreamFrame() {
+			streams.dispatch(req)
+			protocol.FreeMsg(req)
+			continue
+		}
+
+		if req.MessageType() == protocol.Cancel {
+			streams.cancel(req.Seq())
+			protocol.FreeMsg(req)
+			continue
+		}
+
 
 		ctx = share.WithLocalValue(ctx, StartRequestContextKey, time.Now().UnixNano())
 		closeConn := false
@@ -464,6 +540,7 @@ func (s *Server) serveConn(conn net.Conn) {
 			} else {
 				s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
 			}
+			s.Plugins.DoPostHandleRequest(ctx, req, err)
 			protocol.FreeMsg(req)
 
 			if s.HandleServiceError != nil {
@@ -478,6 +555,13 @@ func (s *Server) serveConn(conn net.Conn) {
 			continue
 		}
 
+		if window := protocol.StreamWindowOf(req.Metadata); window > 0 && req.MessageType() == protocol.Request {
+			streams.open(req, window)
+		}
+		if req.Metadata[protocol.CancelableKey] != "" && !req.IsOneway() {
+			streams.track(ctx, req)
+		}
+
 		if s.pool != nil {
 			s.pool.Submit(func() {
 				s.processOneRequest(ctx, req, conn, writeCh)
@@ -495,6 +579,7 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 			buf = buf[:runtime.Stack(buf, true)]
 
 			log.Errorf("failed to handle the request: %v， stacks: %s", r, buf)
+			s.Plugins.DoPostHandleRequest(ctx, req, fmt.Errorf("panic: %v", r))
 		}
 	}()
 
@@ -505,7 +590,12 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	if req.IsHeartbeat() {
 		s.Plugins.DoHeartbeatRequest(ctx, req)
 		req.SetMessageType(protocol.Response)
-		data := req.EncodeSlicePointer()
+		data, err := req.EncodeSlicePointer()
+		if err != nil {
+			log.Errorf("rpcx: failed to encode heartbeat: %v", err)
+			protocol.FreeMsg(req)
+			return
+		}
 
 		if s.writeTimeout != 0 {
 			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
@@ -513,11 +603,24 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 		conn.Write(*data)
 
 		protocol.PutData(data)
+		s.Plugins.DoPostHandleRequest(ctx, req, nil)
 		protocol.FreeMsg(req)
 
 		return
 	}
 
+	if req.Metadata[protocol.CancelableKey] != "" && !req.IsOneway() {
+		if cs, ok := ctx.Value(streamsContextKey).(*connStreams); ok {
+			defer cs.untrack(req.Seq())
+		}
+		// the client canceled it while it waited for a worker
+		if ctx.Err() != nil {
+			s.Plugins.DoPostHandleRequest(ctx, req, ctx.Err())
+			protocol.FreeMsg(req)
+			return
+		}
+	}
+
 	cancelFunc := parseServerTimeout(ctx, req)
 	if cancelFunc != nil {
 		defer cancelFunc()
@@ -530,7 +633,24 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	ctx = share.WithLocalValue(share.WithLocalValue(ctx, share.ReqMetaDataKey, req.Metadata),
 		share.ResMetaDataKey, resMetadata)
 
-	s.Plugins.DoPreHandleRequest(ctx, req)
+	// a plugin may shed the request, such as a limiter
+	if err := s.Plugins.DoPreHandleRequest(ctx, req); err != nil {
+		if !req.IsOneway() {
+			res := req.Clone()
+			res.SetMessageType(protocol.Response)
+			s.handleError(res, err)
+			s.sendResponse(ctx, conn, writeCh, err, req, res)
+			protocol.FreeMsg(res)
+		} else {
+			s.Plugins.DoPreWriteResponse(ctx, req, nil, err)
+		}
+		if st := streamOf(ctx, req); st != nil {
+			st.conn.remove(st)
+		}
+		s.Plugins.DoPostHandleRequest(ctx, req, err)
+		protocol.FreeMsg(req)
+		return
+	}
 
 	if share.Trace {
 		log.Debugf("server handle request %+v from conn: %v", req, conn.RemoteAddr().String())
@@ -539,11 +659,13 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	// use handlers first
 	if handler, ok := s.router[req.ServicePath+"."+req.ServiceMethod]; ok {
 		sctx := NewContext(ctx, conn, req, writeCh)
+		sctx.compressThreshold = s.compressThreshold
 		err := handler(sctx)
 		if err != nil {
 			log.Errorf("[handler internal error]: servicepath: %s, servicemethod, err: %v", req.ServicePath, req.ServiceMethod, err)
 		}
 
+		s.Plugins.DoPostHandleRequest(ctx, req, err)
 		protocol.FreeMsg(req)
 		return
 	}
@@ -552,13 +674,14 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 	if err != nil {
 		if s.HandleServiceError != nil {
 			s.HandleServiceError(err)
-		} else {
+		} else if ctx.Err() != context.Canceled {
 			log.Warnf("rpcx: failed to handle request: %v", err)
 		}
 	}
     DTest()
 
-	if !req.IsOneway() {
+	// nobody waits for the response of a canceled request
+	if !req.IsOneway() && ctx.Err() != context.Canceled {
 		if len(resMetadata) > 0 { // copy meta in context to responses
 			meta := res.Metadata
 			if meta == nil {
@@ -579,6 +702,7 @@ func (s *Server) processOneRequest(ctx *share.Context, req *protocol.Message, co
 		log.Debugf("server write response %+v for an request %+v from conn: %v", res, req, conn.RemoteAddr().String())
 	}
 
+	s.Plugins.DoPostHandleRequest(ctx, req, err)
 	protocol.FreeMsg(req)
 	protocol.FreeMsg(res)
 }
@@ -672,6 +796,11 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	res = req.Clone()
 
 	res.SetMessageType(protocol.Response)
+	st := streamOf(ctx, req)
+	if st != nil {
+		defer st.conn.remove(st)
+	}
+
 	s.serviceMapMu.RLock()
 	service := s.serviceMap[serviceName]
 
@@ -686,12 +815,16 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 	}
 	mtype := service.method[methodName]
 	if mtype == nil {
-		if service.function[methodName] != nil { // check raw functions
+		if service.function[methodName] != nil && st == nil { // check raw functions
 			return s.handleRequestForFunction(ctx, req)
 		}
 		err = errors.New("rpcx: can't find method " + methodName)
 		return s.handleError(res, err)
 	}
+	if st != nil && !mtype.IsStream {
+		err = errors.New("rpcx: " + methodName + " is not a stream method")
+		return s.handleError(res, err)
+	}
 
 	// get a argv object from object pool
 	argv := reflectTypePools.Get(mtype.ArgType)
@@ -704,7 +837,12 @@ func (s *Server) handleRequest(ctx context.Context, req *protocol.Message) (res
 
 	err = codec.Decode(req.Payload, argv)
 	if err != nil {
-		return s.handleError(res, err)
+		reflectTypePools.Put(mtype.ArgType, argv)
+		return s.handleError(res, fmt.Errorf("rpcx: failed to decode args of %s.%s: %w", serviceName, methodName, err))
+	}
+
+	if mtype.IsStream {
+		return s.handleStreamRequest(ctx, req, res, service, mtype, argv)
 	}
 
 	// and get a reply object from object pool
@@ -812,7 +950,8 @@ func (s *Server) handleRequestForFunction(ctx context.Context, req *protocol.Mes
 
 	err = codec.Decode(req.Payload, argv)
 	if err != nil {
-		return s.handleError(res, err)
+		reflectTypePools.Put(mtype.ArgType, argv)
+		return s.handleError(res, fmt.Errorf("rpcx: failed to decode args of %s.%s: %w", serviceName, methodName, err))
 	}
 
 	replyv := reflectTypePools.Get(mtype.ReplyType)
@@ -855,6 +994,9 @@ func (s *Server) handleError(res *protocol.Message, err error) (*protocol.Messag
 	} else {
 		res.Metadata[protocol.ServiceError] = err.Error()
 	}
+	if errors.Is(err, ErrReqReachLimit) {
+		res.Metadata[protocol.ShedKey] = "1"
+	}
 
 	return res, err
 }
@@ -924,6 +1066,10 @@ func (s *Server) RegisterOnRestart(f func(s *Server)) {
 
 var shutdownPollInterval = 1000 * time.Millisecond
 
+// goAwayGrace is how long Shutdown waits for the requests the clients sent before they
+// read GOAWAY.
+var goAwayGrace = 100 * time.Millisecond
+
 // Shutdown gracefully shuts down the server without interrupting any
 // active connections. Shutdown works by first closing the
 // listener, then closing all idle connections, and then waiting
@@ -931,31 +1077,49 @@ var shutdownPollInterval = 1000 * time.Millisecond
 // If the provided context expires before the shutdown is complete,
 // Shutdown returns the context's error, otherwise it returns any
 // error returned from closing the Server's underlying Listener.
+// The PreShutdown plugins run first and the PostDrain plugins once
+// the in-processing requests finished.
 func (s *Server) Shutdown(ctx context.Context) error {
 	var err error
 	if atomic.CompareAndSwapInt32(&s.inShutdown, 0, 1) {
 		log.Info("shutdown begin")
 
+		// the new process of a restart serves the same address and keeps its registration
+		restarting := atomic.LoadInt32(&s.restarting) == 1
+
+		if !restarting {
+			if e := s.Plugins.DoPreShutdown(ctx); e != nil {
+				log.Warnf("rpcx: pre shutdown: %v", e)
+			}
+		}
+
 		s.mu.Lock()
 
 		// 主动注销注册的服务
-		if s.Plugins != nil {
+		if s.Plugins != nil && !restarting {
 			for name := range s.serviceMap {
 				s.Plugins.DoUnregister(name)
 			}
 		}
 
-		s.ln.Close()
+		if s.ln != nil {
+			s.ln.Close()
+		}
+		goingAway := len(s.activeConn) > 0
 		for conn := range s.activeConn {
-			if tcpConn, ok := conn.(*net.TCPConn); ok {
-				tcpConn.CloseRead()
-			}
+			s.sendGoAway(conn, restarting)
 		}
 		s.mu.Unlock()
 
-		// wait all in-processing requests finish.
+		// wait all in-processing requests finish, with the ones sent before GOAWAY was read.
 		ticker := time.NewTicker(shutdownPollInterval)
 		defer ticker.Stop()
+		if goingAway {
+			select {
+			case <-ctx.Done():
+			case <-time.After(goAwayGrace):
+			}
+		}
 	outer:
 		for {
 			if s.checkProcessMsg() {
@@ -969,6 +1133,12 @@ func (s *Server) Shutdown(ctx context.Context) error {
 			}
 		}
 
+		if !restarting {
+			if e := s.Plugins.DoPostDrain(ctx); e != nil {
+				log.Warnf("rpcx: post drain: %v", e)
+			}
+		}
+
         /*
 		if s.gatewayHTTPServer != nil {
 			if err := s.closeHTTP1APIGateway(ctx); err != nil {
@@ -1003,44 +1173,6 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return err
 }
 
-// Restart restarts this server gracefully.
-// It starts a new rpcx server with the same port with SO_REUSEPORT socket option,
-// and shutdown this rpcx server gracefully.
-func (s *Server) Restart(ctx context.Context) error {
-	pid, err := s.startProcess()
-	if err != nil {
-		return err
-	}
-	log.Infof("restart a new rpcx server: %d", pid)
-
-	// TODO: is it necessary?
-	time.Sleep(3 * time.Second)
-	return s.Shutdown(ctx)
-}
-
-func (s *Server) startProcess() (int, error) {
-	argv0, err := exec.LookPath(os.Args[0])
-	if err != nil {
-		return 0, err
-	}
-
-	// Pass on the environment and replace the old count key with the new one.
-	var env []string
-	env = append(env, os.Environ()...)
-
-	originalWD, _ := os.Getwd()
-	allFiles := []*os.File{os.Stdin, os.Stdout, os.Stderr}
-	process, err := os.StartProcess(argv0, os.Args, &os.ProcAttr{
-		Dir:   originalWD,
-		Env:   env,
-		Files: allFiles,
-	})
-	if err != nil {
-		return 0, err
-	}
-	return process.Pid, nil
-}
-
 func (s *Server) checkProcessMsg() bool {
 	size := atomic.LoadInt32(&s.handlerMsgNum)
 	log.Info("need handle in-processing msg size:", size)
@@ -31,6 +31,7 @@ type methodType struct {
 	ReplyType  reflect.Type
 	// numCalls   uint
     UseRetcode     bool
+    IsStream       bool // reply is *Stream
 }
 
 type functionType struct {
@@ -67,7 +68,7 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 // receiver value that satisfy the following conditions:
 //	- exported method of exported type
 //	- three arguments, the first is of context.Context, both of exported type for three arguments
-//	- the third argument is a pointer
+//	- the third argument is a pointer, or *Stream for streaming methods
 //	- one return value, of type error
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error.
@@ -314,11 +315,14 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 			continue
 		}
         mname = strings.ToLower(mname)
-        methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType, UseRetcode: useRetcode}
+        isStream := replyType == typeOfStream
+        methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType, UseRetcode: useRetcode, IsStream: isStream}
 
 		// init pool for reflect.Type of args and reply
 		reflectTypePools.Init(argType)
-		reflectTypePools.Init(replyType)
+		if !isStream {
+			reflectTypePools.Init(replyType)
+		}
 	}
 	return methods
 }
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"xace/log"
+	"xace/share"
+)
+
+// The retcodes of StreamService.Stream.
+const (
+	// RetStreamNotAccepted means the acceptor of the service rejected the stream.
+	RetStreamNotAccepted int32 = -90211
+	// RetTooManyStreams means MaxStreams streams are already open.
+	RetTooManyStreams int32 = -90212
+)
+
+// StreamHandler handles a stream connection. The connection is closed after it returns.
+type StreamHandler func(conn net.Conn, args *share.StreamServiceArgs)
+
+// StreamAcceptor decides whether to accept a stream request, nil accepts all of them.
+type StreamAcceptor func(ctx context.Context, args *share.StreamServiceArgs) bool
+
+// StreamService supports raw bidirectional connections for clients.
+// Clients get a token by the Stream method and then write it first on
+// a new connection to Addr, the connection is handed to the handler.
+type StreamService struct {
+	Addr string
+	// AdvertiseAddr is the address returned to clients, the listened address is used if it is empty.
+	AdvertiseAddr string
+	// HandshakeTimeout limits how long to wait for the token of a new connection.
+	HandshakeTimeout time.Duration
+	// IdleTimeout closes a stream that has no reads or writes for this long, 0 means no limit.
+	IdleTimeout time.Duration
+	// MaxStreams limits open streams, 0 means no limit.
+	MaxStreams int32
+
+	handler      StreamHandler
+	acceptor     StreamAcceptor
+	cachedTokens *tokenCache
+	streams      int32
+
+	mu        sync.Mutex
+	ln        net.Listener
+	startOnce sync.Once
+	done      chan struct{}
+}
+
+// NewStreamService creates a stream service.
+// waitNum is how many tokens can wait for their connections at the same time.
+func NewStreamService(addr string, streamHandler StreamHandler, acceptor StreamAcceptor, waitNum int) *StreamService {
+	return &StreamService{
+		Addr:             addr,
+		HandshakeTimeout: 10 * time.Second,
+		handler:          streamHandler,
+		acceptor:         acceptor,
+		cachedTokens:     newTokenCache(waitNum, DefaultTokenTTL),
+		done:             make(chan struct{}),
+	}
+}
+
+// EnableStreamService supports stream service in this server.
+func (s *Server) EnableStreamService(serviceName string, streamService *StreamService) error {
+	if serviceName == "" {
+		serviceName = share.StreamServiceName
+	}
+	if err := streamService.Start(); err != nil {
+		return err
+	}
+	s.RegisterOnShutdown(func(s *Server) {
+		streamService.Stop()
+	})
+	return s.RegisterName(serviceName, streamService, "")
+}
+
+// Stream returns a token and the address to open the stream.
+func (s *StreamService) Stream(ctx context.Context, args *share.StreamServiceArgs, reply *share.StreamServiceReply) int32 {
+	if s.acceptor != nil && !s.acceptor(ctx, args) {
+		return RetStreamNotAccepted
+	}
+
+	// checked again when the connection comes
+	if s.MaxStreams > 0 && atomic.LoadInt32(&s.streams) >= s.MaxStreams {
+		return RetTooManyStreams
+	}
+
+	// args may go back to the pool after this call
+	a := *args
+	token := generateToken()
+	s.cachedTokens.Add(token, &a)
+
+	reply.Token = token
+	reply.Addr = s.advertiseAddr()
+	return 0
+}
+
+// Streams returns how many streams are open.
+func (s *StreamService) Streams() int {
+	return int(atomic.LoadInt32(&s.streams))
+}
+
+func (s *StreamService) advertiseAddr() string {
+	if s.AdvertiseAddr != "" {
+		return s.AdvertiseAddr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Addr().String()
+	}
+	return s.Addr
+}
+
+// Start starts listening on Addr.
+func (s *StreamService) Start() error {
+	var err error
+	s.startOnce.Do(func() {
+		var ln net.Listener
+		ln, err = net.Listen("tcp", s.Addr)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.ln = ln
+		s.mu.Unlock()
+		go serveSideListener("stream", ln, s.done, s.handleConn)
+	})
+	return err
+}
+
+func (s *StreamService) handleConn(conn net.Conn) {
+	token, err := readToken(conn, s.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		log.Errorf("failed to read token from %s: %v", conn.RemoteAddr().String(), err)
+		return
+	}
+
+	info, ok := s.cachedTokens.Take(token)
+	if !ok {
+		conn.Close()
+		log.Errorf("unexpected or expired token from %s", conn.RemoteAddr().String())
+		return
+	}
+
+	n := atomic.AddInt32(&s.streams, 1)
+	defer atomic.AddInt32(&s.streams, -1)
+	if s.MaxStreams > 0 && n > s.MaxStreams {
+		conn.Close()
+		log.Errorf("stream from %s is rejected: %d streams are open", conn.RemoteAddr().String(), s.MaxStreams)
+		return
+	}
+
+	if s.IdleTimeout > 0 {
+		conn = &idleTimeoutConn{Conn: conn, timeout: s.IdleTimeout}
+	}
+	defer conn.Close()
+
+	s.handler(conn, info.(*share.StreamServiceArgs))
+}
+
+// Stop stops listening, the open streams are not closed.
+func (s *StreamService) Stop() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// idleTimeoutConn extends the deadline on every read and write,
+// so the connection fails only after it is idle for timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+	"xace/share"
+)
+
+func TestStreamService(t *testing.T) {
+	s, addr := startServer(t)
+	defer s.Close()
+	ss := server.NewStreamService("127.0.0.1:0", func(conn net.Conn, args *share.StreamServiceArgs) {
+		conn.Write([]byte(args.Meta["k"]))
+		io.Copy(conn, conn)
+	}, nil, 10)
+	ss.MaxStreams = 1
+	ss.IdleTimeout = 200 * time.Millisecond
+	if err := s.EnableStreamService("", ss); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := client.NewPeer2PeerDiscovery("tcp@"+addr, "")
+	xc := client.NewXClient(share.StreamServiceName, client.Failtry, client.RandomSelect, d, client.DefaultOption)
+	defer xc.Close()
+
+	// the handler gets the metadata and the raw connection
+	conn, err := xc.Stream(context.Background(), map[string]string{"k": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "hi" {
+		t.Fatal(string(b), err)
+	}
+	conn.Write([]byte("ab"))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ab" {
+		t.Fatal(string(b), err)
+	}
+	var re *client.RetcodeError
+	if _, err := xc.Stream(context.Background(), nil); !errors.As(err, &re) || re.Retcode != server.RetTooManyStreams {
+		t.Fatalf("a second stream: %v", err)
+	}
+
+	// an idle stream is closed, which frees its slot
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ne net.Error
+	if _, err := conn.Read(b); err == nil || errors.As(err, &ne) && ne.Timeout() {
+		t.Fatalf("the idle stream is open: %v", err)
+	}
+	for i := 0; ss.Streams() != 0; i++ {
+		if i == 200 {
+			t.Fatalf("%d streams", ss.Streams())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn2, err := xc.Stream(context.Background(), map[string]string{"k": "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	if _, err := io.ReadFull(conn2, b); err != nil || string(b) != "ok" {
+		t.Fatal(string(b), err)
+	}
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"xace/codec"
+	"xace/log"
+	"xace/protocol"
+	"xace/share"
+)
+
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+// streamsContextKey is the context key of streams of a connection.
+var streamsContextKey = &contextKey{"conn-streams"}
+
+// Stream is the server side of a streaming call. A service method takes it in place of reply:
+//
+//	func (t *T) Method(ctx context.Context, args *Args, stream *server.Stream) error
+//
+// The client can send messages until it calls CloseSend, and the server can send messages
+// until the method returns. The return value is sent to the client as the end of the stream.
+type Stream struct {
+	seq   uint64
+	req   protocol.Header
+	codec codec.Codec
+	conn  *connStreams
+
+	credit *protocol.StreamCredit
+	recv   *protocol.StreamReceiver
+
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	canceled bool
+}
+
+// Context returns the context of the stream, it is done when the client cancels the stream,
+// the connection is closed or the method returns.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// Metadata returns metadata of the request that opens the stream.
+func (st *Stream) Metadata() map[string]string {
+	return st.req.Metadata
+}
+
+// Send sends a message to the client. It blocks if the client doesn't read fast enough.
+func (st *Stream) Send(v interface{}) error {
+	if err := st.credit.Acquire(st.ctx); err != nil {
+		return err
+	}
+	data, err := st.codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return st.writeFrame(st.ctx, protocol.StreamData, protocol.Normal, data)
+}
+
+// Recv receives a message from the client into v.
+// It returns io.EOF after the client calls CloseSend.
+func (st *Stream) Recv(v interface{}) error {
+	data, grant, err := st.recv.Recv(st.ctx)
+	if err != nil {
+		return err
+	}
+	if grant > 0 {
+		if err = st.writeFrame(st.ctx, protocol.StreamWindow, protocol.Normal, protocol.PackWindow(grant)); err != nil {
+			return err
+		}
+	}
+	if _, ok := st.codec.(*codec.AceCodec); ok {
+		// AcePack sends a message as the only parameter of a list, like the args of a call
+		if _, ok := v.([]any); !ok {
+			v = []any{v}
+		}
+	}
+	return st.codec.Decode(data, v)
+}
+
+// writeFrame writes a frame of the stream, it gives up when ctx is done.
+func (st *Stream) writeFrame(ctx context.Context, t protocol.MessageType, status protocol.MessageStatusType, payload []byte) error {
+	msg := protocol.NewMessage()
+	msg.SetMessageType(t)
+	msg.SetSeq(st.seq)
+	msg.SetSerializeType(st.req.SerializeType())
+	if t == protocol.StreamData && len(payload) >= st.conn.s.compressThreshold {
+		msg.SetCompressType(st.req.CompressType())
+	}
+	msg.SetMessageStatusType(status)
+	msg.Payload = payload
+	data, err := msg.EncodeSlicePointer()
+	if err != nil {
+		return err
+	}
+	return st.conn.write(ctx, data)
+}
+
+// start binds the stream to the context of the method call.
+func (st *Stream) start(ctx context.Context) {
+	st.mu.Lock()
+	st.ctx, st.cancel = context.WithCancel(ctx)
+	if st.canceled {
+		st.cancel()
+	}
+	st.mu.Unlock()
+}
+
+// abort cancels the stream, err is returned by later Recv.
+func (st *Stream) abort(err error) {
+	st.recv.End(err)
+	st.credit.Close()
+
+	st.mu.Lock()
+	st.canceled = true
+	if st.cancel != nil {
+		st.cancel()
+	}
+	st.mu.Unlock()
+}
+
+// connStreams are the open streams of one connection, and its requests the client
+// may cancel.
+type connStreams struct {
+	s       *Server
+	conn    net.Conn
+	writeCh chan *[]byte
+	done    chan struct{}  // closed by closeAll
+	writers sync.WaitGroup // the writes sending on writeCh
+
+	mu      sync.RWMutex
+	closed  bool
+	streams map[uint64]*Stream
+	calls   map[uint64]context.CancelFunc
+}
+
+func newConnStreams(s *Server, conn net.Conn, writeCh chan *[]byte) *connStreams {
+	return &connStreams{
+		s:       s,
+		conn:    conn,
+		writeCh: writeCh,
+		done:    make(chan struct{}),
+		streams: make(map[uint64]*Stream),
+		calls:   make(map[uint64]context.CancelFunc),
+	}
+}
+
+// open registers the stream of req before its frames come.
+// It is called in the reading loop so no frame can be missed.
+func (cs *connStreams) open(req *protocol.Message, clientWindow int) {
+	c := share.Codecs[req.SerializeType()]
+	if c == nil {
+		// handleRequest reports it
+		return
+	}
+	st := &Stream{
+		seq:    req.Seq(),
+		req:    *req.Header,
+		codec:  c,
+		conn:   cs,
+		credit: protocol.NewStreamCredit(clientWindow),
+		recv:   protocol.NewStreamReceiver(cs.s.streamWindow),
+	}
+
+	cs.mu.Lock()
+	if cs.closed {
+		cs.mu.Unlock()
+		return
+	}
+	cs.streams[st.seq] = st
+	cs.mu.Unlock()
+
+	// let the client send before the method starts
+	err := st.writeFrame(context.Background(), protocol.StreamWindow, protocol.Normal, protocol.PackWindow(st.recv.Window()))
+	if err != nil {
+		log.Warnf("failed to open stream %d: %v", st.seq, err)
+	}
+}
+
+func (cs *connStreams) get(seq uint64) *Stream {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return cs.streams[seq]
+}
+
+func (cs *connStreams) remove(st *Stream) {
+	cs.mu.Lock()
+	if cs.streams[st.seq] == st {
+		delete(cs.streams, st.seq)
+	}
+	cs.mu.Unlock()
+	st.abort(protocol.ErrStreamClosed)
+}
+
+// dispatch handles a frame of an open stream, frames of unknown streams are dropped.
+func (cs *connStreams) dispatch(msg *protocol.Message) {
+	st := cs.get(msg.Seq())
+	if st == nil {
+		return
+	}
+
+	switch msg.MessageType() {
+	case protocol.StreamData:
+		if !st.recv.Push(msg.Payload) {
+			log.Warnf("stream %d from %s exceeds its window", st.seq, cs.conn.RemoteAddr().String())
+			st.abort(errors.New("stream window exceeded"))
+		}
+	case protocol.StreamEnd:
+		if msg.MessageStatusType() == protocol.Error {
+			st.abort(context.Canceled)
+		} else {
+			st.recv.End(nil)
+		}
+	case protocol.StreamWindow:
+		n, err := protocol.UnpackWindow(msg.Payload)
+		if err == nil {
+			st.credit.Grant(n)
+		}
+	}
+}
+
+// track makes the context of a cancelable request cancelable. Like open it is called
+// in the reading loop, before a Cancel of the request can be read.
+func (cs *connStreams) track(ctx *share.Context, req *protocol.Message) {
+	newCtx, cancel := context.WithCancel(ctx.Context)
+	ctx.Context = newCtx
+
+	cs.mu.Lock()
+	cs.calls[req.Seq()] = cancel
+	cs.mu.Unlock()
+}
+
+// untrack forgets the request of seq once it is handled.
+func (cs *connStreams) untrack(seq uint64) {
+	cs.mu.Lock()
+	cancel := cs.calls[seq]
+	delete(cs.calls, seq)
+	cs.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancel cancels the request of seq, the client doesn't wait for it anymore.
+func (cs *connStreams) cancel(seq uint64) {
+	cs.mu.RLock()
+	cancel := cs.calls[seq]
+	cs.mu.RUnlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// write writes a frame to the connection. It doesn't hold the lock while it waits
+// for the connection, a client that doesn't read must not block closeAll.
+func (cs *connStreams) write(ctx context.Context, data *[]byte) error {
+	cs.mu.RLock()
+	if cs.closed {
+		cs.mu.RUnlock()
+		protocol.PutData(data)
+		return protocol.ErrStreamClosed
+	}
+	if cs.writeCh != nil {
+		// closeAll waits for the writers before writeCh is closed
+		cs.writers.Add(1)
+	}
+	cs.mu.RUnlock()
+
+	if cs.writeCh != nil {
+		defer cs.writers.Done()
+		select {
+		case cs.writeCh <- data:
+			return nil
+		case <-cs.done:
+			protocol.PutData(data)
+			return protocol.ErrStreamClosed
+		case <-cs.s.doneChan:
+			protocol.PutData(data)
+			return ErrServerClosed
+		case <-ctx.Done():
+			protocol.PutData(data)
+			return ctx.Err()
+		}
+	}
+
+	if cs.s.writeTimeout != 0 {
+		cs.conn.SetWriteDeadline(time.Now().Add(cs.s.writeTimeout))
+	}
+	_, err := cs.conn.Write(*data)
+	protocol.PutData(data)
+	return err
+}
+
+// writeAsync hands data to the writing goroutine of the connection of ctx, it is
+// dropped if the connection is closed.
+func writeAsync(ctx context.Context, writeCh chan *[]byte, data *[]byte) error {
+	if cs, ok := ctx.Value(streamsContextKey).(*connStreams); ok {
+		return cs.write(context.Background(), data)
+	}
+	writeCh <- data
+	return nil
+}
+
+// closeAll aborts all streams when the connection is closed.
+// The writes to writeCh are done when it returns.
+func (cs *connStreams) closeAll() {
+	cs.mu.Lock()
+	cs.closed = true
+	close(cs.done)
+	streams := cs.streams
+	cs.streams = make(map[uint64]*Stream)
+	cs.mu.Unlock()
+
+	for _, st := range streams {
+		st.abort(io.ErrUnexpectedEOF)
+	}
+	cs.writers.Wait()
+}
+
+// streamOf returns the stream opened by req.
+func streamOf(ctx context.Context, req *protocol.Message) *Stream {
+	cs, ok := ctx.Value(streamsContextKey).(*connStreams)
+	if !ok || protocol.StreamWindowOf(req.Metadata) == 0 {
+		return nil
+	}
+	return cs.get(req.Seq())
+}
+
+func (s *Server) handleStreamRequest(ctx context.Context, req, res *protocol.Message, service *service, mtype *methodType, argv interface{}) (*protocol.Message, error) {
+	serviceName := req.ServicePath
+	methodName := strings.ToLower(req.ServiceMethod)
+
+	st := streamOf(ctx, req)
+	if st == nil {
+		reflectTypePools.Put(mtype.ArgType, argv)
+		return s.handleError(res, errors.New("rpcx: "+methodName+" is a stream method"))
+	}
+
+	argv, err := s.Plugins.DoPreCall(ctx, serviceName, methodName, argv)
+	if err != nil {
+		reflectTypePools.Put(mtype.ArgType, argv)
+		return s.handleError(res, err)
+	}
+
+	st.start(ctx)
+	argValue := reflect.ValueOf(argv)
+	if mtype.ArgType.Kind() != reflect.Ptr {
+		argValue = argValue.Elem()
+	}
+	if mtype.UseRetcode {
+		res.Retcode = service.call2(st.ctx, mtype, argValue, reflect.ValueOf(st))
+	} else {
+		err = service.call(st.ctx, mtype, argValue, reflect.ValueOf(st))
+	}
+
+	if err == nil {
+		_, err = s.Plugins.DoPostCall(ctx, serviceName, methodName, argv, nil)
+	}
+	reflectTypePools.Put(mtype.ArgType, argv)
+	if err != nil {
+		return s.handleError(res, err)
+	}
+
+	res.Payload = codec.EncodeRetArgs(res.Retcode, nil)
+	return res, nil
+}
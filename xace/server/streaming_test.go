@@ -0,0 +1,166 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"xace/client"
+	"xace/server"
+)
+
+type Streamer struct{ sent int32 }
+
+type Num struct{ N int }
+
+func (s *Streamer) Count(ctx context.Context, args *Num, st *server.Stream) error {
+	for i := 0; i < args.N; i++ {
+		if err := st.Send(&Num{i}); err != nil {
+			return err
+		}
+		atomic.AddInt32(&s.sent, 1)
+	}
+	return nil
+}
+
+func (s *Streamer) Sum(ctx context.Context, args *Num, st *server.Stream) error {
+	total := 0
+	for {
+		n := &Num{}
+		err := st.Recv(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		total += n.N
+	}
+	return st.Send(&Num{total})
+}
+
+func (s *Streamer) Echo(ctx context.Context, args *Num, st *server.Stream) error {
+	for {
+		n := &Num{}
+		if err := st.Recv(n); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := st.Send(n); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Streamer) Fail(ctx context.Context, args *Num, st *server.Stream) error {
+	return errors.New("boom")
+}
+
+func (s *Streamer) Block(ctx context.Context, args *Num, st *server.Stream) error {
+	<-ctx.Done()
+	atomic.StoreInt32(&s.sent, -1)
+	return ctx.Err()
+}
+
+func TestStreaming(t *testing.T) {
+	s := server.NewServer(server.WithStreamWindow(4))
+	sv := &Streamer{}
+	s.RegisterName("S", sv, "")
+	s.RegisterName("Arith", new(Arith), "")
+	go s.Serve("tcp", "127.0.0.1:0")
+	for s.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer s.Close()
+	opt := client.DefaultOption
+	opt.StreamWindow = 3
+	c := client.NewClient(opt)
+	if err := c.Connect("tcp", s.Address().String()); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// server streaming with flow control
+	cs, err := c.NewStream(ctx, "S", "Count", []any{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&sv.sent); n > 3 {
+		t.Fatal("flow control not applied", n)
+	}
+	for i := 0; i < 100; i++ {
+		n := &Num{}
+		if err := cs.Recv(n); err != nil || n.N != i {
+			t.Fatal(i, err, n)
+		}
+	}
+	if err := cs.Recv(&Num{}); err != io.EOF {
+		t.Fatal(err)
+	}
+	// client streaming
+	cs, _ = c.NewStream(ctx, "S", "Sum", []any{0})
+	for i := 1; i <= 50; i++ {
+		if err := cs.Send(&Num{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs.CloseSend()
+	n := &Num{}
+	if err := cs.Recv(n); err != nil || n.N != 1275 {
+		t.Fatal(err, n)
+	}
+	// bidi concurrently with unary calls
+	cs, _ = c.NewStream(ctx, "S", "Echo", []any{0})
+	go func() {
+		for i := 0; i < 200; i++ {
+			cs.Send(&Num{i})
+		}
+		cs.CloseSend()
+	}()
+	for i := 0; i < 200; i++ {
+		if i%50 == 0 {
+			r := &Reply{}
+			if err := c.Call(ctx, "Arith", "Div", []any{i * 2, 2}, []any{r}); err != nil || r.C != i {
+				t.Fatal(err, r)
+			}
+		}
+		n := &Num{}
+		if err := cs.Recv(n); err != nil || n.N != i {
+			t.Fatal(err, n)
+		}
+	}
+	if err := cs.Recv(n); err != io.EOF {
+		t.Fatal(err)
+	}
+	// error
+	cs, _ = c.NewStream(ctx, "S", "Fail", []any{0})
+	if err := cs.Recv(n); err == nil || err.Error() != "boom" {
+		t.Fatal(err)
+	}
+	// cancel
+	cctx, cancel := context.WithCancel(ctx)
+	cs, _ = c.NewStream(cctx, "S", "Block", []any{0})
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&sv.sent) != -1 {
+		t.Fatal("server not canceled")
+	}
+	if err := cs.Recv(n); err != context.Canceled {
+		t.Fatal(err)
+	}
+	// unary call to stream method
+	if err := c.Call(ctx, "S", "Count", []any{1}, []any{&Num{}}); err == nil {
+		t.Fatal("expected error")
+	}
+	// stream to non-stream method
+	cs, _ = c.NewStream(ctx, "Arith", "Mul", []any{1, 2})
+	if err := cs.Recv(n); err == nil || err == io.EOF {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"xace/log"
+)
+
+// tokenLen is the length of tokens that clients write first on side connections.
+const tokenLen = 32
+
+// DefaultTokenTTL is how long a token is valid if the owner doesn't set one.
+var DefaultTokenTTL = time.Minute
+
+func generateToken() []byte {
+	b := make([]byte, tokenLen)
+	_, _ = rand.Read(b)
+	return b
+}
+
+type tokenItem struct {
+	value  interface{}
+	expire time.Time
+}
+
+// tokenCache keeps one-time tokens handed out by rpc calls until
+// the client dials the side listener with them.
+type tokenCache struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	items map[string]tokenItem
+}
+
+func newTokenCache(max int, ttl time.Duration) *tokenCache {
+	if max <= 0 {
+		max = 1024
+	}
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	return &tokenCache{max: max, ttl: ttl, items: make(map[string]tokenItem)}
+}
+
+// Add stores v with token. If the cache is full the expired tokens are dropped first
+// and then the one closest to expire.
+func (c *tokenCache) Add(token []byte, v interface{}) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.items) >= c.max {
+		var oldest string
+		var oldestExpire time.Time
+		for k, item := range c.items {
+			if item.expire.Before(now) {
+				delete(c.items, k)
+				continue
+			}
+			if oldest == "" || item.expire.Before(oldestExpire) {
+				oldest, oldestExpire = k, item.expire
+			}
+		}
+		if len(c.items) >= c.max {
+			delete(c.items, oldest)
+		}
+	}
+	c.items[string(token)] = tokenItem{value: v, expire: now.Add(c.ttl)}
+}
+
+// Take returns the value of token and removes it, a token can only be used once.
+func (c *tokenCache) Take(token []byte) (interface{}, bool) {
+	c.mu.Lock()
+	item, ok := c.items[string(token)]
+	if ok {
+		delete(c.items, string(token))
+	}
+	c.mu.Unlock()
+
+	if !ok || item.expire.Before(time.Now()) {
+		return nil, false
+	}
+	return item.value, true
+}
+
+// serveSideListener accepts connections of ln until done is closed,
+// handle is called in a new goroutine for each of them.
+func serveSideListener(name string, ln net.Listener, done chan struct{}, handle func(conn net.Conn)) {
+	var tempDelay time.Duration
+	for {
+		conn, e := ln.Accept()
+		if e != nil {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if ne, ok := e.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+
+				log.Errorf("%s: accept error: %v; retrying in %v", name, e, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			log.Errorf("%s: accept error: %v", name, e)
+			return
+		}
+		tempDelay = 0
+
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(3 * time.Minute)
+			tc.SetLinger(10)
+		}
+
+		go handle(conn)
+	}
+}
+
+// readToken reads the token a client writes first on a side connection.
+func readToken(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	token := make([]byte, tokenLen)
+	_, err := io.ReadFull(conn, token)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return token, nil
+}
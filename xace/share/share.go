@@ -15,6 +15,10 @@ const (
 	// ServerAddress is used to get address of the server by client
 	ServerAddress = "__ServerAddress"
 
+	// HedgeIndex is set by clients in the metadata of responses of Failbackup calls,
+	// the number of the request that answered, 0 for the first one.
+	HedgeIndex = "__HedgeIndex"
+
 	// ServerTimeout timeout value passed from client to control timeout of server
 	ServerTimeout = "__ServerTimeout"
 
@@ -61,22 +65,40 @@ var ReqMetaDataKey = ContextKey("__req_metadata")
 var ResMetaDataKey = ContextKey("__res_metadata")
 
 // FileTransferArgs args from clients.
+// Offset is where the client starts to send, Checksum is the hex sha256 of the whole file.
 type FileTransferArgs struct {
 	FileName string            `json:"file_name,omitempty"`
 	FileSize int64             `json:"file_size,omitempty"`
 	Meta     map[string]string `json:"meta,omitempty"`
+	Offset   int64             `json:"offset,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
 }
 
 // FileTransferReply response to token and addr to clients.
+// For uploads Offset is how many bytes the server already has,
+// for downloads FileSize and Checksum describe the file to be sent.
 type FileTransferReply struct {
-	Token []byte `json:"token,omitempty"`
-	Addr  string `json:"addr,omitempty"`
+	Token    []byte `json:"token,omitempty"`
+	Addr     string `json:"addr,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
 }
 
+// After an upload the server writes one of these bytes back before it closes the connection.
+const (
+	// FileTransferOK means the file is stored.
+	FileTransferOK byte = 0
+	// FileTransferFailed means the file can't be stored or the checksum doesn't match.
+	FileTransferFailed byte = 1
+)
+
 // DownloadFileArgs args from clients.
+// Offset is how many bytes the client already has.
 type DownloadFileArgs struct {
 	FileName string            `json:"file_name,omitempty"`
 	Meta     map[string]string `json:"meta,omitempty"`
+	Offset   int64             `json:"offset,omitempty"`
 }
 
 // StreamServiceArgs is the request type for stream service.
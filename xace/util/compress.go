@@ -70,6 +70,8 @@ func Zip(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	dec := buf.Bytes()
+	// buf goes back to the pool, so return a copy of its bytes.
+	dec := make([]byte, buf.Len())
+	copy(dec, buf.Bytes())
 	return dec, nil
 }